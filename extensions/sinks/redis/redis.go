@@ -17,6 +17,7 @@ package main
 import (
 	"encoding/json"
 	"errors"
+	"fmt"
 	"time"
 
 	"github.com/go-redis/redis/v7"
@@ -44,6 +45,12 @@ type RedisSink struct {
 
 	expiration time.Duration
 
+	// The approximate max length of the stream
+	maxLen int64
+
+	// The field of the stream entry or hash to save the whole record
+	payloadField string
+
 	sendSingle bool
 
 	cli *redis.Client
@@ -99,6 +106,12 @@ func (r *RedisSink) Configure(props map[string]interface{}) error {
 		}
 	}
 
+	switch r.dataType {
+	case "string", "list", "stream", "hash":
+	default:
+		return fmt.Errorf("invalid dataType %s, must be string, list, stream or hash", r.dataType)
+	}
+
 	r.expiration = -1
 	if i, ok := props["expiration"]; ok {
 		if t, err := cast.ToInt(i, cast.STRICT); err == nil {
@@ -106,6 +119,18 @@ func (r *RedisSink) Configure(props map[string]interface{}) error {
 		}
 	}
 
+	if i, ok := props["maxLen"]; ok {
+		if t, err := cast.ToInt(i, cast.STRICT); err == nil {
+			r.maxLen = int64(t)
+		}
+	}
+
+	if i, ok := props["payloadField"]; ok {
+		if i, ok := i.(string); ok {
+			r.payloadField = i
+		}
+	}
+
 	return nil
 }
 
@@ -127,81 +152,37 @@ func (r *RedisSink) Collect(ctx api.StreamContext, data interface{}) error {
 	logger := ctx.GetLogger()
 
 	if v, ok := data.([]byte); ok {
-		if r.field != "" {
-			if !r.sendSingle {
-				var out []map[string]interface{}
-				if err := json.Unmarshal(v, &out); err != nil {
+		// The stream and hash are written by the fields of each record
+		if r.field != "" || r.dataType == "stream" || r.dataType == "hash" {
+			var out []map[string]interface{}
+			if r.sendSingle {
+				var m map[string]interface{}
+				if err := json.Unmarshal(v, &m); err != nil {
 					logger.Debug("Failed to unmarshal data with error: ", err, " data:", string(v))
 					return err
 				}
+				out = append(out, m)
+			} else if err := json.Unmarshal(v, &out); err != nil {
+				logger.Debug("Failed to unmarshal data with error: ", err, " data:", string(v))
+				return err
+			}
 
-				for _, m := range out {
-					key := r.field
+			for _, m := range out {
+				key := r.key
+				if r.field != "" {
+					key = r.field
 					field, ok := m[key].(string)
 					if ok {
 						key = field
 					}
-
-					if r.dataType == "list" {
-						err := r.cli.LPush(key, v).Err()
-						if err != nil {
-							logger.Error(err)
-							return err
-						}
-						logger.Debugf("send redis list success, key:%s data: %s", key, string(v))
-					} else {
-						err := r.cli.Set(key, v, r.expiration*time.Second).Err()
-						if err != nil {
-							logger.Error(err)
-							return err
-						}
-						logger.Debugf("send redis string success, key:%s data: %s", key, string(v))
-					}
 				}
-			} else {
-				var out map[string]interface{}
-				if err := json.Unmarshal(v, &out); err != nil {
-					logger.Debug("Failed to unmarshal data with error: ", err, " data:", string(v))
+				if err := r.save(ctx, key, v, m); err != nil {
 					return err
 				}
-				key := r.field
-				field, ok := out[key].(string)
-				if ok {
-					key = field
-				}
-
-				if r.dataType == "list" {
-					err := r.cli.LPush(key, v).Err()
-					if err != nil {
-						logger.Error(err)
-						return err
-					}
-					logger.Debugf("send redis list success, key:%s data: %s", key, string(v))
-				} else {
-					err := r.cli.Set(key, v, r.expiration*time.Second).Err()
-					if err != nil {
-						logger.Error(err)
-						return err
-					}
-					logger.Debugf("send redis string success, key:%s data: %s", key, string(v))
-				}
 			}
-
 		} else if r.key != "" {
-			if r.dataType == "list" {
-				err := r.cli.LPush(r.key, v).Err()
-				if err != nil {
-					logger.Error(err)
-					return err
-				}
-				logger.Debugf("send redis list success, key:%s data: %s", r.key, string(v))
-			} else {
-				err := r.cli.Set(r.key, v, r.expiration*time.Second).Err()
-				if err != nil {
-					logger.Error(err)
-					return err
-				}
-				logger.Debugf("send redis string success, key:%s data: %s", r.key, string(v))
+			if err := r.save(ctx, r.key, v, nil); err != nil {
+				return err
 			}
 		}
 
@@ -212,6 +193,65 @@ func (r *RedisSink) Collect(ctx api.StreamContext, data interface{}) error {
 	return nil
 }
 
+// save writes the data to the key by the data type. The string and list save the whole data while the stream and
+// hash save the fields of the record.
+func (r *RedisSink) save(ctx api.StreamContext, key string, v []byte, m map[string]interface{}) error {
+	logger := ctx.GetLogger()
+	var err error
+	switch r.dataType {
+	case "list":
+		err = r.cli.LPush(key, v).Err()
+	case "stream":
+		var values map[string]interface{}
+		values, err = r.fieldValues(m)
+		if err == nil {
+			err = r.cli.XAdd(&redis.XAddArgs{Stream: key, MaxLenApprox: r.maxLen, Values: values}).Err()
+		}
+	case "hash":
+		var values map[string]interface{}
+		values, err = r.fieldValues(m)
+		if err == nil && len(values) > 0 {
+			err = r.cli.HSet(key, values).Err()
+			if err == nil && r.expiration > 0 {
+				err = r.cli.Expire(key, r.expiration*time.Second).Err()
+			}
+		}
+	default:
+		err = r.cli.Set(key, v, r.expiration*time.Second).Err()
+	}
+	if err != nil {
+		logger.Error(err)
+		return err
+	}
+	logger.Debugf("send redis %s success, key:%s data: %s", r.dataType, key, string(v))
+	return nil
+}
+
+// fieldValues converts the record to the field values of the stream entry or hash. The values which are not
+// string are encoded as json. If payloadField is set, the whole record is encoded as json to the field.
+func (r *RedisSink) fieldValues(m map[string]interface{}) (map[string]interface{}, error) {
+	if r.payloadField != "" {
+		b, err := json.Marshal(m)
+		if err != nil {
+			return nil, err
+		}
+		return map[string]interface{}{r.payloadField: string(b)}, nil
+	}
+	values := make(map[string]interface{}, len(m))
+	for k, v := range m {
+		if s, ok := v.(string); ok {
+			values[k] = s
+			continue
+		}
+		b, err := json.Marshal(v)
+		if err != nil {
+			return nil, err
+		}
+		values[k] = string(b)
+	}
+	return values, nil
+}
+
 func (r *RedisSink) Close(ctx api.StreamContext) error {
 	err := r.cli.Close()
 	return err
@@ -87,3 +87,20 @@ func IsAggFunc(funcName string) bool {
 	}
 	return false
 }
+
+type multiIncAggFunc interface {
+	IsIncrementalWithName(name string) bool
+}
+
+// IsIncAggFunc tells if the function is an aggregate function which can be calculated incrementally
+func IsIncAggFunc(funcName string) bool {
+	f, _ := Function(funcName)
+	if f != nil {
+		if mf, ok := f.(multiIncAggFunc); ok {
+			return mf.IsIncrementalWithName(funcName)
+		} else if _, ok := f.(api.IncrementalFunction); ok {
+			return f.IsAggregate()
+		}
+	}
+	return false
+}
@@ -0,0 +1,130 @@
+// Copyright 2021 EMQ Technologies Co., Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package function
+
+import (
+	"encoding/gob"
+	"fmt"
+	"strings"
+)
+
+// incAggFuncMap is the builtin aggregate functions which can be calculated incrementally
+var incAggFuncMap = map[string]string{"avg": "",
+	"count": "",
+	"max":   "", "min": "",
+	"sum": "",
+}
+
+// AggAccumulator is the accumulator of the builtin incremental aggregate functions
+type AggAccumulator struct {
+	Value interface{} // The sum for sum and avg, the extremum for max and min
+	Count int         // The count of the non-nil values
+}
+
+func init() {
+	gob.Register(&AggAccumulator{})
+}
+
+func isIncAggFunc(name string) bool {
+	_, ok := incAggFuncMap[strings.ToLower(name)]
+	return ok
+}
+
+// aggAccumulate returns a new accumulator instead of updating the given one which may be in the checkpoint state
+func aggAccumulate(name string, acc interface{}, args []interface{}) (interface{}, error) {
+	a, err := toAccumulator(name, acc)
+	if err != nil {
+		return nil, err
+	}
+	if len(args) == 0 || args[0] == nil {
+		return a, nil
+	}
+	r := &AggAccumulator{Value: a.Value, Count: a.Count + 1}
+	if name != "count" {
+		if r.Value, err = aggMergeValue(name, a.Value, args[0]); err != nil {
+			return nil, err
+		}
+	}
+	return r, nil
+}
+
+func aggMerge(name string, acc1 interface{}, acc2 interface{}) (interface{}, error) {
+	a1, err := toAccumulator(name, acc1)
+	if err != nil {
+		return nil, err
+	}
+	a2, err := toAccumulator(name, acc2)
+	if err != nil {
+		return nil, err
+	}
+	r := &AggAccumulator{Count: a1.Count + a2.Count}
+	if name != "count" {
+		if r.Value, err = aggMergeValue(name, a1.Value, a2.Value); err != nil {
+			return nil, err
+		}
+	}
+	return r, nil
+}
+
+func aggResult(name string, acc interface{}) (interface{}, bool) {
+	a, err := toAccumulator(name, acc)
+	if err != nil {
+		return err, false
+	}
+	switch name {
+	case "count":
+		return a.Count, true
+	case "avg":
+		if a.Count == 0 {
+			return 0, true
+		}
+		switch v := a.Value.(type) {
+		case int:
+			return v / a.Count, true
+		case float64:
+			return v / float64(a.Count), true
+		default:
+			return fmt.Errorf("run avg function error: found invalid arg %[1]T(%[1]v)", v), false
+		}
+	default:
+		return a.Value, true
+	}
+}
+
+// aggMergeValue merges two values by the same rule of the aggregate function
+func aggMergeValue(name string, v1 interface{}, v2 interface{}) (interface{}, error) {
+	if name == "avg" {
+		name = "sum"
+	}
+	r, ok := aggCall(name, []interface{}{[]interface{}{v1, v2}})
+	if !ok {
+		if e, ok := r.(error); ok {
+			return nil, e
+		}
+		return nil, fmt.Errorf("run %s function error: %v", name, r)
+	}
+	return r, nil
+}
+
+func toAccumulator(name string, acc interface{}) (*AggAccumulator, error) {
+	switch a := acc.(type) {
+	case nil:
+		return &AggAccumulator{}, nil
+	case *AggAccumulator:
+		return a, nil
+	default:
+		return nil, fmt.Errorf("run %s function error: invalid accumulator %[2]T(%[2]v)", name, acc)
+	}
+}
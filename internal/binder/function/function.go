@@ -90,8 +90,9 @@ var jsonFuncMap = map[string]string{
 
 var otherFuncMap = map[string]string{"isnull": "",
 	"newuuid": "", "tstamp": "", "mqtt": "", "meta": "", "cardinality": "",
-	"window_start": "",
-	"window_end":   "",
+	"window_start":   "",
+	"window_end":     "",
+	"window_trigger": "",
 }
 
 func getFuncType(name string) funcType {
@@ -156,6 +157,22 @@ func (f *funcExecutor) IsAggregateWithName(name string) bool {
 	return getFuncType(lowerName) == AggFunc
 }
 
+func (f *funcExecutor) IsIncrementalWithName(name string) bool {
+	return isIncAggFunc(name)
+}
+
+func (f *funcExecutor) AccumulateWithName(acc interface{}, args []interface{}, _ api.FunctionContext, name string) (interface{}, error) {
+	return aggAccumulate(strings.ToLower(name), acc, args)
+}
+
+func (f *funcExecutor) MergeWithName(acc1 interface{}, acc2 interface{}, _ api.FunctionContext, name string) (interface{}, error) {
+	return aggMerge(strings.ToLower(name), acc1, acc2)
+}
+
+func (f *funcExecutor) ResultWithName(acc interface{}, _ api.FunctionContext, name string) (interface{}, bool) {
+	return aggResult(strings.ToLower(name), acc)
+}
+
 var staticFuncExecutor = &funcExecutor{}
 
 type Manager struct{}
@@ -25,16 +25,36 @@ type NewSinkFunc func() api.Sink
 
 var (
 	sources = map[string]NewSourceFunc{
-		"mqtt":     func() api.Source { return &source.MQTTSource{} },
-		"httppull": func() api.Source { return &source.HTTPPullSource{} },
-		"file":     func() api.Source { return &source.FileSource{} },
+		"mqtt":      func() api.Source { return &source.MQTTSource{} },
+		"httppull":  func() api.Source { return &source.HTTPPullSource{} },
+		"httppush":  func() api.Source { return &source.HTTPPushSource{} },
+		"file":      func() api.Source { return &source.FileSource{} },
+		"websocket": func() api.Source { return &source.WebsocketSource{} },
+		"sql":       func() api.Source { return &source.SQLSource{} },
+		"nats":      func() api.Source { return &source.NATSSource{} },
+		"modbus":    func() api.Source { return &source.ModbusSource{} },
+		"opcua":     func() api.Source { return &source.OPCUASource{} },
+		"redis":     func() api.Source { return &source.RedisSource{} },
+		"coap":      func() api.Source { return &source.CoapSource{} },
+		"syslog":    func() api.Source { return &source.SyslogSource{} },
+		"socket":    func() api.Source { return &source.SocketSource{} },
 	}
 	sinks = map[string]NewSinkFunc{
-		"log":         sink.NewLogSink,
-		"logToMemory": sink.NewLogSinkToMemory,
-		"mqtt":        func() api.Sink { return &sink.MQTTSink{} },
-		"rest":        func() api.Sink { return &sink.RestSink{} },
-		"nop":         func() api.Sink { return &sink.NopSink{} },
+		"log":           sink.NewLogSink,
+		"logToMemory":   sink.NewLogSinkToMemory,
+		"mqtt":          func() api.Sink { return &sink.MQTTSink{} },
+		"rest":          func() api.Sink { return &sink.RestSink{} },
+		"nop":           func() api.Sink { return &sink.NopSink{} },
+		"websocket":     func() api.Sink { return &sink.WebsocketSink{} },
+		"sql":           func() api.Sink { return &sink.SQLSink{} },
+		"file":          func() api.Sink { return &sink.FileSink{} },
+		"nats":          func() api.Sink { return &sink.NATSSink{} },
+		"influx2":       func() api.Sink { return &sink.Influx2Sink{} },
+		"prometheus":    func() api.Sink { return &sink.PrometheusSink{} },
+		"elasticsearch": func() api.Sink { return &sink.ElasticsearchSink{} },
+		"sse":           func() api.Sink { return &sink.SSESink{} },
+		"coap":          func() api.Sink { return &sink.CoapSink{} },
+		"socket":        func() api.Sink { return &sink.SocketSink{} },
 	}
 )
 
@@ -53,7 +53,12 @@ type KuiperConf struct {
 		PrometheusPort int      `yaml:"prometheusPort"`
 		PluginHosts    string   `yaml:"pluginHosts"`
 	}
-	Rule api.RuleOption
+	Rule   api.RuleOption
+	Source struct {
+		HttpServerIp   string   `yaml:"httpServerIp"`
+		HttpServerPort int      `yaml:"httpServerPort"`
+		HttpServerTls  *tlsConf `yaml:"httpServerTls"`
+	}
 	Sink struct {
 		CacheThreshold    int  `yaml:"cacheThreshold"`
 		CacheTriggerCount int  `yaml:"cacheTriggerCount"`
@@ -17,6 +17,9 @@ package meta
 import (
 	"encoding/json"
 	"fmt"
+	"github.com/lf-edge/ekuiper/internal/conf"
+	"os"
+	"path/filepath"
 	"reflect"
 	"testing"
 )
@@ -29,6 +32,13 @@ var (
 )
 
 func TestGetSourceMeta(t *testing.T) {
+	// Save the conf keys into a temp etc folder instead of the real one
+	base := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(base, "etc", "sources"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	_ = os.Setenv(conf.KuiperBaseKey, base)
+	defer os.Unsetenv(conf.KuiperBaseKey)
 	source := new(sourceProperty)
 	var cf map[string]map[string]interface{}
 	if err := json.Unmarshal([]byte(gCf), &cf); nil != err {
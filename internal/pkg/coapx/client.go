@@ -0,0 +1,364 @@
+// Copyright 2021 EMQ Technologies Co., Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package coapx
+
+import (
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"github.com/pion/dtls/v2"
+	mrand "math/rand"
+	"net"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+// The transmission parameters of the confirmable messages
+const (
+	ackTimeout    = 2 * time.Second
+	ackRandom     = 1.5
+	maxRetransmit = 4
+)
+
+var messageID = uint32(mrand.Int31())
+
+func nextMessageID() uint16 {
+	return uint16(atomic.AddUint32(&messageID, 1))
+}
+
+func newToken() []byte {
+	t := make([]byte, 8)
+	_, _ = rand.Read(t)
+	return t
+}
+
+var ErrClosed = errors.New("coap connection is closed")
+
+// Client is a CoAP connection to a server over UDP or DTLS
+type Client struct {
+	conn    net.Conn
+	timeout time.Duration
+
+	mu     sync.Mutex
+	acks   map[uint16]chan *Message
+	tokens map[string]func(*Message)
+
+	done chan struct{}
+	once sync.Once
+	err  error
+}
+
+// Dial connects to the address. The DTLS handshake is done if DTLS is enabled. The timeout is the max time to wait
+// for the handshake and the response of a request.
+func Dial(addr string, c *DTLSConf, timeout time.Duration) (*Client, error) {
+	raddr, err := net.ResolveUDPAddr("udp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("coap fails to resolve %s: %v", addr, err)
+	}
+	if timeout <= 0 {
+		timeout = DEFAULT_TIMEOUT * time.Millisecond
+	}
+	var conn net.Conn
+	if c != nil && c.Enabled() {
+		cfg, err := c.clientConfig(timeout)
+		if err != nil {
+			return nil, err
+		}
+		conn, err = dtls.Dial("udp", raddr, cfg)
+		if err != nil {
+			return nil, fmt.Errorf("coap fails to connect to %s by dtls: %v", addr, err)
+		}
+	} else {
+		conn, err = net.DialUDP("udp", nil, raddr)
+		if err != nil {
+			return nil, fmt.Errorf("coap fails to connect to %s: %v", addr, err)
+		}
+	}
+	cli := &Client{
+		conn:    conn,
+		timeout: timeout,
+		acks:    make(map[uint16]chan *Message),
+		tokens:  make(map[string]func(*Message)),
+		done:    make(chan struct{}),
+	}
+	go cli.read()
+	return cli, nil
+}
+
+// Done is closed when the connection is closed or broken
+func (c *Client) Done() <-chan struct{} {
+	return c.done
+}
+
+// Err returns the error which breaks the connection
+func (c *Client) Err() error {
+	select {
+	case <-c.done:
+		return c.err
+	default:
+		return nil
+	}
+}
+
+func (c *Client) Close() error {
+	c.fail(ErrClosed)
+	return nil
+}
+
+func (c *Client) fail(err error) {
+	c.once.Do(func() {
+		c.err = err
+		close(c.done)
+		_ = c.conn.Close()
+	})
+}
+
+func (c *Client) read() {
+	buf := make([]byte, maxDatagramSize)
+	for {
+		n, err := c.conn.Read(buf)
+		if err != nil {
+			// The connected udp socket gets connection refused by icmp if the server is not started yet
+			if errors.Is(err, syscall.ECONNREFUSED) {
+				continue
+			}
+			c.fail(err)
+			return
+		}
+		m, err := Unmarshal(buf[:n])
+		if err != nil {
+			continue
+		}
+		switch m.Type {
+		case Acknowledgement, Reset:
+			c.mu.Lock()
+			ch, ok := c.acks[m.MessageID]
+			delete(c.acks, m.MessageID)
+			c.mu.Unlock()
+			if ok {
+				ch <- m
+			}
+			if m.Type == Acknowledgement && m.Code != Empty {
+				c.dispatch(m)
+			}
+		default:
+			if isRequest(m.Code) || m.Code == Empty {
+				if m.Type == Confirmable {
+					_ = c.send(&Message{Type: Reset, MessageID: m.MessageID})
+				}
+				continue
+			}
+			if !c.dispatch(m) {
+				// Reject the unknown response or notification so that the server removes the observer
+				_ = c.send(&Message{Type: Reset, MessageID: m.MessageID})
+				continue
+			}
+			if m.Type == Confirmable {
+				_ = c.send(&Message{Type: Acknowledgement, MessageID: m.MessageID})
+			}
+		}
+	}
+}
+
+// dispatch sends the response to the handler of the token
+func (c *Client) dispatch(m *Message) bool {
+	c.mu.Lock()
+	h, ok := c.tokens[string(m.Token)]
+	c.mu.Unlock()
+	if ok {
+		h(m)
+	}
+	return ok
+}
+
+func (c *Client) send(m *Message) error {
+	b, err := m.Marshal()
+	if err != nil {
+		return err
+	}
+	_, err = c.conn.Write(b)
+	return err
+}
+
+// transmit sends the message. The confirmable message is retransmitted with exponential backoff until acknowledged.
+func (c *Client) transmit(m *Message) error {
+	if m.Type != Confirmable {
+		return c.send(m)
+	}
+	ack := make(chan *Message, 1)
+	c.mu.Lock()
+	c.acks[m.MessageID] = ack
+	c.mu.Unlock()
+	defer func() {
+		c.mu.Lock()
+		delete(c.acks, m.MessageID)
+		c.mu.Unlock()
+	}()
+	timeout := time.Duration(float64(ackTimeout) * (1 + mrand.Float64()*(ackRandom-1)))
+	for i := 0; i <= maxRetransmit; i++ {
+		if err := c.send(m); err != nil {
+			return err
+		}
+		timer := time.NewTimer(timeout)
+		select {
+		case a := <-ack:
+			timer.Stop()
+			if a.Type == Reset {
+				return errors.New("coap request is reset by the server")
+			}
+			return nil
+		case <-c.done:
+			timer.Stop()
+			return c.err
+		case <-timer.C:
+		}
+		timeout *= 2
+	}
+	return errors.New("coap request is not acknowledged")
+}
+
+// Do sends the request and waits for the response. The message id and the token are generated.
+func (c *Client) Do(req *Message) (*Message, error) {
+	req.MessageID = nextMessageID()
+	req.Token = newToken()
+	resp := make(chan *Message, 1)
+	c.handle(req.Token, func(m *Message) {
+		select {
+		case resp <- m:
+		default:
+		}
+	})
+	defer c.unhandle(req.Token)
+	return c.exchange(req, resp)
+}
+
+// Send sends the request without waiting for the response. The confirmable request is still retransmitted until
+// acknowledged.
+func (c *Client) Send(req *Message) error {
+	req.MessageID = nextMessageID()
+	req.Token = newToken()
+	return c.transmit(req)
+}
+
+func (c *Client) exchange(req *Message, resp chan *Message) (*Message, error) {
+	deadline := time.NewTimer(c.timeout)
+	defer deadline.Stop()
+	errCh := make(chan error, 1)
+	go func() { errCh <- c.transmit(req) }()
+	for {
+		select {
+		case m := <-resp:
+			return m, nil
+		case err := <-errCh:
+			if err != nil {
+				return nil, err
+			}
+			// acknowledged, wait for the separate response
+			errCh = nil
+		case <-deadline.C:
+			return nil, errors.New("coap request timeout")
+		case <-c.done:
+			return nil, c.err
+		}
+	}
+}
+
+func (c *Client) handle(token []byte, h func(*Message)) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.tokens[string(token)] = h
+}
+
+func (c *Client) unhandle(token []byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.tokens, string(token))
+}
+
+// Observation is the registration to observe a resource
+type Observation struct {
+	c     *Client
+	req   *Message
+	mu    sync.Mutex
+	first bool
+	seq   uint32
+	last  time.Time
+}
+
+// Observe registers to observe the resource by the GET request and returns the first response. The later
+// notifications are sent to the handler in order. If the resource is not observable, nil observation is returned.
+func (c *Client) Observe(req *Message, h func(*Message)) (*Observation, *Message, error) {
+	req.Code = GET
+	req.MessageID = nextMessageID()
+	req.Token = newToken()
+	req.SetUintOption(Observe, 0)
+	o := &Observation{c: c, req: req, first: true}
+	resp := make(chan *Message, 1)
+	c.handle(req.Token, func(m *Message) {
+		o.mu.Lock()
+		if o.first {
+			o.first = false
+			o.mu.Unlock()
+			resp <- m
+			return
+		}
+		fresh := o.fresh(m)
+		o.mu.Unlock()
+		if fresh {
+			h(m)
+		}
+	})
+	first, err := c.exchange(req, resp)
+	if err != nil {
+		c.unhandle(req.Token)
+		return nil, nil, err
+	}
+	seq, ok := first.UintOption(Observe)
+	if !ok || !first.Code.IsSuccess() {
+		c.unhandle(req.Token)
+		return nil, first, nil
+	}
+	o.mu.Lock()
+	o.seq, o.last = seq, time.Now()
+	o.mu.Unlock()
+	return o, first, nil
+}
+
+// fresh checks if the notification is newer than the last one by the sequence number. Must be called with the lock
+// held.
+func (o *Observation) fresh(m *Message) bool {
+	seq, ok := m.UintOption(Observe)
+	if !ok {
+		// The final response without observe option
+		return true
+	}
+	now := time.Now()
+	if o.seq < seq && seq-o.seq < 1<<23 || o.seq > seq && o.seq-seq > 1<<23 || now.After(o.last.Add(128*time.Second)) {
+		o.seq, o.last = seq, now
+		return true
+	}
+	return false
+}
+
+// Cancel deregisters the observation
+func (o *Observation) Cancel() {
+	o.c.unhandle(o.req.Token)
+	req := &Message{Type: NonConfirmable, Code: GET, MessageID: nextMessageID(), Token: o.req.Token}
+	req.Options = append(req.Options, o.req.Options...)
+	req.SetUintOption(Observe, 1)
+	_ = o.c.send(req)
+}
@@ -0,0 +1,267 @@
+// Copyright 2021 EMQ Technologies Co., Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package coapx
+
+import (
+	"net"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestMessageCodec(t *testing.T) {
+	m := &Message{Type: Confirmable, Code: POST, MessageID: 1234, Token: []byte{1, 2, 3}, Payload: []byte(`{"a":1}`)}
+	m.SetPath("/sensors/temperature")
+	m.AddQuery("unit=c")
+	m.SetUintOption(ContentFormat, AppJSON)
+	m.Options = append(m.Options, Option{ID: 300, Value: make([]byte, 20)})
+	b, err := m.Marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, err := Unmarshal(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Type != m.Type || r.Code != m.Code || r.MessageID != m.MessageID || !reflect.DeepEqual(r.Token, m.Token) || !reflect.DeepEqual(r.Payload, m.Payload) {
+		t.Fatalf("message mismatch:\n  exp=%+v\n  got=%+v", m, r)
+	}
+	if r.Path() != "/sensors/temperature" {
+		t.Errorf("path mismatch, got %s", r.Path())
+	}
+	if !reflect.DeepEqual(r.Queries(), []string{"unit=c"}) {
+		t.Errorf("query mismatch, got %v", r.Queries())
+	}
+	if v, ok := r.UintOption(ContentFormat); !ok || v != AppJSON {
+		t.Errorf("content format mismatch, got %d", v)
+	}
+	if v, ok := r.Option(300); !ok || len(v) != 20 {
+		t.Errorf("extended option mismatch, got %v", v)
+	}
+	for _, b := range [][]byte{{0x40}, {0x48, 0x01, 0, 0}, {0x40, 0x01, 0, 0, 0xff}, {0x40, 0x01, 0, 0, 0x12, 0x01}} {
+		if _, err := Unmarshal(b); err == nil {
+			t.Errorf("expect error for %x", b)
+		}
+	}
+	if POST.String() != "0.02" || Content.String() != "2.05" || NotFound.String() != "4.04" {
+		t.Errorf("code string mismatch")
+	}
+}
+
+func TestParseUrl(t *testing.T) {
+	var tests = []struct {
+		url    string
+		addr   string
+		path   string
+		query  []string
+		secure bool
+		err    string
+	}{
+		{url: "coap://127.0.0.1/a/b?x=1&y=2", addr: "127.0.0.1:5683", path: "/a/b", query: []string{"x=1", "y=2"}},
+		{url: "coaps://device:6000/a", addr: "device:6000", path: "/a", secure: true},
+		{url: "http://device/a", err: "invalid url http://device/a, must start with coap:// or coaps://"},
+		{url: "coap:///a", err: "invalid url coap:///a, host is missing"},
+	}
+	for i, tt := range tests {
+		addr, path, query, secure, err := ParseUrl(tt.url)
+		if err != nil {
+			if err.Error() != tt.err {
+				t.Errorf("%d: error mismatch:\n  exp=%s\n  got=%s", i, tt.err, err)
+			}
+			continue
+		}
+		if tt.err != "" || addr != tt.addr || path != tt.path || !reflect.DeepEqual(query, tt.query) || secure != tt.secure {
+			t.Errorf("%d: result mismatch, got %s %s %v %v %v", i, addr, path, query, secure, err)
+		}
+	}
+}
+
+func echoHandler(req *Message, _ net.Addr) (Code, []byte) {
+	if req.Code != POST {
+		return MethodNotAllowed, nil
+	}
+	return Changed, req.Payload
+}
+
+func TestServerClient(t *testing.T) {
+	s, err := AcquireServer("127.0.0.1:0", &DTLSConf{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ReleaseServer(s)
+	if err := s.Handle("/echo", echoHandler); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Handle("/echo", echoHandler); err == nil {
+		t.Errorf("expect error for the duplicated path")
+	}
+	cli, err := Dial(s.Addr().String(), nil, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cli.Close()
+	var tests = []struct {
+		t       Type
+		code    Code
+		path    string
+		resp    Code
+		payload string
+	}{
+		{t: Confirmable, code: POST, path: "/echo", resp: Changed, payload: "hello"},
+		{t: NonConfirmable, code: POST, path: "/echo", resp: Changed, payload: "hello"},
+		{t: Confirmable, code: GET, path: "/echo", resp: MethodNotAllowed},
+		{t: Confirmable, code: POST, path: "/unknown", resp: NotFound},
+	}
+	for i, tt := range tests {
+		req := &Message{Type: tt.t, Code: tt.code, Payload: []byte(tt.payload)}
+		req.SetPath(tt.path)
+		resp, err := cli.Do(req)
+		if err != nil {
+			t.Errorf("%d: %v", i, err)
+			continue
+		}
+		if resp.Code != tt.resp || string(resp.Payload) != tt.payload {
+			t.Errorf("%d: response mismatch, got %s %s", i, resp.Code, resp.Payload)
+		}
+	}
+	s.Remove("/echo")
+	req := &Message{Type: Confirmable, Code: POST}
+	req.SetPath("/echo")
+	if resp, err := cli.Do(req); err != nil || resp.Code != NotFound {
+		t.Errorf("expect 4.04 after removing the handler, got %v %v", resp, err)
+	}
+}
+
+func TestDTLS(t *testing.T) {
+	sc := &DTLSConf{PskIdentity: "ekuiper", Psk: "0x73656372657431"}
+	s, err := AcquireServer("127.0.0.1:0", sc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ReleaseServer(s)
+	if _, err := AcquireServer("127.0.0.1:0", &DTLSConf{}); err == nil {
+		t.Errorf("expect error for the different dtls configuration")
+	}
+	if err := s.Handle("/echo", echoHandler); err != nil {
+		t.Fatal(err)
+	}
+	cli, err := Dial(s.Addr().String(), &DTLSConf{PskIdentity: "ekuiper", Psk: "secret1"}, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cli.Close()
+	req := &Message{Type: Confirmable, Code: POST, Payload: []byte("hello")}
+	req.SetPath("/echo")
+	resp, err := cli.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.Code != Changed || string(resp.Payload) != "hello" {
+		t.Errorf("response mismatch, got %s %s", resp.Code, resp.Payload)
+	}
+	if _, err := Dial(s.Addr().String(), &DTLSConf{PskIdentity: "ekuiper", Psk: "wrong"}, time.Second); err == nil {
+		t.Errorf("expect handshake error for the wrong key")
+	}
+	if _, err := Dial(s.Addr().String(), &DTLSConf{PskIdentity: "other", Psk: "secret1"}, time.Second); err == nil {
+		t.Errorf("expect handshake error for the unknown identity")
+	}
+}
+
+// observableServer answers the observe registration and then sends the notifications including a stale one
+func observableServer(t *testing.T) (net.PacketConn, chan *Message) {
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	received := make(chan *Message, 10)
+	go func() {
+		buf := make([]byte, maxDatagramSize)
+		for {
+			n, remote, err := pc.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			req, err := Unmarshal(buf[:n])
+			if err != nil {
+				continue
+			}
+			received <- req
+			if req.Code != GET {
+				continue
+			}
+			write := func(m *Message) {
+				b, _ := m.Marshal()
+				_, _ = pc.WriteTo(b, remote)
+			}
+			if v, _ := req.UintOption(Observe); v != 0 {
+				write(&Message{Type: Acknowledgement, Code: Content, MessageID: req.MessageID, Token: req.Token})
+				continue
+			}
+			resp := &Message{Type: Acknowledgement, Code: Content, MessageID: req.MessageID, Token: req.Token, Payload: []byte("1")}
+			resp.SetUintOption(Observe, 10)
+			write(resp)
+			for i, seq := range []uint32{11, 9, 12} {
+				n := &Message{Type: Confirmable, Code: Content, MessageID: uint16(100 + i), Token: req.Token, Payload: []byte{byte('2' + i)}}
+				n.SetUintOption(Observe, seq)
+				write(n)
+			}
+		}
+	}()
+	return pc, received
+}
+
+func TestObserve(t *testing.T) {
+	pc, received := observableServer(t)
+	defer pc.Close()
+	cli, err := Dial(pc.LocalAddr().String(), nil, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cli.Close()
+	notes := make(chan *Message, 10)
+	req := &Message{Type: Confirmable}
+	req.SetPath("/temp")
+	obs, first, err := cli.Observe(req, func(m *Message) { notes <- m })
+	if err != nil {
+		t.Fatal(err)
+	}
+	if obs == nil || string(first.Payload) != "1" {
+		t.Fatalf("first response mismatch, got %v %v", obs, first)
+	}
+	var payloads []string
+	timeout := time.After(2 * time.Second)
+	for len(payloads) < 2 {
+		select {
+		case m := <-notes:
+			payloads = append(payloads, string(m.Payload))
+		case <-timeout:
+			t.Fatalf("notification timeout, got %v", payloads)
+		}
+	}
+	if !reflect.DeepEqual(payloads, []string{"2", "4"}) {
+		t.Errorf("notifications mismatch, the stale one must be dropped, got %v", payloads)
+	}
+	obs.Cancel()
+	for {
+		select {
+		case m := <-received:
+			if v, ok := m.UintOption(Observe); m.Code == GET && ok && v == 1 {
+				return
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatal("deregistration is not received")
+		}
+	}
+}
@@ -0,0 +1,166 @@
+// Copyright 2021 EMQ Technologies Co., Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package coapx
+
+import (
+	"context"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/pion/dtls/v2"
+	"net"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	SCHEME_COAP  = "coap"
+	SCHEME_COAPS = "coaps"
+	DEFAULT_PORT = 5683
+	DTLS_PORT    = 5684
+
+	DEFAULT_TIMEOUT = 10000
+	// The time to wait for the dtls handshake
+	handshakeTimeout = 10 * time.Second
+)
+
+// DTLSConf is the pre-shared key of DTLS. DTLS is enabled if the key is set.
+type DTLSConf struct {
+	PskIdentity string `json:"pskIdentity"`
+	// The key in plain text, or in hex with the prefix 0x
+	Psk string `json:"psk"`
+}
+
+func (c *DTLSConf) Enabled() bool {
+	return c.Psk != ""
+}
+
+func (c *DTLSConf) Validate() error {
+	if c.Psk == "" {
+		if c.PskIdentity != "" {
+			return errors.New("property psk is required if pskIdentity is set")
+		}
+		return nil
+	}
+	_, err := c.key()
+	return err
+}
+
+func (c *DTLSConf) key() ([]byte, error) {
+	if strings.HasPrefix(c.Psk, "0x") {
+		k, err := hex.DecodeString(c.Psk[2:])
+		if err != nil {
+			return nil, fmt.Errorf("invalid property psk: %v", err)
+		}
+		return k, nil
+	}
+	return []byte(c.Psk), nil
+}
+
+func (c *DTLSConf) equal(o *DTLSConf) bool {
+	return c.Psk == o.Psk && c.PskIdentity == o.PskIdentity
+}
+
+var pskCipherSuites = []dtls.CipherSuiteID{dtls.TLS_PSK_WITH_AES_128_CCM_8, dtls.TLS_PSK_WITH_AES_128_GCM_SHA256, dtls.TLS_PSK_WITH_AES_128_CCM}
+
+// clientConfig returns the config of which the handshake is bounded by the timeout
+func (c *DTLSConf) clientConfig(timeout time.Duration) (*dtls.Config, error) {
+	k, err := c.key()
+	if err != nil {
+		return nil, err
+	}
+	return &dtls.Config{
+		PSK:             func([]byte) ([]byte, error) { return k, nil },
+		PSKIdentityHint: []byte(c.PskIdentity),
+		CipherSuites:    pskCipherSuites,
+		ConnectContextMaker: func() (context.Context, func()) {
+			return context.WithTimeout(context.Background(), timeout)
+		},
+	}, nil
+}
+
+// serverConfig returns the config which rejects the clients of other identities if the identity is set
+func (c *DTLSConf) serverConfig() (*dtls.Config, error) {
+	k, err := c.key()
+	if err != nil {
+		return nil, err
+	}
+	return &dtls.Config{
+		PSK: func(identity []byte) ([]byte, error) {
+			if c.PskIdentity != "" && string(identity) != c.PskIdentity {
+				return nil, fmt.Errorf("unknown psk identity %s", identity)
+			}
+			return k, nil
+		},
+		CipherSuites: pskCipherSuites,
+		ConnectContextMaker: func() (context.Context, func()) {
+			return context.WithTimeout(context.Background(), handshakeTimeout)
+		},
+	}, nil
+}
+
+// ParseUrl parses the coap or coaps url. It returns the address with the default port and the path.
+func ParseUrl(rawUrl string) (addr string, path string, query []string, secure bool, err error) {
+	u, err := url.Parse(rawUrl)
+	if err != nil {
+		return "", "", nil, false, fmt.Errorf("invalid url %s: %v", rawUrl, err)
+	}
+	port := DEFAULT_PORT
+	switch u.Scheme {
+	case SCHEME_COAP:
+	case SCHEME_COAPS:
+		secure = true
+		port = DTLS_PORT
+	default:
+		return "", "", nil, false, fmt.Errorf("invalid url %s, must start with coap:// or coaps://", rawUrl)
+	}
+	if u.Hostname() == "" {
+		return "", "", nil, false, fmt.Errorf("invalid url %s, host is missing", rawUrl)
+	}
+	addr = u.Host
+	if u.Port() == "" {
+		addr = net.JoinHostPort(u.Hostname(), fmt.Sprint(port))
+	}
+	if u.RawQuery != "" {
+		query = strings.Split(u.RawQuery, "&")
+	}
+	return addr, u.Path, query, secure, nil
+}
+
+const (
+	MODE_CLIENT = "client"
+	MODE_SERVER = "server"
+)
+
+// GetMode returns the mode of the source. If not set, it is client mode if the url is set, otherwise server mode.
+func GetMode(mode string, u string) (string, error) {
+	switch strings.ToLower(mode) {
+	case "":
+		if u != "" {
+			return MODE_CLIENT, nil
+		}
+		return MODE_SERVER, nil
+	case MODE_CLIENT:
+		if u == "" {
+			return "", errors.New("property url is required in client mode")
+		}
+		return MODE_CLIENT, nil
+	case MODE_SERVER:
+		return MODE_SERVER, nil
+	default:
+		return "", fmt.Errorf("invalid property mode: %s, must be client or server", mode)
+	}
+}
@@ -0,0 +1,328 @@
+// Copyright 2021 EMQ Technologies Co., Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package coapx provides the CoAP (RFC 7252) client and server over UDP or DTLS for the coap source and sink.
+// The observe extension (RFC 7641) is supported while the block-wise transfer is not, so the payload must fit
+// in one datagram.
+package coapx
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+type Type uint8
+
+const (
+	Confirmable Type = iota
+	NonConfirmable
+	Acknowledgement
+	Reset
+)
+
+// Code is the method code of the requests or the response code. It is written as c.dd such as 2.05 in the doc.
+type Code uint8
+
+const (
+	Empty  Code = 0
+	GET    Code = 1
+	POST   Code = 2
+	PUT    Code = 3
+	DELETE Code = 4
+
+	Created               Code = 65
+	Deleted               Code = 66
+	Valid                 Code = 67
+	Changed               Code = 68
+	Content               Code = 69
+	BadRequest            Code = 128
+	Unauthorized          Code = 129
+	NotFound              Code = 132
+	MethodNotAllowed      Code = 133
+	RequestEntityTooLarge Code = 141
+	InternalServerError   Code = 160
+	ServiceUnavailable    Code = 163
+)
+
+var methods = map[string]Code{"GET": GET, "POST": POST, "PUT": PUT, "DELETE": DELETE}
+
+// ParseMethod returns the code of the method name
+func ParseMethod(m string) (Code, error) {
+	if c, ok := methods[strings.ToUpper(m)]; ok {
+		return c, nil
+	}
+	return 0, fmt.Errorf("invalid method %s, must be GET, POST, PUT or DELETE", m)
+}
+
+func (c Code) String() string {
+	return fmt.Sprintf("%d.%02d", c>>5, c&0x1f)
+}
+
+// IsSuccess returns true for the 2.xx response codes
+func (c Code) IsSuccess() bool {
+	return c>>5 == 2
+}
+
+// OptionID is the number of the option. Only the options used by eKuiper are defined.
+type OptionID uint16
+
+const (
+	IfMatch       OptionID = 1
+	URIHost       OptionID = 3
+	ETag          OptionID = 4
+	Observe       OptionID = 6
+	URIPort       OptionID = 7
+	URIPath       OptionID = 11
+	ContentFormat OptionID = 12
+	MaxAge        OptionID = 14
+	URIQuery      OptionID = 15
+	Accept        OptionID = 17
+)
+
+// The content formats of the common media types
+const (
+	TextPlain     = 0
+	AppOctets     = 42
+	AppJSON       = 50
+	AppCBOR       = 60
+	DefaultMaxAge = 60
+)
+
+type Option struct {
+	ID    OptionID
+	Value []byte
+}
+
+// Message is a CoAP message. The options are kept sorted by the option number when encoding.
+type Message struct {
+	Type      Type
+	Code      Code
+	MessageID uint16
+	Token     []byte
+	Options   []Option
+	Payload   []byte
+}
+
+var errMessageFormat = errors.New("invalid coap message format")
+
+// Marshal encodes the message in the datagram format
+func (m *Message) Marshal() ([]byte, error) {
+	if len(m.Token) > 8 {
+		return nil, errors.New("token is longer than 8 bytes")
+	}
+	b := make([]byte, 4, 4+len(m.Token)+len(m.Payload)+16)
+	b[0] = 1<<6 | byte(m.Type)<<4 | byte(len(m.Token))
+	b[1] = byte(m.Code)
+	binary.BigEndian.PutUint16(b[2:], m.MessageID)
+	b = append(b, m.Token...)
+	opts := append([]Option(nil), m.Options...)
+	sort.SliceStable(opts, func(i, j int) bool { return opts[i].ID < opts[j].ID })
+	var prev OptionID
+	for _, o := range opts {
+		delta := int(o.ID - prev)
+		prev = o.ID
+		dn, dext := optionNibble(delta)
+		ln, lext := optionNibble(len(o.Value))
+		b = append(b, byte(dn<<4|ln))
+		b = append(b, dext...)
+		b = append(b, lext...)
+		b = append(b, o.Value...)
+	}
+	if len(m.Payload) > 0 {
+		b = append(b, 0xff)
+		b = append(b, m.Payload...)
+	}
+	return b, nil
+}
+
+// optionNibble returns the 4 bits value and the extended bytes of the option delta or length
+func optionNibble(v int) (int, []byte) {
+	switch {
+	case v < 13:
+		return v, nil
+	case v < 269:
+		return 13, []byte{byte(v - 13)}
+	default:
+		ext := make([]byte, 2)
+		binary.BigEndian.PutUint16(ext, uint16(v-269))
+		return 14, ext
+	}
+}
+
+// Unmarshal decodes the datagram
+func Unmarshal(data []byte) (*Message, error) {
+	if len(data) < 4 || data[0]>>6 != 1 {
+		return nil, errMessageFormat
+	}
+	tkl := int(data[0] & 0x0f)
+	if tkl > 8 || len(data) < 4+tkl {
+		return nil, errMessageFormat
+	}
+	m := &Message{
+		Type:      Type(data[0] >> 4 & 0x03),
+		Code:      Code(data[1]),
+		MessageID: binary.BigEndian.Uint16(data[2:]),
+		Token:     append([]byte(nil), data[4:4+tkl]...),
+	}
+	b := data[4+tkl:]
+	var id int
+	for len(b) > 0 {
+		if b[0] == 0xff {
+			if len(b) == 1 {
+				return nil, errMessageFormat
+			}
+			m.Payload = append([]byte(nil), b[1:]...)
+			break
+		}
+		dn, ln := int(b[0]>>4), int(b[0]&0x0f)
+		b = b[1:]
+		var (
+			delta, length int
+			err           error
+		)
+		if delta, b, err = readNibble(dn, b); err != nil {
+			return nil, err
+		}
+		if length, b, err = readNibble(ln, b); err != nil {
+			return nil, err
+		}
+		if len(b) < length {
+			return nil, errMessageFormat
+		}
+		id += delta
+		m.Options = append(m.Options, Option{ID: OptionID(id), Value: append([]byte(nil), b[:length]...)})
+		b = b[length:]
+	}
+	return m, nil
+}
+
+func readNibble(n int, b []byte) (int, []byte, error) {
+	switch n {
+	case 13:
+		if len(b) < 1 {
+			return 0, nil, errMessageFormat
+		}
+		return int(b[0]) + 13, b[1:], nil
+	case 14:
+		if len(b) < 2 {
+			return 0, nil, errMessageFormat
+		}
+		return int(binary.BigEndian.Uint16(b)) + 269, b[2:], nil
+	case 15:
+		return 0, nil, errMessageFormat
+	default:
+		return n, b, nil
+	}
+}
+
+// Option returns the value of the first option of the id
+func (m *Message) Option(id OptionID) ([]byte, bool) {
+	for _, o := range m.Options {
+		if o.ID == id {
+			return o.Value, true
+		}
+	}
+	return nil, false
+}
+
+// UintOption returns the value of the first option of the id as unsigned integer
+func (m *Message) UintOption(id OptionID) (uint32, bool) {
+	v, ok := m.Option(id)
+	if !ok {
+		return 0, false
+	}
+	return decodeUint(v), true
+}
+
+func (m *Message) SetUintOption(id OptionID, v uint32) {
+	m.RemoveOption(id)
+	m.Options = append(m.Options, Option{ID: id, Value: encodeUint(v)})
+}
+
+func (m *Message) RemoveOption(id OptionID) {
+	opts := m.Options[:0]
+	for _, o := range m.Options {
+		if o.ID != id {
+			opts = append(opts, o)
+		}
+	}
+	m.Options = opts
+}
+
+// Path returns the Uri-Path options joined by / with a leading /
+func (m *Message) Path() string {
+	var b strings.Builder
+	for _, o := range m.Options {
+		if o.ID == URIPath {
+			b.WriteByte('/')
+			b.Write(o.Value)
+		}
+	}
+	if b.Len() == 0 {
+		return "/"
+	}
+	return b.String()
+}
+
+// SetPath sets the Uri-Path options by the segments of the path
+func (m *Message) SetPath(p string) {
+	m.RemoveOption(URIPath)
+	for _, s := range strings.Split(strings.Trim(p, "/"), "/") {
+		if s != "" {
+			m.Options = append(m.Options, Option{ID: URIPath, Value: []byte(s)})
+		}
+	}
+}
+
+// Queries returns the Uri-Query options
+func (m *Message) Queries() []string {
+	var result []string
+	for _, o := range m.Options {
+		if o.ID == URIQuery {
+			result = append(result, string(o.Value))
+		}
+	}
+	return result
+}
+
+func (m *Message) AddQuery(q string) {
+	m.Options = append(m.Options, Option{ID: URIQuery, Value: []byte(q)})
+}
+
+// encodeUint encodes the unsigned integer option in the minimal bytes
+func encodeUint(v uint32) []byte {
+	switch {
+	case v == 0:
+		return nil
+	case v < 1<<8:
+		return []byte{byte(v)}
+	case v < 1<<16:
+		return []byte{byte(v >> 8), byte(v)}
+	case v < 1<<24:
+		return []byte{byte(v >> 16), byte(v >> 8), byte(v)}
+	default:
+		return []byte{byte(v >> 24), byte(v >> 16), byte(v >> 8), byte(v)}
+	}
+}
+
+func decodeUint(b []byte) uint32 {
+	var v uint32
+	for _, c := range b {
+		v = v<<8 | uint32(c)
+	}
+	return v
+}
@@ -0,0 +1,275 @@
+// Copyright 2021 EMQ Technologies Co., Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package coapx
+
+import (
+	"fmt"
+	"github.com/lf-edge/ekuiper/internal/conf"
+	"github.com/pion/dtls/v2"
+	"github.com/pion/udp"
+	"net"
+	"sync"
+	"time"
+)
+
+const (
+	maxDatagramSize = 65535
+	// The time to keep the response of a confirmable request to answer the duplicated requests
+	exchangeLifetime = 247 * time.Second
+)
+
+// Handler handles the request of a path and returns the response code and payload
+type Handler func(req *Message, remote net.Addr) (Code, []byte)
+
+// The servers are shared by the sources of different paths with the same listening address
+var (
+	serversMu sync.Mutex
+	servers   = make(map[string]*Server)
+)
+
+// Server is a CoAP server listening to an UDP address with or without DTLS
+type Server struct {
+	addr string
+	dtls *DTLSConf
+	refs int
+
+	mu       sync.RWMutex
+	handlers map[string]Handler
+
+	pc net.PacketConn
+	ln net.Listener
+
+	dedupMu   sync.Mutex
+	dedup     map[string]*dedupEntry
+	lastSweep time.Time
+}
+
+type dedupEntry struct {
+	resp   *Message
+	expire time.Time
+}
+
+// AcquireServer returns the server listening to the address. It is started if not exist.
+func AcquireServer(addr string, c *DTLSConf) (*Server, error) {
+	serversMu.Lock()
+	defer serversMu.Unlock()
+	if s, ok := servers[addr]; ok {
+		if !s.dtls.equal(c) {
+			return nil, fmt.Errorf("coap server %s is already started with different dtls configuration", addr)
+		}
+		s.refs++
+		return s, nil
+	}
+	s := &Server{addr: addr, dtls: c, refs: 1, handlers: make(map[string]Handler), dedup: make(map[string]*dedupEntry)}
+	if err := s.listen(); err != nil {
+		return nil, err
+	}
+	servers[addr] = s
+	return s, nil
+}
+
+// ReleaseServer releases the reference of the server. The last release stops the server.
+func ReleaseServer(s *Server) {
+	serversMu.Lock()
+	defer serversMu.Unlock()
+	s.refs--
+	if s.refs > 0 {
+		return
+	}
+	delete(servers, s.addr)
+	if s.pc != nil {
+		_ = s.pc.Close()
+	}
+	if s.ln != nil {
+		_ = s.ln.Close()
+	}
+}
+
+func (s *Server) listen() error {
+	if !s.dtls.Enabled() {
+		pc, err := net.ListenPacket("udp", s.addr)
+		if err != nil {
+			return fmt.Errorf("coap server fails to listen to %s: %v", s.addr, err)
+		}
+		s.pc = pc
+		go s.servePacket()
+		return nil
+	}
+	cfg, err := s.dtls.serverConfig()
+	if err != nil {
+		return err
+	}
+	laddr, err := net.ResolveUDPAddr("udp", s.addr)
+	if err != nil {
+		return fmt.Errorf("coap server fails to resolve %s: %v", s.addr, err)
+	}
+	ln, err := udp.Listen("udp", laddr)
+	if err != nil {
+		return fmt.Errorf("coap server fails to listen to %s: %v", s.addr, err)
+	}
+	s.ln = ln
+	go s.serveDTLS(cfg)
+	return nil
+}
+
+// Addr returns the listening address which has the actual port if listening to port 0
+func (s *Server) Addr() net.Addr {
+	if s.pc != nil {
+		return s.pc.LocalAddr()
+	}
+	return s.ln.Addr()
+}
+
+// Handle registers the handler of the path. Only one handler can be registered for a path.
+func (s *Server) Handle(path string, h Handler) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.handlers[path]; ok {
+		return fmt.Errorf("coap path %s is already handled in server %s", path, s.addr)
+	}
+	s.handlers[path] = h
+	return nil
+}
+
+func (s *Server) Remove(path string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.handlers, path)
+}
+
+func (s *Server) servePacket() {
+	buf := make([]byte, maxDatagramSize)
+	for {
+		n, remote, err := s.pc.ReadFrom(buf)
+		if err != nil {
+			// closed
+			return
+		}
+		req, err := Unmarshal(buf[:n])
+		if err != nil {
+			conf.Log.Debugf("coap server %s drops invalid message from %s: %v", s.addr, remote, err)
+			continue
+		}
+		if resp := s.serve(req, remote); resp != nil {
+			if b, err := resp.Marshal(); err == nil {
+				_, _ = s.pc.WriteTo(b, remote)
+			}
+		}
+	}
+}
+
+func (s *Server) serveDTLS(cfg *dtls.Config) {
+	for {
+		c, err := s.ln.Accept()
+		if err != nil {
+			return
+		}
+		go func() {
+			dc, err := dtls.Server(c, cfg)
+			if err != nil {
+				conf.Log.Warnf("coap server %s dtls handshake with %s fails: %v", s.addr, c.RemoteAddr(), err)
+				_ = c.Close()
+				return
+			}
+			defer dc.Close()
+			buf := make([]byte, maxDatagramSize)
+			for {
+				n, err := dc.Read(buf)
+				if err != nil {
+					return
+				}
+				req, err := Unmarshal(buf[:n])
+				if err != nil {
+					continue
+				}
+				if resp := s.serve(req, dc.RemoteAddr()); resp != nil {
+					if b, err := resp.Marshal(); err == nil {
+						if _, err := dc.Write(b); err != nil {
+							return
+						}
+					}
+				}
+			}
+		}()
+	}
+}
+
+// serve handles the request and returns the response to send back. The duplicated request is answered by the
+// cached response without calling the handler again.
+func (s *Server) serve(req *Message, remote net.Addr) *Message {
+	switch req.Type {
+	case Acknowledgement, Reset:
+		return nil
+	}
+	if req.Code == Empty || !isRequest(req.Code) {
+		// ping or unexpected response
+		if req.Type == Confirmable {
+			return &Message{Type: Reset, MessageID: req.MessageID}
+		}
+		return nil
+	}
+	key := fmt.Sprintf("%s|%d", remote, req.MessageID)
+	if resp := s.cached(key); resp != nil {
+		return resp
+	}
+	s.mu.RLock()
+	h, ok := s.handlers[req.Path()]
+	s.mu.RUnlock()
+	code, payload := NotFound, []byte(nil)
+	if ok {
+		code, payload = h(req, remote)
+	}
+	resp := &Message{Code: code, Token: req.Token, Payload: payload}
+	if req.Type == Confirmable {
+		resp.Type = Acknowledgement
+		resp.MessageID = req.MessageID
+	} else {
+		resp.Type = NonConfirmable
+		resp.MessageID = nextMessageID()
+	}
+	if len(payload) > 0 {
+		resp.SetUintOption(ContentFormat, TextPlain)
+	}
+	s.cache(key, resp)
+	return resp
+}
+
+func isRequest(c Code) bool {
+	return c >= GET && c <= DELETE
+}
+
+func (s *Server) cached(key string) *Message {
+	s.dedupMu.Lock()
+	defer s.dedupMu.Unlock()
+	if e, ok := s.dedup[key]; ok && time.Now().Before(e.expire) {
+		return e.resp
+	}
+	return nil
+}
+
+func (s *Server) cache(key string, resp *Message) {
+	s.dedupMu.Lock()
+	defer s.dedupMu.Unlock()
+	now := time.Now()
+	s.dedup[key] = &dedupEntry{resp: resp, expire: now.Add(exchangeLifetime)}
+	if now.Sub(s.lastSweep) > 10*time.Second {
+		for k, e := range s.dedup {
+			if now.After(e.expire) {
+				delete(s.dedup, k)
+			}
+		}
+		s.lastSweep = now
+	}
+}
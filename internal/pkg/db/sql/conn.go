@@ -0,0 +1,61 @@
+// Copyright 2021 EMQ Technologies Co., Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sql
+
+import (
+	"database/sql"
+	"fmt"
+	"github.com/lf-edge/ekuiper/internal/conf"
+	_ "github.com/mattn/go-sqlite3"
+	"path/filepath"
+	"strings"
+)
+
+const DRIVER_SQLITE = "sqlite3"
+
+// Open connects to the database of the database/sql driver. For sqlite, the relative file path
+// is resolved in the data directory.
+func Open(driver string, dsn string) (*sql.DB, error) {
+	found := false
+	for _, d := range sql.Drivers() {
+		if d == driver {
+			found = true
+			break
+		}
+	}
+	if !found {
+		return nil, fmt.Errorf("unknown sql driver %s, available drivers are %v", driver, sql.Drivers())
+	}
+	if driver == DRIVER_SQLITE && !strings.HasPrefix(dsn, "file:") && dsn != ":memory:" && !filepath.IsAbs(dsn) {
+		dataDir, err := conf.GetDataLoc()
+		if err != nil {
+			return nil, err
+		}
+		dsn = filepath.Join(dataDir, dsn)
+	}
+	db, err := sql.Open(driver, dsn)
+	if err != nil {
+		return nil, fmt.Errorf("fail to open %s database %s: %v", driver, dsn, err)
+	}
+	if err = db.Ping(); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("fail to connect %s database %s: %v", driver, dsn, err)
+	}
+	if driver == DRIVER_SQLITE {
+		// Avoid the database is locked error of concurrent writing
+		db.SetMaxOpenConns(1)
+	}
+	return db, nil
+}
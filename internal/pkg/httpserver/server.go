@@ -0,0 +1,169 @@
+// Copyright 2021 EMQ Technologies Co., Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package httpserver provides the embedded http server shared by the sources and sinks
+// which accept connections such as the http push source and the websocket source and sink.
+package httpserver
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"github.com/lf-edge/ekuiper/internal/conf"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const DEFAULT_PORT = 10081
+
+// server is started when the first endpoint is registered and stopped when the last endpoint is removed.
+type server struct {
+	sync.RWMutex
+	endpoints map[string]http.Handler
+	server    *http.Server
+}
+
+var srv = &server{endpoints: make(map[string]http.Handler)}
+
+// RegisterEndpoint serves the exact path with the handler. The server will be started if not yet.
+func RegisterEndpoint(path string, handler http.Handler) error {
+	srv.Lock()
+	defer srv.Unlock()
+	if _, ok := srv.endpoints[path]; ok {
+		return fmt.Errorf("endpoint %s is already registered", path)
+	}
+	if srv.server == nil {
+		if err := srv.start(); err != nil {
+			return err
+		}
+	}
+	srv.endpoints[path] = handler
+	return nil
+}
+
+// UnregisterEndpoint removes the handler of the path. The server will be stopped if no endpoint is left.
+func UnregisterEndpoint(path string) {
+	srv.Lock()
+	defer srv.Unlock()
+	delete(srv.endpoints, path)
+	if len(srv.endpoints) == 0 && srv.server != nil {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := srv.server.Shutdown(ctx); err != nil {
+			conf.Log.Warnf("shutdown embedded http server error: %v", err)
+		}
+		srv.server = nil
+		conf.Log.Infof("embedded http server stopped")
+	}
+}
+
+// start listens synchronously so that the error like port in use can be reported to the caller
+func (s *server) start() error {
+	ip, port := "0.0.0.0", DEFAULT_PORT
+	var certfile, keyfile string
+	if conf.Config != nil {
+		if conf.Config.Source.HttpServerIp != "" {
+			ip = conf.Config.Source.HttpServerIp
+		}
+		if conf.Config.Source.HttpServerPort > 0 {
+			port = conf.Config.Source.HttpServerPort
+		}
+		if t := conf.Config.Source.HttpServerTls; t != nil {
+			certfile, keyfile = t.Certfile, t.Keyfile
+		}
+	}
+	addr := fmt.Sprintf("%s:%d", ip, port)
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return fmt.Errorf("fail to start embedded http server at %s: %v", addr, err)
+	}
+	// No write timeout as the handler may serve long-lived connections like websocket. The read deadline
+	// is cleared when the connection is hijacked, see hijackWriter.
+	srv := &http.Server{
+		Handler:           s,
+		ReadHeaderTimeout: 30 * time.Second,
+		ReadTimeout:       60 * time.Second,
+		IdleTimeout:       120 * time.Second,
+	}
+	go func() {
+		var err error
+		if certfile == "" {
+			err = srv.Serve(ln)
+		} else {
+			err = srv.ServeTLS(ln, certfile, keyfile)
+		}
+		if err != nil && err != http.ErrServerClosed {
+			conf.Log.Errorf("embedded http server error: %v", err)
+		}
+	}()
+	s.server = srv
+	conf.Log.Infof("embedded http server started at %s", addr)
+	return nil
+}
+
+func (s *server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.RLock()
+	h, ok := s.endpoints[r.URL.Path]
+	s.RUnlock()
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	if hj, ok := w.(http.Hijacker); ok {
+		w = &hijackWriter{ResponseWriter: w, hijacker: hj}
+	}
+	h.ServeHTTP(w, r)
+}
+
+// hijackWriter clears the deadlines set by the server timeouts on the hijacked connection such as the
+// websocket whose lifetime is managed by the handler
+type hijackWriter struct {
+	http.ResponseWriter
+	hijacker http.Hijacker
+}
+
+func (w *hijackWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	conn, rw, err := w.hijacker.Hijack()
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := conn.SetDeadline(time.Time{}); err != nil {
+		conn.Close()
+		return nil, nil, err
+	}
+	return conn, rw, nil
+}
+
+// Receiver is implemented by the endpoint handlers which accept the data delivered in process
+type Receiver interface {
+	Receive(body []byte, meta map[string]interface{}) error
+}
+
+// Deliver sends the body to the handler of the path in process without going through the network. It is
+// used to feed the data produced by the rules back into the streams listening to the endpoint.
+func Deliver(path string, body []byte, meta map[string]interface{}) error {
+	srv.RLock()
+	h, ok := srv.endpoints[path]
+	srv.RUnlock()
+	if !ok {
+		return fmt.Errorf("endpoint %s is not registered", path)
+	}
+	r, ok := h.(Receiver)
+	if !ok {
+		return fmt.Errorf("endpoint %s does not accept delivered data", path)
+	}
+	return r.Receive(body, meta)
+}
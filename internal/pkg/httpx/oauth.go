@@ -0,0 +1,141 @@
+// Copyright 2021 EMQ Technologies Co., Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package httpx
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/lf-edge/ekuiper/internal/conf"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// The token is refreshed this time before it expires
+const tokenExpiryDelta = 10 * time.Second
+
+// OAuthConf is the configuration of the OAuth2 client credentials grant
+type OAuthConf struct {
+	TokenUrl     string   `json:"tokenUrl"`
+	ClientId     string   `json:"clientId"`
+	ClientSecret string   `json:"clientSecret"`
+	Scopes       []string `json:"scopes"`
+	// header: send the client credentials by basic auth header; params: send them in the form
+	AuthStyle string `json:"authStyle"`
+}
+
+// TokenSource fetches the access token by the client credentials grant and caches it until expired
+type TokenSource struct {
+	conf   *OAuthConf
+	client *http.Client
+
+	mu     sync.Mutex
+	token  string
+	expiry time.Time
+}
+
+func NewTokenSource(c *OAuthConf, client *http.Client) (*TokenSource, error) {
+	if c.TokenUrl == "" {
+		return nil, fmt.Errorf("oauth tokenUrl is required")
+	}
+	if c.ClientId == "" {
+		return nil, fmt.Errorf("oauth clientId is required")
+	}
+	switch c.AuthStyle {
+	case "":
+		c.AuthStyle = "header"
+	case "header", "params":
+	default:
+		return nil, fmt.Errorf("invalid oauth authStyle %s, the value could be only header or params", c.AuthStyle)
+	}
+	return &TokenSource{conf: c, client: client}, nil
+}
+
+// Authorization returns the value of the Authorization header. The token is fetched if not exist or expired.
+func (t *TokenSource) Authorization() (string, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.token != "" && (t.expiry.IsZero() || conf.GetNow().Add(tokenExpiryDelta).Before(t.expiry)) {
+		return t.token, nil
+	}
+	if err := t.refresh(); err != nil {
+		return "", err
+	}
+	return t.token, nil
+}
+
+// Invalidate drops the cached token so that it will be fetched again, usually because it is rejected by the server
+func (t *TokenSource) Invalidate() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.token = ""
+}
+
+func (t *TokenSource) refresh() error {
+	form := url.Values{}
+	form.Set("grant_type", "client_credentials")
+	if len(t.conf.Scopes) > 0 {
+		form.Set("scope", strings.Join(t.conf.Scopes, " "))
+	}
+	if t.conf.AuthStyle == "params" {
+		form.Set("client_id", t.conf.ClientId)
+		form.Set("client_secret", t.conf.ClientSecret)
+	}
+	req, err := http.NewRequest(http.MethodPost, t.conf.TokenUrl, strings.NewReader(form.Encode()))
+	if err != nil {
+		return fmt.Errorf("fail to create token request: %v", err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	if t.conf.AuthStyle == "header" {
+		req.SetBasicAuth(url.QueryEscape(t.conf.ClientId), url.QueryEscape(t.conf.ClientSecret))
+	}
+	resp, err := t.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("fail to fetch token: %v", err)
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("fail to read token response: %v", err)
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("fail to fetch token with status %d: %s", resp.StatusCode, body)
+	}
+	tr := &struct {
+		AccessToken string `json:"access_token"`
+		TokenType   string `json:"token_type"`
+		ExpiresIn   int64  `json:"expires_in"`
+	}{}
+	if err := json.Unmarshal(body, tr); err != nil {
+		return fmt.Errorf("invalid token response %s: %v", body, err)
+	}
+	if tr.AccessToken == "" {
+		return fmt.Errorf("no access_token in token response %s", body)
+	}
+	tokenType := tr.TokenType
+	if tokenType == "" || strings.EqualFold(tokenType, "bearer") {
+		tokenType = "Bearer"
+	}
+	t.token = tokenType + " " + tr.AccessToken
+	if tr.ExpiresIn > 0 {
+		t.expiry = conf.GetNow().Add(time.Duration(tr.ExpiresIn) * time.Second)
+	} else {
+		t.expiry = time.Time{}
+	}
+	return nil
+}
@@ -0,0 +1,227 @@
+// Copyright 2021 EMQ Technologies Co., Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package linex parses the text lines such as logs into fields by a regular expression with named groups or
+// a grok expression. It is shared by the sources which read lines.
+package linex
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+)
+
+// Conf is the line parser configuration embedded in the source configurations. Only one of pattern and grok
+// can be set.
+type Conf struct {
+	// The regular expression whose named groups are the fields
+	Pattern string `json:"pattern"`
+	// The grok expression such as %{IP:client} %{WORD:method} %{NUMBER:bytes:int}
+	Grok string `json:"grok"`
+	// The custom grok patterns which can be referred in the grok expression
+	GrokPatterns map[string]string `json:"grokPatterns"`
+}
+
+func (c *Conf) Enabled() bool {
+	return c.Pattern != "" || c.Grok != ""
+}
+
+// Parser extracts the fields of a line. The fields are strings unless a type is specified in the grok expression.
+type Parser struct {
+	re    *regexp.Regexp
+	types map[string]string
+}
+
+// NewParser compiles the pattern or the grok expression of the configuration
+func NewParser(c *Conf) (*Parser, error) {
+	if c.Pattern != "" && c.Grok != "" {
+		return nil, errors.New("only one of property pattern and grok can be set")
+	}
+	p := &Parser{types: make(map[string]string)}
+	expr := c.Pattern
+	if c.Grok != "" {
+		g := &grok{custom: c.GrokPatterns, types: p.types}
+		var err error
+		expr, err = g.expand(c.Grok, 0)
+		if err != nil {
+			return nil, fmt.Errorf("invalid property grok: %v", err)
+		}
+	}
+	re, err := regexp.Compile(expr)
+	if err != nil {
+		if c.Grok != "" {
+			return nil, fmt.Errorf("invalid property grok: %v", err)
+		}
+		return nil, fmt.Errorf("invalid property pattern: %v", err)
+	}
+	hasName := false
+	for _, n := range re.SubexpNames() {
+		if n != "" {
+			hasName = true
+			break
+		}
+	}
+	if !hasName {
+		return nil, errors.New("the line pattern must have at least one named field")
+	}
+	p.re = re
+	return p, nil
+}
+
+// Parse returns the named fields of the line. The fields of the unmatched optional groups are omitted. It returns
+// false if the line does not match.
+func (p *Parser) Parse(line string) (map[string]interface{}, bool, error) {
+	loc := p.re.FindStringSubmatchIndex(line)
+	if loc == nil {
+		return nil, false, nil
+	}
+	result := make(map[string]interface{})
+	for i, n := range p.re.SubexpNames() {
+		if n == "" || loc[2*i] < 0 {
+			continue
+		}
+		// The same name could be used in the alternatives, take the matched one
+		if _, ok := result[n]; ok {
+			continue
+		}
+		v, err := convert(line[loc[2*i]:loc[2*i+1]], p.types[n])
+		if err != nil {
+			return nil, true, fmt.Errorf("field %s: %v", n, err)
+		}
+		result[n] = v
+	}
+	return result, true, nil
+}
+
+func convert(s string, t string) (interface{}, error) {
+	switch t {
+	case "int":
+		return strconv.Atoi(s)
+	case "float":
+		return strconv.ParseFloat(s, 64)
+	case "bool":
+		return strconv.ParseBool(s)
+	default:
+		return s, nil
+	}
+}
+
+const maxGrokDepth = 20
+
+var grokRef = regexp.MustCompile(`%\{(\w+)(?::([^:}]+))?(?::(\w+))?}`)
+var fieldName = regexp.MustCompile(`^\w+$`)
+
+type grok struct {
+	custom map[string]string
+	types  map[string]string
+}
+
+// expand replaces the pattern references recursively. The reference with a field name becomes a named group.
+func (g *grok) expand(expr string, depth int) (string, error) {
+	if depth > maxGrokDepth {
+		return "", errors.New("pattern references are nested too deep")
+	}
+	var err error
+	result := grokRef.ReplaceAllStringFunc(expr, func(ref string) string {
+		if err != nil {
+			return ""
+		}
+		m := grokRef.FindStringSubmatch(ref)
+		name, field, typ := m[1], m[2], m[3]
+		def, ok := g.custom[name]
+		if !ok {
+			def, ok = grokPatterns[name]
+		}
+		if !ok {
+			err = fmt.Errorf("unknown pattern %s", name)
+			return ""
+		}
+		def, err = g.expand(def, depth+1)
+		if err != nil {
+			return ""
+		}
+		if field == "" {
+			return "(?:" + def + ")"
+		}
+		if !fieldName.MatchString(field) {
+			err = fmt.Errorf("invalid field name %s, only letters, digits and underscore are allowed", field)
+			return ""
+		}
+		switch typ {
+		case "", "string":
+		case "int", "float", "bool":
+			g.types[field] = typ
+		default:
+			err = fmt.Errorf("invalid type %s of field %s, must be int, float, bool or string", typ, field)
+			return ""
+		}
+		return "(?P<" + field + ">" + def + ")"
+	})
+	if err != nil {
+		return "", err
+	}
+	return result, nil
+}
+
+// The built-in grok patterns, a subset of the logstash patterns in the RE2 syntax
+var grokPatterns = map[string]string{
+	"USERNAME":          `[a-zA-Z0-9._-]+`,
+	"USER":              `%{USERNAME}`,
+	"INT":               `[+-]?[0-9]+`,
+	"BASE10NUM":         `[+-]?(?:[0-9]+(?:\.[0-9]+)?|\.[0-9]+)`,
+	"NUMBER":            `%{BASE10NUM}`,
+	"BASE16NUM":         `[+-]?(?:0x)?[0-9A-Fa-f]+`,
+	"POSINT":            `[1-9][0-9]*`,
+	"NONNEGINT":         `[0-9]+`,
+	"WORD":              `\b\w+\b`,
+	"NOTSPACE":          `\S+`,
+	"SPACE":             `\s*`,
+	"DATA":              `.*?`,
+	"GREEDYDATA":        `.*`,
+	"QUOTEDSTRING":      `"(?:[^"\\]|\\.)*"|'(?:[^'\\]|\\.)*'`,
+	"UUID":              `[A-Fa-f0-9]{8}-(?:[A-Fa-f0-9]{4}-){3}[A-Fa-f0-9]{12}`,
+	"MAC":               `(?:[A-Fa-f0-9]{2}[:-]){5}[A-Fa-f0-9]{2}|(?:[A-Fa-f0-9]{4}\.){2}[A-Fa-f0-9]{4}`,
+	"IPV4":              `(?:(?:25[0-5]|2[0-4][0-9]|1[0-9]{2}|[1-9]?[0-9])\.){3}(?:25[0-5]|2[0-4][0-9]|1[0-9]{2}|[1-9]?[0-9])`,
+	"IPV6":              `(?:[0-9A-Fa-f]{0,4}:){2,7}[0-9A-Fa-f]{0,4}(?:%\w+)?`,
+	"IP":                `%{IPV6}|%{IPV4}`,
+	"HOSTNAME":          `\b[0-9A-Za-z][0-9A-Za-z-]{0,62}(?:\.[0-9A-Za-z][0-9A-Za-z-]{0,62})*\.?\b`,
+	"IPORHOST":          `%{IP}|%{HOSTNAME}`,
+	"HOSTPORT":          `%{IPORHOST}:%{POSINT}`,
+	"PATH":              `(?:/[\w%!$@:.,+~-]*)+`,
+	"URIPROTO":          `[A-Za-z][A-Za-z0-9+\-.]*`,
+	"URIHOST":           `%{IPORHOST}(?::%{POSINT})?`,
+	"URIPATH":           `(?:/[A-Za-z0-9$.+!*'(){},~:;=@#%&_\-]*)+`,
+	"URIPARAM":          `\?[A-Za-z0-9$.+!*'|(){},~@#%&/=:;_?\-\[\]<>]*`,
+	"URIPATHPARAM":      `%{URIPATH}(?:%{URIPARAM})?`,
+	"URI":               `%{URIPROTO}://(?:%{USER}(?::[^@]*)?@)?(?:%{URIHOST})?(?:%{URIPATHPARAM})?`,
+	"MONTH":             `\b(?:Jan(?:uary)?|Feb(?:ruary)?|Mar(?:ch)?|Apr(?:il)?|May|June?|July?|Aug(?:ust)?|Sep(?:tember)?|Oct(?:ober)?|Nov(?:ember)?|Dec(?:ember)?)\b`,
+	"MONTHNUM":          `0?[1-9]|1[0-2]`,
+	"MONTHDAY":          `0[1-9]|[12][0-9]|3[01]|[1-9]`,
+	"DAY":               `Mon(?:day)?|Tue(?:sday)?|Wed(?:nesday)?|Thu(?:rsday)?|Fri(?:day)?|Sat(?:urday)?|Sun(?:day)?`,
+	"YEAR":              `(?:[0-9]{2}){1,2}`,
+	"HOUR":              `2[0123]|[01]?[0-9]`,
+	"MINUTE":            `[0-5][0-9]`,
+	"SECOND":            `(?:[0-5]?[0-9]|60)(?:[:.,][0-9]+)?`,
+	"TIME":              `%{HOUR}:%{MINUTE}(?::%{SECOND})?`,
+	"DATE_US":           `%{MONTHNUM}[/-]%{MONTHDAY}[/-]%{YEAR}`,
+	"DATE_EU":           `%{MONTHDAY}[./-]%{MONTHNUM}[./-]%{YEAR}`,
+	"DATE":              `%{DATE_US}|%{DATE_EU}`,
+	"ISO8601_TIMEZONE":  `Z|[+-]%{HOUR}(?::?%{MINUTE})`,
+	"TIMESTAMP_ISO8601": `%{YEAR}-%{MONTHNUM}-%{MONTHDAY}[T ]%{HOUR}:?%{MINUTE}(?::?%{SECOND})?(?:%{ISO8601_TIMEZONE})?`,
+	"SYSLOGTIMESTAMP":   `%{MONTH} +%{MONTHDAY} %{TIME}`,
+	"HTTPDATE":          `%{MONTHDAY}/%{MONTH}/%{YEAR}:%{TIME} %{INT}`,
+	"LOGLEVEL":          `(?i:alert|trace|debug|notice|info|warn(?:ing)?|err(?:or)?|crit(?:ical)?|fatal|severe|emerg(?:ency)?)`,
+	"COMMONAPACHELOG":   `%{IPORHOST:clientip} %{USER:ident} %{USER:auth} \[%{HTTPDATE:timestamp}\] "(?:%{WORD:verb} %{NOTSPACE:request}(?: HTTP/%{NUMBER:httpversion})?|%{DATA:rawrequest})" %{INT:response:int} (?:%{INT:bytes:int}|-)`,
+}
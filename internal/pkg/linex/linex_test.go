@@ -0,0 +1,109 @@
+// Copyright 2021 EMQ Technologies Co., Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package linex
+
+import (
+	"github.com/lf-edge/ekuiper/internal/testx"
+	"reflect"
+	"regexp"
+	"testing"
+)
+
+func TestParser(t *testing.T) {
+	var tests = []struct {
+		conf    *Conf
+		line    string
+		result  map[string]interface{}
+		matched bool
+		err     string
+	}{
+		{
+			conf:    &Conf{Pattern: `^(?P<level>\w+) (?P<msg>.*)$`},
+			line:    "INFO service started",
+			result:  map[string]interface{}{"level": "INFO", "msg": "service started"},
+			matched: true,
+		}, {
+			conf: &Conf{Pattern: `^(?P<level>\w+) (?P<msg>.*)$`},
+			line: "",
+		}, {
+			conf:    &Conf{Grok: `%{TIMESTAMP_ISO8601:ts} %{LOGLEVEL:level} \[%{DATA:thread}\] %{GREEDYDATA:msg}`},
+			line:    "2021-11-30T08:01:02.123Z warn [main] temperature 38.5 is high",
+			result:  map[string]interface{}{"ts": "2021-11-30T08:01:02.123Z", "level": "warn", "thread": "main", "msg": "temperature 38.5 is high"},
+			matched: true,
+		}, {
+			conf:    &Conf{Grok: `%{DEVICE:device} t=%{NUMBER:t:float} on=%{WORD:on:bool}(?: err=%{INT:err:int})?`, GrokPatterns: map[string]string{"DEVICE": `dev-%{INT}`}},
+			line:    "dev-12 t=21.5 on=true",
+			result:  map[string]interface{}{"device": "dev-12", "t": 21.5, "on": true},
+			matched: true,
+		}, {
+			conf:    &Conf{Grok: `%{COMMONAPACHELOG}`},
+			line:    `127.0.0.1 - frank [10/Oct/2000:13:55:36 -0700] "GET /apache_pb.gif HTTP/1.0" 200 2326`,
+			result:  map[string]interface{}{"clientip": "127.0.0.1", "ident": "-", "auth": "frank", "timestamp": "10/Oct/2000:13:55:36 -0700", "verb": "GET", "request": "/apache_pb.gif", "httpversion": "1.0", "response": 200, "bytes": 2326},
+			matched: true,
+		}, {
+			conf:    &Conf{Grok: `on=%{WORD:on:bool}`},
+			line:    "on=yes",
+			matched: true,
+			err:     `field on: strconv.ParseBool: parsing "yes": invalid syntax`,
+		},
+	}
+	for i, tt := range tests {
+		p, err := NewParser(tt.conf)
+		if err != nil {
+			t.Errorf("%d: %v", i, err)
+			continue
+		}
+		result, matched, err := p.Parse(tt.line)
+		if tt.err != testx.Errstring(err) || matched != tt.matched || !reflect.DeepEqual(tt.result, result) {
+			t.Errorf("%d: result mismatch:\n  exp=%v %v %s\n  got=%v %v %v", i, tt.result, tt.matched, tt.err, result, matched, err)
+		}
+	}
+}
+
+func TestParserConf(t *testing.T) {
+	var tests = []struct {
+		conf *Conf
+		err  string
+	}{
+		{conf: &Conf{Pattern: "a", Grok: "%{WORD:a}"}, err: "only one of property pattern and grok can be set"},
+		{conf: &Conf{Pattern: "(?P<a"}, err: "invalid property pattern: error parsing regexp: invalid named capture: `(?P<a`"},
+		{conf: &Conf{Pattern: `\w+`}, err: "the line pattern must have at least one named field"},
+		{conf: &Conf{Grok: "%{NOPE:a}"}, err: "invalid property grok: unknown pattern NOPE"},
+		{conf: &Conf{Grok: "%{WORD:a.b}"}, err: "invalid property grok: invalid field name a.b, only letters, digits and underscore are allowed"},
+		{conf: &Conf{Grok: "%{WORD:a:long}"}, err: "invalid property grok: invalid type long of field a, must be int, float, bool or string"},
+		{conf: &Conf{Grok: "%{LOOP:a}", GrokPatterns: map[string]string{"LOOP": "%{LOOP}"}}, err: "invalid property grok: pattern references are nested too deep"},
+	}
+	for i, tt := range tests {
+		_, err := NewParser(tt.conf)
+		if tt.err != testx.Errstring(err) {
+			t.Errorf("%d: error mismatch:\n  exp=%s\n  got=%s", i, tt.err, err)
+		}
+	}
+}
+
+// All the built-in patterns must be valid in RE2
+func TestGrokPatterns(t *testing.T) {
+	for name := range grokPatterns {
+		g := &grok{types: make(map[string]string)}
+		expr, err := g.expand("%{"+name+"}", 0)
+		if err != nil {
+			t.Errorf("%s: %v", name, err)
+			continue
+		}
+		if _, err := regexp.Compile(expr); err != nil {
+			t.Errorf("%s: %v", name, err)
+		}
+	}
+}
@@ -0,0 +1,157 @@
+// Copyright 2021 EMQ Technologies Co., Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package mqttx provides the MQTT client for the mqtt source and sink. Both MQTT 3.1/3.1.1 and MQTT 5 are
+// supported. The client connects to the configured servers in order and fails over to the next one if
+// the connection fails or is lost.
+package mqttx
+
+import (
+	"crypto/tls"
+	"fmt"
+	"github.com/lf-edge/ekuiper/internal/conf"
+	"github.com/lf-edge/ekuiper/pkg/api"
+	"sync"
+)
+
+const (
+	PROTOCOL_V31  = "3.1"
+	PROTOCOL_V311 = "3.1.1"
+	PROTOCOL_V5   = "5"
+)
+
+const DEFAULT_MAX_RECONNECT_INTERVAL = 60000
+
+type ClientConf struct {
+	Servers  []string
+	ClientId string
+	// 3.1, 3.1.1 or 5
+	PVersion           string
+	Username           string
+	Password           string
+	Certification      string
+	PrivateKeyPath     string
+	InsecureSkipVerify bool
+	// The max interval in milliseconds between the reconnect attempts
+	MaxReconnectInterval int
+}
+
+type Message struct {
+	Topic     string
+	Payload   []byte
+	MessageId uint16
+	Qos       byte
+	Retained  bool
+	// The properties of MQTT 5
+	UserProperties  map[string]string
+	ResponseTopic   string
+	ContentType     string
+	CorrelationData []byte
+	MessageExpiry   *uint32
+}
+
+type MessageHandler func(msg *Message)
+
+type PublishOptions struct {
+	Qos      byte
+	Retained bool
+	// The properties of MQTT 5, ignored by MQTT 3.1/3.1.1
+	UserProperties  map[string]string
+	ResponseTopic   string
+	ContentType     string
+	CorrelationData []byte
+	// The message expiry interval in seconds, 0 means no expiry
+	MessageExpiry uint32
+}
+
+type Client interface {
+	// Connect tries the servers in order and returns error if none of them can be connected.
+	// After connected, the client reconnects automatically when the connection is lost.
+	Connect() error
+	// Subscribe the topic. The subscription is restored after reconnected.
+	Subscribe(topic string, qos byte, handler MessageHandler) error
+	// Unsubscribe the topic. It will not be restored after reconnected.
+	Unsubscribe(topic string) error
+	Publish(topic string, payload []byte, opts *PublishOptions) error
+	Status() api.ConnectionStatus
+	Disconnect()
+}
+
+func NewClient(c *ClientConf, logger api.Logger) (Client, error) {
+	if len(c.Servers) == 0 {
+		return nil, fmt.Errorf("missing server property")
+	}
+	if c.MaxReconnectInterval <= 0 {
+		c.MaxReconnectInterval = DEFAULT_MAX_RECONNECT_INTERVAL
+	}
+	switch c.PVersion {
+	case "", PROTOCOL_V31, PROTOCOL_V311:
+		return newV3Client(c, logger), nil
+	case PROTOCOL_V5, "5.0":
+		tlsConf, err := c.tlsConfig()
+		if err != nil {
+			return nil, err
+		}
+		return newV5Client(c, tlsConf, logger), nil
+	default:
+		return nil, fmt.Errorf("unknown protocol version %s, the value could be only 3.1, 3.1.1 or 5", c.PVersion)
+	}
+}
+
+// tlsConfig loads the certification and private key if configured. Return nil if not configured.
+func (c *ClientConf) tlsConfig() (*tls.Config, error) {
+	if c.Certification == "" && c.PrivateKeyPath == "" {
+		if c.InsecureSkipVerify {
+			return &tls.Config{InsecureSkipVerify: true}, nil
+		}
+		return nil, nil
+	}
+	cp, err := conf.ProcessPath(c.Certification)
+	if err != nil {
+		return nil, err
+	}
+	kp, err := conf.ProcessPath(c.PrivateKeyPath)
+	if err != nil {
+		return nil, err
+	}
+	cer, err := tls.LoadX509KeyPair(cp, kp)
+	if err != nil {
+		return nil, err
+	}
+	return &tls.Config{Certificates: []tls.Certificate{cer}, InsecureSkipVerify: c.InsecureSkipVerify}, nil
+}
+
+// status is the connection status shared by the client implementations
+type status struct {
+	sync.RWMutex
+	s api.ConnectionStatus
+}
+
+func (s *status) set(st string, server string, err error) {
+	s.Lock()
+	defer s.Unlock()
+	s.s.Status = st
+	if server != "" {
+		s.s.Server = server
+	}
+	if err != nil {
+		s.s.LastError = err.Error()
+	}
+}
+
+func (s *status) Status() api.ConnectionStatus {
+	s.RLock()
+	defer s.RUnlock()
+	return s.s
+}
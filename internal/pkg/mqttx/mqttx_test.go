@@ -0,0 +1,318 @@
+// Copyright 2021 EMQ Technologies Co., Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mqttx
+
+import (
+	"github.com/eclipse/paho.golang/packets"
+	"github.com/lf-edge/ekuiper/internal/conf"
+	"github.com/lf-edge/ekuiper/pkg/api"
+	"net"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeBroker is a minimal MQTT 5 broker which accepts one connection, sends messages on demand and records the
+// published messages
+type fakeBroker struct {
+	ln   net.Listener
+	mu   sync.Mutex
+	conn net.Conn
+	// The subscription identifiers of the subscribed topic filters
+	subIds       map[string]int
+	published    chan *packets.Publish
+	subscribed   chan string
+	unsubscribed chan string
+	disconnected chan struct{}
+}
+
+func newFakeBroker(t *testing.T) *fakeBroker {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := &fakeBroker{
+		ln:           ln,
+		subIds:       make(map[string]int),
+		published:    make(chan *packets.Publish, 10),
+		subscribed:   make(chan string, 10),
+		unsubscribed: make(chan string, 10),
+		disconnected: make(chan struct{}),
+	}
+	go b.serve()
+	return b
+}
+
+func (b *fakeBroker) serve() {
+	conn, err := b.ln.Accept()
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+	b.mu.Lock()
+	b.conn = conn
+	b.mu.Unlock()
+	for {
+		p, err := packets.ReadPacket(conn)
+		if err != nil {
+			return
+		}
+		switch c := p.Content.(type) {
+		case *packets.Connect:
+			ca := packets.NewControlPacket(packets.CONNACK)
+			ca.Content.(*packets.Connack).Properties = &packets.Properties{}
+			b.write(ca)
+		case *packets.Subscribe:
+			for topic := range c.Subscriptions {
+				b.mu.Lock()
+				b.subIds[topic] = 0
+				if c.Properties != nil && c.Properties.SubscriptionIdentifier != nil {
+					b.subIds[topic] = *c.Properties.SubscriptionIdentifier
+				}
+				b.mu.Unlock()
+				b.subscribed <- topic
+			}
+			sa := packets.NewControlPacket(packets.SUBACK)
+			sa.Content.(*packets.Suback).PacketID = c.PacketID
+			sa.Content.(*packets.Suback).Reasons = []byte{0}
+			b.write(sa)
+		case *packets.Publish:
+			b.published <- c
+		case *packets.Pingreq:
+			b.write(packets.NewControlPacket(packets.PINGRESP))
+		case *packets.Unsubscribe:
+			ua := packets.NewControlPacket(packets.UNSUBACK)
+			ua.Content.(*packets.Unsuback).PacketID = c.PacketID
+			ua.Content.(*packets.Unsuback).Reasons = []byte{0}
+			b.write(ua)
+			for _, topic := range c.Topics {
+				b.mu.Lock()
+				delete(b.subIds, topic)
+				b.mu.Unlock()
+				b.unsubscribed <- topic
+			}
+		case *packets.Disconnect:
+			close(b.disconnected)
+			return
+		}
+	}
+}
+
+func (b *fakeBroker) write(p *packets.ControlPacket) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	_, _ = p.WriteTo(b.conn)
+}
+
+// publish sends a message with MQTT 5 properties to the client. Like the brokers, a copy is sent for each
+// subscribed filter of the topic with its subscription identifier.
+func (b *fakeBroker) publish(topic string) {
+	b.mu.Lock()
+	var ids []int
+	for t, id := range b.subIds {
+		if routeOf(t) == topic {
+			ids = append(ids, id)
+		}
+	}
+	b.mu.Unlock()
+	for _, id := range ids {
+		pb := packets.NewControlPacket(packets.PUBLISH)
+		pb.Content = &packets.Publish{
+			Topic:   topic,
+			Payload: []byte(`{"a":1}`),
+			Properties: &packets.Properties{
+				ResponseTopic: "resp",
+				ContentType:   "json",
+				User:          []packets.User{{Key: "k", Value: "v"}},
+			},
+		}
+		if id > 0 {
+			sid := id
+			pb.Content.(*packets.Publish).Properties.SubscriptionIdentifier = &sid
+		}
+		b.write(pb)
+	}
+}
+
+func (b *fakeBroker) url() string {
+	return "tcp://" + b.ln.Addr().String()
+}
+
+func closedServer(t *testing.T) string {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := ln.Addr().String()
+	ln.Close()
+	return "tcp://" + addr
+}
+
+func TestNewClient(t *testing.T) {
+	var tests = []struct {
+		conf *ClientConf
+		err  string
+	}{
+		{
+			conf: &ClientConf{},
+			err:  "missing server property",
+		}, {
+			conf: &ClientConf{Servers: []string{"tcp://127.0.0.1:1883"}, PVersion: "4"},
+			err:  "unknown protocol version 4, the value could be only 3.1, 3.1.1 or 5",
+		}, {
+			conf: &ClientConf{Servers: []string{"tcp://127.0.0.1:1883"}, PVersion: "3.1.1"},
+		}, {
+			conf: &ClientConf{Servers: []string{"tcp://127.0.0.1:1883"}, PVersion: "5"},
+		},
+	}
+	for i, tt := range tests {
+		_, err := NewClient(tt.conf, conf.Log)
+		var errStr string
+		if err != nil {
+			errStr = err.Error()
+		}
+		if errStr != tt.err {
+			t.Errorf("%d: error mismatch:\n  exp=%s\n  got=%s", i, tt.err, errStr)
+		}
+	}
+}
+
+func TestV5Failover(t *testing.T) {
+	b := newFakeBroker(t)
+	defer b.ln.Close()
+	c, err := NewClient(&ClientConf{
+		Servers:  []string{closedServer(t), b.url()},
+		ClientId: "test",
+		PVersion: PROTOCOL_V5,
+	}, conf.Log)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Connect(); err != nil {
+		t.Fatal(err)
+	}
+	defer c.Disconnect()
+	st := c.Status()
+	if st.Status != api.ConnectionConnected || st.Server != b.url() || st.LastError == "" {
+		t.Errorf("unexpected status %+v", st)
+	}
+
+	received := make(chan *Message, 2)
+	if err := c.Subscribe("$share/g1/test", 0, func(msg *Message) {
+		received <- msg
+	}); err != nil {
+		t.Fatal(err)
+	}
+	b.publish("test")
+	select {
+	case msg := <-received:
+		exp := &Message{
+			Topic:          "test",
+			Payload:        []byte(`{"a":1}`),
+			UserProperties: map[string]string{"k": "v"},
+			ResponseTopic:  "resp",
+			ContentType:    "json",
+		}
+		if !reflect.DeepEqual(exp, msg) {
+			t.Errorf("message mismatch:\n  exp=%+v\n  got=%+v", exp, msg)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timeout to receive message")
+	}
+
+	// Subscribe again with higher qos and unsubscribe another filter of the same route
+	if err := c.Subscribe("$share/g1/test", 1, func(msg *Message) {
+		received <- msg
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Subscribe("test", 0, func(msg *Message) {
+		t.Errorf("unexpected message for unsubscribed topic %+v", msg)
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Unsubscribe("test"); err != nil {
+		t.Fatal(err)
+	}
+	b.publish("test")
+	select {
+	case <-received:
+	case <-time.After(5 * time.Second):
+		t.Fatal("timeout to receive message after re-subscribed")
+	}
+	select {
+	case msg := <-received:
+		t.Errorf("duplicate message %+v", msg)
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	// The shared and the normal filters of the same route receive their own copies only
+	other := make(chan *Message, 2)
+	if err := c.Subscribe("test", 0, func(msg *Message) {
+		other <- msg
+	}); err != nil {
+		t.Fatal(err)
+	}
+	b.publish("test")
+	for _, ch := range []chan *Message{received, other} {
+		select {
+		case <-ch:
+		case <-time.After(5 * time.Second):
+			t.Fatal("timeout to receive message of both filters")
+		}
+	}
+	select {
+	case msg := <-received:
+		t.Errorf("duplicate message %+v", msg)
+	case msg := <-other:
+		t.Errorf("duplicate message %+v", msg)
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	err = c.Publish("out", []byte("hello"), &PublishOptions{
+		UserProperties: map[string]string{"a": "b"},
+		ContentType:    "text",
+		MessageExpiry:  60,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case p := <-b.published:
+		if p.Topic != "out" || string(p.Payload) != "hello" || p.Properties == nil ||
+			p.Properties.ContentType != "text" || p.Properties.MessageExpiry == nil || *p.Properties.MessageExpiry != 60 ||
+			!reflect.DeepEqual([]packets.User{{Key: "a", Value: "b"}}, p.Properties.User) {
+			t.Errorf("unexpected published message %+v", p)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timeout to receive published message")
+	}
+}
+
+func TestConnectFail(t *testing.T) {
+	for _, v := range []string{PROTOCOL_V311, PROTOCOL_V5} {
+		c, err := NewClient(&ClientConf{Servers: []string{closedServer(t)}, ClientId: "test", PVersion: v}, conf.Log)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := c.Connect(); err == nil {
+			t.Errorf("%s: should fail to connect", v)
+		}
+		if st := c.Status(); st.Status != api.ConnectionDisconnected || st.LastError == "" {
+			t.Errorf("%s: unexpected status %+v", v, st)
+		}
+	}
+}
@@ -0,0 +1,343 @@
+// Copyright 2021 EMQ Technologies Co., Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mqttx
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"github.com/google/uuid"
+	"github.com/lf-edge/ekuiper/internal/conf"
+	"github.com/lf-edge/ekuiper/pkg/api"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// ConnectionInfo is the view of a pooled connection
+type ConnectionInfo struct {
+	Id              string   `json:"id"`
+	Servers         []string `json:"servers"`
+	ClientId        string   `json:"clientId"`
+	ProtocolVersion string   `json:"protocolVersion"`
+	Status          string   `json:"status"`
+	Server          string   `json:"server"`
+	LastError       string   `json:"lastError,omitempty"`
+	RefCount        int      `json:"refCount"`
+	Topics          []string `json:"topics"`
+}
+
+// The connections are shared by all the mqtt sources and sinks with the same connection config
+var pool = &connPool{conns: make(map[string]*pooledConn)}
+
+type connPool struct {
+	sync.Mutex
+	conns map[string]*pooledConn
+	// the sequence to generate the connection id and the reference id
+	connSeq int
+	refSeq  int
+}
+
+type pooledConn struct {
+	seq  int
+	id   string
+	key  string
+	conf *ClientConf
+	cli  Client
+	// closed when the first connection attempt is done
+	ready chan struct{}
+	err   error
+	// guarded by the pool lock
+	refs int
+
+	mu   sync.RWMutex
+	subs map[string]*topicSubs
+}
+
+// The length of the message queue of each subscriber
+const subscriberQueueLength = 1024
+
+// topicSubs is the subscribers of a topic which share the same subscription to the broker
+type topicSubs struct {
+	qos      byte
+	handlers map[int]*subscriber
+}
+
+// subscriber runs the handler of a reference in its own goroutine so that a slow rule does not block the
+// others sharing the subscription unless its queue is full
+type subscriber struct {
+	queue chan *Message
+	done  chan struct{}
+}
+
+func newSubscriber(handler MessageHandler) *subscriber {
+	s := &subscriber{queue: make(chan *Message, subscriberQueueLength), done: make(chan struct{})}
+	go func() {
+		for {
+			select {
+			case msg := <-s.queue:
+				handler(msg)
+			case <-s.done:
+				return
+			}
+		}
+	}()
+	return s
+}
+
+func (s *subscriber) send(msg *Message) {
+	select {
+	case s.queue <- msg:
+	case <-s.done:
+	}
+}
+
+func (s *subscriber) close() {
+	close(s.done)
+}
+
+// Conn is a reference of the pooled connection held by a source or sink instance. It must be closed to
+// release the connection after use.
+type Conn struct {
+	id     int
+	pc     *pooledConn
+	mu     sync.Mutex
+	topics []string
+	closed bool
+}
+
+// key returns the identity of the connection config. The client id is part of the key, so the sources
+// and sinks without client id share the same connection with a generated client id. The credentials are
+// hashed so that the password is not kept in the key.
+func (c *ClientConf) key() string {
+	cred := sha256.Sum256([]byte(fmt.Sprintf("%d:%s%s", len(c.Username), c.Username, c.Password)))
+	return fmt.Sprintf("%s|%s|%s|%x|%s|%s|%t|%d", strings.Join(c.Servers, ","), c.ClientId, c.PVersion,
+		cred, c.Certification, c.PrivateKeyPath, c.InsecureSkipVerify, c.MaxReconnectInterval)
+}
+
+// GetConnection returns the reference of the connection for the config. The connection is created and
+// connected if not exist, otherwise the existing one is reused.
+func GetConnection(c *ClientConf) (*Conn, error) {
+	cc := *c
+	if cc.MaxReconnectInterval <= 0 {
+		cc.MaxReconnectInterval = DEFAULT_MAX_RECONNECT_INTERVAL
+	}
+	key := cc.key()
+	pool.Lock()
+	pc, ok := pool.conns[key]
+	if !ok {
+		if cc.ClientId == "" {
+			newUUID, err := uuid.NewUUID()
+			if err != nil {
+				pool.Unlock()
+				return nil, fmt.Errorf("failed to get uuid, the error is %s", err)
+			}
+			cc.ClientId = newUUID.String()
+		}
+		// The connection is shared by rules so the global logger is used
+		cli, err := NewClient(&cc, conf.Log)
+		if err != nil {
+			pool.Unlock()
+			return nil, err
+		}
+		pool.connSeq++
+		pc = &pooledConn{
+			seq:   pool.connSeq,
+			id:    fmt.Sprintf("mqtt_%d", pool.connSeq),
+			key:   key,
+			conf:  &cc,
+			cli:   cli,
+			ready: make(chan struct{}),
+			subs:  make(map[string]*topicSubs),
+		}
+		pool.conns[key] = pc
+	}
+	pc.refs++
+	pool.refSeq++
+	ref := &Conn{id: pool.refSeq, pc: pc}
+	pool.Unlock()
+
+	if !ok {
+		if err := pc.cli.Connect(); err != nil {
+			pool.Lock()
+			if pool.conns[key] == pc {
+				delete(pool.conns, key)
+			}
+			pool.Unlock()
+			pc.err = err
+		}
+		close(pc.ready)
+	} else {
+		<-pc.ready
+	}
+	if pc.err != nil {
+		return nil, pc.err
+	}
+	return ref, nil
+}
+
+// GetConnections returns the view of all the pooled connections ordered by id
+func GetConnections() []ConnectionInfo {
+	pool.Lock()
+	conns := make([]*pooledConn, 0, len(pool.conns))
+	refs := make(map[*pooledConn]int, len(pool.conns))
+	for _, pc := range pool.conns {
+		conns = append(conns, pc)
+		refs[pc] = pc.refs
+	}
+	pool.Unlock()
+	sort.Slice(conns, func(i, j int) bool {
+		return conns[i].seq < conns[j].seq
+	})
+	result := make([]ConnectionInfo, 0, len(conns))
+	for _, pc := range conns {
+		st := pc.cli.Status()
+		pc.mu.RLock()
+		topics := make([]string, 0, len(pc.subs))
+		for t := range pc.subs {
+			topics = append(topics, t)
+		}
+		pc.mu.RUnlock()
+		sort.Strings(topics)
+		result = append(result, ConnectionInfo{
+			Id:              pc.id,
+			Servers:         pc.conf.Servers,
+			ClientId:        pc.conf.ClientId,
+			ProtocolVersion: pc.conf.PVersion,
+			Status:          st.Status,
+			Server:          st.Server,
+			LastError:       st.LastError,
+			RefCount:        refs[pc],
+			Topics:          topics,
+		})
+	}
+	return result
+}
+
+// Subscribe the topic. If the topic is already subscribed by other references of the connection, the
+// subscription to the broker is shared and the message is dispatched to all the subscribers. Each subscriber
+// has its own queue, so the handler is called in a separate goroutine.
+func (c *Conn) Subscribe(topic string, qos byte, handler MessageHandler) error {
+	pc := c.pc
+	pc.mu.Lock()
+	ts, ok := pc.subs[topic]
+	if !ok {
+		ts = &topicSubs{qos: qos, handlers: make(map[int]*subscriber)}
+		pc.subs[topic] = ts
+	}
+	if old, ok := ts.handlers[c.id]; ok {
+		old.close()
+	}
+	ts.handlers[c.id] = newSubscriber(handler)
+	// Subscribe again to upgrade the qos
+	needSub := !ok || qos > ts.qos
+	if qos > ts.qos {
+		ts.qos = qos
+	}
+	pc.mu.Unlock()
+
+	c.mu.Lock()
+	c.topics = append(c.topics, topic)
+	c.mu.Unlock()
+	if needSub {
+		if err := pc.cli.Subscribe(topic, qos, pc.dispatcher(topic)); err != nil {
+			pc.removeHandler(topic, c.id)
+			return err
+		}
+	}
+	return nil
+}
+
+func (pc *pooledConn) dispatcher(topic string) MessageHandler {
+	return func(msg *Message) {
+		pc.mu.RLock()
+		ts, ok := pc.subs[topic]
+		if !ok {
+			pc.mu.RUnlock()
+			return
+		}
+		subs := make([]*subscriber, 0, len(ts.handlers))
+		for _, s := range ts.handlers {
+			subs = append(subs, s)
+		}
+		pc.mu.RUnlock()
+		for _, s := range subs {
+			s.send(msg)
+		}
+	}
+}
+
+// removeHandler removes the handler of the reference and returns true if the topic has no subscribers
+func (pc *pooledConn) removeHandler(topic string, id int) bool {
+	pc.mu.Lock()
+	defer pc.mu.Unlock()
+	ts, ok := pc.subs[topic]
+	if !ok {
+		return false
+	}
+	if s, ok := ts.handlers[id]; ok {
+		s.close()
+		delete(ts.handlers, id)
+	}
+	if len(ts.handlers) == 0 {
+		delete(pc.subs, topic)
+		return true
+	}
+	return false
+}
+
+func (c *Conn) Publish(topic string, payload []byte, opts *PublishOptions) error {
+	return c.pc.cli.Publish(topic, payload, opts)
+}
+
+func (c *Conn) Status() api.ConnectionStatus {
+	return c.pc.cli.Status()
+}
+
+// Close releases the reference. The subscriptions of this reference are removed and the connection is
+// disconnected if it is not referenced anymore.
+func (c *Conn) Close() {
+	c.mu.Lock()
+	if c.closed {
+		c.mu.Unlock()
+		return
+	}
+	c.closed = true
+	topics := c.topics
+	c.mu.Unlock()
+
+	pc := c.pc
+	var unsubs []string
+	for _, t := range topics {
+		if pc.removeHandler(t, c.id) {
+			unsubs = append(unsubs, t)
+		}
+	}
+	pool.Lock()
+	pc.refs--
+	last := pc.refs == 0
+	if last && pool.conns[pc.key] == pc {
+		delete(pool.conns, pc.key)
+	}
+	pool.Unlock()
+	if last {
+		pc.cli.Disconnect()
+		return
+	}
+	for _, t := range unsubs {
+		if err := pc.cli.Unsubscribe(t); err != nil {
+			conf.Log.Warnf("failed to unsubscribe topic %s of connection %s: %v", t, pc.id, err)
+		}
+	}
+}
@@ -0,0 +1,131 @@
+// Copyright 2021 EMQ Technologies Co., Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mqttx
+
+import (
+	"github.com/lf-edge/ekuiper/pkg/api"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPool(t *testing.T) {
+	b := newFakeBroker(t)
+	defer b.ln.Close()
+	cc := &ClientConf{Servers: []string{b.url()}, PVersion: PROTOCOL_V5}
+	c1, err := GetConnection(cc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c2, err := GetConnection(cc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c1.pc != c2.pc {
+		t.Fatal("connections with the same config should be shared")
+	}
+
+	r1, r2 := make(chan *Message, 1), make(chan *Message, 1)
+	// The first subscriber is blocked until released
+	release := make(chan struct{})
+	if err := c1.Subscribe("test", 0, func(msg *Message) {
+		<-release
+		r1 <- msg
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if err := c2.Subscribe("test", 0, func(msg *Message) { r2 <- msg }); err != nil {
+		t.Fatal(err)
+	}
+	if topic := <-b.subscribed; topic != "test" {
+		t.Errorf("subscribed topic mismatch, got %s", topic)
+	}
+	select {
+	case topic := <-b.subscribed:
+		t.Errorf("the topic should be subscribed only once, got another subscription %s", topic)
+	case <-time.After(100 * time.Millisecond):
+	}
+	// The message of the only subscription is dispatched to both subscribers and the blocked one does not
+	// block the other
+	b.publish("test")
+	for i, r := range []chan *Message{r2, r1} {
+		select {
+		case <-r:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("timeout to receive message by subscriber %d", i)
+		}
+		if i == 0 {
+			close(release)
+		}
+	}
+
+	conns := GetConnections()
+	if len(conns) != 1 {
+		t.Fatalf("expect 1 connection but got %v", conns)
+	}
+	if ci := conns[0]; ci.RefCount != 2 || ci.Status != api.ConnectionConnected || ci.Server != b.url() || ci.ClientId == "" || len(ci.Topics) != 1 {
+		t.Errorf("unexpected connection info %+v", ci)
+	}
+
+	c1.Close()
+	// Close again takes no effect
+	c1.Close()
+	select {
+	case topic := <-b.unsubscribed:
+		t.Errorf("the topic %s should not be unsubscribed while still referenced", topic)
+	case <-time.After(100 * time.Millisecond):
+	}
+	if conns := GetConnections(); len(conns) != 1 || conns[0].RefCount != 1 {
+		t.Errorf("unexpected connections %v", conns)
+	}
+	c2.Close()
+	select {
+	case <-b.disconnected:
+	case <-time.After(5 * time.Second):
+		t.Fatal("timeout to disconnect the connection without reference")
+	}
+	if conns := GetConnections(); len(conns) != 0 {
+		t.Errorf("expect no connections but got %v", conns)
+	}
+}
+
+func TestPoolConnectFail(t *testing.T) {
+	_, err := GetConnection(&ClientConf{Servers: []string{closedServer(t)}, PVersion: PROTOCOL_V5})
+	if err == nil {
+		t.Fatal("should fail to connect")
+	}
+	if conns := GetConnections(); len(conns) != 0 {
+		t.Errorf("expect no connections but got %v", conns)
+	}
+}
+
+func TestClientConfKey(t *testing.T) {
+	c1 := &ClientConf{Servers: []string{"tcp://127.0.0.1:1883"}, Username: "user", Password: "secret"}
+	if strings.Contains(c1.key(), "secret") {
+		t.Errorf("the key should not contain the password: %s", c1.key())
+	}
+	for i, c := range []*ClientConf{
+		{Servers: []string{"tcp://127.0.0.1:1883"}, Username: "user", Password: "other"},
+		{Servers: []string{"tcp://127.0.0.1:1883"}, Username: "users", Password: "ecret"},
+	} {
+		if c.key() == c1.key() {
+			t.Errorf("%d: the key should differ by the credentials", i)
+		}
+	}
+	c2 := *c1
+	if c2.key() != c1.key() {
+		t.Error("the key should be the same for the same config")
+	}
+}
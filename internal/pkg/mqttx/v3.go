@@ -0,0 +1,162 @@
+// Copyright 2021 EMQ Technologies Co., Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mqttx
+
+import (
+	"crypto/tls"
+	"fmt"
+	MQTT "github.com/eclipse/paho.mqtt.golang"
+	"github.com/lf-edge/ekuiper/pkg/api"
+	"net/url"
+	"sync"
+	"time"
+)
+
+type subscription struct {
+	qos     byte
+	handler MessageHandler
+}
+
+// v3Client is the MQTT 3.1/3.1.1 client by paho. The paho client tries the servers in order for each
+// connection attempt.
+type v3Client struct {
+	status
+	conf   *ClientConf
+	logger api.Logger
+	cli    MQTT.Client
+
+	mu       sync.Mutex
+	subs     map[string]*subscription
+	attempt  string
+	connects int
+}
+
+func newV3Client(c *ClientConf, logger api.Logger) *v3Client {
+	return &v3Client{conf: c, logger: logger, subs: make(map[string]*subscription)}
+}
+
+func (c *v3Client) Connect() error {
+	var pVersion uint = 3
+	if c.conf.PVersion == PROTOCOL_V311 {
+		pVersion = 4
+	}
+	opts := MQTT.NewClientOptions().SetProtocolVersion(pVersion).SetClientID(c.conf.ClientId)
+	for _, s := range c.conf.Servers {
+		opts.AddBroker(s)
+	}
+	tlsConf, err := c.conf.tlsConfig()
+	if err != nil {
+		return err
+	}
+	if tlsConf != nil {
+		c.logger.Infof("Connect MQTT broker with certification and keys.")
+		opts.SetTLSConfig(tlsConf)
+	}
+	if c.conf.Username != "" {
+		opts.SetUsername(c.conf.Username)
+	}
+	if c.conf.Password != "" {
+		opts.SetPassword(c.conf.Password)
+	}
+	opts.SetAutoReconnect(true)
+	opts.SetMaxReconnectInterval(time.Duration(c.conf.MaxReconnectInterval) * time.Millisecond)
+	opts.SetConnectionAttemptHandler(func(broker *url.URL, tlsCfg *tls.Config) *tls.Config {
+		c.mu.Lock()
+		c.attempt = broker.String()
+		c.mu.Unlock()
+		c.set(api.ConnectionConnecting, broker.String(), nil)
+		return tlsCfg
+	})
+	opts.SetConnectionLostHandler(func(client MQTT.Client, e error) {
+		c.logger.Errorf("The connection %s is disconnected due to error %s, will try to re-connect later.", c.Status().Server+": "+c.conf.ClientId, e)
+		c.set(api.ConnectionDisconnected, "", e)
+	})
+	opts.SetOnConnectHandler(func(client MQTT.Client) {
+		c.mu.Lock()
+		server := c.attempt
+		c.connects++
+		reconn := c.connects > 1
+		subs := make(map[string]*subscription, len(c.subs))
+		for t, s := range c.subs {
+			subs[t] = s
+		}
+		c.mu.Unlock()
+		c.set(api.ConnectionConnected, server, nil)
+		if reconn {
+			c.logger.Infof("The connection is %s re-established successfully.", server+": "+c.conf.ClientId)
+			for t, s := range subs {
+				if err := c.subscribe(client, t, s); err != nil {
+					c.logger.Errorf("Found error when re-subscribing topic %s: %s", t, err)
+				}
+			}
+		}
+	})
+	cli := MQTT.NewClient(opts)
+	if token := cli.Connect(); token.Wait() && token.Error() != nil {
+		c.set(api.ConnectionDisconnected, "", token.Error())
+		return fmt.Errorf("found error when connecting to %v: %s", c.conf.Servers, token.Error())
+	}
+	c.logger.Infof("The connection to server %s was established successfully", c.Status().Server)
+	c.cli = cli
+	return nil
+}
+
+func (c *v3Client) Subscribe(topic string, qos byte, handler MessageHandler) error {
+	s := &subscription{qos: qos, handler: handler}
+	c.mu.Lock()
+	c.subs[topic] = s
+	c.mu.Unlock()
+	return c.subscribe(c.cli, topic, s)
+}
+
+func (c *v3Client) subscribe(cli MQTT.Client, topic string, s *subscription) error {
+	token := cli.Subscribe(topic, s.qos, func(_ MQTT.Client, msg MQTT.Message) {
+		s.handler(&Message{
+			Topic:     msg.Topic(),
+			Payload:   msg.Payload(),
+			MessageId: msg.MessageID(),
+			Qos:       msg.Qos(),
+			Retained:  msg.Retained(),
+		})
+	})
+	if token.Wait() && token.Error() != nil {
+		return token.Error()
+	}
+	return nil
+}
+
+func (c *v3Client) Unsubscribe(topic string) error {
+	c.mu.Lock()
+	delete(c.subs, topic)
+	c.mu.Unlock()
+	if token := c.cli.Unsubscribe(topic); token.Wait() && token.Error() != nil {
+		return token.Error()
+	}
+	return nil
+}
+
+func (c *v3Client) Publish(topic string, payload []byte, opts *PublishOptions) error {
+	if token := c.cli.Publish(topic, opts.Qos, opts.Retained, payload); token.Wait() && token.Error() != nil {
+		return token.Error()
+	}
+	return nil
+}
+
+func (c *v3Client) Disconnect() {
+	if c.cli != nil && c.cli.IsConnected() {
+		c.cli.Disconnect(5000)
+	}
+	c.set(api.ConnectionDisconnected, "", nil)
+}
@@ -0,0 +1,417 @@
+// Copyright 2021 EMQ Technologies Co., Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mqttx
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"github.com/eclipse/paho.golang/paho"
+	"github.com/lf-edge/ekuiper/pkg/api"
+	"net"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	v5ConnectTimeout = 10 * time.Second
+	v5RequestTimeout = 10 * time.Second
+	v5KeepAlive      = 30
+)
+
+// v5Client is the MQTT 5 client. It tries the servers in order when connecting. When the connection is lost,
+// it reconnects from the first server again with exponential backoff.
+type v5Client struct {
+	status
+	conf    *ClientConf
+	tlsConf *tls.Config
+	logger  api.Logger
+	router  *paho.StandardRouter
+	// Serializes the route registration which must not hold mu as the router calls the handlers with its lock
+	routeMu sync.Mutex
+
+	mu   sync.Mutex
+	cli  *paho.Client
+	subs map[string]*v5Sub
+	// The reference count of the routes registered to the router by the subscribed topic filters
+	routes map[string]int
+	cancel context.CancelFunc
+	done   chan struct{}
+	// The sequence to generate the subscription identifier of the topic filters
+	subSeq int
+	// Whether the connected server supports the subscription identifier
+	subIDAvailable bool
+}
+
+type v5Sub struct {
+	// The subscription identifier to tell which filter a message is delivered for
+	id      int
+	qos     byte
+	handler MessageHandler
+}
+
+func newV5Client(c *ClientConf, tlsConf *tls.Config, logger api.Logger) *v5Client {
+	return &v5Client{conf: c, tlsConf: tlsConf, logger: logger, router: paho.NewStandardRouter(), subs: make(map[string]*v5Sub), routes: make(map[string]int)}
+}
+
+func (c *v5Client) Connect() error {
+	ctx, cancel := context.WithCancel(context.Background())
+	cli, lost, err := c.connectServers(ctx)
+	if err != nil {
+		cancel()
+		return fmt.Errorf("found error when connecting to %v: %s", c.conf.Servers, err)
+	}
+	c.logger.Infof("The connection to server %s was established successfully", c.Status().Server)
+	c.mu.Lock()
+	c.cli = cli
+	c.cancel = cancel
+	c.done = make(chan struct{})
+	c.mu.Unlock()
+	go c.run(ctx, lost)
+	return nil
+}
+
+// run waits for the connection lost and reconnects
+func (c *v5Client) run(ctx context.Context, lost <-chan error) {
+	defer close(c.done)
+	for {
+		select {
+		case err := <-lost:
+			c.logger.Errorf("The connection %s is disconnected due to error %s, will try to re-connect later.", c.Status().Server+": "+c.conf.ClientId, err)
+			c.set(api.ConnectionDisconnected, "", err)
+			c.mu.Lock()
+			c.cli = nil
+			c.mu.Unlock()
+			delay := time.Second
+			for {
+				select {
+				case <-time.After(delay):
+				case <-ctx.Done():
+					return
+				}
+				cli, l, err := c.connectServers(ctx)
+				if err == nil {
+					c.logger.Infof("The connection is %s re-established successfully.", c.Status().Server+": "+c.conf.ClientId)
+					c.mu.Lock()
+					c.cli = cli
+					subs := make(map[string]v5Sub, len(c.subs))
+					for t, s := range c.subs {
+						subs[t] = *s
+					}
+					c.mu.Unlock()
+					for t, s := range subs {
+						if err := c.subscribe(cli, t, s.qos, s.id); err != nil {
+							c.logger.Errorf("Found error when re-subscribing topic %s: %s", t, err)
+						}
+					}
+					lost = l
+					break
+				}
+				if ctx.Err() != nil {
+					return
+				}
+				delay *= 2
+				if max := time.Duration(c.conf.MaxReconnectInterval) * time.Millisecond; delay > max {
+					delay = max
+				}
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// connectServers tries the servers in order and returns the first connected client with its lost channel
+func (c *v5Client) connectServers(ctx context.Context) (*paho.Client, <-chan error, error) {
+	var lastErr error
+	for _, s := range c.conf.Servers {
+		c.set(api.ConnectionConnecting, s, nil)
+		cli, lost, err := c.connect(ctx, s)
+		if err == nil {
+			c.set(api.ConnectionConnected, s, nil)
+			return cli, lost, nil
+		}
+		c.logger.Warnf("failed to connect to MQTT server %s: %v", s, err)
+		c.set(api.ConnectionConnecting, "", err)
+		lastErr = err
+		if ctx.Err() != nil {
+			break
+		}
+	}
+	c.set(api.ConnectionDisconnected, "", lastErr)
+	return nil, nil, lastErr
+}
+
+func (c *v5Client) connect(ctx context.Context, server string) (*paho.Client, <-chan error, error) {
+	u, err := url.Parse(server)
+	if err != nil {
+		return nil, nil, err
+	}
+	connCtx, cancel := context.WithTimeout(ctx, v5ConnectTimeout)
+	defer cancel()
+	var conn net.Conn
+	switch strings.ToLower(u.Scheme) {
+	case "tcp", "mqtt", "":
+		d := &net.Dialer{}
+		conn, err = d.DialContext(connCtx, "tcp", u.Host)
+	case "ssl", "tls", "mqtts", "tcps":
+		d := &tls.Dialer{Config: c.tlsConf}
+		conn, err = d.DialContext(connCtx, "tcp", u.Host)
+	default:
+		return nil, nil, fmt.Errorf("unsupported scheme %s for MQTT 5", u.Scheme)
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	// Only the first error of the connection is needed to trigger reconnection
+	lost := make(chan error, 1)
+	notify := func(err error) {
+		select {
+		case lost <- err:
+		default:
+		}
+	}
+	cli := paho.NewClient(paho.ClientConfig{
+		ClientID:      c.conf.ClientId,
+		Conn:          conn,
+		Router:        c.router,
+		OnClientError: notify,
+		OnServerDisconnect: func(d *paho.Disconnect) {
+			notify(fmt.Errorf("disconnected by server with reason code %d", d.ReasonCode))
+		},
+	})
+	cp := &paho.Connect{
+		ClientID:   c.conf.ClientId,
+		KeepAlive:  v5KeepAlive,
+		CleanStart: true,
+	}
+	if c.conf.Username != "" {
+		cp.Username = c.conf.Username
+		cp.UsernameFlag = true
+	}
+	if c.conf.Password != "" {
+		cp.Password = []byte(c.conf.Password)
+		cp.PasswordFlag = true
+	}
+	ca, err := cli.Connect(connCtx, cp)
+	if err != nil {
+		conn.Close()
+		if ca != nil && ca.Properties != nil && ca.Properties.ReasonString != "" {
+			err = fmt.Errorf("%v: %s", err, ca.Properties.ReasonString)
+		}
+		return nil, nil, err
+	}
+	// The subscription identifier is available if the server does not tell
+	c.mu.Lock()
+	c.subIDAvailable = ca.Properties == nil || ca.Properties.SubIDAvailable
+	c.mu.Unlock()
+	return cli, lost, nil
+}
+
+// Subscribe the topic filter. The paho router appends the handlers of the same route, so the route is only
+// registered once and the handler of the filter is replaced if subscribed again. Each filter is subscribed
+// with its own subscription identifier which is kept when subscribed again.
+func (c *v5Client) Subscribe(topic string, qos byte, handler MessageHandler) error {
+	route := routeOf(topic)
+	c.routeMu.Lock()
+	c.mu.Lock()
+	first := false
+	s, ok := c.subs[topic]
+	if ok {
+		s.qos = qos
+		s.handler = handler
+	} else {
+		c.subSeq++
+		s = &v5Sub{id: c.subSeq, qos: qos, handler: handler}
+		c.subs[topic] = s
+		c.routes[route]++
+		first = c.routes[route] == 1
+	}
+	id := s.id
+	cli := c.cli
+	c.mu.Unlock()
+	if first {
+		c.router.RegisterHandler(route, c.dispatch(route))
+	}
+	c.routeMu.Unlock()
+	// If not connected, it will be subscribed after connected
+	if cli == nil {
+		return nil
+	}
+	return c.subscribe(cli, topic, qos, id)
+}
+
+// dispatch returns the handler of the route. The filters like $share/group/a and a share the same route, so the
+// message is only sent to the filter of its subscription identifier. If the server does not support the
+// subscription identifier, the message is sent to all the filters of the route.
+func (c *v5Client) dispatch(route string) paho.MessageHandler {
+	return func(p *paho.Publish) {
+		var id int
+		if p.Properties != nil && p.Properties.SubscriptionIdentifier != nil {
+			id = *p.Properties.SubscriptionIdentifier
+		}
+		var handlers []MessageHandler
+		c.mu.Lock()
+		for t, s := range c.subs {
+			if routeOf(t) == route && (id == 0 || s.id == id) {
+				handlers = append(handlers, s.handler)
+			}
+		}
+		c.mu.Unlock()
+		if len(handlers) == 0 {
+			return
+		}
+		msg := fromPublish(p)
+		for _, h := range handlers {
+			h(msg)
+		}
+	}
+}
+
+// routeOf returns the topic filter of a shared subscription like $share/group/filter. The paho router only
+// strips the $share prefix but not the group name, so the route is registered without both.
+func routeOf(topic string) string {
+	if strings.HasPrefix(topic, "$share/") {
+		if parts := strings.SplitN(topic, "/", 3); len(parts) == 3 {
+			return parts[2]
+		}
+	}
+	return topic
+}
+
+func (c *v5Client) subscribe(cli *paho.Client, topic string, qos byte, id int) error {
+	ctx, cancel := context.WithTimeout(context.Background(), v5RequestTimeout)
+	defer cancel()
+	sp := &paho.Subscribe{
+		Subscriptions: map[string]paho.SubscribeOptions{topic: {QoS: qos}},
+	}
+	c.mu.Lock()
+	if c.subIDAvailable {
+		sp.Properties = &paho.SubscribeProperties{SubscriptionIdentifier: &id}
+	}
+	c.mu.Unlock()
+	sa, err := cli.Subscribe(ctx, sp)
+	if err != nil {
+		return err
+	}
+	if len(sa.Reasons) > 0 && sa.Reasons[0] >= 0x80 {
+		return fmt.Errorf("subscription is rejected with reason code %d", sa.Reasons[0])
+	}
+	return nil
+}
+
+// Unsubscribe the topic filter. The route is unregistered only if no other filter uses it.
+func (c *v5Client) Unsubscribe(topic string) error {
+	route := routeOf(topic)
+	c.routeMu.Lock()
+	c.mu.Lock()
+	last := false
+	if _, ok := c.subs[topic]; ok {
+		delete(c.subs, topic)
+		c.routes[route]--
+		if c.routes[route] == 0 {
+			delete(c.routes, route)
+			last = true
+		}
+	}
+	cli := c.cli
+	c.mu.Unlock()
+	if last {
+		c.router.UnregisterHandler(route)
+	}
+	c.routeMu.Unlock()
+	if cli == nil {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), v5RequestTimeout)
+	defer cancel()
+	_, err := cli.Unsubscribe(ctx, &paho.Unsubscribe{Topics: []string{topic}})
+	return err
+}
+
+func fromPublish(p *paho.Publish) *Message {
+	msg := &Message{
+		Topic:     p.Topic,
+		Payload:   p.Payload,
+		MessageId: p.PacketID,
+		Qos:       p.QoS,
+		Retained:  p.Retain,
+	}
+	if p.Properties != nil {
+		msg.ResponseTopic = p.Properties.ResponseTopic
+		msg.ContentType = p.Properties.ContentType
+		msg.CorrelationData = p.Properties.CorrelationData
+		msg.MessageExpiry = p.Properties.MessageExpiry
+		if len(p.Properties.User) > 0 {
+			msg.UserProperties = make(map[string]string, len(p.Properties.User))
+			for _, u := range p.Properties.User {
+				msg.UserProperties[u.Key] = u.Value
+			}
+		}
+	}
+	return msg
+}
+
+func (c *v5Client) Publish(topic string, payload []byte, opts *PublishOptions) error {
+	c.mu.Lock()
+	cli := c.cli
+	c.mu.Unlock()
+	if cli == nil {
+		return errors.New("not connected")
+	}
+	p := &paho.Publish{
+		Topic:   topic,
+		QoS:     opts.Qos,
+		Retain:  opts.Retained,
+		Payload: payload,
+	}
+	if len(opts.UserProperties) > 0 || opts.ResponseTopic != "" || opts.ContentType != "" || len(opts.CorrelationData) > 0 || opts.MessageExpiry > 0 {
+		props := &paho.PublishProperties{
+			ResponseTopic:   opts.ResponseTopic,
+			ContentType:     opts.ContentType,
+			CorrelationData: opts.CorrelationData,
+		}
+		if opts.MessageExpiry > 0 {
+			e := opts.MessageExpiry
+			props.MessageExpiry = &e
+		}
+		for k, v := range opts.UserProperties {
+			props.User.Add(k, v)
+		}
+		p.Properties = props
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), v5RequestTimeout)
+	defer cancel()
+	_, err := cli.Publish(ctx, p)
+	return err
+}
+
+func (c *v5Client) Disconnect() {
+	c.mu.Lock()
+	cancel, cli, done := c.cancel, c.cli, c.done
+	c.cli = nil
+	c.mu.Unlock()
+	if cancel != nil {
+		cancel()
+		<-done
+	}
+	if cli != nil {
+		_ = cli.Disconnect(&paho.Disconnect{ReasonCode: 0})
+	}
+	c.set(api.ConnectionDisconnected, "", nil)
+}
@@ -0,0 +1,129 @@
+// Copyright 2021 EMQ Technologies Co., Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package natsx provides the NATS connection for the nats source and sink
+package natsx
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"github.com/lf-edge/ekuiper/internal/conf"
+	"github.com/lf-edge/ekuiper/pkg/api"
+	"github.com/nats-io/nats.go"
+	"sync"
+	"time"
+)
+
+const DEFAULT_SERVER = "nats://127.0.0.1:4222"
+
+// ConnConf is the connection configuration shared by the nats source and sink
+type ConnConf struct {
+	// The server urls separated by comma
+	Server             string `json:"server"`
+	Username           string `json:"username"`
+	Password           string `json:"password"`
+	Token              string `json:"token"`
+	CredentialsFile    string `json:"credentialsFile"`
+	InsecureSkipVerify bool   `json:"insecureSkipVerify"`
+	// The interval in milliseconds between the reconnect attempts
+	ReconnectInterval int `json:"reconnectInterval"`
+}
+
+func (c *ConnConf) Validate() error {
+	if c.Server == "" {
+		c.Server = DEFAULT_SERVER
+	}
+	if c.Token != "" && c.Username != "" {
+		return errors.New("only one of token and username can be set")
+	}
+	if c.ReconnectInterval < 0 {
+		return fmt.Errorf("invalid property reconnectInterval: %d, must not be negative", c.ReconnectInterval)
+	}
+	if c.ReconnectInterval == 0 {
+		c.ReconnectInterval = 2000
+	}
+	return nil
+}
+
+// Conn is the NATS connection which tracks the connection status
+type Conn struct {
+	*nats.Conn
+	mu     sync.RWMutex
+	status api.ConnectionStatus
+}
+
+// Connect connects to the servers and reconnects forever when the connection is lost
+func Connect(c *ConnConf, name string, logger api.Logger) (*Conn, error) {
+	conn := &Conn{}
+	opts := []nats.Option{
+		nats.Name(name),
+		nats.MaxReconnects(-1),
+		nats.ReconnectWait(time.Duration(c.ReconnectInterval) * time.Millisecond),
+		nats.DisconnectErrHandler(func(nc *nats.Conn, err error) {
+			if err != nil {
+				logger.Errorf("The nats connection %s is disconnected due to error %s, will try to re-connect later.", name, err)
+			}
+			conn.set(api.ConnectionConnecting, "", err)
+		}),
+		nats.ReconnectHandler(func(nc *nats.Conn) {
+			logger.Infof("The nats connection %s is re-established to %s successfully.", name, nc.ConnectedUrl())
+			conn.set(api.ConnectionConnected, nc.ConnectedUrl(), nil)
+		}),
+		nats.ClosedHandler(func(nc *nats.Conn) {
+			conn.set(api.ConnectionDisconnected, "", nil)
+		}),
+	}
+	switch {
+	case c.CredentialsFile != "":
+		p, err := conf.ProcessPath(c.CredentialsFile)
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, nats.UserCredentials(p))
+	case c.Token != "":
+		opts = append(opts, nats.Token(c.Token))
+	case c.Username != "":
+		opts = append(opts, nats.UserInfo(c.Username, c.Password))
+	}
+	if c.InsecureSkipVerify {
+		opts = append(opts, nats.Secure(&tls.Config{InsecureSkipVerify: true}))
+	}
+	nc, err := nats.Connect(c.Server, opts...)
+	if err != nil {
+		return nil, fmt.Errorf("found error when connecting to %s: %v", c.Server, err)
+	}
+	logger.Infof("The nats connection to server %s was established successfully", nc.ConnectedUrl())
+	conn.Conn = nc
+	conn.set(api.ConnectionConnected, nc.ConnectedUrl(), nil)
+	return conn, nil
+}
+
+func (c *Conn) set(st string, server string, err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.status.Status = st
+	if server != "" {
+		c.status.Server = server
+	}
+	if err != nil {
+		c.status.LastError = err.Error()
+	}
+}
+
+func (c *Conn) ConnectionStatus() api.ConnectionStatus {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.status
+}
@@ -0,0 +1,250 @@
+// Copyright 2021 EMQ Technologies Co., Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package socketx implements the framing of the records over raw tcp or udp and the listeners shared by the
+// socket sources and sinks.
+package socketx
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+const (
+	FRAMING_DELIMITER = "delimiter"
+	FRAMING_FIXED     = "fixed"
+	FRAMING_LENGTH    = "length"
+
+	DEFAULT_MAX_FRAME_SIZE = 64 * 1024
+	maxDatagramSize        = 65535
+)
+
+// FramingConf is the framing configuration embedded in the source and sink configurations. The zero values are
+// the defaults: the frames are delimited by the line break, and the length prefix is 4 bytes in big endian.
+type FramingConf struct {
+	// delimiter, fixed or length
+	Framing string `json:"framing"`
+	// The delimiter in plain text, or in hex with the prefix 0x
+	Delimiter string `json:"delimiter"`
+	// The size of each frame in fixed framing
+	FrameLength int `json:"frameLength"`
+	// The size of the length prefix in bytes, 1, 2 or 4
+	LengthSize int `json:"lengthSize"`
+	// The byte order of the length prefix, big or little
+	ByteOrder    string `json:"byteOrder"`
+	MaxFrameSize int    `json:"maxFrameSize"`
+}
+
+// Framer reads the frames from a byte stream and encodes the data into frames
+type Framer struct {
+	conf      FramingConf
+	delimiter []byte
+	order     binary.ByteOrder
+}
+
+// NewFramer validates the configuration and fills the defaults
+func NewFramer(c *FramingConf) (*Framer, error) {
+	f := &Framer{conf: *c, order: binary.BigEndian}
+	fc := &f.conf
+	fc.Framing = strings.ToLower(fc.Framing)
+	if fc.MaxFrameSize == 0 {
+		fc.MaxFrameSize = DEFAULT_MAX_FRAME_SIZE
+	}
+	if fc.MaxFrameSize < 0 {
+		return nil, fmt.Errorf("invalid property maxFrameSize: %d, must be a positive integer", fc.MaxFrameSize)
+	}
+	switch fc.Framing {
+	case "", FRAMING_DELIMITER:
+		fc.Framing = FRAMING_DELIMITER
+		if fc.Delimiter == "" {
+			fc.Delimiter = "\n"
+		}
+		if strings.HasPrefix(fc.Delimiter, "0x") {
+			d, err := hex.DecodeString(fc.Delimiter[2:])
+			if err != nil || len(d) == 0 {
+				return nil, fmt.Errorf("invalid property delimiter: %s, must be a text or hex with the prefix 0x", fc.Delimiter)
+			}
+			f.delimiter = d
+		} else {
+			f.delimiter = []byte(fc.Delimiter)
+		}
+	case FRAMING_FIXED:
+		if fc.FrameLength <= 0 || fc.FrameLength > fc.MaxFrameSize {
+			return nil, fmt.Errorf("invalid property frameLength: %d, must be between 1 and maxFrameSize %d", fc.FrameLength, fc.MaxFrameSize)
+		}
+	case FRAMING_LENGTH:
+		switch fc.LengthSize {
+		case 0:
+			fc.LengthSize = 4
+		case 1, 2, 4:
+		default:
+			return nil, fmt.Errorf("invalid property lengthSize: %d, must be 1, 2 or 4", fc.LengthSize)
+		}
+		switch strings.ToLower(fc.ByteOrder) {
+		case "", "big":
+			fc.ByteOrder = "big"
+		case "little":
+			fc.ByteOrder = "little"
+			f.order = binary.LittleEndian
+		default:
+			return nil, fmt.Errorf("invalid property byteOrder: %s, must be big or little", fc.ByteOrder)
+		}
+	default:
+		return nil, fmt.Errorf("invalid property framing: %s, must be delimiter, fixed or length", c.Framing)
+	}
+	return f, nil
+}
+
+// Reader reads the frames from a byte stream
+type Reader struct {
+	f *Framer
+	r *bufio.Reader
+}
+
+func (f *Framer) NewReader(r io.Reader) *Reader {
+	return &Reader{f: f, r: bufio.NewReader(r)}
+}
+
+// Read returns the next frame. In delimiter framing, the remaining data without the delimiter is the last frame
+// at EOF. It returns io.EOF if there is no more frame.
+func (r *Reader) Read() ([]byte, error) {
+	switch r.f.conf.Framing {
+	case FRAMING_FIXED:
+		return r.readFull(r.f.conf.FrameLength)
+	case FRAMING_LENGTH:
+		prefix, err := r.readFull(r.f.conf.LengthSize)
+		if err != nil {
+			return nil, err
+		}
+		var n uint64
+		switch len(prefix) {
+		case 1:
+			n = uint64(prefix[0])
+		case 2:
+			n = uint64(r.f.order.Uint16(prefix))
+		case 4:
+			n = uint64(r.f.order.Uint32(prefix))
+		}
+		if n > uint64(r.f.conf.MaxFrameSize) {
+			return nil, fmt.Errorf("frame length %d exceeds the max frame size %d", n, r.f.conf.MaxFrameSize)
+		}
+		if n == 0 {
+			return []byte{}, nil
+		}
+		frame, err := r.readFull(int(n))
+		if err == io.EOF {
+			return nil, errors.New("incomplete frame at the end of the data")
+		}
+		return frame, err
+	default:
+		return r.readDelimited()
+	}
+}
+
+func (r *Reader) readFull(n int) ([]byte, error) {
+	frame := make([]byte, n)
+	if _, err := io.ReadFull(r.r, frame); err != nil {
+		if err == io.ErrUnexpectedEOF {
+			return nil, errors.New("incomplete frame at the end of the data")
+		}
+		return nil, err
+	}
+	return frame, nil
+}
+
+func (r *Reader) readDelimited() ([]byte, error) {
+	d := r.f.delimiter
+	var frame []byte
+	for {
+		b, err := r.r.ReadSlice(d[len(d)-1])
+		frame = append(frame, b...)
+		if len(frame) > r.f.conf.MaxFrameSize+len(d) {
+			return nil, fmt.Errorf("frame exceeds the max frame size %d", r.f.conf.MaxFrameSize)
+		}
+		if err == nil && bytes.HasSuffix(frame, d) {
+			return frame[:len(frame)-len(d)], nil
+		}
+		if err != nil && err != bufio.ErrBufferFull {
+			if err == io.EOF && len(frame) > 0 {
+				return frame, nil
+			}
+			return nil, err
+		}
+	}
+}
+
+// Split splits a datagram into the frames
+func (f *Framer) Split(datagram []byte) ([][]byte, error) {
+	var frames [][]byte
+	r := f.NewReader(bytes.NewReader(datagram))
+	for {
+		frame, err := r.Read()
+		if err == io.EOF {
+			return frames, nil
+		}
+		if err != nil {
+			return frames, err
+		}
+		frames = append(frames, frame)
+	}
+}
+
+// Encode returns the frame of the data. In fixed framing, the shorter data is padded with zero bytes.
+func (f *Framer) Encode(data []byte) ([]byte, error) {
+	switch f.conf.Framing {
+	case FRAMING_FIXED:
+		if len(data) > f.conf.FrameLength {
+			return nil, fmt.Errorf("data length %d exceeds the frame length %d", len(data), f.conf.FrameLength)
+		}
+		frame := make([]byte, f.conf.FrameLength)
+		copy(frame, data)
+		return frame, nil
+	case FRAMING_LENGTH:
+		n := len(data)
+		if n > f.conf.MaxFrameSize || f.conf.LengthSize < 4 && n >= 1<<(8*f.conf.LengthSize) {
+			return nil, fmt.Errorf("data length %d exceeds the max frame size", n)
+		}
+		frame := make([]byte, f.conf.LengthSize, f.conf.LengthSize+n)
+		switch f.conf.LengthSize {
+		case 1:
+			frame[0] = byte(n)
+		case 2:
+			f.order.PutUint16(frame, uint16(n))
+		case 4:
+			f.order.PutUint32(frame, uint32(n))
+		}
+		return append(frame, data...), nil
+	default:
+		frame := make([]byte, 0, len(data)+len(f.delimiter))
+		return append(append(frame, data...), f.delimiter...), nil
+	}
+}
+
+// ReadFrames reads the frames from the tcp connection until it is broken
+func (f *Framer) ReadFrames(c io.Reader, h func(frame []byte)) error {
+	r := f.NewReader(c)
+	for {
+		frame, err := r.Read()
+		if err != nil {
+			return err
+		}
+		h(frame)
+	}
+}
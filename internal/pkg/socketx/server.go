@@ -0,0 +1,287 @@
+// Copyright 2021 EMQ Technologies Co., Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package socketx
+
+import (
+	"errors"
+	"fmt"
+	"github.com/lf-edge/ekuiper/internal/conf"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	MODE_CLIENT = "client"
+	MODE_SERVER = "server"
+
+	PROTOCOL_TCP = "tcp"
+	PROTOCOL_UDP = "udp"
+
+	DEFAULT_TIMEOUT = 10000
+	// The max count of the udp peers to send to in server mode
+	maxUdpPeers = 1024
+)
+
+// GetMode validates the mode. The default mode is used if not set.
+func GetMode(mode string, def string) (string, error) {
+	switch strings.ToLower(mode) {
+	case "":
+		return def, nil
+	case MODE_CLIENT:
+		return MODE_CLIENT, nil
+	case MODE_SERVER:
+		return MODE_SERVER, nil
+	default:
+		return "", fmt.Errorf("invalid property mode: %s, must be client or server", mode)
+	}
+}
+
+// GetProtocol validates the protocol. The default protocol is tcp.
+func GetProtocol(protocol string) (string, error) {
+	switch strings.ToLower(protocol) {
+	case "", PROTOCOL_TCP:
+		return PROTOCOL_TCP, nil
+	case PROTOCOL_UDP:
+		return PROTOCOL_UDP, nil
+	default:
+		return "", fmt.Errorf("invalid property protocol: %s, must be tcp or udp", protocol)
+	}
+}
+
+// ValidateAddr checks the address is in the form of host:port. The host can be omitted in server mode.
+func ValidateAddr(addr string, mode string) error {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return fmt.Errorf("invalid address %s: %v", addr, err)
+	}
+	if host == "" && mode == MODE_CLIENT {
+		return fmt.Errorf("invalid address %s: host is missing", addr)
+	}
+	return nil
+}
+
+// Handler handles a frame received from the remote address
+type Handler func(frame []byte, remote net.Addr)
+
+// The servers are shared by the sources and sinks with the same protocol and listening address
+var (
+	serversMu sync.Mutex
+	servers   = make(map[string]*Server)
+)
+
+// Server listens to a tcp or udp address. The received frames are dispatched to all the handlers, and the written
+// frames are sent to all the connected tcp clients or the udp peers which have sent any datagram.
+type Server struct {
+	key    string
+	framer *Framer
+	refs   int
+
+	pc net.PacketConn
+	ln net.Listener
+
+	mu       sync.RWMutex
+	handlers map[interface{}]Handler
+	conns    map[net.Conn]struct{}
+	peers    map[string]net.Addr
+
+	writeMu sync.Mutex
+}
+
+// AcquireServer returns the server listening to the address. It is started if not exist.
+func AcquireServer(protocol, addr string, c *FramingConf) (*Server, error) {
+	f, err := NewFramer(c)
+	if err != nil {
+		return nil, err
+	}
+	serversMu.Lock()
+	defer serversMu.Unlock()
+	key := protocol + "://" + addr
+	if s, ok := servers[key]; ok {
+		if s.framer.conf != f.conf {
+			return nil, fmt.Errorf("socket server %s is already started with different framing", key)
+		}
+		s.refs++
+		return s, nil
+	}
+	s := &Server{key: key, framer: f, refs: 1, handlers: make(map[interface{}]Handler), conns: make(map[net.Conn]struct{}), peers: make(map[string]net.Addr)}
+	if protocol == PROTOCOL_UDP {
+		pc, err := net.ListenPacket(protocol, addr)
+		if err != nil {
+			return nil, fmt.Errorf("socket server fails to listen to %s: %v", key, err)
+		}
+		s.pc = pc
+		go s.serveUDP()
+	} else {
+		ln, err := net.Listen(protocol, addr)
+		if err != nil {
+			return nil, fmt.Errorf("socket server fails to listen to %s: %v", key, err)
+		}
+		s.ln = ln
+		go s.serveTCP()
+	}
+	servers[key] = s
+	return s, nil
+}
+
+// ReleaseServer releases the reference of the server. The last release stops the server and closes the connections.
+func ReleaseServer(s *Server) {
+	serversMu.Lock()
+	defer serversMu.Unlock()
+	s.refs--
+	if s.refs > 0 {
+		return
+	}
+	delete(servers, s.key)
+	if s.pc != nil {
+		_ = s.pc.Close()
+	}
+	if s.ln != nil {
+		_ = s.ln.Close()
+	}
+	s.mu.Lock()
+	for c := range s.conns {
+		_ = c.Close()
+	}
+	s.mu.Unlock()
+}
+
+// Addr returns the listening address which has the actual port if listening to port 0
+func (s *Server) Addr() net.Addr {
+	if s.pc != nil {
+		return s.pc.LocalAddr()
+	}
+	return s.ln.Addr()
+}
+
+// Handle registers the handler by the key
+func (s *Server) Handle(key interface{}, h Handler) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.handlers[key] = h
+}
+
+func (s *Server) Remove(key interface{}) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.handlers, key)
+}
+
+func (s *Server) dispatch(frame []byte, remote net.Addr) {
+	s.mu.RLock()
+	hs := make([]Handler, 0, len(s.handlers))
+	for _, h := range s.handlers {
+		hs = append(hs, h)
+	}
+	s.mu.RUnlock()
+	for _, h := range hs {
+		h(frame, remote)
+	}
+}
+
+// serveUDP splits each datagram into frames and remembers the peer to send to
+func (s *Server) serveUDP() {
+	buf := make([]byte, maxDatagramSize)
+	for {
+		n, remote, err := s.pc.ReadFrom(buf)
+		if err != nil {
+			return
+		}
+		s.mu.Lock()
+		if _, ok := s.peers[remote.String()]; ok || len(s.peers) < maxUdpPeers {
+			s.peers[remote.String()] = remote
+		}
+		s.mu.Unlock()
+		frames, err := s.framer.Split(buf[:n])
+		for _, frame := range frames {
+			s.dispatch(frame, remote)
+		}
+		if err != nil {
+			conf.Log.Warnf("socket server %s drops the invalid datagram from %s: %v", s.key, remote, err)
+		}
+	}
+}
+
+func (s *Server) serveTCP() {
+	for {
+		c, err := s.ln.Accept()
+		if err != nil {
+			return
+		}
+		s.mu.Lock()
+		s.conns[c] = struct{}{}
+		s.mu.Unlock()
+		go s.serveConn(c)
+	}
+}
+
+func (s *Server) serveConn(c net.Conn) {
+	err := s.framer.ReadFrames(c, func(frame []byte) {
+		s.dispatch(frame, c.RemoteAddr())
+	})
+	// The connection is closed when the server is released
+	if err != nil && err != io.EOF && !errors.Is(err, net.ErrClosed) {
+		conf.Log.Warnf("socket server %s closes the connection from %s: %v", s.key, c.RemoteAddr(), err)
+	}
+	s.closeConn(c)
+}
+
+func (s *Server) closeConn(c net.Conn) {
+	s.mu.Lock()
+	delete(s.conns, c)
+	s.mu.Unlock()
+	_ = c.Close()
+}
+
+// Write encodes the data into a frame and sends it to all the connected clients or peers. The connection which
+// fails to write in the timeout is closed. It returns the count of the clients sent to.
+func (s *Server) Write(data []byte, timeout time.Duration) (int, error) {
+	frame, err := s.framer.Encode(data)
+	if err != nil {
+		return 0, err
+	}
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+	s.mu.RLock()
+	conns := make([]net.Conn, 0, len(s.conns))
+	for c := range s.conns {
+		conns = append(conns, c)
+	}
+	peers := make([]net.Addr, 0, len(s.peers))
+	for _, p := range s.peers {
+		peers = append(peers, p)
+	}
+	s.mu.RUnlock()
+	n := 0
+	for _, c := range conns {
+		_ = c.SetWriteDeadline(time.Now().Add(timeout))
+		if _, err := c.Write(frame); err != nil {
+			conf.Log.Warnf("socket server %s fails to write to %s: %v, close the connection", s.key, c.RemoteAddr(), err)
+			s.closeConn(c)
+			continue
+		}
+		n++
+	}
+	for _, p := range peers {
+		if _, err := s.pc.WriteTo(frame, p); err != nil {
+			conf.Log.Warnf("socket server %s fails to write to %s: %v", s.key, p, err)
+			continue
+		}
+		n++
+	}
+	return n, nil
+}
@@ -0,0 +1,183 @@
+// Copyright 2021 EMQ Technologies Co., Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package socketx
+
+import (
+	"bufio"
+	"github.com/lf-edge/ekuiper/internal/testx"
+	"net"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestFraming(t *testing.T) {
+	var tests = []struct {
+		conf   *FramingConf
+		data   string
+		frames []string
+		err    string
+		encode string
+	}{
+		{
+			conf:   &FramingConf{},
+			data:   "a,1\nb,2\n\nc,3",
+			frames: []string{"a,1", "b,2", "", "c,3"},
+			encode: "a,1\n",
+		}, {
+			conf:   &FramingConf{Framing: "Delimiter", Delimiter: "\r\n"},
+			data:   "a\rb\r\nc\r\n",
+			frames: []string{"a\rb", "c"},
+			encode: "a,1\r\n",
+		}, {
+			conf:   &FramingConf{Delimiter: "0x03"},
+			data:   "\x02a\x03\x02b\x03",
+			frames: []string{"\x02a", "\x02b"},
+			encode: "a,1\x03",
+		}, {
+			conf:   &FramingConf{Delimiter: "|", MaxFrameSize: 4},
+			data:   "abc|abcdefgh|",
+			frames: []string{"abc"},
+			err:    "frame exceeds the max frame size 4",
+			encode: "a,1|",
+		}, {
+			conf:   &FramingConf{Framing: "fixed", FrameLength: 4},
+			data:   "abcd1234xy",
+			frames: []string{"abcd", "1234"},
+			err:    "incomplete frame at the end of the data",
+			encode: "a,1\x00",
+		}, {
+			conf:   &FramingConf{Framing: "length"},
+			data:   "\x00\x00\x00\x03abc\x00\x00\x00\x00\x00\x00\x00\x01d",
+			frames: []string{"abc", "", "d"},
+			encode: "\x00\x00\x00\x03a,1",
+		}, {
+			conf:   &FramingConf{Framing: "length", LengthSize: 2, ByteOrder: "little", MaxFrameSize: 100},
+			data:   "\x03\x00abc\xff\xff",
+			frames: []string{"abc"},
+			err:    "frame length 65535 exceeds the max frame size 100",
+			encode: "\x03\x00a,1",
+		}, {
+			conf:   &FramingConf{Framing: "length", LengthSize: 1},
+			data:   "\x02ab\x05",
+			frames: []string{"ab"},
+			err:    "incomplete frame at the end of the data",
+			encode: "\x03a,1",
+		},
+	}
+	for i, tt := range tests {
+		f, err := NewFramer(tt.conf)
+		if err != nil {
+			t.Errorf("%d: %v", i, err)
+			continue
+		}
+		frames, err := f.Split([]byte(tt.data))
+		var result []string
+		for _, frame := range frames {
+			result = append(result, string(frame))
+		}
+		if tt.err != testx.Errstring(err) || !reflect.DeepEqual(tt.frames, result) {
+			t.Errorf("%d: frames mismatch:\n  exp=%q %s\n  got=%q %v", i, tt.frames, tt.err, result, err)
+		}
+		encoded, err := f.Encode([]byte("a,1"))
+		if err != nil || string(encoded) != tt.encode {
+			t.Errorf("%d: encode mismatch:\n  exp=%q\n  got=%q %v", i, tt.encode, encoded, err)
+		}
+	}
+}
+
+func TestFramingConf(t *testing.T) {
+	var tests = []struct {
+		conf *FramingConf
+		err  string
+	}{
+		{conf: &FramingConf{Framing: "slip"}, err: "invalid property framing: slip, must be delimiter, fixed or length"},
+		{conf: &FramingConf{Delimiter: "0xzz"}, err: "invalid property delimiter: 0xzz, must be a text or hex with the prefix 0x"},
+		{conf: &FramingConf{Framing: "fixed"}, err: "invalid property frameLength: 0, must be between 1 and maxFrameSize 65536"},
+		{conf: &FramingConf{Framing: "length", LengthSize: 3}, err: "invalid property lengthSize: 3, must be 1, 2 or 4"},
+		{conf: &FramingConf{Framing: "length", ByteOrder: "middle"}, err: "invalid property byteOrder: middle, must be big or little"},
+		{conf: &FramingConf{MaxFrameSize: -1}, err: "invalid property maxFrameSize: -1, must be a positive integer"},
+	}
+	for i, tt := range tests {
+		_, err := NewFramer(tt.conf)
+		if tt.err != testx.Errstring(err) {
+			t.Errorf("%d: error mismatch:\n  exp=%s\n  got=%s", i, tt.err, err)
+		}
+	}
+	f, _ := NewFramer(&FramingConf{Framing: "length", LengthSize: 1})
+	if _, err := f.Encode(make([]byte, 256)); testx.Errstring(err) != "data length 256 exceeds the max frame size" {
+		t.Errorf("encode error mismatch, got %v", err)
+	}
+}
+
+func TestServer(t *testing.T) {
+	for _, protocol := range []string{PROTOCOL_TCP, PROTOCOL_UDP} {
+		s, err := AcquireServer(protocol, "127.0.0.1:0", &FramingConf{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		addr := s.Addr().String()
+		// Share the server with the same key and framing
+		s2, err := AcquireServer(protocol, "127.0.0.1:0", &FramingConf{Framing: "delimiter", Delimiter: "\n"})
+		if err != nil || s2 != s {
+			t.Fatalf("%s: the server is not shared: %v", protocol, err)
+		}
+		if _, err := AcquireServer(protocol, "127.0.0.1:0", &FramingConf{Delimiter: ";"}); testx.Errstring(err) != "socket server "+protocol+"://127.0.0.1:0 is already started with different framing" {
+			t.Errorf("%s: error mismatch, got %v", protocol, err)
+		}
+		received := make(chan string, 10)
+		for _, k := range []string{"a", "b"} {
+			key := k
+			s.Handle(key, func(frame []byte, _ net.Addr) {
+				received <- key + ":" + string(frame)
+			})
+		}
+		c, err := net.Dial(protocol, addr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, _ = c.Write([]byte("1\n2\n"))
+		var result []string
+		for i := 0; i < 4; i++ {
+			select {
+			case r := <-received:
+				result = append(result, r)
+			case <-time.After(2 * time.Second):
+				t.Fatalf("%s: timeout", protocol)
+			}
+		}
+		if len(result) != 4 {
+			t.Errorf("%s: received mismatch, got %v", protocol, result)
+		}
+		n, err := s.Write([]byte("ack"), time.Second)
+		if err != nil || n != 1 {
+			t.Errorf("%s: write to %d clients: %v", protocol, n, err)
+		}
+		_ = c.SetReadDeadline(time.Now().Add(2 * time.Second))
+		line, err := bufio.NewReader(c).ReadString('\n')
+		if err != nil || line != "ack\n" {
+			t.Errorf("%s: read mismatch, got %q %v", protocol, line, err)
+		}
+		_ = c.Close()
+		ReleaseServer(s2)
+		ReleaseServer(s)
+		serversMu.Lock()
+		l := len(servers)
+		serversMu.Unlock()
+		if l != 0 {
+			t.Errorf("%s: the server is not released", protocol)
+		}
+	}
+}
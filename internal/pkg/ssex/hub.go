@@ -0,0 +1,227 @@
+// Copyright 2021 EMQ Technologies Co., Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ssex
+
+import (
+	"sync"
+	"time"
+)
+
+const (
+	DEFAULT_BUFFER_LENGTH      = 100
+	DEFAULT_CLIENT_BUFFER      = 100
+	DEFAULT_HEARTBEAT_INTERVAL = 15000
+)
+
+type HubConf struct {
+	// The number of the latest events kept to resume the clients by the last event id
+	BufferLength int `json:"bufferLength"`
+	// The number of events buffered for each client. The client which falls behind is disconnected.
+	ClientBuffer int `json:"clientBuffer"`
+	// The interval in milliseconds to send the comment line to keep the connection alive. 0 means no heartbeat.
+	HeartbeatInterval int `json:"heartbeatInterval"`
+}
+
+// Event is a result published to the stream. The id increases by one for each event of a hub.
+type Event struct {
+	Id   int64
+	Data []byte
+}
+
+// The hubs are keyed by the rule id. The sse sinks of the same rule share the hub.
+var (
+	hubsMu sync.Mutex
+	hubs   = make(map[string]*Hub)
+)
+
+// Hub keeps the latest events of a rule in a ring buffer and dispatches the events to the connected clients
+type Hub struct {
+	sync.Mutex
+	name    string
+	conf    *HubConf
+	refs    int
+	ring    []Event
+	start   int
+	lastId  int64
+	clients map[*client]bool
+	// closed and replaced when a new event is published to wake up the long-poll requests
+	notify chan struct{}
+	closed bool
+}
+
+type client struct {
+	ch chan Event
+	// closed when the client is disconnected by the hub
+	done chan struct{}
+}
+
+// AcquireHub returns the hub of the rule. It is created with the conf if not exist.
+func AcquireHub(name string, c *HubConf) *Hub {
+	hubsMu.Lock()
+	defer hubsMu.Unlock()
+	if h, ok := hubs[name]; ok {
+		h.refs++
+		return h
+	}
+	cc := *c
+	if cc.BufferLength <= 0 {
+		cc.BufferLength = DEFAULT_BUFFER_LENGTH
+	}
+	if cc.ClientBuffer <= 0 {
+		cc.ClientBuffer = DEFAULT_CLIENT_BUFFER
+	}
+	if cc.HeartbeatInterval < 0 {
+		cc.HeartbeatInterval = 0
+	}
+	h := &Hub{
+		name:    name,
+		conf:    &cc,
+		refs:    1,
+		ring:    make([]Event, 0, cc.BufferLength),
+		clients: make(map[*client]bool),
+		notify:  make(chan struct{}),
+	}
+	hubs[name] = h
+	return h
+}
+
+// ReleaseHub releases the reference of the hub. The last release closes the hub and disconnects all the clients.
+func ReleaseHub(h *Hub) {
+	hubsMu.Lock()
+	h.refs--
+	if h.refs > 0 {
+		hubsMu.Unlock()
+		return
+	}
+	if hubs[h.name] == h {
+		delete(hubs, h.name)
+	}
+	hubsMu.Unlock()
+	h.Lock()
+	defer h.Unlock()
+	h.closed = true
+	for c := range h.clients {
+		h.disconnect(c)
+	}
+	close(h.notify)
+}
+
+// GetHub returns the hub of the rule if the rule has a running sse sink
+func GetHub(name string) (*Hub, bool) {
+	hubsMu.Lock()
+	defer hubsMu.Unlock()
+	h, ok := hubs[name]
+	return h, ok
+}
+
+// Publish appends the event to the ring buffer and sends it to the clients. It returns the number of the clients.
+func (h *Hub) Publish(data []byte) int {
+	h.Lock()
+	defer h.Unlock()
+	if h.closed {
+		return 0
+	}
+	h.lastId++
+	e := Event{Id: h.lastId, Data: data}
+	if len(h.ring) < h.conf.BufferLength {
+		h.ring = append(h.ring, e)
+	} else {
+		h.ring[h.start] = e
+		h.start = (h.start + 1) % len(h.ring)
+	}
+	for c := range h.clients {
+		select {
+		case c.ch <- e:
+		default:
+			// The slow client is disconnected. It can resume by the last event id if still in the ring buffer.
+			h.disconnect(c)
+		}
+	}
+	close(h.notify)
+	h.notify = make(chan struct{})
+	return len(h.clients)
+}
+
+// since returns the buffered events after the id. If the id is bigger than the last id, the hub is supposed to be
+// recreated by restarting the rule, so all the buffered events are returned. Must be called with the lock held.
+func (h *Hub) since(id int64) []Event {
+	if id > h.lastId {
+		id = 0
+	}
+	var result []Event
+	for i := 0; i < len(h.ring); i++ {
+		e := h.ring[(h.start+i)%len(h.ring)]
+		if e.Id > id {
+			result = append(result, e)
+		}
+	}
+	return result
+}
+
+// subscribe registers a client and returns the buffered events after the id atomically so that no event is missed
+func (h *Hub) subscribe(id int64) (*client, []Event, bool) {
+	h.Lock()
+	defer h.Unlock()
+	if h.closed {
+		return nil, nil, false
+	}
+	c := &client{ch: make(chan Event, h.conf.ClientBuffer), done: make(chan struct{})}
+	h.clients[c] = true
+	return c, h.since(id), true
+}
+
+func (h *Hub) unsubscribe(c *client) {
+	h.Lock()
+	defer h.Unlock()
+	if h.clients[c] {
+		h.disconnect(c)
+	}
+}
+
+// disconnect removes the client. Must be called with the lock held.
+func (h *Hub) disconnect(c *client) {
+	delete(h.clients, c)
+	close(c.done)
+}
+
+// poll returns the buffered events after the id. If there is none, it waits for the next event until timeout.
+func (h *Hub) poll(id int64, timeout time.Duration, done <-chan struct{}) []Event {
+	h.Lock()
+	events := h.since(id)
+	notify, closed := h.notify, h.closed
+	h.Unlock()
+	if len(events) > 0 || closed {
+		return events
+	}
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case <-notify:
+	case <-timer.C:
+		return nil
+	case <-done:
+		return nil
+	}
+	h.Lock()
+	defer h.Unlock()
+	return h.since(id)
+}
+
+// ClientCount returns the number of the connected stream clients
+func (h *Hub) ClientCount() int {
+	h.Lock()
+	defer h.Unlock()
+	return len(h.clients)
+}
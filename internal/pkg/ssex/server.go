@@ -0,0 +1,189 @@
+// Copyright 2021 EMQ Technologies Co., Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ssex
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	DEFAULT_POLL_TIMEOUT = 30000
+	MAX_POLL_TIMEOUT     = 120000
+	// The reconnection time in milliseconds suggested to the EventSource
+	retryInterval = 3000
+)
+
+// PollEvent is an event in the long-poll response. The data is embedded as json if valid, otherwise as a string.
+type PollEvent struct {
+	Id   int64       `json:"id"`
+	Data interface{} `json:"data"`
+}
+
+type PollResult struct {
+	Events      []PollEvent `json:"events"`
+	LastEventId int64       `json:"lastEventId"`
+}
+
+// ServeHTTP serves the events as server sent events. If the request accepts json only or the mode query is poll,
+// the events after the last event id are returned as json by long polling instead.
+func (h *Hub) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	lastId, err := lastEventId(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if isPoll(r) {
+		h.servePoll(w, r, lastId)
+		return
+	}
+	h.serveStream(w, r, lastId)
+}
+
+// lastEventId reads the id from the Last-Event-ID header sent by the EventSource on reconnection or the lastEventId
+// query
+func lastEventId(r *http.Request) (int64, error) {
+	s := r.Header.Get("Last-Event-ID")
+	if s == "" {
+		s = r.URL.Query().Get("lastEventId")
+	}
+	if s == "" {
+		return 0, nil
+	}
+	id, err := strconv.ParseInt(s, 10, 64)
+	if err != nil || id < 0 {
+		return 0, fmt.Errorf("invalid last event id %s", s)
+	}
+	return id, nil
+}
+
+func isPoll(r *http.Request) bool {
+	if r.URL.Query().Get("mode") == "poll" {
+		return true
+	}
+	accept := r.Header.Get("Accept")
+	return strings.Contains(accept, "application/json") && !strings.Contains(accept, "text/event-stream")
+}
+
+func (h *Hub) serveStream(w http.ResponseWriter, r *http.Request, lastId int64) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming is not supported", http.StatusInternalServerError)
+		return
+	}
+	c, backlog, ok := h.subscribe(lastId)
+	if !ok {
+		http.Error(w, "the stream is closed", http.StatusNotFound)
+		return
+	}
+	defer h.unsubscribe(c)
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+	if _, err := fmt.Fprintf(w, "retry: %d\n\n", retryInterval); err != nil {
+		return
+	}
+	for _, e := range backlog {
+		if err := writeEvent(w, e); err != nil {
+			return
+		}
+	}
+	flusher.Flush()
+	var heartbeat <-chan time.Time
+	if h.conf.HeartbeatInterval > 0 {
+		ticker := time.NewTicker(time.Duration(h.conf.HeartbeatInterval) * time.Millisecond)
+		defer ticker.Stop()
+		heartbeat = ticker.C
+	}
+	for {
+		select {
+		case e := <-c.ch:
+			if err := writeEvent(w, e); err != nil {
+				return
+			}
+			flusher.Flush()
+		case <-heartbeat:
+			if _, err := w.Write([]byte(":\n\n")); err != nil {
+				return
+			}
+			flusher.Flush()
+		case <-c.done:
+			// Send the events already buffered before disconnected
+			for {
+				select {
+				case e := <-c.ch:
+					if err := writeEvent(w, e); err != nil {
+						return
+					}
+				default:
+					flusher.Flush()
+					return
+				}
+			}
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
+
+// writeEvent writes the event with the id. Each line of the data is written as a data field.
+func writeEvent(w http.ResponseWriter, e Event) error {
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "id: %d\n", e.Id)
+	for _, line := range bytes.Split(bytes.TrimRight(e.Data, "\r\n"), []byte("\n")) {
+		b.WriteString("data: ")
+		b.Write(bytes.TrimRight(line, "\r"))
+		b.WriteByte('\n')
+	}
+	b.WriteByte('\n')
+	_, err := w.Write(b.Bytes())
+	return err
+}
+
+func (h *Hub) servePoll(w http.ResponseWriter, r *http.Request, lastId int64) {
+	timeout := DEFAULT_POLL_TIMEOUT
+	if s := r.URL.Query().Get("timeout"); s != "" {
+		t, err := strconv.Atoi(s)
+		if err != nil || t < 0 {
+			http.Error(w, fmt.Sprintf("invalid timeout %s", s), http.StatusBadRequest)
+			return
+		}
+		if t > MAX_POLL_TIMEOUT {
+			t = MAX_POLL_TIMEOUT
+		}
+		timeout = t
+	}
+	events := h.poll(lastId, time.Duration(timeout)*time.Millisecond, r.Context().Done())
+	result := &PollResult{Events: make([]PollEvent, 0, len(events)), LastEventId: lastId}
+	for _, e := range events {
+		var data interface{}
+		if json.Valid(e.Data) {
+			data = json.RawMessage(e.Data)
+		} else {
+			data = string(e.Data)
+		}
+		result.Events = append(result.Events, PollEvent{Id: e.Id, Data: data})
+		result.LastEventId = e.Id
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-cache")
+	_ = json.NewEncoder(w).Encode(result)
+}
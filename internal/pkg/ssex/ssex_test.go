@@ -0,0 +1,183 @@
+// Copyright 2021 EMQ Technologies Co., Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ssex
+
+import (
+	"bufio"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// readEvents reads n events from the stream and returns the id and data lines of each event
+func readEvents(t *testing.T, r *bufio.Reader, n int) []string {
+	var (
+		result []string
+		cur    []string
+	)
+	for len(result) < n {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			t.Fatalf("read stream error: %v", err)
+		}
+		line = strings.TrimRight(line, "\n")
+		switch {
+		case line == "":
+			if len(cur) > 0 {
+				result = append(result, strings.Join(cur, "|"))
+				cur = nil
+			}
+		case strings.HasPrefix(line, "id:") || strings.HasPrefix(line, "data:"):
+			cur = append(cur, line)
+		}
+	}
+	return result
+}
+
+func waitClients(h *Hub, n int) {
+	for i := 0; i < 100 && h.ClientCount() != n; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestStream(t *testing.T) {
+	h := AcquireHub("rule1", &HubConf{BufferLength: 3, ClientBuffer: 10})
+	defer ReleaseHub(h)
+	if g, ok := GetHub("rule1"); !ok || g != h {
+		t.Fatal("hub is not registered")
+	}
+	ts := httptest.NewServer(h)
+	defer ts.Close()
+
+	h.Publish([]byte(`{"a":1}`))
+	h.Publish([]byte(`{"a":2}`))
+	resp, err := http.Get(ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ct := resp.Header.Get("Content-Type"); ct != "text/event-stream" {
+		t.Errorf("expect content type text/event-stream but got %s", ct)
+	}
+	r := bufio.NewReader(resp.Body)
+	// The buffered events are sent first
+	exp := []string{`id: 1|data: {"a":1}`, `id: 2|data: {"a":2}`}
+	if got := readEvents(t, r, 2); !reflect.DeepEqual(exp, got) {
+		t.Errorf("expect %v but got %v", exp, got)
+	}
+	waitClients(h, 1)
+	h.Publish([]byte("line1\nline2"))
+	exp = []string{`id: 3|data: line1|data: line2`}
+	if got := readEvents(t, r, 1); !reflect.DeepEqual(exp, got) {
+		t.Errorf("expect %v but got %v", exp, got)
+	}
+	resp.Body.Close()
+	waitClients(h, 0)
+
+	// Resume by the last event id. The event 1 and 2 are dropped from the ring buffer.
+	h.Publish([]byte(`{"a":4}`))
+	h.Publish([]byte(`{"a":5}`))
+	req, _ := http.NewRequest(http.MethodGet, ts.URL, nil)
+	req.Header.Set("Last-Event-ID", "3")
+	resp, err = http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	exp = []string{`id: 4|data: {"a":4}`, `id: 5|data: {"a":5}`}
+	if got := readEvents(t, bufio.NewReader(resp.Body), 2); !reflect.DeepEqual(exp, got) {
+		t.Errorf("expect %v but got %v", exp, got)
+	}
+	resp.Body.Close()
+}
+
+func TestSlowClient(t *testing.T) {
+	h := AcquireHub("rule2", &HubConf{BufferLength: 10, ClientBuffer: 1})
+	c, _, _ := h.subscribe(0)
+	h.Publish([]byte("1"))
+	h.Publish([]byte("2"))
+	select {
+	case <-c.done:
+	default:
+		t.Error("the slow client is not disconnected")
+	}
+	if n := h.ClientCount(); n != 0 {
+		t.Errorf("expect no client but got %d", n)
+	}
+	c2, _, _ := h.subscribe(0)
+	ReleaseHub(h)
+	select {
+	case <-c2.done:
+	default:
+		t.Error("the client is not disconnected when the hub is released")
+	}
+	if _, ok := GetHub("rule2"); ok {
+		t.Error("hub is not removed")
+	}
+}
+
+func TestPoll(t *testing.T) {
+	h := AcquireHub("rule3", &HubConf{})
+	defer ReleaseHub(h)
+	ts := httptest.NewServer(h)
+	defer ts.Close()
+	h.Publish([]byte(`{"a":1}`))
+	h.Publish([]byte(`text`))
+
+	poll := func(query string) *PollResult {
+		req, _ := http.NewRequest(http.MethodGet, ts.URL+query, nil)
+		req.Header.Set("Accept", "application/json")
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		body, _ := ioutil.ReadAll(resp.Body)
+		result := &PollResult{}
+		if err := json.Unmarshal(body, result); err != nil {
+			t.Fatalf("decode %s error: %v", body, err)
+		}
+		return result
+	}
+	exp := &PollResult{Events: []PollEvent{{Id: 1, Data: map[string]interface{}{"a": 1.0}}, {Id: 2, Data: "text"}}, LastEventId: 2}
+	if got := poll(""); !reflect.DeepEqual(exp, got) {
+		t.Errorf("expect %v but got %v", exp, got)
+	}
+	exp = &PollResult{Events: []PollEvent{}, LastEventId: 2}
+	if got := poll("?lastEventId=2&timeout=10"); !reflect.DeepEqual(exp, got) {
+		t.Errorf("expect %v but got %v", exp, got)
+	}
+	// The request waits for the next event
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		h.Publish([]byte(`{"a":3}`))
+	}()
+	exp = &PollResult{Events: []PollEvent{{Id: 3, Data: map[string]interface{}{"a": 3.0}}}, LastEventId: 3}
+	if got := poll("?lastEventId=2&timeout=5000"); !reflect.DeepEqual(exp, got) {
+		t.Errorf("expect %v but got %v", exp, got)
+	}
+
+	resp, err := http.Get(ts.URL + "?mode=poll&lastEventId=abc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("expect bad request but got %d", resp.StatusCode)
+	}
+}
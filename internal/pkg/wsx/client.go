@@ -0,0 +1,182 @@
+// Copyright 2021 EMQ Technologies Co., Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package wsx
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"github.com/gorilla/websocket"
+	"github.com/lf-edge/ekuiper/pkg/api"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	MODE_CLIENT = "client"
+	MODE_SERVER = "server"
+)
+
+const (
+	DEFAULT_RECONNECT_INTERVAL     = 1000
+	DEFAULT_MAX_RECONNECT_INTERVAL = 30000
+)
+
+type ClientConf struct {
+	Url                  string            `json:"url"`
+	Headers              map[string]string `json:"headers"`
+	ReconnectInterval    int               `json:"reconnectInterval"`
+	MaxReconnectInterval int               `json:"maxReconnectInterval"`
+	InsecureSkipVerify   bool              `json:"insecureSkipVerify"`
+}
+
+// GetMode validates the mode property. If the mode is not set, it is client mode if the url is set, otherwise server mode.
+func GetMode(mode string, url string) (string, error) {
+	switch strings.ToLower(mode) {
+	case "":
+		if url != "" {
+			return MODE_CLIENT, nil
+		}
+		return MODE_SERVER, nil
+	case MODE_CLIENT:
+		if url == "" {
+			return "", errors.New("property url is required in client mode")
+		}
+		return MODE_CLIENT, nil
+	case MODE_SERVER:
+		return MODE_SERVER, nil
+	default:
+		return "", fmt.Errorf("invalid property mode: %s, must be client or server", mode)
+	}
+}
+
+// Client keeps a connection to the websocket server. When the connection is lost, it reconnects with
+// an exponential backoff from ReconnectInterval to MaxReconnectInterval in milliseconds.
+type Client struct {
+	sync.Mutex
+	conf   *ClientConf
+	conn   *websocket.Conn
+	closed bool
+}
+
+func NewClient(c *ClientConf) *Client {
+	if c.ReconnectInterval <= 0 {
+		c.ReconnectInterval = DEFAULT_RECONNECT_INTERVAL
+	}
+	if c.MaxReconnectInterval < c.ReconnectInterval {
+		c.MaxReconnectInterval = DEFAULT_MAX_RECONNECT_INTERVAL
+		if c.MaxReconnectInterval < c.ReconnectInterval {
+			c.MaxReconnectInterval = c.ReconnectInterval
+		}
+	}
+	return &Client{conf: c}
+}
+
+// Run connects to the server and reads the messages until the context is done or the client is closed.
+// The received messages are passed to the handler if it is not nil.
+func (c *Client) Run(ctx api.StreamContext, h MessageHandler) {
+	logger := ctx.GetLogger()
+	dialer := &websocket.Dialer{
+		Proxy:            http.ProxyFromEnvironment,
+		HandshakeTimeout: 10 * time.Second,
+		TLSClientConfig:  &tls.Config{InsecureSkipVerify: c.conf.InsecureSkipVerify},
+	}
+	header := http.Header{}
+	for k, v := range c.conf.Headers {
+		header.Set(k, v)
+	}
+	backoff := c.conf.ReconnectInterval
+	for {
+		if c.isClosed(ctx) {
+			return
+		}
+		conn, _, err := dialer.Dial(c.conf.Url, header)
+		if err != nil {
+			logger.Warnf("websocket fails to connect to %s: %v, retry after %d ms", c.conf.Url, err, backoff)
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(time.Duration(backoff) * time.Millisecond):
+			}
+			backoff *= 2
+			if backoff > c.conf.MaxReconnectInterval {
+				backoff = c.conf.MaxReconnectInterval
+			}
+			continue
+		}
+		c.Lock()
+		if c.closed {
+			c.Unlock()
+			_ = conn.Close()
+			return
+		}
+		c.conn = conn
+		c.Unlock()
+		backoff = c.conf.ReconnectInterval
+		logger.Infof("websocket connected to %s", c.conf.Url)
+		meta := map[string]interface{}{"url": c.conf.Url, "remoteAddr": conn.RemoteAddr().String()}
+		for {
+			_, data, err := conn.ReadMessage()
+			if err != nil {
+				if !c.isClosed(ctx) {
+					logger.Warnf("websocket connection to %s is lost: %v, reconnecting", c.conf.Url, err)
+				}
+				break
+			}
+			if h != nil {
+				h(data, meta)
+			}
+		}
+		c.Lock()
+		c.conn = nil
+		c.Unlock()
+		_ = conn.Close()
+	}
+}
+
+// Send writes the data as a text message. It fails if the client is not connected.
+func (c *Client) Send(data []byte) error {
+	c.Lock()
+	defer c.Unlock()
+	if c.conn == nil {
+		return errors.New("websocket is not connected")
+	}
+	_ = c.conn.SetWriteDeadline(time.Now().Add(writeTimeout))
+	return c.conn.WriteMessage(websocket.TextMessage, data)
+}
+
+func (c *Client) Close() error {
+	c.Lock()
+	defer c.Unlock()
+	c.closed = true
+	if c.conn != nil {
+		_ = c.conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""), time.Now().Add(time.Second))
+		return c.conn.Close()
+	}
+	return nil
+}
+
+func (c *Client) isClosed(ctx api.StreamContext) bool {
+	select {
+	case <-ctx.Done():
+		return true
+	default:
+	}
+	c.Lock()
+	defer c.Unlock()
+	return c.closed
+}
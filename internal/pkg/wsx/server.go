@@ -0,0 +1,179 @@
+// Copyright 2021 EMQ Technologies Co., Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package wsx implements the websocket server endpoints and the reconnecting client
+// shared by the websocket source and sink.
+package wsx
+
+import (
+	"github.com/google/uuid"
+	"github.com/gorilla/websocket"
+	"github.com/lf-edge/ekuiper/internal/conf"
+	"github.com/lf-edge/ekuiper/internal/pkg/httpserver"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+const writeTimeout = 5 * time.Second
+
+// MessageHandler handles the received message with the metadata of the connection
+type MessageHandler func(data []byte, meta map[string]interface{})
+
+var upgrader = websocket.Upgrader{
+	// The endpoint is supposed to be consumed by dashboards from any origin
+	CheckOrigin: func(r *http.Request) bool { return true },
+}
+
+var (
+	endpointsMu sync.Mutex
+	endpoints   = make(map[string]*Endpoint)
+)
+
+// Endpoint is a websocket path of the embedded http server. It is shared by all the websocket sources
+// and sinks of the same path so that a client could both send and receive data in one connection.
+type Endpoint struct {
+	sync.RWMutex
+	path     string
+	refs     int
+	conns    map[*serverConn]bool
+	handlers map[int]MessageHandler
+	nextId   int
+}
+
+type serverConn struct {
+	sync.Mutex
+	conn *websocket.Conn
+	meta map[string]interface{}
+}
+
+// AcquireEndpoint gets the endpoint of the path and registers it to the embedded http server if not exist.
+// Each acquirement must be released by ReleaseEndpoint.
+func AcquireEndpoint(path string) (*Endpoint, error) {
+	endpointsMu.Lock()
+	defer endpointsMu.Unlock()
+	if ep, ok := endpoints[path]; ok {
+		ep.refs++
+		return ep, nil
+	}
+	ep := &Endpoint{path: path, refs: 1, conns: make(map[*serverConn]bool), handlers: make(map[int]MessageHandler)}
+	if err := httpserver.RegisterEndpoint(path, ep); err != nil {
+		return nil, err
+	}
+	endpoints[path] = ep
+	return ep, nil
+}
+
+// ReleaseEndpoint unregisters the endpoint and closes all its connections when it is not used any more
+func ReleaseEndpoint(ep *Endpoint) {
+	endpointsMu.Lock()
+	defer endpointsMu.Unlock()
+	ep.refs--
+	if ep.refs > 0 {
+		return
+	}
+	delete(endpoints, ep.path)
+	// The hijacked connections are not closed by the server shutdown
+	ep.Lock()
+	for c := range ep.conns {
+		_ = c.conn.Close()
+	}
+	ep.conns = make(map[*serverConn]bool)
+	ep.Unlock()
+	httpserver.UnregisterEndpoint(ep.path)
+}
+
+// Subscribe adds a handler for the messages from all the connections and returns the id to unsubscribe
+func (ep *Endpoint) Subscribe(h MessageHandler) int {
+	ep.Lock()
+	defer ep.Unlock()
+	ep.nextId++
+	ep.handlers[ep.nextId] = h
+	return ep.nextId
+}
+
+func (ep *Endpoint) Unsubscribe(id int) {
+	ep.Lock()
+	defer ep.Unlock()
+	delete(ep.handlers, id)
+}
+
+// Broadcast sends the data to all the connected clients and returns the number of successful sending.
+// The failed connections are closed.
+func (ep *Endpoint) Broadcast(data []byte) int {
+	ep.RLock()
+	conns := make([]*serverConn, 0, len(ep.conns))
+	for c := range ep.conns {
+		conns = append(conns, c)
+	}
+	ep.RUnlock()
+	count := 0
+	for _, c := range conns {
+		c.Lock()
+		_ = c.conn.SetWriteDeadline(time.Now().Add(writeTimeout))
+		err := c.conn.WriteMessage(websocket.TextMessage, data)
+		c.Unlock()
+		if err != nil {
+			conf.Log.Warnf("websocket endpoint %s fails to send to %s: %v", ep.path, c.meta["connId"], err)
+			_ = c.conn.Close()
+			continue
+		}
+		count++
+	}
+	return count
+}
+
+func (ep *Endpoint) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		// The upgrader has replied the error to the client
+		conf.Log.Warnf("websocket endpoint %s upgrade error: %v", ep.path, err)
+		return
+	}
+	meta := make(map[string]interface{}, len(r.Header)+3)
+	for k, v := range r.Header {
+		meta[k] = strings.Join(v, ",")
+	}
+	meta["connId"] = uuid.New().String()
+	meta["remoteAddr"] = r.RemoteAddr
+	meta["path"] = r.URL.Path
+	c := &serverConn{conn: conn, meta: meta}
+	ep.Lock()
+	ep.conns[c] = true
+	ep.Unlock()
+	conf.Log.Infof("websocket endpoint %s accepts connection %s from %s", ep.path, meta["connId"], r.RemoteAddr)
+	defer func() {
+		ep.Lock()
+		delete(ep.conns, c)
+		ep.Unlock()
+		_ = conn.Close()
+		conf.Log.Infof("websocket endpoint %s closes connection %s", ep.path, meta["connId"])
+	}()
+	for {
+		_, data, err := conn.ReadMessage()
+		if err != nil {
+			return
+		}
+		ep.RLock()
+		handlers := make([]MessageHandler, 0, len(ep.handlers))
+		for _, h := range ep.handlers {
+			handlers = append(handlers, h)
+		}
+		ep.RUnlock()
+		for _, h := range handlers {
+			h(data, meta)
+		}
+	}
+}
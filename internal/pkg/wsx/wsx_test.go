@@ -0,0 +1,134 @@
+// Copyright 2021 EMQ Technologies Co., Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package wsx
+
+import (
+	"fmt"
+	"github.com/lf-edge/ekuiper/internal/conf"
+	"github.com/lf-edge/ekuiper/internal/topo/context"
+	"testing"
+	"time"
+)
+
+type received struct {
+	data string
+	meta map[string]interface{}
+}
+
+func TestEndpointAndClient(t *testing.T) {
+	if conf.Config == nil {
+		conf.Config = &conf.KuiperConf{}
+	}
+	conf.Config.Source.HttpServerIp = "127.0.0.1"
+	conf.Config.Source.HttpServerPort = 10092
+	ctx, cancel := context.WithValue(context.Background(), context.LoggerKey, conf.Log).WithCancel()
+	defer cancel()
+
+	ep, err := AcquireEndpoint("/ws")
+	if err != nil {
+		t.Fatal(err)
+	}
+	serverCh := make(chan received, 10)
+	ep.Subscribe(func(data []byte, meta map[string]interface{}) {
+		serverCh <- received{data: string(data), meta: meta}
+	})
+	clientCh := make(chan received, 10)
+	c := NewClient(&ClientConf{Url: fmt.Sprintf("ws://127.0.0.1:%d/ws", conf.Config.Source.HttpServerPort), Headers: map[string]string{"X-Device": "d1"}, ReconnectInterval: 50, MaxReconnectInterval: 100})
+	go c.Run(ctx, func(data []byte, meta map[string]interface{}) {
+		clientCh <- received{data: string(data), meta: meta}
+	})
+	defer c.Close()
+
+	waitSend := func() {
+		deadline := time.Now().Add(2 * time.Second)
+		for c.Send([]byte(`{"a":1}`)) != nil {
+			if time.Now().After(deadline) {
+				t.Fatal("timeout to connect")
+			}
+			time.Sleep(20 * time.Millisecond)
+		}
+	}
+	waitSend()
+	select {
+	case r := <-serverCh:
+		if r.data != `{"a":1}` || r.meta["X-Device"] != "d1" || r.meta["path"] != "/ws" || r.meta["connId"] == "" {
+			t.Errorf("server received mismatch, got %v", r)
+		}
+	case <-time.After(time.Second):
+		t.Error("timeout to receive in server")
+	}
+	if n := ep.Broadcast([]byte(`{"b":2}`)); n != 1 {
+		t.Errorf("expect broadcast to 1 client but got %d", n)
+	}
+	select {
+	case r := <-clientCh:
+		if r.data != `{"b":2}` || r.meta["url"] != c.conf.Url {
+			t.Errorf("client received mismatch, got %v", r)
+		}
+	case <-time.After(time.Second):
+		t.Error("timeout to receive in client")
+	}
+
+	// Restart the endpoint, the client should reconnect
+	ReleaseEndpoint(ep)
+	ep, err = AcquireEndpoint("/ws")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ReleaseEndpoint(ep)
+	ep.Subscribe(func(data []byte, meta map[string]interface{}) {
+		serverCh <- received{data: string(data), meta: meta}
+	})
+	// The message may be sent to the closing connection before the client detects it, so keep sending
+	deadline := time.After(2 * time.Second)
+	for done := false; !done; {
+		_ = c.Send([]byte(`{"a":1}`))
+		select {
+		case r := <-serverCh:
+			if r.data != `{"a":1}` {
+				t.Errorf("server received mismatch after reconnect, got %v", r)
+			}
+			done = true
+		case <-time.After(50 * time.Millisecond):
+		case <-deadline:
+			t.Fatal("timeout to receive in server after reconnect")
+		}
+	}
+}
+
+func TestGetMode(t *testing.T) {
+	var tests = []struct {
+		mode string
+		url  string
+		exp  string
+		err  string
+	}{
+		{exp: MODE_SERVER},
+		{url: "ws://localhost", exp: MODE_CLIENT},
+		{mode: "Server", url: "ws://localhost", exp: MODE_SERVER},
+		{mode: "client", err: "property url is required in client mode"},
+		{mode: "peer", err: "invalid property mode: peer, must be client or server"},
+	}
+	for i, tt := range tests {
+		r, err := GetMode(tt.mode, tt.url)
+		if err != nil {
+			if err.Error() != tt.err {
+				t.Errorf("%d: error mismatch:\n  exp=%s\n  got=%v", i, tt.err, err)
+			}
+		} else if tt.err != "" || r != tt.exp {
+			t.Errorf("%d: mode mismatch:\n  exp=%s %s\n  got=%s", i, tt.exp, tt.err, r)
+		}
+	}
+}
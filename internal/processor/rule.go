@@ -168,6 +168,12 @@ func (p *RuleProcessor) getRuleByJson(name, ruleJson string) (*api.Rule, error)
 	if rule.Options.LateTol < 0 {
 		return nil, fmt.Errorf("rule option lateTolerance %d is invalid, require a positive integer", rule.Options.LateTol)
 	}
+	if rule.Options.WindowMemoryLimit < 0 {
+		return nil, fmt.Errorf("rule option windowMemoryLimit %d is invalid, require a positive integer", rule.Options.WindowMemoryLimit)
+	}
+	if rule.Options.MaxWindowKeys < 0 {
+		return nil, fmt.Errorf("rule option maxWindowKeys %d is invalid, require a positive integer", rule.Options.MaxWindowKeys)
+	}
 	return rule, nil
 }
 
@@ -223,6 +229,9 @@ func (p *RuleProcessor) ExecDrop(name string) (string, error) {
 		if err := cleanCheckpoint(name); err != nil {
 			result = fmt.Sprintf("%s. Clean checkpoint cache faile: %s.", result, err)
 		}
+		if err := node.CleanWindowBuffer(name); err != nil {
+			result = fmt.Sprintf("%s. Clean window buffer faile: %s.", result, err)
+		}
 	}
 	err := p.db.Delete(name)
 	if err != nil {
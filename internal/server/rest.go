@@ -15,6 +15,7 @@
 package server
 
 import (
+	"context"
 	"crypto/tls"
 	"encoding/json"
 	"fmt"
@@ -22,6 +23,8 @@ import (
 	"github.com/gorilla/mux"
 	"github.com/lf-edge/ekuiper/internal/conf"
 	"github.com/lf-edge/ekuiper/internal/meta"
+	"github.com/lf-edge/ekuiper/internal/pkg/mqttx"
+	"github.com/lf-edge/ekuiper/internal/pkg/ssex"
 	"github.com/lf-edge/ekuiper/internal/plugin/native"
 	"github.com/lf-edge/ekuiper/internal/service"
 	"github.com/lf-edge/ekuiper/pkg/api"
@@ -30,6 +33,7 @@ import (
 	"golang.org/x/net/html"
 	"io"
 	"io/ioutil"
+	"net"
 	"net/http"
 	"os"
 	"runtime"
@@ -104,6 +108,7 @@ func createRestServer(ip string, port int) *http.Server {
 	r.HandleFunc("/rules/{name}/stop", stopRuleHandler).Methods(http.MethodPost)
 	r.HandleFunc("/rules/{name}/restart", restartRuleHandler).Methods(http.MethodPost)
 	r.HandleFunc("/rules/{name}/topo", getTopoRuleHandler).Methods(http.MethodGet)
+	r.HandleFunc("/rules/{name}/stream", streamRuleHandler).Methods(http.MethodGet)
 
 	r.HandleFunc("/plugins/sources", sourcesHandler).Methods(http.MethodGet, http.MethodPost)
 	r.HandleFunc("/plugins/sources/prebuild", prebuildSourcePlugins).Methods(http.MethodGet)
@@ -131,6 +136,8 @@ func createRestServer(ip string, port int) *http.Server {
 	r.HandleFunc("/metadata/sources/{name}/confKeys/{confKey}", sourceConfKeyHandler).Methods(http.MethodDelete, http.MethodPost)
 	r.HandleFunc("/metadata/sources/{name}/confKeys/{confKey}/field", sourceConfKeyFieldsHandler).Methods(http.MethodDelete, http.MethodPost)
 
+	r.HandleFunc("/connections", connectionsHandler).Methods(http.MethodGet)
+
 	r.HandleFunc("/services", servicesHandler).Methods(http.MethodGet, http.MethodPost)
 	r.HandleFunc("/services/functions", serviceFunctionsHandler).Methods(http.MethodGet)
 	r.HandleFunc("/services/functions/{name}", serviceFunctionHandler).Methods(http.MethodGet)
@@ -142,12 +149,29 @@ func createRestServer(ip string, port int) *http.Server {
 		WriteTimeout: time.Second * 60 * 5,
 		ReadTimeout:  time.Second * 60 * 5,
 		IdleTimeout:  time.Second * 60,
-		Handler:      handlers.CORS(handlers.AllowedHeaders([]string{"Accept", "Accept-Language", "Content-Type", "Content-Language", "Origin"}))(r),
+		Handler:      handlers.CORS(handlers.AllowedHeaders([]string{"Accept", "Accept-Language", "Content-Type", "Content-Language", "Origin", "Last-Event-ID"}))(r),
+		ConnContext:  saveConn,
 	}
 	server.SetKeepAlivesEnabled(false)
 	return server
 }
 
+type connKey struct{}
+
+// saveConn keeps the connection in the request context so that the long-lived handler can clear its deadlines
+func saveConn(ctx context.Context, c net.Conn) context.Context {
+	return context.WithValue(ctx, connKey{}, c)
+}
+
+// clearDeadline removes the read and write timeout of the server from the connection of the request
+func clearDeadline(r *http.Request) error {
+	c, ok := r.Context().Value(connKey{}).(net.Conn)
+	if !ok {
+		return fmt.Errorf("no connection found in the request")
+	}
+	return c.SetDeadline(time.Time{})
+}
+
 type information struct {
 	Version       string `json:"version"`
 	Os            string `json:"os"`
@@ -173,6 +197,12 @@ func pingHandler(w http.ResponseWriter, r *http.Request) {
 	w.WriteHeader(http.StatusOK)
 }
 
+//list the shared connections
+func connectionsHandler(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+	jsonResponse(mqttx.GetConnections(), w, logger)
+}
+
 func sourcesManageHandler(w http.ResponseWriter, r *http.Request, st ast.StreamType) {
 	defer r.Body.Close()
 	switch r.Method {
@@ -422,6 +452,24 @@ func getTopoRuleHandler(w http.ResponseWriter, r *http.Request) {
 	w.Write([]byte(content))
 }
 
+//stream the results of the rule published by the sse sink
+func streamRuleHandler(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+	vars := mux.Vars(r)
+	name := vars["name"]
+
+	hub, ok := ssex.GetHub(name)
+	if !ok {
+		handleError(w, errorx.NewWithCode(errorx.NOT_FOUND, fmt.Sprintf("Rule %s has no running sse sink", name)), "stream rule error", logger)
+		return
+	}
+	// The stream is long-lived so it must not be cut by the timeout of the server
+	if err := clearDeadline(r); err != nil {
+		logger.Warnf("clear the deadline of rule %s stream error: %v", name, err)
+	}
+	hub.ServeHTTP(w, r)
+}
+
 func pluginsHandler(w http.ResponseWriter, r *http.Request, t native.PluginType) {
 	pluginManager := native.GetManager()
 	defer r.Body.Close()
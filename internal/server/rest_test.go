@@ -16,11 +16,13 @@ package server
 
 import (
 	"fmt"
+	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
 	"reflect"
 	"strings"
 	"testing"
+	"time"
 )
 
 func TestParseHtml(t1 *testing.T) {
@@ -253,3 +255,39 @@ func TestFetchPluginList(t1 *testing.T) {
 		}
 	}
 }
+
+func TestClearDeadline(t *testing.T) {
+	ts := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("clear") == "1" {
+			if err := clearDeadline(r); err != nil {
+				t.Errorf("clear deadline error: %v", err)
+			}
+		}
+		w.Write([]byte("a"))
+		w.(http.Flusher).Flush()
+		time.Sleep(300 * time.Millisecond)
+		w.Write([]byte("b"))
+	}))
+	ts.Config.WriteTimeout = 100 * time.Millisecond
+	ts.Config.ConnContext = saveConn
+	ts.Start()
+	defer ts.Close()
+
+	for _, tt := range []struct {
+		clear string
+		exp   string
+	}{
+		{clear: "0", exp: "a"},
+		{clear: "1", exp: "ab"},
+	} {
+		resp, err := http.Get(ts.URL + "?clear=" + tt.clear)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, _ := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		if string(body) != tt.exp {
+			t.Errorf("clear=%s: body mismatch:\n  exp=%s\n  got=%s", tt.clear, tt.exp, body)
+		}
+	}
+}
@@ -86,6 +86,7 @@ func (s *checkpointStore) getLatest() *completedCheckpoint {
 type Coordinator struct {
 	tasksToTrigger          []Responder
 	tasksToWaitFor          []Responder
+	completeListeners       []CompleteListener
 	sinkTasks               []SinkTask
 	pendingCheckpoints      *sync.Map
 	completedCheckpoints    *checkpointStore
@@ -104,14 +105,23 @@ func NewCoordinator(ruleId string, sources []StreamTask, operators []NonSourceTa
 	logger := ctx.GetLogger()
 	logger.Infof("create new coordinator for rule %s", ruleId)
 	signal := make(chan *Signal, 1024)
-	var allResponders, sourceResponders []Responder
+	var (
+		allResponders, sourceResponders []Responder
+		listeners                       []CompleteListener
+	)
 	for _, r := range sources {
+		if l, ok := r.(CompleteListener); ok {
+			listeners = append(listeners, l)
+		}
 		r.SetQos(qos)
 		re := NewResponderExecutor(signal, r)
 		allResponders = append(allResponders, re)
 		sourceResponders = append(sourceResponders, re)
 	}
 	for _, r := range operators {
+		if l, ok := r.(CompleteListener); ok {
+			listeners = append(listeners, l)
+		}
 		r.SetQos(qos)
 		re := NewResponderExecutor(signal, r)
 		handler := createBarrierHandler(re, r.GetInputCount(), qos)
@@ -132,6 +142,7 @@ func NewCoordinator(ruleId string, sources []StreamTask, operators []NonSourceTa
 	return &Coordinator{
 		tasksToTrigger:     sourceResponders,
 		tasksToWaitFor:     allResponders,
+		completeListeners:  listeners,
 		sinkTasks:          sinks,
 		pendingCheckpoints: new(sync.Map),
 		completedCheckpoints: &checkpointStore{
@@ -262,6 +273,9 @@ func (c *Coordinator) complete(checkpointId int64) {
 		for _, sink := range c.sinkTasks {
 			sink.SaveCache()
 		}
+		for _, l := range c.completeListeners {
+			l.NotifyCheckpointComplete(checkpointId)
+		}
 		c.completedCheckpoints.add(ccp.(*pendingCheckpoint).finalize())
 		c.pendingCheckpoints.Delete(checkpointId)
 		//Drop the previous pendingCheckpoints
@@ -39,6 +39,24 @@ type SinkTask interface {
 	SaveCache()
 }
 
+// CompleteListener is implemented by the source and operator tasks which act on the completed checkpoints such as
+// acknowledging the consumed messages
+type CompleteListener interface {
+	NotifyCheckpointComplete(checkpointId int64)
+}
+
+// SnapshotListener is implemented by the tasks which need to know the checkpoint id of the snapshot just taken.
+// It is called in the goroutine of the task.
+type SnapshotListener interface {
+	NotifySnapshot(checkpointId int64)
+}
+
+// CheckpointPreparer is implemented by the tasks which may fail to prepare the checkpoint such as the sink failing
+// to flush. If an error is returned, the checkpoint is declined. It is called in the goroutine of the task.
+type CheckpointPreparer interface {
+	PrepareCheckpoint(checkpointId int64) error
+}
+
 type BufferOrEvent struct {
 	Data    interface{}
 	Channel string
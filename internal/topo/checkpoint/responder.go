@@ -46,6 +46,15 @@ func (re *ResponderExecutor) TriggerCheckpoint(checkpointId int64) error {
 	}
 	name := re.GetName()
 	logger.Debugf("Starting checkpoint %d on task %s", checkpointId, name)
+	if p, ok := re.task.(CheckpointPreparer); ok {
+		if err := p.PrepareCheckpoint(checkpointId); err != nil {
+			logger.Warnf("Decline checkpoint %d on task %s: %v", checkpointId, name, err)
+			go func() {
+				re.responder <- &Signal{Message: DEC, Barrier: Barrier{CheckpointId: checkpointId, OpId: name}}
+			}()
+			return nil
+		}
+	}
 	//create
 	barrier := &Barrier{
 		CheckpointId: checkpointId,
@@ -58,6 +67,9 @@ func (re *ResponderExecutor) TriggerCheckpoint(checkpointId int64) error {
 	if err != nil {
 		return err
 	}
+	if l, ok := re.task.(SnapshotListener); ok {
+		l.NotifySnapshot(checkpointId)
+	}
 	go func() {
 		state := ACK
 		err := sctx.SaveState(checkpointId)
@@ -0,0 +1,244 @@
+// Copyright 2021 EMQ Technologies Co., Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package node
+
+import (
+	"encoding/gob"
+	"fmt"
+	"github.com/lf-edge/ekuiper/internal/xsql"
+	"github.com/lf-edge/ekuiper/pkg/api"
+	"github.com/lf-edge/ekuiper/pkg/ast"
+)
+
+const INC_PANES_KEY = "$$incPanes"
+
+func init() {
+	gob.Register([]*aggPane{})
+}
+
+// IncAggregate is an aggregate call calculated incrementally by the window.
+// The result is set as the alias Name of the output tuple.
+type IncAggregate struct {
+	Name string
+	Call *ast.Call
+}
+
+// aggGroup is the first tuple and the accumulators of a group
+type aggGroup struct {
+	Key   string
+	Tuple *xsql.Tuple
+	Accs  []interface{}
+}
+
+// aggPane is the groups of the events between two window triggers
+type aggPane struct {
+	Groups []*aggGroup
+	index  map[string]*aggGroup
+}
+
+func newAggPane() *aggPane {
+	return &aggPane{index: make(map[string]*aggGroup)}
+}
+
+// incAggregator pre-aggregates the window inputs by group. For hopping window, each pane keeps
+// the accumulators of an interval and the panes of a window are merged when firing.
+type incAggregator struct {
+	aggs   []*IncAggregate
+	groups ast.Dimensions
+	size   int        // The number of panes in a window
+	panes  []*aggPane // The last one is the current pane
+	funcs  []api.Function
+	fctxs  []api.FunctionContext
+	fv     *xsql.FunctionValuer
+}
+
+func newIncAggregator(w *WindowConfig) *incAggregator {
+	size := 1
+	if w.Type == ast.HOPPING_WINDOW && w.Interval > 0 {
+		size = w.Length / w.Interval
+	}
+	return &incAggregator{aggs: w.Aggregates, groups: w.Groups, size: size}
+}
+
+// open resolves the aggregate functions and restores the panes from the state
+func (a *incAggregator) open(ctx api.StreamContext) error {
+	runtime := xsql.NewFuncRuntime(ctx)
+	a.fv = xsql.NewFunctionValuer(runtime)
+	a.funcs = make([]api.Function, len(a.aggs))
+	a.fctxs = make([]api.FunctionContext, len(a.aggs))
+	for i, agg := range a.aggs {
+		f, fctx, err := runtime.Get(agg.Call.Name)
+		if err != nil {
+			return fmt.Errorf("fail to get incremental aggregate function %s: %v", agg.Call.Name, err)
+		}
+		a.funcs[i], a.fctxs[i] = f, fctx
+	}
+	a.panes = nil
+	if s, err := ctx.GetState(INC_PANES_KEY); err == nil && s != nil {
+		if ps, ok := s.([]*aggPane); ok {
+			for _, p := range ps {
+				p.index = make(map[string]*aggGroup, len(p.Groups))
+				for _, g := range p.Groups {
+					p.index[g.Key] = g
+				}
+			}
+			a.panes = ps
+			ctx.PutState(INC_PANES_KEY, a.snapshot())
+			ctx.GetLogger().Infof("Restore incremental aggregate state with %d panes", len(ps))
+		} else {
+			return fmt.Errorf("restore window state `incPanes` %v error, invalid type", s)
+		}
+	}
+	if len(a.panes) == 0 {
+		a.panes = []*aggPane{newAggPane()}
+	}
+	return nil
+}
+
+// accumulate the tuple into the accumulators of its group in the current pane
+func (a *incAggregator) accumulate(tuple *xsql.Tuple, ctx api.StreamContext) error {
+	var key string
+	ve := &xsql.ValuerEval{Valuer: xsql.MultiValuer(tuple, a.fv)}
+	for _, d := range a.groups {
+		r := ve.Eval(d.Expr)
+		if _, ok := r.(error); ok {
+			return fmt.Errorf("run Group By error: %s", r)
+		}
+		key = appendKey(key, r)
+	}
+	p := a.panes[len(a.panes)-1]
+	g, ok := p.index[key]
+	if !ok {
+		g = &aggGroup{Key: key, Tuple: tuple, Accs: make([]interface{}, len(a.aggs))}
+		p.Groups = append(p.Groups, g)
+		p.index[key] = g
+	}
+	valuer := xsql.MultiValuer(tuple, a.fv, &xsql.WildcardValuer{Data: tuple})
+	for i, agg := range a.aggs {
+		args := make([]interface{}, len(agg.Call.Args))
+		for j, arg := range agg.Call.Args {
+			args[j] = xsql.Eval(arg, valuer)
+			if e, ok := args[j].(error); ok {
+				return fmt.Errorf("run %s function error: %v", agg.Call.Name, e)
+			}
+		}
+		acc, err := xsql.AccumulateFunc(agg.Call.Name, a.funcs[i], g.Accs[i], args, a.fctxs[i])
+		if err != nil {
+			return err
+		}
+		g.Accs[i] = acc
+	}
+	ctx.PutState(INC_PANES_KEY, a.snapshot())
+	return nil
+}
+
+// snapshot copies the panes, the groups and the accumulator list for the state. The state is encoded asynchronously
+// by the checkpoint so it must not share the structures which are updated by the following events. The accumulators
+// are not updated in place by the incremental functions so they are shared.
+func (a *incAggregator) snapshot() []*aggPane {
+	r := make([]*aggPane, len(a.panes))
+	for i, p := range a.panes {
+		c := &aggPane{Groups: make([]*aggGroup, len(p.Groups))}
+		for j, g := range p.Groups {
+			c.Groups[j] = &aggGroup{Key: g.Key, Tuple: g.Tuple, Accs: append([]interface{}(nil), g.Accs...)}
+		}
+		r[i] = c
+	}
+	return r
+}
+
+func (a *incAggregator) hasData() bool {
+	for _, p := range a.panes {
+		if len(p.Groups) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// fire merges the panes of the window into the grouped result and slides to a new pane.
+// Each group has a single tuple which is the first tuple of the group with the aggregate results as alias.
+func (a *incAggregator) fire(wr *xsql.WindowRange, ctx api.StreamContext) (xsql.GroupedTuplesSet, error) {
+	var merged []*aggGroup
+	index := make(map[string]*aggGroup)
+	for _, p := range a.panes {
+		for _, g := range p.Groups {
+			m, ok := index[g.Key]
+			if !ok {
+				m = &aggGroup{Key: g.Key, Tuple: g.Tuple, Accs: make([]interface{}, len(g.Accs))}
+				copy(m.Accs, g.Accs)
+				merged = append(merged, m)
+				index[g.Key] = m
+				continue
+			}
+			for i, agg := range a.aggs {
+				acc, err := xsql.MergeFunc(agg.Call.Name, a.funcs[i], m.Accs[i], g.Accs[i], a.fctxs[i])
+				if err != nil {
+					return nil, err
+				}
+				m.Accs[i] = acc
+			}
+		}
+	}
+	if len(a.panes) >= a.size {
+		a.panes = a.panes[len(a.panes)-a.size+1:]
+	}
+	a.panes = append(a.panes, newAggPane())
+	ctx.PutState(INC_PANES_KEY, a.snapshot())
+
+	result := make(xsql.GroupedTuplesSet, 0, len(merged))
+	for _, m := range merged {
+		// Create a new tuple as the alias will be appended by the following operators
+		t := &xsql.Tuple{
+			Emitter:   m.Tuple.Emitter,
+			Message:   m.Tuple.Message,
+			Timestamp: m.Tuple.Timestamp,
+			Metadata:  m.Tuple.Metadata,
+		}
+		for i, agg := range a.aggs {
+			// The error result is set to be reported when evaluating the alias
+			r, _ := xsql.ResultFunc(agg.Call.Name, a.funcs[i], m.Accs[i], a.fctxs[i])
+			t.AppendAlias(agg.Name, r)
+		}
+		result = append(result, xsql.GroupedTuples{Content: []xsql.DataValuer{t}, WindowRange: wr})
+	}
+	return result, nil
+}
+
+// fireIncAggregate fires the incrementally aggregated window which ends at triggerTime
+func (o *WindowOperator) fireIncAggregate(triggerTime int64, ctx api.StreamContext) bool {
+	log := ctx.GetLogger()
+	if !o.incAgg.hasData() {
+		return false
+	}
+	wr := &xsql.WindowRange{WindowStart: o.triggerTime, WindowEnd: triggerTime}
+	if o.window.Type == ast.HOPPING_WINDOW {
+		wr.WindowStart = o.triggerTime - int64(o.window.Interval)
+	}
+	results, err := o.incAgg.fire(wr, ctx)
+	o.triggerTime = triggerTime
+	if err != nil {
+		log.Errorf("fire incremental aggregate window error: %v", err)
+		o.Broadcast(err)
+		o.statManager.IncTotalExceptions()
+		return true
+	}
+	if len(results) > 0 {
+		log.Debugf("Sent: %v", results)
+		o.Broadcast(results)
+		o.statManager.IncTotalRecordsOut()
+	}
+	return true
+}
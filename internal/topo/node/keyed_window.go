@@ -0,0 +1,414 @@
+// Copyright 2021 EMQ Technologies Co., Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package node
+
+import (
+	"encoding/gob"
+	"fmt"
+	"github.com/benbjohnson/clock"
+	"github.com/lf-edge/ekuiper/internal/conf"
+	"github.com/lf-edge/ekuiper/internal/xsql"
+	"github.com/lf-edge/ekuiper/pkg/api"
+	"github.com/lf-edge/ekuiper/pkg/ast"
+	"github.com/lf-edge/ekuiper/pkg/cast"
+	"math"
+	"sort"
+	"time"
+)
+
+// keyedWindow is the state of the window for a single key
+type keyedWindow struct {
+	Inputs     []*xsql.Tuple
+	MsgCount   int
+	LastActive int64
+	// Pending events of state window in event time which wait for the watermark to be processed in order
+	Pending []*xsql.Tuple
+}
+
+const KEYED_WINDOWS_KEY = "$$keyedWindows"
+
+func init() {
+	gob.Register(map[string]*keyedWindow{})
+}
+
+// execKeyedWindow runs the session or count window which is partitioned by the group by keys,
+// and the state window. Each key has its own window which is fired independently.
+// The state window without keys is run as a single key.
+func (o *WindowOperator) execKeyedWindow(ctx api.StreamContext, errCh chan<- error) {
+	log := ctx.GetLogger()
+	windows := make(map[string]*keyedWindow)
+	if s, err := ctx.GetState(KEYED_WINDOWS_KEY); err == nil && s != nil {
+		if si, ok := s.(map[string]*keyedWindow); ok {
+			windows = copyKeyedWindows(si)
+			log.Infof("Restore keyed window state with %d keys", len(windows))
+		} else {
+			errCh <- fmt.Errorf("restore window state `keyedWindows` %v error, invalid type", s)
+		}
+	}
+	if o.isEventTime {
+		o.watermarkGenerator.lastWatermarkTs = 0
+		if s, err := ctx.GetState(WATERMARK_KEY); err == nil && s != nil {
+			if si, ok := s.(int64); ok {
+				o.watermarkGenerator.lastWatermarkTs = si
+			} else {
+				errCh <- fmt.Errorf("restore window state `lastWatermarkTs` %v error, invalid type", s)
+			}
+		}
+	}
+	fv, _ := xsql.NewFunctionValuersForOp(ctx)
+	var (
+		timer   *clock.Timer
+		timeout <-chan time.Time
+	)
+	// In processing time, a single timer is scheduled for the earliest window end of all keys
+	schedule := func() {
+		if o.isEventTime {
+			return
+		}
+		var next int64 = math.MaxInt64
+		for _, w := range windows {
+			if end := o.keyedWindowEnd(w.Inputs); end < next {
+				next = end
+			}
+		}
+		if timer != nil {
+			timer.Stop()
+		}
+		if next == math.MaxInt64 {
+			timer, timeout = nil, nil
+			return
+		}
+		d := next - conf.GetNowInMilli()
+		if d < 0 {
+			d = 0
+		}
+		timer = conf.GetTimer(int(d))
+		timeout = timer.C
+	}
+	schedule()
+
+	for {
+		select {
+		// process incoming item
+		case item, opened := <-o.input:
+			processed := false
+			if item, processed = o.preprocess(item); processed {
+				break
+			}
+			o.statManager.ProcessTimeStart()
+			if !opened {
+				o.statManager.IncTotalExceptions()
+				break
+			}
+			switch d := item.(type) {
+			case error:
+				o.statManager.IncTotalRecordsIn()
+				o.Broadcast(d)
+				o.statManager.IncTotalExceptions()
+			case xsql.Event:
+				if d.IsWatermark() {
+					if o.window.Type == ast.STATE_WINDOW {
+						o.processPending(windows, d.GetTimestamp(), fv, ctx)
+					}
+					o.fireKeyedWindows(windows, d.GetTimestamp(), ctx)
+				} else {
+					o.statManager.IncTotalRecordsIn()
+					tuple, ok := d.(*xsql.Tuple)
+					if !ok {
+						log.Debugf("receive non tuple element %v", d)
+						break
+					}
+					if o.isEventTime && !o.watermarkGenerator.track(tuple.Emitter, d.GetTimestamp(), ctx) {
+						log.Debugf("drop late tuple %s", tuple.Message)
+						break
+					}
+					key, err := o.windowKey(tuple, fv)
+					if err != nil {
+						o.Broadcast(err)
+						o.statManager.IncTotalExceptions()
+						break
+					}
+					w, ok := windows[key]
+					if !ok {
+						o.evictKeys(windows, ctx)
+						w = &keyedWindow{}
+						windows[key] = w
+					}
+					w.LastActive = conf.GetNowInMilli()
+					switch o.window.Type {
+					case ast.COUNT_WINDOW:
+						w.Inputs = append(w.Inputs, tuple)
+						o.fireKeyedCount(w, errCh, ctx)
+					case ast.STATE_WINDOW:
+						if o.isEventTime {
+							w.Pending = append(w.Pending, tuple)
+						} else {
+							o.processState(w, tuple, fv, ctx)
+							if len(w.Inputs) == 0 {
+								delete(windows, key)
+							}
+						}
+					default:
+						w.Inputs = append(w.Inputs, tuple)
+					}
+				}
+				o.statManager.ProcessTimeEnd()
+				o.statManager.SetBufferLength(int64(len(o.input)))
+				ctx.PutState(KEYED_WINDOWS_KEY, copyKeyedWindows(windows))
+				schedule()
+			default:
+				o.statManager.IncTotalRecordsIn()
+				o.Broadcast(fmt.Errorf("run Window error: expect xsql.Event type but got %[1]T(%[1]v)", d))
+				o.statManager.IncTotalExceptions()
+			}
+		case now := <-timeout:
+			o.statManager.ProcessTimeStart()
+			log.Debugf("keyed window triggered by timeout")
+			o.fireKeyedWindows(windows, cast.TimeToUnixMilli(now), ctx)
+			o.statManager.ProcessTimeEnd()
+			ctx.PutState(KEYED_WINDOWS_KEY, copyKeyedWindows(windows))
+			schedule()
+		// is cancelling
+		case <-ctx.Done():
+			log.Infoln("Cancelling window....")
+			if timer != nil {
+				timer.Stop()
+			}
+			return
+		}
+	}
+}
+
+// copyKeyedWindows copies the windows and their tuple slices for the state. The state is encoded asynchronously
+// by the checkpoint so it must not share the map and the slices which are updated by the following events.
+func copyKeyedWindows(windows map[string]*keyedWindow) map[string]*keyedWindow {
+	r := make(map[string]*keyedWindow, len(windows))
+	for k, w := range windows {
+		r[k] = &keyedWindow{
+			Inputs:     append([]*xsql.Tuple(nil), w.Inputs...),
+			MsgCount:   w.MsgCount,
+			LastActive: w.LastActive,
+			Pending:    append([]*xsql.Tuple(nil), w.Pending...),
+		}
+	}
+	return r
+}
+
+// windowKey calculates the partition key of the tuple by the group by dimensions. Each value is prefixed by its
+// length so that different values never produce the same key, such as "a," + "b" and "a" + ",b".
+func (o *WindowOperator) windowKey(tuple *xsql.Tuple, fv *xsql.FunctionValuer) (string, error) {
+	var name string
+	ve := &xsql.ValuerEval{Valuer: xsql.MultiValuer(tuple, fv)}
+	for _, d := range o.window.Keys {
+		r := ve.Eval(d.Expr)
+		if _, ok := r.(error); ok {
+			return "", fmt.Errorf("run Window error: fail to evaluate key %s", r)
+		}
+		name = appendKey(name, r)
+	}
+	return name, nil
+}
+
+// appendKey appends the value to the key with its length as the prefix so that the keys never collide
+func appendKey(key string, r interface{}) string {
+	v := fmt.Sprintf("%v", r)
+	return key + fmt.Sprintf("%d:%s", len(v), v)
+}
+
+// fireKeyedCount fires the count window of a key once the count reaches the interval
+func (o *WindowOperator) fireKeyedCount(w *keyedWindow, errCh chan<- error, ctx api.StreamContext) {
+	log := ctx.GetLogger()
+	w.MsgCount++
+	if w.MsgCount%o.window.Interval != 0 {
+		return
+	}
+	w.MsgCount = 0
+	if tl, er := NewTupleList(w.Inputs, o.window.Length); er != nil {
+		errCh <- er
+	} else {
+		for tl.hasMoreCountWindow() {
+			tsets := tl.nextCountWindow()
+			log.Debugf("Sent: %v", tsets)
+			o.Broadcast(tsets)
+			o.statManager.IncTotalRecordsOut()
+		}
+		w.Inputs = tl.getRestTuples()
+	}
+}
+
+// processPending processes the pending events of state windows up to the watermark in the order of timestamp
+func (o *WindowOperator) processPending(windows map[string]*keyedWindow, watermark int64, fv *xsql.FunctionValuer, ctx api.StreamContext) {
+	for _, k := range sortedKeys(windows) {
+		w := windows[k]
+		sort.SliceStable(w.Pending, func(i, j int) bool {
+			return w.Pending[i].Timestamp < w.Pending[j].Timestamp
+		})
+		i := 0
+		for ; i < len(w.Pending) && w.Pending[i].Timestamp <= watermark; i++ {
+			o.processState(w, w.Pending[i], fv, ctx)
+		}
+		w.Pending = w.Pending[i:]
+	}
+}
+
+// processState runs the state machine of the state window for an event.
+// The window opens by an event which meets the begin condition and closes by an event which meets the end condition.
+// Both events are included in the window. Events arrive when the window is closed are dropped.
+func (o *WindowOperator) processState(w *keyedWindow, tuple *xsql.Tuple, fv *xsql.FunctionValuer, ctx api.StreamContext) {
+	log := ctx.GetLogger()
+	// Close the window reaching the max duration before handling the event
+	if end := o.keyedWindowEnd(w.Inputs); end < tuple.Timestamp {
+		w.Inputs = o.fireKeyedWindow(w.Inputs, end, ctx)
+	}
+	ve := &xsql.ValuerEval{Valuer: xsql.MultiValuer(tuple, fv)}
+	if len(w.Inputs) == 0 {
+		if !isTrue(ve.Eval(o.window.Begin)) {
+			log.Debugf("state window is closed, drop tuple %s", tuple.Message)
+			return
+		}
+		log.Debugf("state window opened by tuple %s", tuple.Message)
+	}
+	w.Inputs = append(w.Inputs, tuple)
+	if isTrue(ve.Eval(o.window.End)) {
+		log.Debugf("state window closed by tuple %s", tuple.Message)
+		w.Inputs = o.fireKeyedWindow(w.Inputs, tuple.Timestamp, ctx)
+	}
+}
+
+func isTrue(v interface{}) bool {
+	r, ok := v.(bool)
+	return ok && r
+}
+
+// fireKeyedWindows fires all the windows which end no later than the trigger time in the order of keys
+func (o *WindowOperator) fireKeyedWindows(windows map[string]*keyedWindow, triggerTime int64, ctx api.StreamContext) {
+	for _, k := range sortedKeys(windows) {
+		w := windows[k]
+		for len(w.Inputs) > 0 {
+			if o.isEventTime {
+				sort.SliceStable(w.Inputs, func(i, j int) bool {
+					return w.Inputs[i].Timestamp < w.Inputs[j].Timestamp
+				})
+			}
+			end := o.keyedWindowEnd(w.Inputs)
+			if end > triggerTime {
+				break
+			}
+			w.Inputs = o.fireKeyedWindow(w.Inputs, end, ctx)
+		}
+		if len(w.Inputs) == 0 && len(w.Pending) == 0 {
+			delete(windows, k)
+		}
+	}
+}
+
+func sortedKeys(windows map[string]*keyedWindow) []string {
+	keys := make([]string, 0, len(windows))
+	for k := range windows {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// fireKeyedWindow sends the tuples up to the window end and returns the rest
+func (o *WindowOperator) fireKeyedWindow(inputs []*xsql.Tuple, end int64, ctx api.StreamContext) []*xsql.Tuple {
+	results := xsql.WindowTuplesSet{
+		Content: make([]xsql.WindowTuples, 0),
+		WindowRange: &xsql.WindowRange{
+			WindowStart: inputs[0].Timestamp,
+			WindowEnd:   end,
+		},
+	}
+	i := 0
+	for _, tuple := range inputs {
+		if tuple.Timestamp <= end {
+			results = results.AddTuple(tuple)
+		} else {
+			inputs[i] = tuple
+			i++
+		}
+	}
+	ctx.GetLogger().Debugf("Sent: %v", results)
+	o.Broadcast(results)
+	o.statManager.IncTotalRecordsOut()
+	return inputs[:i]
+}
+
+// keyedWindowEnd returns the time when the window must be fired, or max int64 if it is not decided yet
+func (o *WindowOperator) keyedWindowEnd(inputs []*xsql.Tuple) int64 {
+	if len(inputs) == 0 {
+		return math.MaxInt64
+	}
+	switch o.window.Type {
+	case ast.SESSION_WINDOW:
+		return o.nextSessionEnd(inputs)
+	case ast.STATE_WINDOW:
+		if o.window.Length > 0 {
+			return inputs[0].Timestamp + int64(o.window.Length)
+		}
+	}
+	return math.MaxInt64
+}
+
+// nextSessionEnd returns the end of the first session in the inputs which are sorted by timestamp.
+// The session ends when no event arrives within the timeout or when it reaches the max duration.
+func (o *WindowOperator) nextSessionEnd(inputs []*xsql.Tuple) int64 {
+	timeout, duration := int64(o.window.Interval), int64(o.window.Length)
+	start := inputs[0].Timestamp
+	// The session is cut at the next aligned boundary of the duration, the same as the session window without keys
+	tick := start + (duration - start%duration)
+	prev := start
+	for _, tuple := range inputs[1:] {
+		if tuple.Timestamp-prev > timeout || tuple.Timestamp > tick {
+			break
+		}
+		prev = tuple.Timestamp
+	}
+	if prev+timeout < tick {
+		return prev + timeout
+	}
+	return tick
+}
+
+// evictKeys removes the least recently active key if the number of keys reaches the limit
+func (o *WindowOperator) evictKeys(windows map[string]*keyedWindow, ctx api.StreamContext) {
+	if o.maxKeys <= 0 || len(windows) < o.maxKeys {
+		return
+	}
+	var (
+		evicted string
+		last    int64 = math.MaxInt64
+	)
+	for k, w := range windows {
+		if w.LastActive < last || (w.LastActive == last && k < evicted) {
+			evicted, last = k, w.LastActive
+		}
+	}
+	w := windows[evicted]
+	delete(windows, evicted)
+	if len(w.Inputs) == 0 {
+		return
+	}
+	if o.window.Type != ast.COUNT_WINDOW {
+		ctx.GetLogger().Infof("window key %s is evicted, fire its window", evicted)
+		sort.SliceStable(w.Inputs, func(i, j int) bool {
+			return w.Inputs[i].Timestamp < w.Inputs[j].Timestamp
+		})
+		o.fireKeyedWindow(w.Inputs, w.Inputs[len(w.Inputs)-1].Timestamp, ctx)
+	} else {
+		ctx.GetLogger().Warnf("window key %s is evicted, drop %d pending events", evicted, len(w.Inputs))
+	}
+}
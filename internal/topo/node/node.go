@@ -44,6 +44,12 @@ type DataSourceNode interface {
 	SetQos(api.Qos)
 }
 
+// ConnectionNode is implemented by the nodes whose sources or sinks may connect to an external system.
+// The status is keyed by the instance index and only exists for instances implementing api.Connectable.
+type ConnectionNode interface {
+	GetConnectionStatus() map[int]api.ConnectionStatus
+}
+
 type defaultNode struct {
 	name         string
 	outputs      map[string]chan<- interface{}
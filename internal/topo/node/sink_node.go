@@ -21,6 +21,7 @@ import (
 	"github.com/lf-edge/ekuiper/internal/binder/io"
 	"github.com/lf-edge/ekuiper/internal/conf"
 	ct "github.com/lf-edge/ekuiper/internal/template"
+	"github.com/lf-edge/ekuiper/internal/topo/checkpoint"
 	"github.com/lf-edge/ekuiper/pkg/api"
 	"github.com/lf-edge/ekuiper/pkg/cast"
 	"sync"
@@ -39,6 +40,8 @@ type SinkNode struct {
 	//states varies after restart
 	sinks []api.Sink
 	tch   chan struct{} //channel to trigger cache saved, will be trigger by checkpoint only
+	// the flush errors by checkpoint id to decline the checkpoints
+	flushErrs map[int64]error
 }
 
 func NewSinkNode(name string, sinkType string, props map[string]interface{}) *SinkNode {
@@ -210,6 +213,7 @@ func (m *SinkNode) Open(ctx api.StreamContext, result chan<- error) {
 					for {
 						select {
 						case data := <-m.input:
+							m.flushOnBarrier(sink, data, ctx)
 							if newdata, processed := m.preprocess(data); processed {
 								break
 							} else {
@@ -242,6 +246,7 @@ func (m *SinkNode) Open(ctx api.StreamContext, result chan<- error) {
 					for {
 						select {
 						case data := <-cache.Out:
+							m.flushOnBarrier(sink, data.data, ctx)
 							if newdata, processed := m.preprocess(data.data); processed {
 								break
 							} else {
@@ -267,11 +272,55 @@ func (m *SinkNode) Open(ctx api.StreamContext, result chan<- error) {
 	}()
 }
 
+// flushOnBarrier flushes the sink before the barrier is processed so that the data collected before
+// the checkpoint are persisted. If failed, the checkpoint is declined.
+func (m *SinkNode) flushOnBarrier(sink api.Sink, data interface{}, ctx api.StreamContext) {
+	if m.qos < api.AtLeastOnce {
+		return
+	}
+	f, ok := sink.(api.Flushable)
+	if !ok {
+		return
+	}
+	if boe, ok := data.(*checkpoint.BufferOrEvent); ok {
+		if b, ok := boe.Data.(*checkpoint.Barrier); ok {
+			if err := f.Flush(ctx); err != nil {
+				ctx.GetLogger().Warnf("sink node %s flush error: %v", m.name, err)
+				m.mutex.Lock()
+				if m.flushErrs == nil {
+					m.flushErrs = make(map[int64]error)
+				}
+				m.flushErrs[b.CheckpointId] = err
+				m.mutex.Unlock()
+			}
+		}
+	}
+}
+
+// PrepareCheckpoint declines the checkpoint if the sink fails to flush on its barrier
+func (m *SinkNode) PrepareCheckpoint(checkpointId int64) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	err := m.flushErrs[checkpointId]
+	for id := range m.flushErrs {
+		if id <= checkpointId {
+			delete(m.flushErrs, id)
+		}
+	}
+	if err != nil {
+		return fmt.Errorf("sink node %s fails to flush: %v", m.name, err)
+	}
+	return nil
+}
+
 func (m *SinkNode) reset() {
 	if !m.isMock {
 		m.sinks = nil
 	}
 	m.statManagers = nil
+	m.mutex.Lock()
+	m.flushErrs = nil
+	m.mutex.Unlock()
 }
 
 func extractInput(v []byte) ([]map[string]interface{}, error) {
@@ -446,6 +495,18 @@ func (m *SinkNode) drainError(errCh chan<- error, err error, ctx api.StreamConte
 	}()
 }
 
+func (m *SinkNode) GetConnectionStatus() map[int]api.ConnectionStatus {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+	result := make(map[int]api.ConnectionStatus)
+	for i, s := range m.sinks {
+		if c, ok := s.(api.Connectable); ok {
+			result[i] = c.ConnectionStatus()
+		}
+	}
+	return result
+}
+
 func (m *SinkNode) close(ctx api.StreamContext, logger api.Logger) {
 	for _, s := range m.sinks {
 		if err := s.Close(ctx); err != nil {
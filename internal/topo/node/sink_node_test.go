@@ -15,10 +15,13 @@
 package node
 
 import (
+	"errors"
 	"fmt"
 	"github.com/lf-edge/ekuiper/internal/conf"
+	"github.com/lf-edge/ekuiper/internal/topo/checkpoint"
 	"github.com/lf-edge/ekuiper/internal/topo/context"
 	"github.com/lf-edge/ekuiper/internal/topo/topotest/mocknode"
+	"github.com/lf-edge/ekuiper/pkg/api"
 	"reflect"
 	"testing"
 	"time"
@@ -121,3 +124,31 @@ func TestSinkTemplate_Apply(t *testing.T) {
 		}
 	}
 }
+
+type failFlushSink struct {
+	*mocknode.MockSink
+	err error
+}
+
+func (s *failFlushSink) Flush(_ api.StreamContext) error {
+	return s.err
+}
+
+func TestSinkFlushOnBarrier(t *testing.T) {
+	ctx := context.WithValue(context.Background(), context.LoggerKey, conf.Log)
+	s := NewSinkNode("test", "", nil)
+	s.SetQos(api.AtLeastOnce)
+	sink := &failFlushSink{MockSink: mocknode.NewMockSink(), err: errors.New("disk full")}
+	barrier := func(id int64) *checkpoint.BufferOrEvent {
+		return &checkpoint.BufferOrEvent{Data: &checkpoint.Barrier{CheckpointId: id, OpId: "op"}, Channel: "op"}
+	}
+	s.flushOnBarrier(sink, barrier(1), ctx)
+	if err := s.PrepareCheckpoint(1); err == nil {
+		t.Errorf("expect checkpoint 1 to be declined")
+	}
+	sink.err = nil
+	s.flushOnBarrier(sink, barrier(2), ctx)
+	if err := s.PrepareCheckpoint(2); err != nil {
+		t.Errorf("expect checkpoint 2 to be prepared but got %v", err)
+	}
+}
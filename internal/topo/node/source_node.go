@@ -16,6 +16,7 @@ package node
 
 import (
 	"github.com/lf-edge/ekuiper/internal/conf"
+	"github.com/lf-edge/ekuiper/internal/topo/checkpoint"
 	"github.com/lf-edge/ekuiper/internal/xsql"
 	"github.com/lf-edge/ekuiper/pkg/api"
 	"github.com/lf-edge/ekuiper/pkg/ast"
@@ -32,6 +33,22 @@ type SourceNode struct {
 	props        map[string]interface{}
 	mutex        sync.RWMutex
 	sources      []api.Source
+
+	ackMutex sync.Mutex
+	// the last tuple sent out by each acknowledgeable source instance since the last barrier
+	lastAcks map[int]*ackItem
+	// the tuples to acknowledge when the checkpoints are completed, ordered by checkpoint id
+	pendingAcks []*pendingAck
+}
+
+type ackItem struct {
+	source api.Acknowledgeable
+	tuple  api.SourceTuple
+}
+
+type pendingAck struct {
+	checkpointId int64
+	items        map[int]*ackItem
 }
 
 func NewSourceNode(name string, st ast.StreamType, options *ast.Options) *SourceNode {
@@ -84,6 +101,10 @@ func (m *SourceNode) Open(ctx api.StreamContext, errCh chan<- error) {
 		if m.options.RETAIN_SIZE > 0 && m.streamType == ast.TypeTable {
 			props["$retainSize"] = m.options.RETAIN_SIZE
 		}
+		// Let the batch source like file know whether to send the EOF of a batch for table
+		if m.streamType == ast.TypeTable {
+			props["$isTable"] = true
+		}
 		m.reset()
 		logger.Infof("open source node %d instances", m.concurrency)
 		for i := 0; i < m.concurrency; i++ { // workers
@@ -137,6 +158,9 @@ func (m *SourceNode) Open(ctx api.StreamContext, errCh chan<- error) {
 						m.Broadcast(tuple)
 						stats.IncTotalRecordsOut()
 						stats.SetBufferLength(int64(buffer.GetLength()))
+						if ack, ok := si.source.(api.Acknowledgeable); ok {
+							m.ack(ctx, instance, ack, data)
+						}
 						if rw, ok := si.source.(api.Rewindable); ok {
 							if offset, err := rw.GetOffset(); err != nil {
 								m.drainError(errCh, err, ctx, logger)
@@ -158,6 +182,59 @@ func (m *SourceNode) Open(ctx api.StreamContext, errCh chan<- error) {
 
 func (m *SourceNode) reset() {
 	m.statManagers = nil
+	m.ackMutex.Lock()
+	m.lastAcks = make(map[int]*ackItem)
+	m.pendingAcks = nil
+	m.ackMutex.Unlock()
+}
+
+// ack acknowledges the tuple sent out. With checkpoint, the tuple is recorded and acknowledged when the
+// checkpoint covering it is completed. The shared source is acknowledged immediately as it is consumed
+// by multiple rules.
+func (m *SourceNode) ack(ctx api.StreamContext, instance int, source api.Acknowledgeable, tuple api.SourceTuple) {
+	if m.qos >= api.AtLeastOnce && !m.options.SHARED {
+		m.ackMutex.Lock()
+		m.lastAcks[instance] = &ackItem{source: source, tuple: tuple}
+		m.ackMutex.Unlock()
+		return
+	}
+	if err := source.Ack(ctx, tuple); err != nil {
+		ctx.GetLogger().Warnf("source %s fails to ack: %v", m.name, err)
+	}
+}
+
+// Broadcast records the tuples sent out before the barrier so that they are acknowledged when the
+// checkpoint is completed
+func (m *SourceNode) Broadcast(val interface{}) error {
+	if b, ok := val.(*checkpoint.Barrier); ok {
+		m.ackMutex.Lock()
+		if len(m.lastAcks) > 0 {
+			m.pendingAcks = append(m.pendingAcks, &pendingAck{checkpointId: b.CheckpointId, items: m.lastAcks})
+			m.lastAcks = make(map[int]*ackItem)
+		}
+		m.ackMutex.Unlock()
+	}
+	return m.defaultNode.Broadcast(val)
+}
+
+// NotifyCheckpointComplete acknowledges the tuples sent out before the completed checkpoint. The pending
+// acks of the cancelled checkpoints before it are covered too.
+func (m *SourceNode) NotifyCheckpointComplete(checkpointId int64) {
+	m.ackMutex.Lock()
+	items := make(map[int]*ackItem)
+	i := 0
+	for ; i < len(m.pendingAcks) && m.pendingAcks[i].checkpointId <= checkpointId; i++ {
+		for k, v := range m.pendingAcks[i].items {
+			items[k] = v
+		}
+	}
+	m.pendingAcks = m.pendingAcks[i:]
+	m.ackMutex.Unlock()
+	for _, item := range items {
+		if err := item.source.Ack(m.ctx, item.tuple); err != nil {
+			m.ctx.GetLogger().Warnf("source %s fails to ack: %v", m.name, err)
+		}
+	}
 }
 
 func (m *SourceNode) drainError(errCh chan<- error, err error, ctx api.StreamContext, logger api.Logger) {
@@ -169,6 +246,18 @@ func (m *SourceNode) drainError(errCh chan<- error, err error, ctx api.StreamCon
 	return
 }
 
+func (m *SourceNode) GetConnectionStatus() map[int]api.ConnectionStatus {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+	result := make(map[int]api.ConnectionStatus)
+	for i, s := range m.sources {
+		if c, ok := s.(api.Connectable); ok {
+			result[i] = c.ConnectionStatus()
+		}
+	}
+	return result
+}
+
 func (m *SourceNode) close(ctx api.StreamContext, logger api.Logger) {
 	if !m.options.SHARED {
 		for _, s := range m.sources {
@@ -16,7 +16,9 @@ package node
 
 import (
 	"github.com/lf-edge/ekuiper/internal/conf"
+	"github.com/lf-edge/ekuiper/internal/topo/checkpoint"
 	"github.com/lf-edge/ekuiper/internal/topo/context"
+	"github.com/lf-edge/ekuiper/pkg/api"
 	"github.com/lf-edge/ekuiper/pkg/ast"
 	"github.com/lf-edge/ekuiper/pkg/cast"
 	"reflect"
@@ -105,3 +107,51 @@ type randomSourceConfig struct {
 	Pattern     map[string]interface{} `json:"pattern"`
 	Deduplicate int                    `json:"deduplicate"`
 }
+
+type ackSource struct {
+	acked []interface{}
+}
+
+func (s *ackSource) Ack(_ api.StreamContext, tuple api.SourceTuple) error {
+	s.acked = append(s.acked, tuple.Message()["id"])
+	return nil
+}
+
+func TestSourceNodeAck(t *testing.T) {
+	tuple := func(id int) api.SourceTuple {
+		return api.NewDefaultSourceTuple(map[string]interface{}{"id": id}, nil)
+	}
+	n := NewSourceNode("test", ast.TypeStream, &ast.Options{DATASOURCE: "test", TYPE: "test"})
+	n.ctx = context.WithValue(context.Background(), context.LoggerKey, conf.Log)
+	n.reset()
+
+	// Ack immediately without checkpoint
+	s := &ackSource{}
+	n.ack(n.ctx, 0, s, tuple(1))
+	if !reflect.DeepEqual([]interface{}{1}, s.acked) {
+		t.Errorf("expect ack immediately but got %v", s.acked)
+	}
+
+	n.SetQos(api.AtLeastOnce)
+	s0, s1 := &ackSource{}, &ackSource{}
+	n.ack(n.ctx, 0, s0, tuple(1))
+	n.ack(n.ctx, 0, s0, tuple(2))
+	n.ack(n.ctx, 1, s1, tuple(10))
+	_ = n.Broadcast(&checkpoint.Barrier{CheckpointId: 1, OpId: "test"})
+	n.ack(n.ctx, 0, s0, tuple(3))
+	_ = n.Broadcast(&checkpoint.Barrier{CheckpointId: 2, OpId: "test"})
+	n.ack(n.ctx, 0, s0, tuple(4))
+	if len(s0.acked) > 0 || len(s1.acked) > 0 {
+		t.Errorf("expect no ack before checkpoint completed but got %v %v", s0.acked, s1.acked)
+	}
+	n.NotifyCheckpointComplete(1)
+	if !reflect.DeepEqual([]interface{}{2}, s0.acked) || !reflect.DeepEqual([]interface{}{10}, s1.acked) {
+		t.Errorf("expect ack the last tuples before checkpoint 1 but got %v %v", s0.acked, s1.acked)
+	}
+	// Checkpoint 2 is cancelled, checkpoint 3 covers it
+	_ = n.Broadcast(&checkpoint.Barrier{CheckpointId: 3, OpId: "test"})
+	n.NotifyCheckpointComplete(3)
+	if !reflect.DeepEqual([]interface{}{2, 4}, s0.acked) || !reflect.DeepEqual([]interface{}{10}, s1.acked) {
+		t.Errorf("expect ack the last tuples before checkpoint 3 but got %v %v", s0.acked, s1.acked)
+	}
+}
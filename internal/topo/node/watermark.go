@@ -17,11 +17,14 @@ package node
 import (
 	"context"
 	"fmt"
+	"github.com/lf-edge/ekuiper/internal/conf"
 	"github.com/lf-edge/ekuiper/internal/xsql"
 	"github.com/lf-edge/ekuiper/pkg/api"
 	"github.com/lf-edge/ekuiper/pkg/ast"
+	"github.com/lf-edge/ekuiper/pkg/cast"
 	"math"
 	"sort"
+	"time"
 )
 
 type WatermarkTuple struct {
@@ -69,6 +72,9 @@ func NewWatermarkGenerator(window *WindowConfig, l int64, s []string, stream cha
 	case ast.SESSION_WINDOW:
 		//Use timeout to update watermark
 		w.interval = window.Interval
+	case ast.STATE_WINDOW:
+		//Watermark is only used to order the events and check max duration
+		w.interval = window.Length
 	default:
 		return nil, fmt.Errorf("unsupported window type %d", window.Type)
 	}
@@ -183,6 +189,7 @@ func (o *WindowOperator) execEventWindow(ctx api.StreamContext, inputs []*xsql.T
 		nextWindowEndTs int64
 		prevWindowEndTs int64
 		lastTicked      bool
+		emitC           <-chan time.Time
 	)
 
 	o.watermarkGenerator.lastWatermarkTs = 0
@@ -193,7 +200,18 @@ func (o *WindowOperator) execEventWindow(ctx api.StreamContext, inputs []*xsql.T
 			errCh <- fmt.Errorf("restore window state `lastWatermarkTs` %v error, invalid type", s)
 		}
 	}
+	if s, err := ctx.GetState(LATE_PANES_KEY); err == nil && s != nil {
+		if si, ok := s.([]*windowPane); ok {
+			o.panes = si
+		} else {
+			errCh <- fmt.Errorf("restore window state `panes` %v error, invalid type", s)
+		}
+	}
 	log.Infof("Start with window state lastWatermarkTs: %d", o.watermarkGenerator.lastWatermarkTs)
+	if o.window.Trigger != nil && o.window.Trigger.Interval > 0 {
+		o.emitTicker = conf.GetTicker(o.window.Trigger.Interval)
+		emitC = o.emitTicker.C
+	}
 	for {
 		select {
 		// process incoming item
@@ -222,7 +240,7 @@ func (o *WindowOperator) execEventWindow(ctx api.StreamContext, inputs []*xsql.T
 						if o.window.Type == ast.SESSION_WINDOW {
 							windowEndTs, ticked = o.watermarkGenerator.getNextSessionWindow(inputs, prevWindowEndTs, watermarkTs, triggered)
 						} else {
-							windowEndTs = o.watermarkGenerator.getNextWindow(inputs, prevWindowEndTs, watermarkTs, triggered)
+							windowEndTs = o.watermarkGenerator.getNextWindow(o.overlapInputs(inputs, prevWindowEndTs, watermarkTs, ctx), prevWindowEndTs, watermarkTs, triggered)
 						}
 					}
 					for windowEndTs <= watermarkTs && windowEndTs >= 0 {
@@ -232,17 +250,23 @@ func (o *WindowOperator) execEventWindow(ctx api.StreamContext, inputs []*xsql.T
 						if o.window.Type == ast.SESSION_WINDOW && !lastTicked {
 							o.triggerTime = inputs[0].Timestamp
 						}
-						inputs, triggered = o.scan(inputs, windowEndTs, ctx)
+						inputs, triggered = o.scanInputs(inputs, windowEndTs, ctx)
 						prevWindowEndTs = windowEndTs
 						lastTicked = ticked
 						if o.window.Type == ast.SESSION_WINDOW {
 							windowEndTs, ticked = o.watermarkGenerator.getNextSessionWindow(inputs, prevWindowEndTs, watermarkTs, triggered)
+						} else if triggered && o.window.Type != ast.SLIDING_WINDOW {
+							// The next window end does not depend on the inputs
+							windowEndTs = o.watermarkGenerator.getNextWindow(nil, prevWindowEndTs, watermarkTs, triggered)
 						} else {
-							windowEndTs = o.watermarkGenerator.getNextWindow(inputs, prevWindowEndTs, watermarkTs, triggered)
+							windowEndTs = o.watermarkGenerator.getNextWindow(o.overlapInputs(inputs, prevWindowEndTs, watermarkTs, ctx), prevWindowEndTs, watermarkTs, triggered)
 						}
 					}
 					nextWindowEndTs = windowEndTs
 					log.Debugf("next window end %d", nextWindowEndTs)
+					if o.window.Trigger != nil && o.window.Trigger.AllowedLateness > 0 {
+						o.evictPanes(watermarkTs, ctx)
+					}
 				} else {
 					o.statManager.IncTotalRecordsIn()
 					tuple, ok := d.(*xsql.Tuple)
@@ -251,27 +275,68 @@ func (o *WindowOperator) execEventWindow(ctx api.StreamContext, inputs []*xsql.T
 					}
 					log.Debugf("event window receive tuple %s", tuple.Message)
 					if o.watermarkGenerator.track(tuple.Emitter, d.GetTimestamp(), ctx) {
-						inputs = append(inputs, tuple)
+						inputs = o.appendInput(inputs, tuple, ctx)
+					} else if o.window.Trigger != nil && o.window.Trigger.AllowedLateness > 0 {
+						// A late event may still belong to a window not fired yet
+						if tuple.Timestamp > prevWindowEndTs-int64(o.window.Length)+int64(o.watermarkGenerator.interval) {
+							inputs = o.appendInput(inputs, tuple, ctx)
+						}
+						if !o.fireLate(tuple, ctx) {
+							log.Debugf("drop late tuple %s out of allowed lateness", tuple.Message)
+						}
+					}
+					if o.window.Trigger != nil && o.window.Trigger.Count > 0 {
+						o.emitCount++
+						if o.emitCount >= o.window.Trigger.Count {
+							log.Debugf("triggered early by %d events", o.emitCount)
+							if all, ok := o.earlyInputs(inputs, ctx); ok {
+								o.emitEarly(all, o.nextEventWindowEnd(all, nextWindowEndTs, prevWindowEndTs), ctx)
+							}
+						}
 					}
 				}
 				o.statManager.ProcessTimeEnd()
 				ctx.PutState(WINDOW_INPUTS_KEY, inputs)
+				ctx.PutState(EMIT_INDEX_KEY, o.emitIndex)
+				ctx.PutState(EMIT_COUNT_KEY, o.emitCount)
 			default:
 				o.statManager.IncTotalRecordsIn()
 				o.Broadcast(fmt.Errorf("run Window error: expect xsql.Event type but got %[1]T(%[1]v)", d))
 				o.statManager.IncTotalExceptions()
 			}
+		case now := <-emitC:
+			if o.hasInputs(inputs) {
+				o.statManager.ProcessTimeStart()
+				log.Debugf("triggered early at %d", cast.TimeToUnixMilli(now))
+				if all, ok := o.earlyInputs(inputs, ctx); ok {
+					o.emitEarly(all, o.nextEventWindowEnd(all, nextWindowEndTs, prevWindowEndTs), ctx)
+				}
+				o.statManager.ProcessTimeEnd()
+				ctx.PutState(EMIT_INDEX_KEY, o.emitIndex)
+				ctx.PutState(EMIT_COUNT_KEY, o.emitCount)
+			}
 		// is cancelling
 		case <-ctx.Done():
 			log.Infoln("Cancelling window....")
 			if o.ticker != nil {
 				o.ticker.Stop()
 			}
+			if o.emitTicker != nil {
+				o.emitTicker.Stop()
+			}
 			return
 		}
 	}
 }
 
+// nextEventWindowEnd returns the end of the earliest window not fired yet for early firing
+func (o *WindowOperator) nextEventWindowEnd(inputs []*xsql.Tuple, next int64, prev int64) int64 {
+	if next > 0 && next < math.MaxInt64 {
+		return next
+	}
+	return o.watermarkGenerator.getNextWindow(inputs, prev, math.MaxInt64, false)
+}
+
 func getEarliestEventTs(inputs []*xsql.Tuple, startTs int64, endTs int64) int64 {
 	var minTs int64 = math.MaxInt64
 	for _, t := range inputs {
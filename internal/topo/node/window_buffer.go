@@ -0,0 +1,482 @@
+// Copyright 2021 EMQ Technologies Co., Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package node
+
+import (
+	"encoding/gob"
+	"fmt"
+	"github.com/lf-edge/ekuiper/internal/pkg/store"
+	"github.com/lf-edge/ekuiper/internal/xsql"
+	"github.com/lf-edge/ekuiper/pkg/api"
+	"github.com/lf-edge/ekuiper/pkg/ast"
+	"github.com/lf-edge/ekuiper/pkg/kv"
+	"math"
+	"strings"
+	"sync"
+)
+
+const (
+	WINDOW_BUFFER_KEY   = "$$windowBuffer"
+	WINDOW_BUFFER_TABLE = "windowBuffer"
+)
+
+func init() {
+	gob.Register([]*bufferSegment{})
+}
+
+// bufferSegment is a chunk of the oldest window inputs spilled to the store. A segment is never changed once
+// written. When some of its inputs are consumed, the rest is written to a new segment with a new id.
+type bufferSegment struct {
+	Id    int
+	Count int
+	MinTs int64 // The min timestamp of the inputs in the segment
+	MaxTs int64 // The max timestamp of the inputs in the segment
+}
+
+// retiredSegment is a segment no longer used but may still be referred by the checkpoints not completed yet
+type retiredSegment struct {
+	id int
+	// The checkpoint whose snapshot no longer refers to the segment, 0 if no snapshot is taken after the retirement
+	checkpointId int64
+}
+
+// windowBuffer keeps the window inputs within the memory limit. When the estimated size of the inputs
+// in memory exceeds the limit, the oldest inputs are spilled to the store as segments. When the window
+// is scanned, only the segments overlapping the window are loaded. Only the segment index is saved in
+// the state so that the checkpoint does not include the spilled inputs. Thus, a segment is deleted only
+// after the checkpoint whose snapshot no longer refers to it is completed.
+type windowBuffer struct {
+	limit    int
+	store    kv.KeyValue
+	prefix   string
+	segments []*bufferSegment
+	nextId   int
+	size     int // The estimated size of the inputs in memory
+	qos      api.Qos
+
+	mu      sync.Mutex
+	retired []*retiredSegment
+}
+
+func newWindowBuffer(limit int) *windowBuffer {
+	return &windowBuffer{limit: limit}
+}
+
+// open the store and restore the segments from the state. The segments not in the state are removed.
+func (b *windowBuffer) open(ctx api.StreamContext, inputs []*xsql.Tuple, qos api.Qos) error {
+	err, s := store.GetKV(WINDOW_BUFFER_TABLE)
+	if err != nil {
+		return fmt.Errorf("fail to open window buffer store: %v", err)
+	}
+	b.store = s
+	b.prefix = fmt.Sprintf("%s/%s/", ctx.GetRuleId(), ctx.GetOpId())
+	b.qos = qos
+	b.segments = nil
+	b.retired = nil
+	if st, err := ctx.GetState(WINDOW_BUFFER_KEY); err == nil && st != nil {
+		if si, ok := st.([]*bufferSegment); ok {
+			b.segments = si
+		} else {
+			return fmt.Errorf("restore window state `buffer` %v error, invalid type", st)
+		}
+	}
+	valid := make(map[string]bool)
+	for _, seg := range b.segments {
+		valid[b.key(seg.Id)] = true
+		if seg.Id >= b.nextId {
+			b.nextId = seg.Id + 1
+		}
+	}
+	keys, err := b.store.Keys()
+	if err != nil {
+		return err
+	}
+	for _, k := range keys {
+		if strings.HasPrefix(k, b.prefix) && !valid[k] {
+			_ = b.store.Delete(k)
+		}
+	}
+	b.resize(inputs)
+	ctx.GetLogger().Infof("Restore window buffer with %d segments", len(b.segments))
+	return nil
+}
+
+func (b *windowBuffer) key(id int) string {
+	return fmt.Sprintf("%s%d", b.prefix, id)
+}
+
+// setSegments replaces the segment index. The index is always a new slice as the state may be being saved.
+func (b *windowBuffer) setSegments(segments []*bufferSegment, ctx api.StreamContext) {
+	b.segments = segments
+	ctx.PutState(WINDOW_BUFFER_KEY, segments)
+}
+
+// write the inputs to a new segment
+func (b *windowBuffer) write(inputs []*xsql.Tuple) (*bufferSegment, error) {
+	seg := &bufferSegment{Id: b.nextId, Count: len(inputs), MinTs: math.MaxInt64, MaxTs: math.MinInt64}
+	for _, t := range inputs {
+		if t.Timestamp < seg.MinTs {
+			seg.MinTs = t.Timestamp
+		}
+		if t.Timestamp > seg.MaxTs {
+			seg.MaxTs = t.Timestamp
+		}
+	}
+	if err := b.store.Set(b.key(seg.Id), inputs); err != nil {
+		return nil, err
+	}
+	b.nextId++
+	return seg, nil
+}
+
+func (b *windowBuffer) read(seg *bufferSegment, ctx api.StreamContext) ([]*xsql.Tuple, bool) {
+	var tuples []*xsql.Tuple
+	if ok, err := b.store.Get(b.key(seg.Id), &tuples); err != nil || !ok {
+		ctx.GetLogger().Warnf("fail to load window buffer segment %d, %d inputs are lost: %v", seg.Id, seg.Count, err)
+		return nil, false
+	}
+	return tuples, true
+}
+
+// retire the segment which is not referred by the segment index any more. Without checkpoint, it is deleted at once.
+func (b *windowBuffer) retire(seg *bufferSegment) {
+	if b.qos < api.AtLeastOnce {
+		_ = b.store.Delete(b.key(seg.Id))
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.retired = append(b.retired, &retiredSegment{id: seg.Id})
+}
+
+// snapshot marks the segments retired before the snapshot so that they are deleted once the checkpoint is completed
+func (b *windowBuffer) snapshot(checkpointId int64) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for _, r := range b.retired {
+		if r.checkpointId == 0 {
+			r.checkpointId = checkpointId
+		}
+	}
+}
+
+// complete deletes the retired segments not referred by the completed checkpoint
+func (b *windowBuffer) complete(checkpointId int64) {
+	b.mu.Lock()
+	var rest, deleted []*retiredSegment
+	for _, r := range b.retired {
+		if r.checkpointId > 0 && r.checkpointId <= checkpointId {
+			deleted = append(deleted, r)
+		} else {
+			rest = append(rest, r)
+		}
+	}
+	b.retired = rest
+	b.mu.Unlock()
+	for _, r := range deleted {
+		_ = b.store.Delete(b.key(r.id))
+	}
+}
+
+// append a tuple and spill the oldest inputs if exceeding the memory limit
+func (b *windowBuffer) append(inputs []*xsql.Tuple, tuple *xsql.Tuple, ctx api.StreamContext) []*xsql.Tuple {
+	b.size += estimateTupleSize(tuple)
+	return b.spill(append(inputs, tuple), ctx)
+}
+
+// spill the oldest inputs to a new segment until the size in memory is below half of the limit
+func (b *windowBuffer) spill(inputs []*xsql.Tuple, ctx api.StreamContext) []*xsql.Tuple {
+	if b.size <= b.limit {
+		return inputs
+	}
+	i := 0
+	for ; i < len(inputs) && b.size > b.limit/2; i++ {
+		b.size -= estimateTupleSize(inputs[i])
+	}
+	if i == 0 {
+		return inputs
+	}
+	seg, err := b.write(inputs[:i])
+	if err != nil {
+		ctx.GetLogger().Errorf("fail to spill window inputs, keep them in memory: %v", err)
+		b.resize(inputs)
+		return inputs
+	}
+	ctx.GetLogger().Debugf("spill %d window inputs to segment %d", i, seg.Id)
+	segments := make([]*bufferSegment, len(b.segments), len(b.segments)+1)
+	copy(segments, b.segments)
+	b.setSegments(append(segments, seg), ctx)
+	rest := make([]*xsql.Tuple, len(inputs)-i)
+	copy(rest, inputs[i:])
+	return rest
+}
+
+func (b *windowBuffer) resize(inputs []*xsql.Tuple) {
+	b.size = 0
+	for _, t := range inputs {
+		b.size += estimateTupleSize(t)
+	}
+}
+
+// all returns the spilled inputs followed by the inputs in memory without changing the buffer
+func (b *windowBuffer) all(inputs []*xsql.Tuple, ctx api.StreamContext) []*xsql.Tuple {
+	return b.overlap(inputs, math.MinInt64, math.MaxInt64, ctx)
+}
+
+// overlap returns the spilled inputs of the segments overlapping the time range (from, to] followed by the inputs
+// in memory without changing the buffer
+func (b *windowBuffer) overlap(inputs []*xsql.Tuple, from int64, to int64, ctx api.StreamContext) []*xsql.Tuple {
+	if len(b.segments) == 0 {
+		return inputs
+	}
+	var result []*xsql.Tuple
+	for _, seg := range b.segments {
+		if seg.MaxTs <= from || seg.MinTs > to {
+			continue
+		}
+		if tuples, ok := b.read(seg, ctx); ok {
+			result = append(result, tuples...)
+		}
+	}
+	return append(result, inputs...)
+}
+
+// scan the window inputs with the segments which may have inputs in the window. The segments whose inputs are all
+// expired by the window are retired without loading. The segments whose inputs are all after the window are kept
+// without loading. After the scan, the loaded segments are kept unless some of their inputs are consumed. It
+// returns the inputs left in memory.
+func (b *windowBuffer) scan(inputs []*xsql.Tuple, expire int64, triggerTime int64, emitIndex *int, scan func([]*xsql.Tuple) []*xsql.Tuple, ctx api.StreamContext) []*xsql.Tuple {
+	var (
+		loaded   = make(map[*bufferSegment][]*xsql.Tuple)
+		all      []*xsql.Tuple
+		segments []*bufferSegment
+		pos      int
+		skipped  int
+	)
+	for _, seg := range b.segments {
+		load := seg.MaxTs >= expire && seg.MinTs <= triggerTime
+		if load {
+			if tuples, ok := b.read(seg, ctx); ok {
+				loaded[seg] = tuples
+				all = append(all, tuples...)
+				segments = append(segments, seg)
+			} else {
+				b.retire(seg)
+			}
+		} else {
+			// The positions of the early fired inputs are shifted by the segments not loaded
+			if *emitIndex > pos {
+				if *emitIndex-pos < seg.Count {
+					skipped += *emitIndex - pos
+				} else {
+					skipped += seg.Count
+				}
+			}
+			if seg.MaxTs < expire {
+				b.retire(seg)
+			} else {
+				segments = append(segments, seg)
+			}
+		}
+		pos += seg.Count
+	}
+	*emitIndex -= skipped
+	rest := scan(append(all, inputs...))
+	left := make(map[*xsql.Tuple]bool, len(rest))
+	for _, t := range rest {
+		left[t] = true
+	}
+	var (
+		result []*bufferSegment
+		moved  []*xsql.Tuple
+	)
+	for _, seg := range segments {
+		tuples, ok := loaded[seg]
+		if !ok {
+			result = append(result, seg)
+			continue
+		}
+		var kept []*xsql.Tuple
+		for _, t := range tuples {
+			if left[t] {
+				kept = append(kept, t)
+			}
+			delete(left, t)
+		}
+		switch {
+		// The expired inputs are filtered by the later scans, so the segment can be kept
+		case len(kept) == len(tuples) || (len(kept) > 0 && expire > math.MinInt64):
+			result = append(result, seg)
+		case len(kept) == 0:
+			b.retire(seg)
+		default:
+			if nseg, err := b.write(kept); err != nil {
+				ctx.GetLogger().Errorf("fail to spill window inputs, keep them in memory: %v", err)
+				moved = append(moved, kept...)
+			} else {
+				result = append(result, nseg)
+			}
+			b.retire(seg)
+		}
+	}
+	b.setSegments(result, ctx)
+	inputs = moved
+	for _, t := range rest {
+		if left[t] {
+			inputs = append(inputs, t)
+		}
+	}
+	b.resize(inputs)
+	return b.spill(inputs, ctx)
+}
+
+// appendInput adds the tuple to the window inputs
+func (o *WindowOperator) appendInput(inputs []*xsql.Tuple, tuple *xsql.Tuple, ctx api.StreamContext) []*xsql.Tuple {
+	if o.incAgg != nil {
+		if err := o.incAgg.accumulate(tuple, ctx); err != nil {
+			o.Broadcast(err)
+			o.statManager.IncTotalExceptions()
+		}
+		return inputs
+	}
+	if o.buffer == nil {
+		return append(inputs, tuple)
+	}
+	return o.buffer.append(inputs, tuple, ctx)
+}
+
+// hasInputs tells if there are window inputs in memory or spilled
+func (o *WindowOperator) hasInputs(inputs []*xsql.Tuple) bool {
+	if o.incAgg != nil {
+		return o.incAgg.hasData()
+	}
+	return len(inputs) > 0 || (o.buffer != nil && len(o.buffer.segments) > 0)
+}
+
+// earlyInputs returns the window inputs for the early firing. The early firing is skipped while some inputs are
+// spilled, because the early result holds all the inputs of the window which would break the memory limit.
+func (o *WindowOperator) earlyInputs(inputs []*xsql.Tuple, ctx api.StreamContext) ([]*xsql.Tuple, bool) {
+	if o.buffer != nil && len(o.buffer.segments) > 0 {
+		ctx.GetLogger().Debugf("skip the early firing as the window inputs are spilled")
+		o.emitCount = 0
+		return nil, false
+	}
+	return inputs, true
+}
+
+// overlapInputs returns the window inputs in the time range (from, to] including the spilled ones for read only.
+// The inputs in memory are returned as a whole.
+func (o *WindowOperator) overlapInputs(inputs []*xsql.Tuple, from int64, to int64, ctx api.StreamContext) []*xsql.Tuple {
+	if o.buffer == nil {
+		return inputs
+	}
+	return o.buffer.overlap(inputs, from, to, ctx)
+}
+
+// scanInputs scans the window inputs including the spilled ones
+func (o *WindowOperator) scanInputs(inputs []*xsql.Tuple, triggerTime int64, ctx api.StreamContext) ([]*xsql.Tuple, bool) {
+	if o.incAgg != nil {
+		return inputs, o.fireIncAggregate(triggerTime, ctx)
+	}
+	if o.buffer == nil || len(o.buffer.segments) == 0 {
+		return o.scan(inputs, triggerTime, ctx)
+	}
+	// The inputs before expire are dropped by the scan of hopping and sliding windows
+	var expire int64 = math.MinInt64
+	if o.window.Type == ast.HOPPING_WINDOW || o.window.Type == ast.SLIDING_WINDOW {
+		expire = triggerTime - int64(o.window.Length) - o.calDelta(triggerTime, 0, ctx.GetLogger())
+	}
+	var triggered bool
+	inputs = o.buffer.scan(inputs, expire, triggerTime, &o.emitIndex, func(all []*xsql.Tuple) []*xsql.Tuple {
+		var rest []*xsql.Tuple
+		rest, triggered = o.scan(all, triggerTime, ctx)
+		return rest
+	}, ctx)
+	return inputs, triggered
+}
+
+// NotifySnapshot marks the spilled segments to delete after the checkpoint is completed
+func (o *WindowOperator) NotifySnapshot(checkpointId int64) {
+	if o.buffer != nil {
+		o.buffer.snapshot(checkpointId)
+	}
+}
+
+// NotifyCheckpointComplete deletes the spilled segments which are not referred by the completed checkpoint
+func (o *WindowOperator) NotifyCheckpointComplete(checkpointId int64) {
+	if o.buffer != nil {
+		o.buffer.complete(checkpointId)
+	}
+}
+
+// CleanWindowBuffer removes all the spilled window inputs of a rule
+func CleanWindowBuffer(ruleId string) error {
+	err, s := store.GetKV(WINDOW_BUFFER_TABLE)
+	if err != nil {
+		return err
+	}
+	keys, err := s.Keys()
+	if err != nil {
+		return err
+	}
+	prefix := ruleId + "/"
+	for _, k := range keys {
+		if strings.HasPrefix(k, prefix) {
+			if err := s.Delete(k); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// estimateTupleSize roughly estimates the memory in bytes occupied by the tuple
+func estimateTupleSize(t *xsql.Tuple) int {
+	size := 64 + len(t.Emitter)
+	size += estimateSize(map[string]interface{}(t.Message))
+	size += estimateSize(map[string]interface{}(t.Metadata))
+	return size
+}
+
+func estimateSize(v interface{}) int {
+	switch vt := v.(type) {
+	case nil:
+		return 8
+	case string:
+		return 16 + len(vt)
+	case []byte:
+		return 24 + len(vt)
+	case map[string]interface{}:
+		size := 48
+		for k, e := range vt {
+			size += 16 + len(k) + estimateSize(e)
+		}
+		return size
+	case []interface{}:
+		size := 24
+		for _, e := range vt {
+			size += estimateSize(e)
+		}
+		return size
+	case []map[string]interface{}:
+		size := 24
+		for _, e := range vt {
+			size += estimateSize(e)
+		}
+		return size
+	default:
+		return 16
+	}
+}
@@ -0,0 +1,133 @@
+// Copyright 2021 EMQ Technologies Co., Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package node
+
+import (
+	"github.com/lf-edge/ekuiper/internal/conf"
+	"github.com/lf-edge/ekuiper/internal/testx"
+	"github.com/lf-edge/ekuiper/internal/topo/context"
+	"github.com/lf-edge/ekuiper/internal/topo/state"
+	"github.com/lf-edge/ekuiper/internal/xsql"
+	"github.com/lf-edge/ekuiper/pkg/api"
+	"math"
+	"reflect"
+	"testing"
+)
+
+func TestWindowBuffer(t *testing.T) {
+	testx.InitEnv()
+	contextLogger := conf.Log.WithField("rule", "TestWindowBuffer")
+	tempStore, _ := state.CreateStore("TestWindowBuffer", api.AtMostOnce)
+	ctx := context.WithValue(context.Background(), context.LoggerKey, contextLogger).WithMeta("TestWindowBuffer", "window", tempStore)
+	var tuples []*xsql.Tuple
+	for i := 1; i <= 5; i++ {
+		tuples = append(tuples, &xsql.Tuple{Emitter: "test", Message: map[string]interface{}{"f": "v"}, Timestamp: int64(i)})
+	}
+	size := estimateTupleSize(tuples[0])
+	b := newWindowBuffer(size * 3)
+	if err := b.open(ctx, nil, api.AtLeastOnce); err != nil {
+		t.Fatal(err)
+	}
+	var inputs []*xsql.Tuple
+	for _, tuple := range tuples {
+		inputs = b.append(inputs, tuple, ctx)
+	}
+	// Spill the first 3 tuples when the 4th arrives
+	if len(inputs) != 2 || !reflect.DeepEqual(b.segments, []*bufferSegment{{Id: 0, Count: 3, MinTs: 1, MaxTs: 3}}) {
+		t.Errorf("expect 2 tuples in memory and 1 segment of 3 tuples but got %d tuples and segments %v", len(inputs), b.segments)
+	}
+	if r := b.all(inputs, ctx); !reflect.DeepEqual(tuples, r) {
+		t.Errorf("all inputs mismatch:\n  exp=%v\n  got=%v", tuples, r)
+	}
+	// The early firing is skipped while the inputs are spilled
+	o := &WindowOperator{buffer: b, emitCount: 2}
+	if _, ok := o.earlyInputs(inputs, ctx); ok || o.emitCount != 0 {
+		t.Errorf("expect the early firing to be skipped while spilled")
+	}
+	// Restore from the state
+	s, _ := ctx.GetState(WINDOW_BUFFER_KEY)
+	if !reflect.DeepEqual(b.segments, s) {
+		t.Errorf("state mismatch:\n  exp=%v\n  got=%v", b.segments, s)
+	}
+	b = newWindowBuffer(size * 3)
+	if err := b.open(ctx, inputs, api.AtLeastOnce); err != nil {
+		t.Fatal(err)
+	}
+	var scanned []*xsql.Tuple
+	expireBefore := func(ts int64) func([]*xsql.Tuple) []*xsql.Tuple {
+		return func(all []*xsql.Tuple) []*xsql.Tuple {
+			scanned = all
+			var rest []*xsql.Tuple
+			for _, t := range all {
+				if t.Timestamp >= ts {
+					rest = append(rest, t)
+				}
+			}
+			return rest
+		}
+	}
+	// The segment partially expired is loaded and kept
+	emitIndex := 0
+	inputs = b.scan(inputs, 3, 5, &emitIndex, expireBefore(3), ctx)
+	if !reflect.DeepEqual(tuples, scanned) || len(inputs) != 2 || len(b.segments) != 1 || b.segments[0].Id != 0 {
+		t.Errorf("expect the segment kept but got %d scanned, %d tuples and segments %v", len(scanned), len(inputs), b.segments)
+	}
+	// The segment totally expired is retired without loading
+	emitIndex = 4
+	inputs = b.scan(inputs, 4, 6, &emitIndex, expireBefore(4), ctx)
+	if !reflect.DeepEqual(tuples[3:], scanned) || len(inputs) != 2 || len(b.segments) != 0 || emitIndex != 1 {
+		t.Errorf("expect the segment retired but got %d scanned, %d tuples, segments %v and emit index %d", len(scanned), len(inputs), b.segments, emitIndex)
+	}
+	// The retired segment is deleted only after a checkpoint taken after the retirement is completed
+	var r []*xsql.Tuple
+	if ok, _ := b.store.Get(b.key(0), &r); !ok {
+		t.Errorf("segment 0 is deleted before the checkpoint is completed")
+	}
+	b.complete(1)
+	if ok, _ := b.store.Get(b.key(0), &r); !ok {
+		t.Errorf("segment 0 is deleted by the checkpoint taken before the retirement")
+	}
+	b.snapshot(2)
+	b.complete(2)
+	if ok, _ := b.store.Get(b.key(0), &r); ok {
+		t.Errorf("segment 0 is not deleted after the checkpoint is completed")
+	}
+	// The segment partially consumed is replaced by a new segment of the rest
+	b.setSegments(nil, ctx)
+	for _, tuple := range tuples {
+		inputs = b.append(inputs, tuple, ctx)
+	}
+	if len(b.segments) != 2 {
+		t.Fatalf("expect 2 segments but got %v", b.segments)
+	}
+	old := b.segments[0]
+	inputs = b.scan(inputs, math.MinInt64, 4, &emitIndex, func(all []*xsql.Tuple) []*xsql.Tuple {
+		var rest []*xsql.Tuple
+		for _, t := range all {
+			if t.Timestamp > 4 {
+				rest = append(rest, t)
+			}
+		}
+		return rest
+	}, ctx)
+	for _, seg := range b.segments {
+		if seg.Id == old.Id || seg.MinTs <= 4 {
+			t.Errorf("segment %v is not replaced", seg)
+		}
+	}
+	if err := CleanWindowBuffer("TestWindowBuffer"); err != nil {
+		t.Error(err)
+	}
+}
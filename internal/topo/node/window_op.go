@@ -30,7 +30,14 @@ import (
 type WindowConfig struct {
 	Type     ast.WindowType
 	Length   int
-	Interval int //If interval is not set, it is equals to Length
+	Interval int                //If interval is not set, it is equals to Length
+	Trigger  *ast.WindowTrigger //If trigger is not set, only fire at the window end
+	Keys     ast.Dimensions     //If keys are set, each key has its own window
+	Begin    ast.Expr           //The begin condition of state window
+	End      ast.Expr           //The end condition of state window
+	// If aggregates are set, the window pre-aggregates the inputs by groups instead of keeping them
+	Aggregates []*IncAggregate
+	Groups     ast.Dimensions //The group by dimensions of the incremental aggregates
 }
 
 type WindowOperator struct {
@@ -38,21 +45,39 @@ type WindowOperator struct {
 	window             *WindowConfig
 	interval           int
 	isEventTime        bool
+	maxKeys            int                 //The max number of keys for the keyed window, 0 means no limit
+	buffer             *windowBuffer       //Spill the inputs if memory limit is set
+	incAgg             *incAggregator      //Aggregate the inputs incrementally if set
 	watermarkGenerator *WatermarkGenerator //For event time only
 
 	statManager StatManager
 	ticker      *clock.Ticker //For processing time only
+	emitTicker  *clock.Ticker //For early firing by time only
 	// states
 	triggerTime int64
 	msgCount    int
+	emitIndex   int           //The inputs index of the last early firing
+	emitCount   int           //The count of events since the last firing
+	panes       []*windowPane //The fired windows waiting for late events
+}
+
+// windowPane is a fired window kept for the allowed lateness
+type windowPane struct {
+	Start  int64
+	End    int64
+	Tuples []*xsql.Tuple
 }
 
 const WINDOW_INPUTS_KEY = "$$windowInputs"
 const TRIGGER_TIME_KEY = "$$triggerTime"
 const MSG_COUNT_KEY = "$$msgCount"
+const EMIT_INDEX_KEY = "$$emitIndex"
+const EMIT_COUNT_KEY = "$$emitCount"
+const LATE_PANES_KEY = "$$latePanes"
 
 func init() {
 	gob.Register([]*xsql.Tuple{})
+	gob.Register([]*windowPane{})
 }
 
 func NewWindowOp(name string, w WindowConfig, streams []string, options *api.RuleOption) (*WindowOperator, error) {
@@ -67,11 +92,20 @@ func NewWindowOp(name string, w WindowConfig, streams []string, options *api.Rul
 		},
 	}
 	o.isEventTime = options.IsEventTime
+	o.maxKeys = options.MaxWindowKeys
 	o.window = &w
 	if o.window.Interval == 0 && o.window.Type == ast.COUNT_WINDOW {
 		//if no interval value is set and it's count window, then set interval to length value.
 		o.window.Interval = o.window.Length
 	}
+	if len(w.Aggregates) > 0 {
+		o.incAgg = newIncAggregator(o.window)
+	} else if options.WindowMemoryLimit > 0 && len(w.Keys) == 0 {
+		switch w.Type {
+		case ast.TUMBLING_WINDOW, ast.HOPPING_WINDOW, ast.SLIDING_WINDOW:
+			o.buffer = newWindowBuffer(options.WindowMemoryLimit)
+		}
+	}
 	if options.IsEventTime {
 		//Create watermark generator
 		if w, err := NewWatermarkGenerator(o.window, options.LateTol, streams, o.input); err != nil {
@@ -115,6 +149,18 @@ func (o *WindowOperator) Exec(ctx api.StreamContext, errCh chan<- error) {
 	} else {
 		log.Warnf("Restore window state fails: %s", err)
 	}
+	if o.buffer != nil {
+		if err := o.buffer.open(ctx, inputs, o.qos); err != nil {
+			go func() { errCh <- err }()
+			return
+		}
+	}
+	if o.incAgg != nil {
+		if err := o.incAgg.open(ctx); err != nil {
+			go func() { errCh <- err }()
+			return
+		}
+	}
 	o.triggerTime = conf.GetNowInMilli()
 	if s, err := ctx.GetState(TRIGGER_TIME_KEY); err == nil && s != nil {
 		if si, ok := s.(int64); ok {
@@ -131,8 +177,26 @@ func (o *WindowOperator) Exec(ctx api.StreamContext, errCh chan<- error) {
 			errCh <- fmt.Errorf("restore window state `msgCount` %v error, invalid type", s)
 		}
 	}
-	log.Infof("Start with window state triggerTime: %d, msgCount: %d", o.triggerTime, o.msgCount)
-	if o.isEventTime {
+	o.emitIndex = 0
+	if s, err := ctx.GetState(EMIT_INDEX_KEY); err == nil && s != nil {
+		if si, ok := s.(int); ok {
+			o.emitIndex = si
+		} else {
+			errCh <- fmt.Errorf("restore window state `emitIndex` %v error, invalid type", s)
+		}
+	}
+	o.emitCount = 0
+	if s, err := ctx.GetState(EMIT_COUNT_KEY); err == nil && s != nil {
+		if si, ok := s.(int); ok {
+			o.emitCount = si
+		} else {
+			errCh <- fmt.Errorf("restore window state `emitCount` %v error, invalid type", s)
+		}
+	}
+	log.Infof("Start with window state triggerTime: %d, msgCount: %d, emitIndex: %d, emitCount: %d", o.triggerTime, o.msgCount, o.emitIndex, o.emitCount)
+	if len(o.window.Keys) > 0 || o.window.Type == ast.STATE_WINDOW {
+		go o.execKeyedWindow(ctx, errCh)
+	} else if o.isEventTime {
 		go o.execEventWindow(ctx, inputs, errCh)
 	} else {
 		go o.execProcessingWindow(ctx, inputs, errCh)
@@ -145,6 +209,7 @@ func (o *WindowOperator) execProcessingWindow(ctx api.StreamContext, inputs []*x
 		c             <-chan time.Time
 		timeoutTicker *clock.Timer
 		timeout       <-chan time.Time
+		emitC         <-chan time.Time
 	)
 	switch o.window.Type {
 	case ast.NOT_WINDOW:
@@ -162,11 +227,15 @@ func (o *WindowOperator) execProcessingWindow(ctx api.StreamContext, inputs []*x
 	case ast.COUNT_WINDOW:
 		o.interval = o.window.Interval
 	}
+	if o.window.Trigger != nil && o.window.Trigger.Interval > 0 {
+		o.emitTicker = conf.GetTicker(o.window.Trigger.Interval)
+		emitC = o.emitTicker.C
+	}
 
 	if o.ticker != nil {
 		c = o.ticker.C
 		//resume previous window
-		if len(inputs) > 0 && o.triggerTime > 0 {
+		if o.hasInputs(inputs) && o.triggerTime > 0 {
 			nextTick := conf.GetNowInMilli() + int64(o.interval)
 			next := o.triggerTime
 			switch o.window.Type {
@@ -177,7 +246,7 @@ func (o *WindowOperator) execProcessingWindow(ctx api.StreamContext, inputs []*x
 						break
 					}
 					log.Debugf("triggered by restore inputs")
-					inputs, _ = o.scan(inputs, next, ctx)
+					inputs, _ = o.scanInputs(inputs, next, ctx)
 					ctx.PutState(WINDOW_INPUTS_KEY, inputs)
 					ctx.PutState(TRIGGER_TIME_KEY, o.triggerTime)
 				}
@@ -213,7 +282,7 @@ func (o *WindowOperator) execProcessingWindow(ctx api.StreamContext, inputs []*x
 						break
 					}
 					log.Debugf("triggered by restore inputs")
-					inputs, _ = o.scan(inputs, next, ctx)
+					inputs, _ = o.scanInputs(inputs, next, ctx)
 					ctx.PutState(WINDOW_INPUTS_KEY, inputs)
 					ctx.PutState(TRIGGER_TIME_KEY, o.triggerTime)
 				}
@@ -241,12 +310,22 @@ func (o *WindowOperator) execProcessingWindow(ctx api.StreamContext, inputs []*x
 				o.statManager.IncTotalExceptions()
 			case *xsql.Tuple:
 				log.Debugf("Event window receive tuple %s", d.Message)
-				inputs = append(inputs, d)
+				inputs = o.appendInput(inputs, d, ctx)
 				switch o.window.Type {
 				case ast.NOT_WINDOW:
-					inputs, _ = o.scan(inputs, d.Timestamp, ctx)
+					inputs, _ = o.scanInputs(inputs, d.Timestamp, ctx)
+				case ast.TUMBLING_WINDOW, ast.HOPPING_WINDOW:
+					if o.window.Trigger != nil && o.window.Trigger.Count > 0 {
+						o.emitCount++
+						if o.emitCount >= o.window.Trigger.Count {
+							log.Debugf("triggered early by %d events", o.emitCount)
+							if all, ok := o.earlyInputs(inputs, ctx); ok {
+								o.emitEarly(all, o.triggerTime+int64(o.interval), ctx)
+							}
+						}
+					}
 				case ast.SLIDING_WINDOW:
-					inputs, _ = o.scan(inputs, d.Timestamp, ctx)
+					inputs, _ = o.scanInputs(inputs, d.Timestamp, ctx)
 				case ast.SESSION_WINDOW:
 					if timeoutTicker != nil {
 						timeoutTicker.Stop()
@@ -286,6 +365,8 @@ func (o *WindowOperator) execProcessingWindow(ctx api.StreamContext, inputs []*x
 				o.statManager.SetBufferLength(int64(len(o.input)))
 				ctx.PutState(WINDOW_INPUTS_KEY, inputs)
 				ctx.PutState(MSG_COUNT_KEY, o.msgCount)
+				ctx.PutState(EMIT_INDEX_KEY, o.emitIndex)
+				ctx.PutState(EMIT_COUNT_KEY, o.emitCount)
 			default:
 				o.Broadcast(fmt.Errorf("run Window error: expect xsql.Tuple type but got %[1]T(%[1]v)", d))
 				o.statManager.IncTotalExceptions()
@@ -301,10 +382,10 @@ func (o *WindowOperator) execProcessingWindow(ctx api.StreamContext, inputs []*x
 					break
 				}
 			}
-			if len(inputs) > 0 {
+			if o.hasInputs(inputs) {
 				o.statManager.ProcessTimeStart()
 				log.Debugf("triggered by ticker at %d", n)
-				inputs, _ = o.scan(inputs, n, ctx)
+				inputs, _ = o.scanInputs(inputs, n, ctx)
 				o.statManager.ProcessTimeEnd()
 				ctx.PutState(WINDOW_INPUTS_KEY, inputs)
 				ctx.PutState(TRIGGER_TIME_KEY, o.triggerTime)
@@ -313,7 +394,7 @@ func (o *WindowOperator) execProcessingWindow(ctx api.StreamContext, inputs []*x
 			if len(inputs) > 0 {
 				o.statManager.ProcessTimeStart()
 				log.Debugf("triggered by timeout")
-				inputs, _ = o.scan(inputs, cast.TimeToUnixMilli(now), ctx)
+				inputs, _ = o.scanInputs(inputs, cast.TimeToUnixMilli(now), ctx)
 				//expire all inputs, so that when timer scan there is no item
 				inputs = make([]*xsql.Tuple, 0)
 				o.statManager.ProcessTimeEnd()
@@ -321,12 +402,26 @@ func (o *WindowOperator) execProcessingWindow(ctx api.StreamContext, inputs []*x
 				ctx.PutState(TRIGGER_TIME_KEY, o.triggerTime)
 				timeoutTicker = nil
 			}
+		case now := <-emitC:
+			if o.hasInputs(inputs) {
+				o.statManager.ProcessTimeStart()
+				log.Debugf("triggered early at %d", cast.TimeToUnixMilli(now))
+				if all, ok := o.earlyInputs(inputs, ctx); ok {
+					o.emitEarly(all, o.triggerTime+int64(o.interval), ctx)
+				}
+				o.statManager.ProcessTimeEnd()
+				ctx.PutState(EMIT_INDEX_KEY, o.emitIndex)
+				ctx.PutState(EMIT_COUNT_KEY, o.emitCount)
+			}
 		// is cancelling
 		case <-ctx.Done():
 			log.Infoln("Cancelling window....")
 			if o.ticker != nil {
 				o.ticker.Stop()
 			}
+			if o.emitTicker != nil {
+				o.emitTicker.Stop()
+			}
 			return
 		}
 	}
@@ -397,8 +492,18 @@ func (o *WindowOperator) scan(inputs []*xsql.Tuple, triggerTime int64, ctx api.S
 		},
 	}
 	i := 0
+	// In discard mode, the events already fired early are not fired again
+	discarded := 0
+	if o.window.Trigger != nil && o.window.Trigger.Mode == ast.EMIT_DISCARD {
+		discarded = o.emitIndex
+	}
+	keepPane := o.isEventTime && o.window.Trigger != nil && o.window.Trigger.AllowedLateness > 0
+	var (
+		count     int
+		paneInput []*xsql.Tuple
+	)
 	//Sync table
-	for _, tuple := range inputs {
+	for idx, tuple := range inputs {
 		if o.window.Type == ast.HOPPING_WINDOW || o.window.Type == ast.SLIDING_WINDOW {
 			diff := triggerTime - tuple.Timestamp
 			if diff > int64(o.window.Length)+delta {
@@ -416,11 +521,20 @@ func (o *WindowOperator) scan(inputs []*xsql.Tuple, triggerTime int64, ctx api.S
 			i++
 		}
 		if tuple.Timestamp <= triggerTime {
-			results = results.AddTuple(tuple)
+			count++
+			if idx >= discarded {
+				results = results.AddTuple(tuple)
+			}
+			if keepPane && o.window.Trigger.Mode == ast.EMIT_ACCUMULATE {
+				paneInput = append(paneInput, tuple)
+			}
 		}
 	}
+	// The positions of inputs are changed, so the early firing state must restart
+	o.emitIndex = 0
+	o.emitCount = 0
 	triggered := false
-	if len(results.Content) > 0 {
+	if count > 0 {
 		switch o.window.Type {
 		case ast.TUMBLING_WINDOW, ast.SESSION_WINDOW:
 			results.WindowStart = o.triggerTime
@@ -429,22 +543,114 @@ func (o *WindowOperator) scan(inputs []*xsql.Tuple, triggerTime int64, ctx api.S
 		case ast.SLIDING_WINDOW:
 			results.WindowStart = triggerTime - int64(o.window.Length)
 		}
-		log.Debugf("window %s triggered for %d tuples", o.name, len(inputs))
-		if o.isEventTime {
-			results.Sort()
+		if keepPane {
+			o.panes = append(o.panes, &windowPane{Start: triggerTime - int64(o.window.Length), End: triggerTime, Tuples: paneInput})
+			ctx.PutState(LATE_PANES_KEY, o.panes)
+		}
+		if len(results.Content) > 0 {
+			log.Debugf("window %s triggered for %d tuples", o.name, len(inputs))
+			if o.isEventTime {
+				results.Sort()
+			}
+			log.Debugf("Sent: %v", results)
+			//blocking if one of the channel is full
+			o.Broadcast(results)
+			o.statManager.IncTotalRecordsOut()
 		}
-		log.Debugf("Sent: %v", results)
-		//blocking if one of the channel is full
-		o.Broadcast(results)
 		triggered = true
 		o.triggerTime = triggerTime
-		o.statManager.IncTotalRecordsOut()
 		log.Debugf("done scan")
 	}
 
 	return inputs[:i], triggered
 }
 
+// emitEarly fires the speculative result of the window which will end at windowEnd
+func (o *WindowOperator) emitEarly(inputs []*xsql.Tuple, windowEnd int64, ctx api.StreamContext) {
+	windowStart := windowEnd - int64(o.window.Length)
+	results := xsql.WindowTuplesSet{
+		Content: make([]xsql.WindowTuples, 0),
+		WindowRange: &xsql.WindowRange{
+			WindowStart: windowStart,
+			WindowEnd:   windowEnd,
+			Trigger:     xsql.EarlyTrigger,
+		},
+	}
+	from := 0
+	if o.window.Trigger.Mode == ast.EMIT_DISCARD && o.emitIndex <= len(inputs) {
+		from = o.emitIndex
+	}
+	for _, tuple := range inputs[from:] {
+		if tuple.Timestamp > windowStart && tuple.Timestamp <= windowEnd {
+			results = results.AddTuple(tuple)
+		}
+	}
+	o.emitIndex = len(inputs)
+	o.emitCount = 0
+	if len(results.Content) > 0 {
+		if o.isEventTime {
+			results.Sort()
+		}
+		ctx.GetLogger().Debugf("Sent early: %v", results)
+		o.Broadcast(results)
+		o.statManager.IncTotalRecordsOut()
+	}
+}
+
+// fireLate fires the updated results of the kept windows which the late tuple belongs to. Return false if no window is found.
+func (o *WindowOperator) fireLate(tuple *xsql.Tuple, ctx api.StreamContext) bool {
+	found := false
+	for _, p := range o.panes {
+		if tuple.Timestamp <= p.Start || tuple.Timestamp > p.End {
+			continue
+		}
+		found = true
+		results := xsql.WindowTuplesSet{
+			Content: make([]xsql.WindowTuples, 0),
+			WindowRange: &xsql.WindowRange{
+				WindowStart: p.Start,
+				WindowEnd:   p.End,
+				Trigger:     xsql.LateTrigger,
+			},
+		}
+		if o.window.Trigger.Mode == ast.EMIT_DISCARD {
+			results = results.AddTuple(tuple)
+		} else {
+			p.Tuples = append(p.Tuples, tuple)
+			for _, t := range p.Tuples {
+				results = results.AddTuple(t)
+			}
+			results.Sort()
+		}
+		ctx.GetLogger().Debugf("Sent late: %v", results)
+		o.Broadcast(results)
+		o.statManager.IncTotalRecordsOut()
+	}
+	if found {
+		ctx.PutState(LATE_PANES_KEY, o.panes)
+	}
+	return found
+}
+
+// evictPanes drops the kept windows whose allowed lateness has passed
+func (o *WindowOperator) evictPanes(watermark int64, ctx api.StreamContext) {
+	if len(o.panes) == 0 {
+		return
+	}
+	lateness := int64(o.window.Trigger.AllowedLateness)
+	i := 0
+	for _, p := range o.panes {
+		if p.End+lateness >= watermark {
+			o.panes[i] = p
+			i++
+		}
+	}
+	if i < len(o.panes) {
+		o.panes = o.panes[:i]
+		ctx.PutState(LATE_PANES_KEY, o.panes)
+	}
+}
+
 func (o *WindowOperator) calDelta(triggerTime int64, delta int64, log api.Logger) int64 {
 	lastTriggerTime := o.triggerTime
 	if lastTriggerTime <= 0 {
@@ -16,7 +16,12 @@ package node
 
 import (
 	"fmt"
+	"github.com/lf-edge/ekuiper/internal/conf"
+	"github.com/lf-edge/ekuiper/internal/topo/context"
+	"github.com/lf-edge/ekuiper/internal/topo/state"
 	"github.com/lf-edge/ekuiper/internal/xsql"
+	"github.com/lf-edge/ekuiper/pkg/api"
+	"github.com/lf-edge/ekuiper/pkg/ast"
 	"reflect"
 	"testing"
 )
@@ -280,3 +285,92 @@ func TestCountWindow(t *testing.T) {
 		}
 	}
 }
+
+func TestWindowKey(t *testing.T) {
+	o := &WindowOperator{window: &WindowConfig{Keys: ast.Dimensions{
+		{Expr: &ast.FieldRef{Name: "a", StreamName: ast.DefaultStream}},
+		{Expr: &ast.FieldRef{Name: "b", StreamName: ast.DefaultStream}},
+	}}}
+	var keys []string
+	for _, m := range []map[string]interface{}{{"a": "x,", "b": "y"}, {"a": "x", "b": ",y"}, {"a": "x", "b": "y"}} {
+		key, err := o.windowKey(&xsql.Tuple{Emitter: "test", Message: m}, &xsql.FunctionValuer{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		keys = append(keys, key)
+	}
+	if keys[0] == keys[1] || keys[1] == keys[2] || keys[0] == keys[2] {
+		t.Errorf("the keys collide: %q", keys)
+	}
+}
+
+func TestCopyKeyedWindows(t *testing.T) {
+	windows := map[string]*keyedWindow{"a": {Inputs: []*xsql.Tuple{fivet[0]}, MsgCount: 1}}
+	s := copyKeyedWindows(windows)
+	windows["a"].Inputs = append(windows["a"].Inputs[:0], fivet[1])
+	windows["a"].MsgCount = 2
+	windows["b"] = &keyedWindow{}
+	exp := map[string]*keyedWindow{"a": {Inputs: []*xsql.Tuple{fivet[0]}, MsgCount: 1}}
+	if !reflect.DeepEqual(exp, s) {
+		t.Errorf("the state is changed by the windows:\n  exp=%v\n  got=%v", exp, s)
+	}
+}
+
+func TestNextSessionEnd(t *testing.T) {
+	o := &WindowOperator{window: &WindowConfig{Type: ast.SESSION_WINDOW, Length: 1000, Interval: 300}}
+	var tests = []struct {
+		ts  []int64
+		end int64
+	}{
+		{ts: []int64{1000, 1200, 1400}, end: 1700},
+		{ts: []int64{1000, 1200, 1800}, end: 1500},
+		// The unaligned start is cut at the next aligned boundary
+		{ts: []int64{1001, 1250, 1500, 1750, 1999, 2200}, end: 2000},
+		{ts: []int64{1000, 1250, 1500, 1750, 1999, 2200}, end: 2000},
+	}
+	for i, tt := range tests {
+		var inputs []*xsql.Tuple
+		for _, ts := range tt.ts {
+			inputs = append(inputs, &xsql.Tuple{Timestamp: ts})
+		}
+		if end := o.nextSessionEnd(inputs); end != tt.end {
+			t.Errorf("%d: expect end %d but got %d", i, tt.end, end)
+		}
+	}
+}
+
+func TestIncAggregator(t *testing.T) {
+	store, _ := state.CreateStore("TestIncAggregator", api.AtMostOnce)
+	ctx := context.WithValue(context.Background(), context.LoggerKey, conf.Log).WithMeta("TestIncAggregator", "op", store)
+	a := newIncAggregator(&WindowConfig{
+		Type: ast.TUMBLING_WINDOW,
+		Aggregates: []*IncAggregate{
+			{Name: "c", Call: &ast.Call{Name: "count", Args: []ast.Expr{&ast.Wildcard{Token: ast.ASTERISK}}}},
+		},
+		Groups: ast.Dimensions{
+			{Expr: &ast.FieldRef{Name: "a", StreamName: ast.DefaultStream}},
+			{Expr: &ast.FieldRef{Name: "b", StreamName: ast.DefaultStream}},
+		},
+	})
+	if err := a.open(ctx); err != nil {
+		t.Fatal(err)
+	}
+	// The values with the separator must not be merged into the same group
+	for _, m := range []map[string]interface{}{{"a": "x,", "b": "y"}, {"a": "x", "b": ",y"}} {
+		if err := a.accumulate(&xsql.Tuple{Emitter: "test", Message: m}, ctx); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(a.panes[0].Groups) != 2 {
+		t.Errorf("expect 2 groups but got %d", len(a.panes[0].Groups))
+	}
+	// The state is not changed by the following events
+	s, _ := ctx.GetState(INC_PANES_KEY)
+	if err := a.accumulate(&xsql.Tuple{Emitter: "test", Message: map[string]interface{}{"a": "x,", "b": "y"}}, ctx); err != nil {
+		t.Fatal(err)
+	}
+	groups := s.([]*aggPane)[0].Groups
+	if len(groups) != 2 || fmt.Sprintf("%v", groups[0].Accs[0]) != "&{<nil> 1}" {
+		t.Errorf("the state is changed by the following event: %v", groups[0].Accs[0])
+	}
+}
@@ -123,6 +123,14 @@ var tests = []struct {
 		sql: `SELECT sum(next->nid) as nid FROM src1 WHERE next->nid > 20 `,
 		r:   newErrorStruct(""),
 	},
+	{ // 15
+		sql: `SELECT count(*) FROM src1 GROUP BY SESSIONWINDOW(ss, 10, 2) PER KEY`,
+		r:   newErrorStruct("window partitioned by key requires group by keys"),
+	},
+	{ // 16
+		sql: `SELECT count(*) FROM src1 GROUP BY name, SESSIONWINDOW(ss, 10, 2) PER KEY`,
+		r:   newErrorStruct(""),
+	},
 }
 
 func Test_validation(t *testing.T) {
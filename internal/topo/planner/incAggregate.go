@@ -0,0 +1,122 @@
+// Copyright 2021 EMQ Technologies Co., Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package planner
+
+import (
+	"fmt"
+	"github.com/lf-edge/ekuiper/internal/binder/function"
+	"github.com/lf-edge/ekuiper/internal/topo/node"
+	"github.com/lf-edge/ekuiper/internal/xsql"
+	"github.com/lf-edge/ekuiper/pkg/api"
+	"github.com/lf-edge/ekuiper/pkg/ast"
+)
+
+// extractIncAggregates detects if the aggregates of the statement can be calculated incrementally by the window.
+// If so, the aggregate calls in the select fields, having and order by are rewritten to the alias refs of the
+// incremental results and the extracted aggregates are returned. Otherwise, return nil and the statement is unchanged.
+// Only the processing time tumbling and hopping window without join and emit trigger is supported.
+func extractIncAggregates(stmt *ast.SelectStatement, w *ast.Window, opt *api.RuleOption) []*node.IncAggregate {
+	if opt.IsEventTime || w.Trigger != nil || w.PerKey || len(stmt.Joins) > 0 {
+		return nil
+	}
+	switch w.WindowType {
+	case ast.TUMBLING_WINDOW:
+	case ast.HOPPING_WINDOW:
+		if w.Interval == nil || w.Interval.Val <= 0 || w.Length.Val%w.Interval.Val != 0 {
+			return nil
+		}
+	default:
+		return nil
+	}
+	count := 0
+	incremental := true
+	walkAggCalls(stmt, func(c *ast.Call) ast.Expr {
+		count++
+		if !function.IsIncAggFunc(c.Name) {
+			incremental = false
+		}
+		for _, arg := range c.Args {
+			if xsql.HasAggFuncs(arg) {
+				incremental = false
+			}
+		}
+		return c
+	})
+	if count == 0 || !incremental {
+		return nil
+	}
+	var aggs []*node.IncAggregate
+	walkAggCalls(stmt, func(c *ast.Call) ast.Expr {
+		agg := &node.IncAggregate{Name: fmt.Sprintf("$$inc_%d", len(aggs)), Call: c}
+		aggs = append(aggs, agg)
+		fr := &ast.FieldRef{StreamName: ast.AliasStream, Name: agg.Name, AliasRef: &ast.AliasRef{Expression: c}}
+		sources, _ := getRefSources(c)
+		fr.SetRefSource(sources)
+		return fr
+	})
+	return aggs
+}
+
+// walkAggCalls replaces the aggregate calls in the select fields, having and order by with the result of fn
+func walkAggCalls(stmt *ast.SelectStatement, fn func(*ast.Call) ast.Expr) {
+	visited := make(map[*ast.AliasRef]bool)
+	for i := range stmt.Fields {
+		stmt.Fields[i].Expr = replaceAggCalls(stmt.Fields[i].Expr, fn, visited)
+	}
+	stmt.Having = replaceAggCalls(stmt.Having, fn, visited)
+	for i := range stmt.SortFields {
+		stmt.SortFields[i].Expr = replaceAggCalls(stmt.SortFields[i].Expr, fn, visited)
+	}
+}
+
+func replaceAggCalls(expr ast.Expr, fn func(*ast.Call) ast.Expr, visited map[*ast.AliasRef]bool) ast.Expr {
+	switch e := expr.(type) {
+	case *ast.Call:
+		if function.IsAggFunc(e.Name) {
+			return fn(e)
+		}
+		for i, arg := range e.Args {
+			e.Args[i] = replaceAggCalls(arg, fn, visited)
+		}
+	case *ast.BinaryExpr:
+		e.LHS = replaceAggCalls(e.LHS, fn, visited)
+		e.RHS = replaceAggCalls(e.RHS, fn, visited)
+	case *ast.ParenExpr:
+		e.Expr = replaceAggCalls(e.Expr, fn, visited)
+	case *ast.ArrowExpr:
+		e.Expr = replaceAggCalls(e.Expr, fn, visited)
+	case *ast.BracketExpr:
+		e.Expr = replaceAggCalls(e.Expr, fn, visited)
+	case *ast.ColonExpr:
+		e.Start = replaceAggCalls(e.Start, fn, visited)
+		e.End = replaceAggCalls(e.End, fn, visited)
+	case *ast.IndexExpr:
+		e.Index = replaceAggCalls(e.Index, fn, visited)
+	case *ast.CaseExpr:
+		e.Value = replaceAggCalls(e.Value, fn, visited)
+		for _, w := range e.WhenClauses {
+			w.Expr = replaceAggCalls(w.Expr, fn, visited)
+			w.Result = replaceAggCalls(w.Result, fn, visited)
+		}
+		e.ElseClause = replaceAggCalls(e.ElseClause, fn, visited)
+	case *ast.FieldRef:
+		// The alias could be referred multiple times, only replace once
+		if e.IsAlias() && e.AliasRef != nil && !visited[e.AliasRef] {
+			visited[e.AliasRef] = true
+			e.Expression = replaceAggCalls(e.Expression, fn, visited)
+		}
+	}
+	return expr
+}
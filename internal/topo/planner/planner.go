@@ -159,9 +159,15 @@ func buildOps(lp LogicalPlan, tp *topo.Topo, options *api.RuleOption, sources []
 		}
 
 		op, err = node.NewWindowOp(fmt.Sprintf("%d_window", newIndex), node.WindowConfig{
-			Type:     t.wtype,
-			Length:   t.length,
-			Interval: t.interval,
+			Type:       t.wtype,
+			Length:     t.length,
+			Interval:   t.interval,
+			Trigger:    t.trigger,
+			Keys:       t.keys,
+			Begin:      t.begin,
+			End:        t.end,
+			Aggregates: t.aggregates,
+			Groups:     t.groups,
 		}, streamsFromStmt, options)
 		if err != nil {
 			return nil, 0, err
@@ -210,12 +216,15 @@ func createLogicalPlan(stmt *ast.SelectStatement, opt *api.RuleOption, store kv.
 		tableEmitters []string
 		w             *ast.Window
 		ds            ast.Dimensions
+		incremental   bool
 	)
 
 	streamStmts, err := decorateStmt(stmt, store)
 	if err != nil {
 		return nil, err
 	}
+	// Must calculate before the aggregate calls are extracted
+	isAggregate := xsql.IsAggStatement(stmt)
 
 	for _, streamStmt := range streamStmts {
 		p = DataSourcePlan{
@@ -251,8 +260,29 @@ func createLogicalPlan(stmt *ast.SelectStatement, opt *api.RuleOption, store kv.
 			if w.Filter != nil {
 				wp.condition = w.Filter
 			}
+			if w.Trigger != nil {
+				if w.Trigger.AllowedLateness > 0 && !opt.IsEventTime {
+					return nil, errors.New("allowed lateness of window can only be applied to event time")
+				}
+				wp.trigger = w.Trigger
+			}
+			if w.WindowType == ast.STATE_WINDOW {
+				wp.begin = w.BeginCondition
+				wp.end = w.EndCondition
+			}
+			if w.PerKey {
+				keys := dimensions.GetGroups()
+				if len(keys) == 0 {
+					return nil, errors.New("window partitioned by key requires group by keys")
+				}
+				wp.keys = keys
+			}
 			// TODO calculate limit
-			// TODO incremental aggregate
+			if aggs := extractIncAggregates(stmt, w, opt); aggs != nil {
+				wp.aggregates = aggs
+				wp.groups = dimensions.GetGroups()
+				incremental = true
+			}
 			wp.SetChildren(children)
 			children = []LogicalPlan{wp}
 			p = wp
@@ -284,7 +314,8 @@ func createLogicalPlan(stmt *ast.SelectStatement, opt *api.RuleOption, store kv.
 		children = []LogicalPlan{p}
 	}
 	// TODO handle aggregateAlias in optimization as it does not only happen in select fields
-	if dimensions != nil {
+	// The incremental window has grouped the inputs
+	if dimensions != nil && !incremental {
 		ds = dimensions.GetGroups()
 		if ds != nil && len(ds) > 0 {
 			p = AggregatePlan{
@@ -314,7 +345,7 @@ func createLogicalPlan(stmt *ast.SelectStatement, opt *api.RuleOption, store kv.
 	if stmt.Fields != nil {
 		p = ProjectPlan{
 			fields:      stmt.Fields,
-			isAggregate: xsql.IsAggStatement(stmt),
+			isAggregate: isAggregate,
 			sendMeta:    opt.SendMetaToSink,
 		}.Init()
 		p.SetChildren(children)
@@ -20,6 +20,7 @@ import (
 	"github.com/gdexlab/go-render/render"
 	"github.com/lf-edge/ekuiper/internal/pkg/store"
 	"github.com/lf-edge/ekuiper/internal/testx"
+	"github.com/lf-edge/ekuiper/internal/topo/node"
 	"github.com/lf-edge/ekuiper/internal/xsql"
 	"github.com/lf-edge/ekuiper/pkg/api"
 	"github.com/lf-edge/ekuiper/pkg/ast"
@@ -87,8 +88,14 @@ func Test_createLogicalPlan(t *testing.T) {
 	}
 
 	var (
-		//boolTrue = true
+		boolTrue  = true
 		boolFalse = false
+		incCall   = &ast.Call{Name: "count", Args: []ast.Expr{&ast.FieldRef{Name: "temp", StreamName: "src1"}}}
+		incAlias  = &ast.FieldRef{Name: "c", StreamName: ast.AliasStream, AliasRef: ast.MockAliasRef(
+			&ast.FieldRef{Name: "$$inc_0", StreamName: ast.AliasStream, AliasRef: ast.MockAliasRef(incCall, []ast.StreamName{"src1"}, nil)},
+			[]ast.StreamName{"src1"},
+			&boolTrue,
+		)}
 	)
 
 	var tests = []struct {
@@ -1107,6 +1114,70 @@ func Test_createLogicalPlan(t *testing.T) {
 				isAggregate: false,
 				sendMeta:    false,
 			}.Init(),
+		}, { // 12 incremental aggregate
+			sql: `SELECT name, count(temp) AS c FROM src1 GROUP BY name, TUMBLINGWINDOW(ss, 10) HAVING c > 2`,
+			p: ProjectPlan{
+				baseLogicalPlan: baseLogicalPlan{
+					children: []LogicalPlan{
+						HavingPlan{
+							baseLogicalPlan: baseLogicalPlan{
+								children: []LogicalPlan{
+									WindowPlan{
+										baseLogicalPlan: baseLogicalPlan{
+											children: []LogicalPlan{
+												DataSourcePlan{
+													name: "src1",
+													streamFields: []interface{}{
+														&ast.StreamField{
+															Name:      "name",
+															FieldType: &ast.BasicType{Type: ast.STRINGS},
+														},
+														&ast.StreamField{
+															Name:      "temp",
+															FieldType: &ast.BasicType{Type: ast.BIGINT},
+														},
+													},
+													streamStmt: streams["src1"],
+													metaFields: []string{},
+												}.Init(),
+											},
+										},
+										condition: nil,
+										wtype:     ast.TUMBLING_WINDOW,
+										length:    10000,
+										interval:  0,
+										limit:     0,
+										aggregates: []*node.IncAggregate{
+											{Name: "$$inc_0", Call: incCall},
+										},
+										groups: ast.Dimensions{
+											ast.Dimension{Expr: &ast.FieldRef{Name: "name", StreamName: "src1"}},
+										},
+									}.Init(),
+								},
+							},
+							condition: &ast.BinaryExpr{
+								OP:  ast.GT,
+								LHS: incAlias,
+								RHS: &ast.IntegerLiteral{Val: 2},
+							},
+						}.Init(),
+					},
+				},
+				fields: []ast.Field{
+					{
+						Expr:  &ast.FieldRef{Name: "name", StreamName: "src1"},
+						Name:  "name",
+						AName: "",
+					}, {
+						Expr:  incAlias,
+						Name:  "count",
+						AName: "c",
+					},
+				},
+				isAggregate: true,
+				sendMeta:    false,
+			}.Init(),
 		},
 	}
 	fmt.Printf("The test bucket size is %d.\n\n", len(tests))
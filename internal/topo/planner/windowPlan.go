@@ -14,7 +14,10 @@
 
 package planner
 
-import "github.com/lf-edge/ekuiper/pkg/ast"
+import (
+	"github.com/lf-edge/ekuiper/internal/topo/node"
+	"github.com/lf-edge/ekuiper/pkg/ast"
+)
 
 type WindowPlan struct {
 	baseLogicalPlan
@@ -23,6 +26,12 @@ type WindowPlan struct {
 	length      int
 	interval    int //If interval is not set, it is equals to Length
 	limit       int //If limit is not positive, there will be no limit
+	trigger     *ast.WindowTrigger
+	keys        ast.Dimensions       //If keys are set, the window is partitioned by the keys
+	begin       ast.Expr             //The begin condition of state window
+	end         ast.Expr             //The end condition of state window
+	aggregates  []*node.IncAggregate //If set, the aggregates are calculated incrementally by the window
+	groups      ast.Dimensions       //The group by dimensions of the incremental aggregates
 	isEventTime bool
 }
 
@@ -32,7 +41,7 @@ func (p WindowPlan) Init() *WindowPlan {
 }
 
 func (p *WindowPlan) PushDownPredicate(condition ast.Expr) (ast.Expr, LogicalPlan) {
-	if p.wtype == ast.COUNT_WINDOW {
+	if p.wtype == ast.COUNT_WINDOW || p.wtype == ast.STATE_WINDOW {
 		return condition, p
 	} else if p.isEventTime {
 		// TODO event time filter, need event window op support
@@ -52,5 +61,16 @@ func (p *WindowPlan) PushDownPredicate(condition ast.Expr) (ast.Expr, LogicalPla
 
 func (p *WindowPlan) PruneColumns(fields []ast.Expr) error {
 	f := getFields(p.condition)
+	f = append(f, getFields(p.begin)...)
+	f = append(f, getFields(p.end)...)
+	for _, d := range p.keys {
+		f = append(f, getFields(d.Expr)...)
+	}
+	for _, d := range p.groups {
+		f = append(f, getFields(d.Expr)...)
+	}
+	for _, agg := range p.aggregates {
+		f = append(f, getFields(agg.Call)...)
+	}
 	return p.baseLogicalPlan.PruneColumns(append(fields, f...))
 }
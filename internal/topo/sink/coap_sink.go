@@ -0,0 +1,165 @@
+// Copyright 2021 EMQ Technologies Co., Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sink
+
+import (
+	"errors"
+	"fmt"
+	"github.com/lf-edge/ekuiper/internal/pkg/coapx"
+	"github.com/lf-edge/ekuiper/pkg/api"
+	"github.com/lf-edge/ekuiper/pkg/cast"
+	"sync"
+	"time"
+)
+
+type CoapSinkConfig struct {
+	coapx.DTLSConf
+	Url           string `json:"url"`
+	Method        string `json:"method"`
+	Confirmable   bool   `json:"confirmable"`
+	ContentFormat int    `json:"contentFormat"`
+	Timeout       int    `json:"timeout"`
+}
+
+// CoapSink sends the results to a CoAP resource. The connection is created on the first result and recreated after
+// a failure.
+type CoapSink struct {
+	conf   *CoapSinkConfig
+	addr   string
+	path   string
+	query  []string
+	method coapx.Code
+
+	mu     sync.Mutex
+	client *coapx.Client
+}
+
+func (cs *CoapSink) Configure(props map[string]interface{}) error {
+	c := &CoapSinkConfig{Method: "POST", Confirmable: true, ContentFormat: coapx.AppJSON, Timeout: coapx.DEFAULT_TIMEOUT}
+	err := cast.MapToStruct(props, c)
+	if err != nil {
+		return fmt.Errorf("read properties %v fail with error: %v", props, err)
+	}
+	if c.Url == "" {
+		return errors.New("property url is required")
+	}
+	addr, path, query, secure, err := coapx.ParseUrl(c.Url)
+	if err != nil {
+		return err
+	}
+	if err := c.DTLSConf.Validate(); err != nil {
+		return err
+	}
+	if secure != c.DTLSConf.Enabled() {
+		return errors.New("property psk is required for coaps url only")
+	}
+	cs.method, err = coapx.ParseMethod(c.Method)
+	if err != nil {
+		return err
+	}
+	if c.ContentFormat < 0 || c.ContentFormat > 65535 {
+		return fmt.Errorf("invalid property contentFormat: %d, must be between 0 and 65535", c.ContentFormat)
+	}
+	if c.Timeout <= 0 {
+		return fmt.Errorf("invalid property timeout: %d, must be a positive integer", c.Timeout)
+	}
+	cs.addr, cs.path, cs.query = addr, path, query
+	cs.conf = c
+	return nil
+}
+
+func (cs *CoapSink) Open(ctx api.StreamContext) error {
+	ctx.GetLogger().Infof("Opening coap sink for rule %s to %s.", ctx.GetRuleId(), cs.conf.Url)
+	return nil
+}
+
+func (cs *CoapSink) Collect(ctx api.StreamContext, item interface{}) error {
+	logger := ctx.GetLogger()
+	v, ok := item.([]byte)
+	if !ok {
+		logger.Warnf("coap sink receive non []byte data: %v", item)
+		return nil
+	}
+	logger.Debugf("coap sink receive %s", item)
+	cli, err := cs.connect()
+	if err != nil {
+		return err
+	}
+	req := &coapx.Message{Type: coapx.NonConfirmable, Code: cs.method, Payload: v}
+	if cs.conf.Confirmable {
+		req.Type = coapx.Confirmable
+	}
+	req.SetPath(cs.path)
+	for _, q := range cs.query {
+		req.AddQuery(q)
+	}
+	req.SetUintOption(coapx.ContentFormat, uint32(cs.conf.ContentFormat))
+	if !cs.conf.Confirmable {
+		if err := cli.Send(req); err != nil {
+			cs.reset(cli)
+			return fmt.Errorf("coap sink fails to send out the data: %v", err)
+		}
+		return nil
+	}
+	resp, err := cli.Do(req)
+	if err != nil {
+		cs.reset(cli)
+		return fmt.Errorf("coap sink fails to send out the data: %v", err)
+	}
+	if !resp.Code.IsSuccess() {
+		return fmt.Errorf("coap sink gets response code %s: %s", resp.Code, resp.Payload)
+	}
+	return nil
+}
+
+func (cs *CoapSink) connect() (*coapx.Client, error) {
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+	if cs.client != nil {
+		select {
+		case <-cs.client.Done():
+			cs.client = nil
+		default:
+			return cs.client, nil
+		}
+	}
+	cli, err := coapx.Dial(cs.addr, &cs.conf.DTLSConf, time.Duration(cs.conf.Timeout)*time.Millisecond)
+	if err != nil {
+		return nil, err
+	}
+	cs.client = cli
+	return cli, nil
+}
+
+// reset closes the broken client so that the next result reconnects
+func (cs *CoapSink) reset(cli *coapx.Client) {
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+	_ = cli.Close()
+	if cs.client == cli {
+		cs.client = nil
+	}
+}
+
+func (cs *CoapSink) Close(ctx api.StreamContext) error {
+	ctx.GetLogger().Infof("Closing coap sink")
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+	if cs.client != nil {
+		_ = cs.client.Close()
+		cs.client = nil
+	}
+	return nil
+}
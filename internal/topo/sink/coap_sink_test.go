@@ -0,0 +1,116 @@
+// Copyright 2021 EMQ Technologies Co., Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sink
+
+import (
+	"github.com/lf-edge/ekuiper/internal/conf"
+	"github.com/lf-edge/ekuiper/internal/pkg/coapx"
+	"github.com/lf-edge/ekuiper/internal/testx"
+	"github.com/lf-edge/ekuiper/internal/topo/context"
+	"net"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestCoapSinkConfigure(t *testing.T) {
+	var tests = []struct {
+		props map[string]interface{}
+		conf  *CoapSinkConfig
+		err   string
+	}{
+		{
+			props: map[string]interface{}{"url": "coap://127.0.0.1/data"},
+			conf:  &CoapSinkConfig{Url: "coap://127.0.0.1/data", Method: "POST", Confirmable: true, ContentFormat: 50, Timeout: 10000},
+		}, {
+			props: map[string]interface{}{"url": "coaps://127.0.0.1/data", "psk": "secret", "method": "put", "confirmable": false, "contentFormat": 60},
+			conf:  &CoapSinkConfig{DTLSConf: coapx.DTLSConf{Psk: "secret"}, Url: "coaps://127.0.0.1/data", Method: "put", ContentFormat: 60, Timeout: 10000},
+		}, {
+			props: map[string]interface{}{},
+			err:   "property url is required",
+		}, {
+			props: map[string]interface{}{"url": "coap://127.0.0.1/data", "psk": "secret"},
+			err:   "property psk is required for coaps url only",
+		}, {
+			props: map[string]interface{}{"url": "coap://127.0.0.1/data", "method": "PATCH"},
+			err:   "invalid method PATCH, must be GET, POST, PUT or DELETE",
+		}, {
+			props: map[string]interface{}{"url": "coap://127.0.0.1/data", "contentFormat": -1},
+			err:   "invalid property contentFormat: -1, must be between 0 and 65535",
+		},
+	}
+	for i, tt := range tests {
+		s := &CoapSink{}
+		err := s.Configure(tt.props)
+		if !reflect.DeepEqual(tt.err, testx.Errstring(err)) {
+			t.Errorf("%d: error mismatch:\n  exp=%s\n  got=%s", i, tt.err, err)
+		} else if err == nil && !reflect.DeepEqual(tt.conf, s.conf) {
+			t.Errorf("%d: conf mismatch:\n  exp=%v\n  got=%v", i, tt.conf, s.conf)
+		}
+	}
+}
+
+func TestCoapSinkCollect(t *testing.T) {
+	for _, dc := range []coapx.DTLSConf{{}, {PskIdentity: "ekuiper", Psk: "secret"}} {
+		srv, err := coapx.AcquireServer("127.0.0.1:0", &dc)
+		if err != nil {
+			t.Fatal(err)
+		}
+		received := make(chan *coapx.Message, 10)
+		_ = srv.Handle("/data", func(req *coapx.Message, _ net.Addr) (coapx.Code, []byte) {
+			received <- req
+			if string(req.Payload) == "reject" {
+				return coapx.BadRequest, []byte("invalid data")
+			}
+			return coapx.Changed, nil
+		})
+		scheme := "coap"
+		if dc.Enabled() {
+			scheme = "coaps"
+		}
+		for _, confirmable := range []bool{true, false} {
+			s := &CoapSink{}
+			err := s.Configure(map[string]interface{}{"url": scheme + "://" + srv.Addr().String() + "/data?id=1", "confirmable": confirmable, "pskIdentity": dc.PskIdentity, "psk": dc.Psk})
+			if err != nil {
+				t.Fatal(err)
+			}
+			ctx := context.WithValue(context.Background(), context.LoggerKey, conf.Log)
+			if err := s.Open(ctx); err != nil {
+				t.Fatal(err)
+			}
+			if err := s.Collect(ctx, []byte(`{"temperature":20}`)); err != nil {
+				t.Errorf("%s %v: %v", scheme, confirmable, err)
+			}
+			select {
+			case req := <-received:
+				cf, _ := req.UintOption(coapx.ContentFormat)
+				if req.Code != coapx.POST || string(req.Payload) != `{"temperature":20}` || cf != coapx.AppJSON || !reflect.DeepEqual(req.Queries(), []string{"id=1"}) {
+					t.Errorf("%s %v: request mismatch, got %v", scheme, confirmable, req)
+				}
+			case <-time.After(2 * time.Second):
+				t.Errorf("%s %v: request is not received", scheme, confirmable)
+			}
+			err = s.Collect(ctx, []byte("reject"))
+			<-received
+			if confirmable && testx.Errstring(err) != "coap sink gets response code 4.00: invalid data" {
+				t.Errorf("%s %v: error mismatch, got %v", scheme, confirmable, err)
+			} else if !confirmable && err != nil {
+				t.Errorf("%s %v: unexpected error %v", scheme, confirmable, err)
+			}
+			_ = s.Close(ctx)
+		}
+		coapx.ReleaseServer(srv)
+	}
+}
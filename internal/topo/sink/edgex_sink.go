@@ -26,10 +26,12 @@ import (
 	"github.com/edgexfoundry/go-mod-core-contracts/v2/dtos/requests"
 	"github.com/edgexfoundry/go-mod-messaging/v2/messaging"
 	"github.com/edgexfoundry/go-mod-messaging/v2/pkg/types"
+	"github.com/fxamacker/cbor/v2"
 	"github.com/lf-edge/ekuiper/internal/conf"
 	"github.com/lf-edge/ekuiper/pkg/api"
 	"github.com/lf-edge/ekuiper/pkg/cast"
 	"reflect"
+	"strings"
 )
 
 type messageType string
@@ -70,7 +72,7 @@ func (ems *EdgexMsgBusSink) Configure(ps map[string]interface{}) error {
 		Port:        6379,
 		Type:        messaging.Redis,
 		MessageType: MessageTypeEvent,
-		ContentType: "application/json",
+		ContentType: v2.ContentTypeJSON,
 		DeviceName:  "ekuiper",
 		ProfileName: "ekuiperProfile",
 		// SourceName is set in open as the rule id
@@ -92,8 +94,9 @@ func (ems *EdgexMsgBusSink) Configure(ps map[string]interface{}) error {
 		return fmt.Errorf("specified wrong messageType value %s", c.MessageType)
 	}
 
-	if c.MessageType == MessageTypeEvent && c.ContentType != "application/json" {
-		return fmt.Errorf("specified wrong contentType value %s: only 'application/json' is supported if messageType is event", c.ContentType)
+	c.ContentType = strings.ToLower(c.ContentType)
+	if c.ContentType != v2.ContentTypeJSON && c.ContentType != v2.ContentTypeCBOR {
+		return fmt.Errorf("specified wrong contentType value %s: only 'application/json' and 'application/cbor' are supported", c.ContentType)
 	}
 
 	if c.Topic != "" && c.TopicPrefix != "" {
@@ -392,21 +395,10 @@ func (ems *EdgexMsgBusSink) Collect(ctx api.StreamContext, item interface{}) err
 		if err != nil {
 			return fmt.Errorf("Failed to convert to EdgeX event: %s.", err.Error())
 		}
-		var (
-			data  []byte
-			topic string
-		)
-		if ems.c.MessageType == MessageTypeRequest {
-			req := requests.NewAddEventRequest(*evt)
-			data, _, err = req.Encode()
-			if err != nil {
-				return fmt.Errorf("unexpected error encode event %v", err)
-			}
-		} else {
-			data, err = json.Marshal(evt)
-			if err != nil {
-				return fmt.Errorf("unexpected error MarshalEvent %v", err)
-			}
+		var topic string
+		data, err := ems.encode(evt)
+		if err != nil {
+			return fmt.Errorf("unexpected error encode event %v", err)
 		}
 		env := types.NewMessageEnvelope(data, ctx)
 		env.ContentType = ems.c.ContentType
@@ -428,6 +420,20 @@ func (ems *EdgexMsgBusSink) Collect(ctx api.StreamContext, item interface{}) err
 	return nil
 }
 
+// encode encodes the event or the add event request by the content type. The binary readings are byte strings in
+// CBOR while they are base64 encoded in JSON.
+func (ems *EdgexMsgBusSink) encode(evt *dtos.Event) ([]byte, error) {
+	var v interface{} = evt
+	if ems.c.MessageType == MessageTypeRequest {
+		req := requests.NewAddEventRequest(*evt)
+		v = &req
+	}
+	if ems.c.ContentType == v2.ContentTypeCBOR {
+		return cbor.Marshal(v)
+	}
+	return json.Marshal(v)
+}
+
 func (ems *EdgexMsgBusSink) Close(ctx api.StreamContext) error {
 	logger := ctx.GetLogger()
 	logger.Infof("Closing edgex sink")
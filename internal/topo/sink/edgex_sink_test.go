@@ -17,10 +17,13 @@
 package sink
 
 import (
+	"encoding/json"
 	"fmt"
 	v2 "github.com/edgexfoundry/go-mod-core-contracts/v2/common"
 	"github.com/edgexfoundry/go-mod-core-contracts/v2/dtos"
+	"github.com/edgexfoundry/go-mod-core-contracts/v2/dtos/requests"
 	"github.com/edgexfoundry/go-mod-messaging/v2/messaging"
+	"github.com/fxamacker/cbor/v2"
 	"github.com/lf-edge/ekuiper/internal/conf"
 	"github.com/lf-edge/ekuiper/internal/testx"
 	"github.com/lf-edge/ekuiper/internal/topo/context"
@@ -204,6 +207,34 @@ func TestConfigure(t *testing.T) {
 				"contentType": "application/json",
 			},
 			error: "not allow to specify both topic and topicPrefix, please set one only",
+		}, { // 9
+			conf: map[string]interface{}{
+				"protocol":    "redis",
+				"host":        "edgex-redis",
+				"port":        6379,
+				"topic":       "events",
+				"contentType": "Application/CBOR",
+			},
+			expected: &EdgexConf{
+				Protocol:    "redis",
+				Host:        "edgex-redis",
+				Port:        6379,
+				Type:        messaging.Redis,
+				MessageType: MessageTypeEvent,
+				ContentType: "application/cbor",
+				DeviceName:  "ekuiper",
+				ProfileName: "ekuiperProfile",
+				Topic:       "events",
+			},
+		}, { // 10
+			conf: map[string]interface{}{
+				"protocol":    "redis",
+				"host":        "edgex-redis",
+				"port":        6379,
+				"topic":       "events",
+				"contentType": "application/xml",
+			},
+			error: "specified wrong contentType value application/xml: only 'application/json' and 'application/cbor' are supported",
 		},
 	}
 	fmt.Printf("The test bucket size is %d.\n\n", len(tests))
@@ -468,3 +499,42 @@ func TestProduceEvents(t1 *testing.T) {
 		}
 	}
 }
+
+func TestEncode(t *testing.T) {
+	input := `[{"meta":{"bin":{"valueType":"Binary","mediaType":"image/png"}},"bin":"aGVsbG8=","temperature":21.5}]`
+	for i, contentType := range []string{v2.ContentTypeJSON, v2.ContentTypeCBOR} {
+		for _, messageType := range []string{"event", "request"} {
+			ems := EdgexMsgBusSink{}
+			if err := ems.Configure(map[string]interface{}{"metadata": "meta", "contentType": contentType, "messageType": messageType}); err != nil {
+				t.Fatal(err)
+			}
+			ems.c.SourceName = "ruleTest"
+			evt, err := ems.produceEvents(ctx, []byte(input))
+			if err != nil {
+				t.Fatal(err)
+			}
+			data, err := ems.encode(evt)
+			if err != nil {
+				t.Errorf("%d %s: encode error %v", i, messageType, err)
+				continue
+			}
+			unmarshal := json.Unmarshal
+			if contentType == v2.ContentTypeCBOR {
+				unmarshal = cbor.Unmarshal
+			}
+			var result dtos.Event
+			if messageType == "request" {
+				req := requests.AddEventRequest{}
+				err = req.Unmarshal(data, unmarshal)
+				result = req.Event
+			} else {
+				err = unmarshal(data, &result)
+			}
+			if err != nil {
+				t.Errorf("%d %s: decode error %v", i, messageType, err)
+			} else if !reflect.DeepEqual(*evt, result) {
+				t.Errorf("%d %s: result mismatch:\n  exp=%#v\n  got=%#v", i, messageType, evt, result)
+			}
+		}
+	}
+}
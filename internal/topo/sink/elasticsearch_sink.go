@@ -0,0 +1,465 @@
+// Copyright 2021 EMQ Technologies Co., Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sink
+
+import (
+	"bytes"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/lf-edge/ekuiper/internal/conf"
+	ct "github.com/lf-edge/ekuiper/internal/template"
+	"github.com/lf-edge/ekuiper/pkg/api"
+	"github.com/lf-edge/ekuiper/pkg/cast"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+)
+
+type ElasticsearchSinkConfig struct {
+	Addr               string `json:"addr"`
+	Index              string `json:"index"`
+	IdField            string `json:"idField"`
+	Action             string `json:"action"`
+	Pipeline           string `json:"pipeline"`
+	TsFieldName        string `json:"tsFieldName"`
+	Username           string `json:"username"`
+	Password           string `json:"password"`
+	ApiKey             string `json:"apiKey"`
+	BatchSize          int    `json:"batchSize"`
+	BatchBytes         int    `json:"batchBytes"`
+	Linger             int    `json:"lingerInterval"`
+	Timeout            int    `json:"timeout"`
+	InsecureSkipVerify bool   `json:"insecureSkipVerify"`
+}
+
+// esDoc is a buffered document with its action line of the bulk request
+type esDoc struct {
+	index  string
+	id     string
+	action []byte
+	source []byte
+}
+
+// esRejected is a buffered document rejected by the bulk api
+type esRejected struct {
+	doc  *esDoc
+	item BulkItemError
+}
+
+// BulkItemError is a document rejected by the bulk api
+type BulkItemError struct {
+	Index  string
+	Id     string
+	Status int
+	Type   string
+	Reason string
+	Doc    string
+}
+
+// BulkError is returned when some documents of a bulk request are rejected. The other documents are written.
+type BulkError struct {
+	Total int
+	Items []BulkItemError
+}
+
+func (e *BulkError) Error() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%d of %d documents are rejected:", len(e.Items), e.Total)
+	for _, it := range e.Items {
+		fmt.Fprintf(&b, " [index %s id %s status %d %s: %s] %s;", it.Index, it.Id, it.Status, it.Type, it.Reason, it.Doc)
+	}
+	return b.String()
+}
+
+// ElasticsearchSink writes the results to Elasticsearch or OpenSearch by the bulk api. Each result row is a
+// document. The index name can be a template with the fields and the date. The documents are buffered and written
+// in one request when the count or the bytes of the batch is reached or the linger interval is passed.
+type ElasticsearchSink struct {
+	sync.Mutex
+	conf    *ElasticsearchSinkConfig
+	bulkUrl string
+	tp      *template.Template
+	// The record and the time to format by the date function of the index template
+	rec    map[string]interface{}
+	now    time.Time
+	client *http.Client
+	buffer []*esDoc
+	size   int
+	// The documents rejected by the bulk requests of the linger or the previous results which are not reported yet
+	rejected *BulkError
+	cancel   func()
+}
+
+func (m *ElasticsearchSink) Configure(props map[string]interface{}) error {
+	c := &ElasticsearchSinkConfig{Addr: "http://127.0.0.1:9200", Action: "index", BatchSize: 1, Linger: 1000, Timeout: 5000}
+	if err := cast.MapToStruct(props, c); err != nil {
+		return fmt.Errorf("read properties %v fail with error: %v", props, err)
+	}
+	if c.Index == "" {
+		return errors.New("property index is required")
+	}
+	if c.Action != "index" && c.Action != "create" {
+		return fmt.Errorf("invalid property action: %s, must be index or create", c.Action)
+	}
+	if c.BatchSize <= 0 {
+		return fmt.Errorf("invalid property batchSize: %d, must be a positive integer", c.BatchSize)
+	}
+	if c.BatchBytes < 0 {
+		return fmt.Errorf("invalid property batchBytes: %d, must not be negative", c.BatchBytes)
+	}
+	if c.Linger < 0 {
+		return fmt.Errorf("invalid property lingerInterval: %d, must not be negative", c.Linger)
+	}
+	if c.Timeout <= 0 {
+		return fmt.Errorf("invalid property timeout: %d, must be a positive integer", c.Timeout)
+	}
+	if c.ApiKey != "" && c.Username != "" {
+		return errors.New("only one of apiKey and username can be set")
+	}
+	m.tp = nil
+	if strings.Contains(c.Index, "{{") {
+		tp, err := template.New("index").Funcs(ct.FuncMap).Funcs(template.FuncMap{
+			// The date function formats the time by the layout such as {{date "2006.01.02"}}
+			"date": m.date,
+		}).Parse(c.Index)
+		if err != nil {
+			return fmt.Errorf("invalid property index %s: %v", c.Index, err)
+		}
+		m.tp = tp
+	}
+	m.bulkUrl = strings.TrimRight(c.Addr, "/") + "/_bulk"
+	if c.Pipeline != "" {
+		m.bulkUrl += "?pipeline=" + url.QueryEscape(c.Pipeline)
+	}
+	m.conf = c
+	return nil
+}
+
+func (m *ElasticsearchSink) Open(ctx api.StreamContext) error {
+	logger := ctx.GetLogger()
+	logger.Infof("Opening elasticsearch sink for rule %s.", ctx.GetRuleId())
+	m.client = &http.Client{
+		Transport: &http.Transport{TLSClientConfig: &tls.Config{InsecureSkipVerify: m.conf.InsecureSkipVerify}},
+		Timeout:   time.Duration(m.conf.Timeout) * time.Millisecond,
+	}
+	if m.conf.BatchSize > 1 && m.conf.Linger > 0 {
+		done := make(chan struct{})
+		m.cancel = func() { close(done) }
+		go func() {
+			ticker := time.NewTicker(time.Duration(m.conf.Linger) * time.Millisecond)
+			defer ticker.Stop()
+			for {
+				select {
+				case <-ticker.C:
+					m.Lock()
+					total, rejected, err := m.flush(logger)
+					if err != nil {
+						logger.Errorf("elasticsearch sink fails to write the batch and keeps it to write again: %v", err)
+					} else if len(rejected) > 0 {
+						e := m.reject(total, rejected)
+						logger.Errorf("elasticsearch sink fails to write the batch: %v", e)
+					}
+					m.Unlock()
+				case <-done:
+					return
+				}
+			}
+		}()
+	}
+	return nil
+}
+
+func (m *ElasticsearchSink) Collect(ctx api.StreamContext, item interface{}) error {
+	logger := ctx.GetLogger()
+	v, ok := item.([]byte)
+	if !ok {
+		logger.Warnf("elasticsearch sink receive non []byte data: %v", item)
+		return nil
+	}
+	logger.Debugf("elasticsearch sink receive %s", item)
+	records, err := decodeRecords(v)
+	if err != nil {
+		return fmt.Errorf("elasticsearch sink %v", err)
+	}
+	now := conf.GetNow()
+	m.Lock()
+	defer m.Unlock()
+	// Encode all the records first so that the result is buffered either entirely or not at all
+	docs := make([]*esDoc, 0, len(records))
+	for _, r := range records {
+		d, err := m.doc(r, now)
+		if err != nil {
+			return err
+		}
+		docs = append(docs, d)
+	}
+	n := len(m.buffer)
+	m.setBuffer(append(m.buffer, docs...))
+	if len(m.buffer) < m.conf.BatchSize && (m.conf.BatchBytes == 0 || m.size < m.conf.BatchBytes) {
+		return nil
+	}
+	total, rejected, err := m.flush(logger)
+	if err != nil {
+		// Remove the documents of this result which is written again by the retry of the sink
+		m.setBuffer(m.buffer[:n])
+		return fmt.Errorf("elasticsearch sink fails to write the batch: %v", err)
+	}
+	// The rejected documents of this result are returned while the others are reported by the next flush
+	current := make(map[*esDoc]bool, len(docs))
+	for _, d := range docs {
+		current[d] = true
+	}
+	var cur, prev []esRejected
+	for _, r := range rejected {
+		if current[r.doc] {
+			cur = append(cur, r)
+		} else {
+			prev = append(prev, r)
+		}
+	}
+	if len(prev) > 0 {
+		m.reject(total-len(docs), prev)
+	}
+	if len(cur) > 0 {
+		e := &BulkError{Total: len(docs)}
+		for _, r := range cur {
+			e.Items = append(e.Items, r.item)
+		}
+		return fmt.Errorf("elasticsearch sink fails to write the batch: %v", e)
+	}
+	return nil
+}
+
+// Flush writes the buffered documents. It returns the rejected documents which are not reported yet and fails if
+// any document is kept to write again.
+func (m *ElasticsearchSink) Flush(ctx api.StreamContext) error {
+	m.Lock()
+	defer m.Unlock()
+	total, rejected, err := m.flush(ctx.GetLogger())
+	if err != nil {
+		return fmt.Errorf("elasticsearch sink fails to write the batch: %v", err)
+	}
+	if len(rejected) > 0 {
+		m.reject(total, rejected)
+	}
+	if e := m.rejected; e != nil {
+		m.rejected = nil
+		return fmt.Errorf("elasticsearch sink fails to write the batch: %v", e)
+	}
+	if len(m.buffer) > 0 {
+		return fmt.Errorf("elasticsearch sink keeps %d documents to write again", len(m.buffer))
+	}
+	return nil
+}
+
+// reject adds the rejected documents to be reported by the next flush
+func (m *ElasticsearchSink) reject(total int, rejected []esRejected) *BulkError {
+	if m.rejected == nil {
+		m.rejected = &BulkError{}
+	}
+	m.rejected.Total += total
+	for _, r := range rejected {
+		m.rejected.Items = append(m.rejected.Items, r.item)
+	}
+	return m.rejected
+}
+
+// setBuffer replaces the buffered documents and counts the size of the request body
+func (m *ElasticsearchSink) setBuffer(docs []*esDoc) {
+	m.buffer = docs
+	m.size = 0
+	for _, d := range docs {
+		m.size += len(d.action) + len(d.source) + 2
+	}
+}
+
+// doc encodes the record as a document with the action line of the index and the id
+func (m *ElasticsearchSink) doc(r map[string]interface{}, now time.Time) (*esDoc, error) {
+	index, err := m.index(r, now)
+	if err != nil {
+		return nil, err
+	}
+	meta := map[string]string{"_index": index}
+	var id string
+	if m.conf.IdField != "" {
+		v, ok := r[m.conf.IdField]
+		if !ok || v == nil {
+			return nil, fmt.Errorf("elasticsearch sink cannot find id field %s in %v", m.conf.IdField, r)
+		}
+		id = cast.ToStringAlways(v)
+		meta["_id"] = id
+	}
+	action, err := json.Marshal(map[string]interface{}{m.conf.Action: meta})
+	if err != nil {
+		return nil, err
+	}
+	source, err := json.Marshal(r)
+	if err != nil {
+		return nil, fmt.Errorf("elasticsearch sink fails to encode %v: %v", r, err)
+	}
+	return &esDoc{index: index, id: id, action: action, source: source}, nil
+}
+
+// index renders the index name by the template with the fields and the date
+func (m *ElasticsearchSink) index(r map[string]interface{}, now time.Time) (string, error) {
+	if m.tp == nil {
+		return m.conf.Index, nil
+	}
+	m.rec, m.now = r, now
+	var b bytes.Buffer
+	if err := m.tp.Execute(&b, r); err != nil {
+		return "", fmt.Errorf("elasticsearch sink fails to execute the index template: %v", err)
+	}
+	return b.String(), nil
+}
+
+// date formats the time of the timestamp field or the current time in UTC by the layout
+func (m *ElasticsearchSink) date(layout string) (string, error) {
+	t := m.now
+	if m.conf.TsFieldName != "" {
+		v, ok := m.rec[m.conf.TsFieldName]
+		if !ok {
+			return "", fmt.Errorf("cannot find timestamp field %s in %v", m.conf.TsFieldName, m.rec)
+		}
+		milli, err := cast.ToInt64(v, cast.CONVERT_SAMEKIND)
+		if err != nil {
+			return "", fmt.Errorf("invalid timestamp %v: %v", v, err)
+		}
+		t = cast.TimeFromUnixMilli(milli)
+	}
+	return t.UTC().Format(layout), nil
+}
+
+// flush writes the buffered documents in a bulk request and returns the count of the documents and the rejected
+// ones. If the request fails, the buffer is kept to write again by the next flush. Otherwise, the written and the
+// rejected documents are removed from the buffer while the documents failed with a retriable status like 429 are
+// kept. Must be called with the lock held.
+func (m *ElasticsearchSink) flush(logger api.Logger) (int, []esRejected, error) {
+	if len(m.buffer) == 0 {
+		return 0, nil, nil
+	}
+	docs := m.buffer
+	var body bytes.Buffer
+	body.Grow(m.size)
+	for _, d := range docs {
+		body.Write(d.action)
+		body.WriteByte('\n')
+		body.Write(d.source)
+		body.WriteByte('\n')
+	}
+	req, err := http.NewRequest(http.MethodPost, m.bulkUrl, &body)
+	if err != nil {
+		return 0, nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-ndjson")
+	if m.conf.ApiKey != "" {
+		req.Header.Set("Authorization", "ApiKey "+m.conf.ApiKey)
+	} else if m.conf.Username != "" {
+		req.SetBasicAuth(m.conf.Username, m.conf.Password)
+	}
+	resp, err := m.client.Do(req)
+	if err != nil {
+		return 0, nil, err
+	}
+	defer resp.Body.Close()
+	buf, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return 0, nil, err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return 0, nil, fmt.Errorf("http return code: %d and error message %s", resp.StatusCode, buf)
+	}
+	retry, rejected, err := bulkResult(docs, buf)
+	if err != nil {
+		return 0, nil, err
+	}
+	if len(retry) > 0 {
+		logger.Warnf("elasticsearch sink keeps %d of %d documents to write again for the retriable status", len(retry), len(docs))
+	}
+	m.setBuffer(retry)
+	return len(docs), rejected, nil
+}
+
+type bulkResponse struct {
+	Errors bool                             `json:"errors"`
+	Items  []map[string]*bulkResponseResult `json:"items"`
+}
+
+type bulkResponseResult struct {
+	Index  string `json:"_index"`
+	Id     string `json:"_id"`
+	Status int    `json:"status"`
+	Error  *struct {
+		Type   string `json:"type"`
+		Reason string `json:"reason"`
+	} `json:"error"`
+}
+
+// bulkResult parses the bulk response and collects the documents to write again and the rejected documents. The
+// items of the response are in the same order as the documents.
+func bulkResult(docs []*esDoc, body []byte) ([]*esDoc, []esRejected, error) {
+	resp := &bulkResponse{}
+	if err := json.Unmarshal(body, resp); err != nil {
+		return nil, nil, fmt.Errorf("fail to decode the bulk response %s: %v", body, err)
+	}
+	if !resp.Errors {
+		return nil, nil, nil
+	}
+	var (
+		retry    []*esDoc
+		rejected []esRejected
+	)
+	for i, item := range resp.Items {
+		if i >= len(docs) {
+			break
+		}
+		d := docs[i]
+		for _, r := range item {
+			if r == nil || r.Status >= 200 && r.Status <= 299 {
+				continue
+			}
+			if r.Status == http.StatusTooManyRequests || r.Status >= 500 {
+				retry = append(retry, d)
+				continue
+			}
+			it := BulkItemError{Index: r.Index, Id: r.Id, Status: r.Status, Doc: string(d.source)}
+			if r.Error != nil {
+				it.Type, it.Reason = r.Error.Type, r.Error.Reason
+			}
+			if it.Index == "" {
+				it.Index = d.index
+			}
+			if it.Id == "" {
+				it.Id = d.id
+			}
+			rejected = append(rejected, esRejected{doc: d, item: it})
+		}
+	}
+	return retry, rejected, nil
+}
+
+func (m *ElasticsearchSink) Close(ctx api.StreamContext) error {
+	ctx.GetLogger().Infof("Closing elasticsearch sink")
+	if m.cancel != nil {
+		m.cancel()
+	}
+	return m.Flush(ctx)
+}
@@ -0,0 +1,307 @@
+// Copyright 2021 EMQ Technologies Co., Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sink
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"github.com/lf-edge/ekuiper/internal/conf"
+	"github.com/lf-edge/ekuiper/internal/topo/context"
+	"github.com/lf-edge/ekuiper/internal/topo/topotest/mockclock"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestElasticsearchSinkConfigure(t *testing.T) {
+	var tests = []struct {
+		props map[string]interface{}
+		err   string
+	}{
+		{
+			props: map[string]interface{}{},
+			err:   "property index is required",
+		}, {
+			props: map[string]interface{}{"index": "i", "action": "update"},
+			err:   "invalid property action: update, must be index or create",
+		}, {
+			props: map[string]interface{}{"index": "i", "batchBytes": -1},
+			err:   "invalid property batchBytes: -1, must not be negative",
+		}, {
+			props: map[string]interface{}{"index": "i", "apiKey": "k", "username": "u"},
+			err:   "only one of apiKey and username can be set",
+		}, {
+			props: map[string]interface{}{"index": "i-{{.a"},
+			err:   "invalid property index i-{{.a: template: index:1: unclosed action",
+		}, {
+			props: map[string]interface{}{"index": "logs-{{.device}}-{{date \"2006.01.02\"}}"},
+		},
+	}
+	for i, tt := range tests {
+		err := (&ElasticsearchSink{}).Configure(tt.props)
+		if tt.err == "" && err != nil || tt.err != "" && (err == nil || err.Error() != tt.err) {
+			t.Errorf("%d: expect error %s but got %v", i, tt.err, err)
+		}
+	}
+}
+
+// esBulk is a bulk request received by the mock cluster. Each line of the request is decoded.
+type esBulk struct {
+	query string
+	auth  string
+	lines []map[string]interface{}
+}
+
+func TestElasticsearchSink(t *testing.T) {
+	// 2021-11-01 00:00:00 UTC
+	mockclock.ResetClock(1635724800000)
+	var (
+		mu    sync.Mutex
+		bulks []esBulk
+	)
+	// The mock cluster rejects the documents with the field bad
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/_bulk" || r.Header.Get("Content-Type") != "application/x-ndjson" {
+			http.NotFound(w, r)
+			return
+		}
+		b := esBulk{query: r.URL.RawQuery, auth: r.Header.Get("Authorization")}
+		var items []string
+		hasErr := false
+		scanner := bufio.NewScanner(r.Body)
+		for scanner.Scan() {
+			line := make(map[string]interface{})
+			if err := json.Unmarshal(scanner.Bytes(), &line); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			b.lines = append(b.lines, line)
+			if len(b.lines)%2 == 0 {
+				if _, ok := line["bad"]; ok {
+					hasErr = true
+					items = append(items, `{"index":{"_index":"i","_id":"x","status":400,"error":{"type":"mapper_parsing_exception","reason":"failed to parse"}}}`)
+				} else {
+					items = append(items, `{"index":{"status":201}}`)
+				}
+			}
+		}
+		mu.Lock()
+		bulks = append(bulks, b)
+		mu.Unlock()
+		fmt.Fprintf(w, `{"took":1,"errors":%v,"items":[%s]}`, hasErr, strings.Join(items, ","))
+	}))
+	defer ts.Close()
+
+	var tests = []struct {
+		props map[string]interface{}
+		data  [][]byte
+		bulks []esBulk
+		err   string
+	}{
+		{
+			props: map[string]interface{}{
+				"index":    "logs-{{.device}}-{{date \"2006.01.02\"}}",
+				"idField":  "id",
+				"username": "u",
+				"password": "p",
+				"pipeline": "p1",
+			},
+			data: [][]byte{
+				[]byte(`{"id":1,"device":"d1","temperature":25.5}`),
+			},
+			bulks: []esBulk{{
+				query: "pipeline=p1",
+				auth:  "Basic dTpw",
+				lines: []map[string]interface{}{
+					{"index": map[string]interface{}{"_index": "logs-d1-2021.11.01", "_id": "1"}},
+					{"id": 1.0, "device": "d1", "temperature": 25.5},
+				},
+			}},
+		}, {
+			props: map[string]interface{}{
+				"index":       "logs-{{date \"200601\"}}",
+				"tsFieldName": "ts",
+				"action":      "create",
+				"apiKey":      "k",
+				"batchSize":   3,
+			},
+			data: [][]byte{
+				[]byte(`[{"a":1,"ts":1630000000000},{"a":2,"ts":1640000000000}]`),
+				[]byte(`[{"a":3,"ts":1630000000000},{"a":4,"ts":1630000000000}]`),
+			},
+			bulks: []esBulk{{
+				auth: "ApiKey k",
+				lines: []map[string]interface{}{
+					{"create": map[string]interface{}{"_index": "logs-202108"}},
+					{"a": 1.0, "ts": 1630000000000.0},
+					{"create": map[string]interface{}{"_index": "logs-202112"}},
+					{"a": 2.0, "ts": 1640000000000.0},
+					{"create": map[string]interface{}{"_index": "logs-202108"}},
+					{"a": 3.0, "ts": 1630000000000.0},
+					{"create": map[string]interface{}{"_index": "logs-202108"}},
+					{"a": 4.0, "ts": 1630000000000.0},
+				},
+			}},
+		}, {
+			props: map[string]interface{}{
+				"index":      "i",
+				"batchSize":  100,
+				"batchBytes": 40,
+			},
+			data: [][]byte{
+				[]byte(`{"a":1}`),
+				[]byte(`{"a":2}`),
+				[]byte(`{"a":3}`),
+			},
+			bulks: []esBulk{{
+				lines: []map[string]interface{}{
+					{"index": map[string]interface{}{"_index": "i"}},
+					{"a": 1.0},
+					{"index": map[string]interface{}{"_index": "i"}},
+					{"a": 2.0},
+				},
+			}, {
+				lines: []map[string]interface{}{
+					{"index": map[string]interface{}{"_index": "i"}},
+					{"a": 3.0},
+				},
+			}},
+		}, {
+			props: map[string]interface{}{
+				"index":   "i",
+				"idField": "id",
+			},
+			data: [][]byte{
+				[]byte(`[{"id":"x","bad":true},{"id":"y"}]`),
+			},
+			bulks: []esBulk{{
+				lines: []map[string]interface{}{
+					{"index": map[string]interface{}{"_index": "i", "_id": "x"}},
+					{"id": "x", "bad": true},
+					{"index": map[string]interface{}{"_index": "i", "_id": "y"}},
+					{"id": "y"},
+				},
+			}},
+			err: `elasticsearch sink fails to write the batch: 1 of 2 documents are rejected: [index i id x status 400 mapper_parsing_exception: failed to parse] {"bad":true,"id":"x"};`,
+		},
+	}
+	ctx := context.WithValue(context.Background(), context.LoggerKey, conf.Log)
+	for i, tt := range tests {
+		bulks = nil
+		tt.props["addr"] = ts.URL
+		s := &ElasticsearchSink{}
+		if err := s.Configure(tt.props); err != nil {
+			t.Errorf("%d: configure error %v", i, err)
+			continue
+		}
+		if err := s.Open(ctx); err != nil {
+			t.Errorf("%d: open error %v", i, err)
+			continue
+		}
+		var errs []string
+		for _, d := range tt.data {
+			if err := s.Collect(ctx, d); err != nil {
+				errs = append(errs, err.Error())
+			}
+		}
+		if err := s.Close(ctx); err != nil {
+			errs = append(errs, err.Error())
+		}
+		if tt.err == "" && len(errs) > 0 || tt.err != "" && !reflect.DeepEqual([]string{tt.err}, errs) {
+			t.Errorf("%d: expect error %s but got %v", i, tt.err, errs)
+		}
+		mu.Lock()
+		if !reflect.DeepEqual(tt.bulks, bulks) {
+			t.Errorf("%d: result mismatch:\n\nexp=%#v\n\ngot=%#v\n\n", i, tt.bulks, bulks)
+		}
+		mu.Unlock()
+	}
+}
+
+func TestElasticsearchSinkRetry(t *testing.T) {
+	var (
+		mu     sync.Mutex
+		bodies [][]float64
+		busy   = true
+	)
+	// The mock cluster fails the first request. Then it rejects the document a=1 and is busy for a=2 once.
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var (
+			values []float64
+			items  []string
+		)
+		scanner := bufio.NewScanner(r.Body)
+		for i := 0; scanner.Scan(); i++ {
+			if i%2 == 0 {
+				continue
+			}
+			line := make(map[string]interface{})
+			_ = json.Unmarshal(scanner.Bytes(), &line)
+			a := line["a"].(float64)
+			values = append(values, a)
+			switch {
+			case a == 1:
+				items = append(items, `{"index":{"_index":"i","status":400,"error":{"type":"mapper_parsing_exception","reason":"failed to parse"}}}`)
+			case a == 2 && busy && len(bodies) > 0:
+				busy = false
+				items = append(items, `{"index":{"_index":"i","status":429,"error":{"type":"es_rejected_execution_exception","reason":"rejected"}}}`)
+			default:
+				items = append(items, `{"index":{"_index":"i","status":201}}`)
+			}
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		bodies = append(bodies, values)
+		if len(bodies) == 1 {
+			http.Error(w, "unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		fmt.Fprintf(w, `{"took":1,"errors":true,"items":[%s]}`, strings.Join(items, ","))
+	}))
+	defer ts.Close()
+	ctx := context.WithValue(context.Background(), context.LoggerKey, conf.Log)
+	s := &ElasticsearchSink{}
+	if err := s.Configure(map[string]interface{}{"addr": ts.URL, "index": "i", "batchSize": 2, "lingerInterval": 0}); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Open(ctx); err != nil {
+		t.Fatal(err)
+	}
+	_ = s.Collect(ctx, []byte(`{"a":1}`))
+	// Only the documents of the current result are removed when the request fails
+	if err := s.Collect(ctx, []byte(`{"a":2}`)); err == nil {
+		t.Error("expect error when the request fails")
+	}
+	// The rejected document of the previous result is reported by the flush and the busy one is written again
+	if err := s.Collect(ctx, []byte(`[{"a":2},{"a":3}]`)); err != nil {
+		t.Errorf("expect no error but got %v", err)
+	}
+	exp := `elasticsearch sink fails to write the batch: 1 of 1 documents are rejected: [index i id  status 400 mapper_parsing_exception: failed to parse] {"a":1};`
+	if err := s.Flush(ctx); err == nil || err.Error() != exp {
+		t.Errorf("expect error %s but got %v", exp, err)
+	}
+	if err := s.Close(ctx); err != nil {
+		t.Errorf("expect no error but got %v", err)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if !reflect.DeepEqual([][]float64{{1, 2}, {1, 2, 3}, {2}}, bodies) {
+		t.Errorf("result mismatch: %v", bodies)
+	}
+}
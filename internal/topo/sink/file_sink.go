@@ -0,0 +1,571 @@
+// Copyright 2021 EMQ Technologies Co., Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sink
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/lf-edge/ekuiper/internal/conf"
+	ct "github.com/lf-edge/ekuiper/internal/template"
+	"github.com/lf-edge/ekuiper/pkg/api"
+	"github.com/lf-edge/ekuiper/pkg/cast"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+)
+
+const (
+	FILE_FORMAT_JSONL = "jsonl"
+	FILE_FORMAT_CSV   = "csv"
+	FILE_FORMAT_LINES = "lines"
+)
+
+// The file which is not written for a while is closed to release the handler. It is reopened when written again.
+const fileIdleTimeout = 5 * time.Minute
+
+// The date layout elements which can be used in the path. A date layout is a sequence of the digits separated by
+// the punctuations such as 2006-01-02 or 2006/01/02/15, which includes the year 2006 and is not adjacent to
+// letters or digits. So the digits like node01 or log-15 are kept as is.
+var (
+	layoutRegex  = regexp.MustCompile(`\d+(?:[-_./:]\d+)*`)
+	digitsRegex  = regexp.MustCompile(`\d+`)
+	layoutTokens = []string{"2006", "01", "02", "15", "04", "05"}
+)
+
+type FileSinkConfig struct {
+	Path            string   `json:"path"`
+	Format          string   `json:"format"`
+	Fields          []string `json:"fields"`
+	HasHeader       bool     `json:"hasHeader"`
+	Delimiter       string   `json:"delimiter"`
+	Interval        int      `json:"interval"`
+	RollingSize     int64    `json:"rollingSize"`
+	RollingInterval int      `json:"rollingInterval"`
+	Compression     string   `json:"compression"`
+}
+
+type fileWriter struct {
+	path      string
+	file      *os.File
+	writer    *bufio.Writer
+	size      int64
+	fields    []string
+	start     time.Time
+	lastWrite time.Time
+}
+
+// FileSink writes the results to files. The path can be a template with the fields of the result, the date layouts
+// and the date function. The file is rolled by size or time and the rolled file can be compressed. It replaces the
+// file plugin, so the default format writes the data as lines like the plugin.
+type FileSink struct {
+	sync.Mutex
+	c       *FileSinkConfig
+	tp      *template.Template
+	layouts []string
+	writers map[string]*fileWriter
+	dataDir string
+	// The time to format by the date function of the path template
+	now    time.Time
+	wg     sync.WaitGroup
+	cancel func()
+}
+
+func (m *FileSink) Configure(props map[string]interface{}) error {
+	c := &FileSinkConfig{Format: FILE_FORMAT_LINES, Interval: 1000}
+	if err := cast.MapToStruct(props, c); err != nil {
+		return fmt.Errorf("read properties %v fail with error: %v", props, err)
+	}
+	if c.Path == "" {
+		return errors.New("property path is required")
+	}
+	switch c.Format {
+	case FILE_FORMAT_JSONL, FILE_FORMAT_CSV, FILE_FORMAT_LINES:
+	default:
+		return fmt.Errorf("invalid property format: %s, must be jsonl, csv or lines", c.Format)
+	}
+	if len(c.Delimiter) > 1 {
+		return fmt.Errorf("invalid property delimiter: %s, must be a single character", c.Delimiter)
+	}
+	if c.Interval <= 0 {
+		return fmt.Errorf("invalid property interval: %d, must be a positive integer", c.Interval)
+	}
+	if c.RollingSize < 0 {
+		return fmt.Errorf("invalid property rollingSize: %d, must not be negative", c.RollingSize)
+	}
+	if c.RollingInterval < 0 {
+		return fmt.Errorf("invalid property rollingInterval: %d, must not be negative", c.RollingInterval)
+	}
+	if c.Compression != "" && c.Compression != "gzip" {
+		return fmt.Errorf("invalid property compression: %s, only gzip is supported", c.Compression)
+	}
+	// Replace the date layouts by placeholders so that they are not mixed with the field values
+	m.layouts = nil
+	p := replaceLiteral(c.Path, func(s string) string {
+		return replaceLayouts(s, func(d string) string {
+			m.layouts = append(m.layouts, d)
+			return fmt.Sprintf("\x00%d\x00", len(m.layouts)-1)
+		})
+	})
+	if strings.Contains(p, "{{") {
+		// The date function formats the current time by the layout such as {{date "2006-01-02"}}
+		tp, err := template.New("path").Funcs(ct.FuncMap).Funcs(template.FuncMap{
+			"date": func(layout string) string {
+				return m.now.Format(layout)
+			},
+		}).Parse(p)
+		if err != nil {
+			return fmt.Errorf("invalid property path %s: %v", c.Path, err)
+		}
+		m.tp = tp
+	} else {
+		c.Path = p
+	}
+	if !filepath.IsAbs(c.Path) {
+		dataDir, err := conf.GetDataLoc()
+		if err != nil {
+			return err
+		}
+		m.dataDir = dataDir
+	}
+	m.c = c
+	return nil
+}
+
+// replaceLiteral applies the replacement to the text outside the template actions
+func replaceLiteral(s string, fn func(string) string) string {
+	var b strings.Builder
+	for {
+		i := strings.Index(s, "{{")
+		if i < 0 {
+			b.WriteString(fn(s))
+			return b.String()
+		}
+		j := strings.Index(s[i:], "}}")
+		if j < 0 {
+			b.WriteString(fn(s[:i]))
+			b.WriteString(s[i:])
+			return b.String()
+		}
+		b.WriteString(fn(s[:i]))
+		b.WriteString(s[i : i+j+2])
+		s = s[i+j+2:]
+	}
+}
+
+// replaceLayouts applies the replacement to each digits of the date layouts in the text
+func replaceLayouts(s string, fn func(string) string) string {
+	var b strings.Builder
+	last := 0
+	for _, loc := range layoutRegex.FindAllStringIndex(s, -1) {
+		if !isLayoutBoundary(s, loc[0]-1) || !isLayoutBoundary(s, loc[1]) {
+			continue
+		}
+		digits := digitsRegex.FindAllStringIndex(s[loc[0]:loc[1]], -1)
+		hasYear := false
+		valid := true
+		for _, d := range digits {
+			t := s[loc[0]+d[0] : loc[0]+d[1]]
+			if !isDateLayout(t) {
+				valid = false
+				break
+			}
+			if strings.Contains(t, "2006") {
+				hasYear = true
+			}
+		}
+		if !valid || !hasYear {
+			continue
+		}
+		for _, d := range digits {
+			b.WriteString(s[last : loc[0]+d[0]])
+			b.WriteString(fn(s[loc[0]+d[0] : loc[0]+d[1]]))
+			last = loc[0] + d[1]
+		}
+	}
+	b.WriteString(s[last:])
+	return b.String()
+}
+
+// isLayoutBoundary checks if the character at i is out of the text or is not a letter or digit
+func isLayoutBoundary(s string, i int) bool {
+	if i < 0 || i >= len(s) {
+		return true
+	}
+	c := s[i]
+	return !(c >= '0' && c <= '9' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z')
+}
+
+// isDateLayout checks if the digits consist of the date layout elements only like 2006 or 20060102
+func isDateLayout(d string) bool {
+	for d != "" {
+		found := false
+		for _, t := range layoutTokens {
+			if strings.HasPrefix(d, t) {
+				d = d[len(t):]
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+func (m *FileSink) Open(ctx api.StreamContext) error {
+	logger := ctx.GetLogger()
+	logger.Infof("Opening file sink for rule %s.", ctx.GetRuleId())
+	m.writers = make(map[string]*fileWriter)
+	exeCtx, cancel := ctx.WithCancel()
+	m.cancel = cancel
+	go func() {
+		ticker := time.NewTicker(time.Duration(m.c.Interval) * time.Millisecond)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				m.check(logger)
+			case <-exeCtx.Done():
+				logger.Info("file sink done")
+				return
+			}
+		}
+	}()
+	return nil
+}
+
+// check flushes the buffered data, rolls the files by time and closes the idle files
+func (m *FileSink) check(logger api.Logger) {
+	m.Lock()
+	defer m.Unlock()
+	now := conf.GetNow()
+	for p, w := range m.writers {
+		var err error
+		if m.c.RollingInterval > 0 && now.Sub(w.start) >= time.Duration(m.c.RollingInterval)*time.Millisecond {
+			err = m.roll(w, now, logger)
+		} else if now.Sub(w.lastWrite) >= fileIdleTimeout {
+			delete(m.writers, p)
+			err = w.close()
+		} else {
+			err = w.writer.Flush()
+		}
+		if err != nil {
+			logger.Errorf("file sink fails to write file %s: %v", p, err)
+		}
+	}
+}
+
+func (m *FileSink) Collect(ctx api.StreamContext, item interface{}) error {
+	logger := ctx.GetLogger()
+	data, ok := item.([]byte)
+	if !ok {
+		return fmt.Errorf("file sink receives non byte data %v", item)
+	}
+	logger.Debugf("file sink receive %s", data)
+	m.Lock()
+	defer m.Unlock()
+	now := conf.GetNow()
+	if m.c.Format == FILE_FORMAT_LINES {
+		var r map[string]interface{}
+		if m.tp != nil {
+			records, err := decodeRecords(data)
+			if err != nil {
+				return err
+			}
+			if len(records) > 0 {
+				r = records[0]
+			}
+		}
+		return m.write(r, append(data, '\n'), now, logger)
+	}
+	records, err := decodeRecords(data)
+	if err != nil {
+		return err
+	}
+	for _, r := range records {
+		var line []byte
+		if m.c.Format == FILE_FORMAT_JSONL {
+			line, err = json.Marshal(r)
+			if err != nil {
+				return fmt.Errorf("fail to encode %v: %v", r, err)
+			}
+			line = append(line, '\n')
+		}
+		if err := m.write(r, line, now, logger); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func decodeRecords(data []byte) ([]map[string]interface{}, error) {
+	var records []map[string]interface{}
+	if t := bytes.TrimSpace(data); len(t) > 0 && t[0] == '{' {
+		r := make(map[string]interface{})
+		if err := json.Unmarshal(t, &r); err != nil {
+			return nil, fmt.Errorf("fail to decode %s: %v", data, err)
+		}
+		return append(records, r), nil
+	}
+	if err := json.Unmarshal(data, &records); err != nil {
+		return nil, fmt.Errorf("fail to decode %s: %v", data, err)
+	}
+	return records, nil
+}
+
+// write the line to the file of the record. The csv line is encoded here as it depends on the header of the file.
+func (m *FileSink) write(r map[string]interface{}, line []byte, now time.Time, logger api.Logger) error {
+	p, err := m.filePath(r, now)
+	if err != nil {
+		return err
+	}
+	w, ok := m.writers[p]
+	if !ok {
+		w, err = m.openWriter(p, r, now)
+		if err != nil {
+			return err
+		}
+		m.writers[p] = w
+	}
+	if m.c.Format == FILE_FORMAT_CSV {
+		line, err = m.csvLine(w.fields, r)
+		if err != nil {
+			return err
+		}
+	}
+	n, err := w.writer.Write(line)
+	w.size += int64(n)
+	w.lastWrite = now
+	if err != nil {
+		return fmt.Errorf("fail to write file %s: %v", p, err)
+	}
+	if m.c.RollingSize > 0 && w.size >= m.c.RollingSize {
+		return m.roll(w, now, logger)
+	}
+	return nil
+}
+
+// filePath renders the path of the record. Must be called with the lock held.
+func (m *FileSink) filePath(r map[string]interface{}, now time.Time) (string, error) {
+	p := m.c.Path
+	if m.tp != nil {
+		m.now = now
+		var b bytes.Buffer
+		if err := m.tp.Execute(&b, r); err != nil {
+			return "", fmt.Errorf("fail to execute the path template: %v", err)
+		}
+		p = b.String()
+	}
+	for i, l := range m.layouts {
+		p = strings.Replace(p, fmt.Sprintf("\x00%d\x00", i), now.Format(l), 1)
+	}
+	if !filepath.IsAbs(p) {
+		p = filepath.Join(m.dataDir, p)
+	}
+	return filepath.Clean(p), nil
+}
+
+func (m *FileSink) openWriter(p string, r map[string]interface{}, now time.Time) (*fileWriter, error) {
+	if err := os.MkdirAll(filepath.Dir(p), os.ModePerm); err != nil {
+		return nil, fmt.Errorf("fail to create directory for %s: %v", p, err)
+	}
+	f, err := os.OpenFile(p, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("fail to open file %s: %v", p, err)
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	w := &fileWriter{path: p, file: f, writer: bufio.NewWriter(f), size: fi.Size(), start: now, lastWrite: now}
+	if m.c.Format == FILE_FORMAT_CSV {
+		w.fields = m.c.Fields
+		if len(w.fields) == 0 {
+			for k := range r {
+				w.fields = append(w.fields, k)
+			}
+			sort.Strings(w.fields)
+		}
+		if m.c.HasHeader && w.size == 0 {
+			header, err := m.csvRecord(w.fields)
+			if err != nil {
+				f.Close()
+				return nil, err
+			}
+			n, _ := w.writer.Write(header)
+			w.size += int64(n)
+		}
+	}
+	return w, nil
+}
+
+func (m *FileSink) csvLine(fields []string, r map[string]interface{}) ([]byte, error) {
+	values := make([]string, len(fields))
+	for i, k := range fields {
+		switch v := r[k].(type) {
+		case nil:
+		case string:
+			values[i] = v
+		case map[string]interface{}, []interface{}:
+			b, err := json.Marshal(v)
+			if err != nil {
+				return nil, err
+			}
+			values[i] = string(b)
+		default:
+			values[i] = cast.ToStringAlways(v)
+		}
+	}
+	return m.csvRecord(values)
+}
+
+func (m *FileSink) csvRecord(values []string) ([]byte, error) {
+	var b bytes.Buffer
+	cw := csv.NewWriter(&b)
+	if m.c.Delimiter != "" {
+		cw.Comma = rune(m.c.Delimiter[0])
+	}
+	if err := cw.Write(values); err != nil {
+		return nil, err
+	}
+	cw.Flush()
+	return b.Bytes(), cw.Error()
+}
+
+// roll closes the current file and renames it with the timestamp. The rolled file is compressed asynchronously.
+func (m *FileSink) roll(w *fileWriter, now time.Time, logger api.Logger) error {
+	delete(m.writers, w.path)
+	if err := w.close(); err != nil {
+		return err
+	}
+	ext := filepath.Ext(w.path)
+	base := strings.TrimSuffix(w.path, ext)
+	ts := now.UnixNano() / int64(time.Millisecond)
+	rolled := fmt.Sprintf("%s-%d%s", base, ts, ext)
+	for {
+		if _, err := os.Stat(rolled); os.IsNotExist(err) {
+			break
+		}
+		ts++
+		rolled = fmt.Sprintf("%s-%d%s", base, ts, ext)
+	}
+	if err := os.Rename(w.path, rolled); err != nil {
+		return fmt.Errorf("fail to roll file %s: %v", w.path, err)
+	}
+	logger.Debugf("file sink rolls file %s to %s", w.path, rolled)
+	if m.c.Compression == "gzip" {
+		m.wg.Add(1)
+		go func() {
+			defer m.wg.Done()
+			if err := gzipFile(rolled); err != nil {
+				logger.Errorf("file sink fails to compress %s: %v", rolled, err)
+			}
+		}()
+	}
+	return nil
+}
+
+func gzipFile(p string) error {
+	src, err := os.Open(p)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	dst, err := os.Create(p + ".gz")
+	if err != nil {
+		return err
+	}
+	gw := gzip.NewWriter(dst)
+	_, err = io.Copy(gw, src)
+	if err == nil {
+		err = gw.Close()
+	}
+	if err == nil {
+		err = dst.Sync()
+	}
+	if cerr := dst.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(p + ".gz")
+		return err
+	}
+	src.Close()
+	return os.Remove(p)
+}
+
+func (w *fileWriter) sync() error {
+	if err := w.writer.Flush(); err != nil {
+		return err
+	}
+	return w.file.Sync()
+}
+
+func (w *fileWriter) close() error {
+	err := w.sync()
+	if cerr := w.file.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// Flush writes the buffered data and syncs the files to the disk. It is called when checkpoint
+// is enabled so that the collected results will not be lost.
+func (m *FileSink) Flush(ctx api.StreamContext) error {
+	m.Lock()
+	defer m.Unlock()
+	var errs []string
+	for p, w := range m.writers {
+		if err := w.sync(); err != nil {
+			errs = append(errs, fmt.Sprintf("%s: %v", p, err))
+		}
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("fail to flush files: %s", strings.Join(errs, "; "))
+	}
+	ctx.GetLogger().Debugf("file sink flushed %d files", len(m.writers))
+	return nil
+}
+
+func (m *FileSink) Close(ctx api.StreamContext) error {
+	logger := ctx.GetLogger()
+	logger.Infof("Closing file sink")
+	if m.cancel != nil {
+		m.cancel()
+	}
+	m.Lock()
+	for p, w := range m.writers {
+		if err := w.close(); err != nil {
+			logger.Errorf("file sink fails to close file %s: %v", p, err)
+		}
+	}
+	m.writers = nil
+	m.Unlock()
+	m.wg.Wait()
+	return nil
+}
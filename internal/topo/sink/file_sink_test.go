@@ -0,0 +1,181 @@
+// Copyright 2021 EMQ Technologies Co., Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sink
+
+import (
+	"compress/gzip"
+	"github.com/lf-edge/ekuiper/internal/conf"
+	"github.com/lf-edge/ekuiper/internal/topo/context"
+	"github.com/lf-edge/ekuiper/internal/topo/topotest/mockclock"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+func TestFileSinkPath(t *testing.T) {
+	mockclock.ResetClock(1541152486013)
+	now := conf.GetNow()
+	dataDir, err := conf.GetDataLoc()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var tests = []struct {
+		path string
+		data map[string]interface{}
+		exp  string
+	}{
+		{
+			path: "/data/result.txt",
+			exp:  "/data/result.txt",
+		}, {
+			path: "/data/node01/line15.txt",
+			exp:  "/data/node01/line15.txt",
+		}, {
+			path: "/data/log-15/v2006.txt",
+			exp:  "/data/log-15/v2006.txt",
+		}, {
+			path: "result.txt",
+			exp:  filepath.Join(dataDir, "result.txt"),
+		}, {
+			path: "/data/{{.deviceId}}/2006-01-02.csv",
+			data: map[string]interface{}{"deviceId": "dev2006"},
+			exp:  "/data/dev2006/" + now.Format("2006-01-02") + ".csv",
+		}, {
+			path: "/data/2006/01/02/{{.deviceId}}_20060102-15.jsonl",
+			data: map[string]interface{}{"deviceId": "d01"},
+			exp:  "/data/" + now.Format("2006/01/02") + "/d01_" + now.Format("20060102-15") + ".jsonl",
+		}, {
+			path: "2006-01-02.csv",
+			exp:  filepath.Join(dataDir, now.Format("2006-01-02")+".csv"),
+		}, {
+			path: "/data/{{.deviceId}}/{{date \"2006-01-02\"}}.csv",
+			data: map[string]interface{}{"deviceId": "dev2006"},
+			exp:  "/data/dev2006/" + now.Format("2006-01-02") + ".csv",
+		}, {
+			path: "/data/v1/{{.deviceId}}_{{date \"20060102-15\"}}.jsonl",
+			data: map[string]interface{}{"deviceId": "d1"},
+			exp:  "/data/v1/d1_" + now.Format("20060102-15") + ".jsonl",
+		}, {
+			path: "/data/{{printf \"%02d\" .n}}/out.csv",
+			data: map[string]interface{}{"n": 1},
+			exp:  "/data/01/out.csv",
+		},
+	}
+	for i, tt := range tests {
+		s := &FileSink{}
+		if err := s.Configure(map[string]interface{}{"path": tt.path}); err != nil {
+			t.Errorf("%d: configure error %v", i, err)
+			continue
+		}
+		p, err := s.filePath(tt.data, now)
+		if err != nil {
+			t.Errorf("%d: path error %v", i, err)
+		} else if p != tt.exp {
+			t.Errorf("%d: path mismatch:\n  exp=%s\n  got=%s", i, tt.exp, p)
+		}
+	}
+}
+
+func TestFileSinkCollect(t *testing.T) {
+	mockclock.ResetClock(1541152486013)
+	dir := t.TempDir()
+	ctx := context.WithValue(context.Background(), context.LoggerKey, conf.Log)
+	s := &FileSink{}
+	err := s.Configure(map[string]interface{}{"path": filepath.Join(dir, "{{.id}}.jsonl"), "format": "jsonl"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Open(ctx); err != nil {
+		t.Fatal(err)
+	}
+	_ = s.Collect(ctx, []byte(`[{"id":"a","v":1},{"id":"b","v":2}]`))
+	_ = s.Collect(ctx, []byte(`{"id":"a","v":3}`))
+	if err := s.Flush(ctx); err != nil {
+		t.Fatal(err)
+	}
+	exp := map[string]string{
+		"a.jsonl": "{\"id\":\"a\",\"v\":1}\n{\"id\":\"a\",\"v\":3}\n",
+		"b.jsonl": "{\"id\":\"b\",\"v\":2}\n",
+	}
+	for name, content := range exp {
+		b, err := ioutil.ReadFile(filepath.Join(dir, name))
+		if err != nil {
+			t.Errorf("read %s error: %v", name, err)
+		} else if string(b) != content {
+			t.Errorf("%s content mismatch:\n  exp=%s\n  got=%s", name, content, b)
+		}
+	}
+	_ = s.Close(ctx)
+}
+
+func TestFileSinkRolling(t *testing.T) {
+	mockclock.ResetClock(1541152486013)
+	dir := t.TempDir()
+	ctx := context.WithValue(context.Background(), context.LoggerKey, conf.Log)
+	s := &FileSink{}
+	err := s.Configure(map[string]interface{}{
+		"path":        filepath.Join(dir, "out.csv"),
+		"format":      "csv",
+		"fields":      []interface{}{"id", "tags"},
+		"hasHeader":   true,
+		"rollingSize": 20,
+		"compression": "gzip",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Open(ctx); err != nil {
+		t.Fatal(err)
+	}
+	// The header and the first row exceed the size so that the file is rolled
+	_ = s.Collect(ctx, []byte(`[{"id":1,"tags":["a","b"]}]`))
+	mockclock.GetMockClock().Add(1)
+	_ = s.Collect(ctx, []byte(`[{"id":2}]`))
+	_ = s.Close(ctx)
+
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, f := range files {
+		names = append(names, f.Name())
+	}
+	sort.Strings(names)
+	expNames := []string{"out-1541152486013.csv.gz", "out.csv"}
+	if !reflect.DeepEqual(expNames, names) {
+		t.Fatalf("files mismatch:\n  exp=%v\n  got=%v", expNames, names)
+	}
+	f, err := os.Open(filepath.Join(dir, expNames[0]))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	gr, err := gzip.NewReader(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, _ := ioutil.ReadAll(gr)
+	if exp := "id,tags\n1,\"[\"\"a\"\",\"\"b\"\"]\"\n"; string(b) != exp {
+		t.Errorf("rolled content mismatch:\n  exp=%s\n  got=%s", exp, b)
+	}
+	b, _ = ioutil.ReadFile(filepath.Join(dir, expNames[1]))
+	if exp := "id,tags\n2,\n"; string(b) != exp {
+		t.Errorf("content mismatch:\n  exp=%s\n  got=%s", exp, b)
+	}
+}
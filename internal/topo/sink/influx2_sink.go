@@ -0,0 +1,359 @@
+// Copyright 2021 EMQ Technologies Co., Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sink
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/lf-edge/ekuiper/internal/conf"
+	"github.com/lf-edge/ekuiper/pkg/api"
+	"github.com/lf-edge/ekuiper/pkg/cast"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+type Influx2SinkConfig struct {
+	Addr               string   `json:"addr"`
+	Token              string   `json:"token"`
+	Org                string   `json:"org"`
+	Bucket             string   `json:"bucket"`
+	Measurement        string   `json:"measurement"`
+	Tags               []string `json:"tags"`
+	Fields             []string `json:"fields"`
+	TsFieldName        string   `json:"tsFieldName"`
+	Precision          string   `json:"precision"`
+	BatchSize          int      `json:"batchSize"`
+	Linger             int      `json:"lingerInterval"`
+	Gzip               bool     `json:"gzip"`
+	Timeout            int      `json:"timeout"`
+	InsecureSkipVerify bool     `json:"insecureSkipVerify"`
+}
+
+// The number of nanoseconds of each timestamp precision
+var influxPrecisions = map[string]int64{"ns": 1, "us": int64(time.Microsecond), "ms": int64(time.Millisecond), "s": int64(time.Second)}
+
+// Influx2Sink writes the results to InfluxDB 2.x in line protocol. Each result row is converted to a point. The lines
+// are buffered and written in one request when the batch size is reached, the linger interval is passed or a
+// checkpoint is taken. The lines are kept in the buffer until written.
+type Influx2Sink struct {
+	sync.Mutex
+	conf     *Influx2SinkConfig
+	writeUrl string
+	tags     map[string]bool
+	client   *http.Client
+	buffer   [][]byte
+	cancel   func()
+}
+
+func (m *Influx2Sink) Configure(props map[string]interface{}) error {
+	c := &Influx2SinkConfig{Addr: "http://127.0.0.1:8086", Precision: "ms", BatchSize: 1, Linger: 1000, Timeout: 5000}
+	if err := cast.MapToStruct(props, c); err != nil {
+		return fmt.Errorf("read properties %v fail with error: %v", props, err)
+	}
+	if c.Org == "" {
+		return errors.New("property org is required")
+	}
+	if c.Bucket == "" {
+		return errors.New("property bucket is required")
+	}
+	if c.Measurement == "" {
+		return errors.New("property measurement is required")
+	}
+	if _, ok := influxPrecisions[c.Precision]; !ok {
+		return fmt.Errorf("invalid property precision: %s, must be ns, us, ms or s", c.Precision)
+	}
+	if c.BatchSize <= 0 {
+		return fmt.Errorf("invalid property batchSize: %d, must be a positive integer", c.BatchSize)
+	}
+	if c.Linger < 0 {
+		return fmt.Errorf("invalid property lingerInterval: %d, must not be negative", c.Linger)
+	}
+	if c.Timeout <= 0 {
+		return fmt.Errorf("invalid property timeout: %d, must be a positive integer", c.Timeout)
+	}
+	u, err := url.Parse(strings.TrimRight(c.Addr, "/") + "/api/v2/write")
+	if err != nil {
+		return fmt.Errorf("invalid property addr %s: %v", c.Addr, err)
+	}
+	q := u.Query()
+	q.Set("org", c.Org)
+	q.Set("bucket", c.Bucket)
+	q.Set("precision", c.Precision)
+	u.RawQuery = q.Encode()
+	m.writeUrl = u.String()
+	m.tags = make(map[string]bool, len(c.Tags))
+	for _, t := range c.Tags {
+		m.tags[t] = true
+	}
+	m.conf = c
+	return nil
+}
+
+func (m *Influx2Sink) Open(ctx api.StreamContext) error {
+	logger := ctx.GetLogger()
+	logger.Infof("Opening influx2 sink for rule %s.", ctx.GetRuleId())
+	m.client = &http.Client{
+		Transport: &http.Transport{TLSClientConfig: &tls.Config{InsecureSkipVerify: m.conf.InsecureSkipVerify}},
+		Timeout:   time.Duration(m.conf.Timeout) * time.Millisecond,
+	}
+	if m.conf.BatchSize > 1 && m.conf.Linger > 0 {
+		done := make(chan struct{})
+		m.cancel = func() { close(done) }
+		go func() {
+			ticker := time.NewTicker(time.Duration(m.conf.Linger) * time.Millisecond)
+			defer ticker.Stop()
+			for {
+				select {
+				case <-ticker.C:
+					m.Lock()
+					if err := m.flush(); err != nil {
+						logger.Errorf("influx2 sink fails to write %d lines, will write them with the next batch: %v", len(m.buffer), err)
+					}
+					m.Unlock()
+				case <-done:
+					return
+				}
+			}
+		}()
+	}
+	return nil
+}
+
+func (m *Influx2Sink) Collect(ctx api.StreamContext, item interface{}) error {
+	logger := ctx.GetLogger()
+	v, ok := item.([]byte)
+	if !ok {
+		logger.Warnf("influx2 sink receive non []byte data: %v", item)
+		return nil
+	}
+	logger.Debugf("influx2 sink receive %s", item)
+	records, err := decodeRecords(v)
+	if err != nil {
+		return fmt.Errorf("influx2 sink %v", err)
+	}
+	now := conf.GetNow().UnixNano()
+	// Encode all the records first so that a bad record does not leave part of the result in the buffer
+	lines := make([][]byte, 0, len(records))
+	for _, r := range records {
+		line, err := m.line(r, now)
+		if err != nil {
+			return err
+		}
+		if line == nil {
+			logger.Debugf("influx2 sink drops %v without any field", r)
+			continue
+		}
+		lines = append(lines, line)
+	}
+	m.Lock()
+	defer m.Unlock()
+	n := len(m.buffer)
+	m.buffer = append(m.buffer, lines...)
+	if len(m.buffer) < m.conf.BatchSize {
+		return nil
+	}
+	if err := m.flush(); err != nil {
+		// Leave the lines of this result to the retry of the sink node and keep the earlier ones for the next write
+		if len(m.buffer) > n {
+			m.buffer = m.buffer[:n]
+		}
+		return fmt.Errorf("influx2 sink fails to write the batch: %v", err)
+	}
+	return nil
+}
+
+// Flush writes the buffered lines when the checkpoint is taken
+func (m *Influx2Sink) Flush(_ api.StreamContext) error {
+	m.Lock()
+	defer m.Unlock()
+	if err := m.flush(); err != nil {
+		return fmt.Errorf("influx2 sink fails to write the batch: %v", err)
+	}
+	return nil
+}
+
+// line encodes the record as a line of the line protocol. The tags and fields are sorted by key. If no field is found,
+// nil is returned.
+func (m *Influx2Sink) line(r map[string]interface{}, now int64) ([]byte, error) {
+	var b bytes.Buffer
+	b.WriteString(influxEscape(m.conf.Measurement, ", "))
+	tags := make([]string, 0, len(m.conf.Tags))
+	for _, k := range m.conf.Tags {
+		if v, ok := r[k]; ok && v != nil {
+			tags = append(tags, k)
+		}
+	}
+	sort.Strings(tags)
+	for _, k := range tags {
+		s := cast.ToStringAlways(r[k])
+		if s == "" {
+			continue
+		}
+		b.WriteByte(',')
+		b.WriteString(influxEscape(k, ",= "))
+		b.WriteByte('=')
+		b.WriteString(influxEscape(s, ",= "))
+	}
+	fields := m.conf.Fields
+	if len(fields) == 0 {
+		fields = make([]string, 0, len(r))
+		for k := range r {
+			if !m.tags[k] && k != m.conf.TsFieldName {
+				fields = append(fields, k)
+			}
+		}
+	} else {
+		fields = append([]string(nil), fields...)
+	}
+	sort.Strings(fields)
+	n := 0
+	for _, k := range fields {
+		v, ok := r[k]
+		if !ok || v == nil {
+			continue
+		}
+		fv, err := influxFieldValue(v)
+		if err != nil {
+			return nil, fmt.Errorf("influx2 sink fails to encode field %s: %v", k, err)
+		}
+		if n == 0 {
+			b.WriteByte(' ')
+		} else {
+			b.WriteByte(',')
+		}
+		b.WriteString(influxEscape(k, ",= "))
+		b.WriteByte('=')
+		b.WriteString(fv)
+		n++
+	}
+	if n == 0 {
+		return nil, nil
+	}
+	ts := now
+	if m.conf.TsFieldName != "" {
+		v, ok := r[m.conf.TsFieldName]
+		if !ok {
+			return nil, fmt.Errorf("influx2 sink cannot find timestamp field %s in %v", m.conf.TsFieldName, r)
+		}
+		milli, err := cast.ToInt64(v, cast.CONVERT_SAMEKIND)
+		if err != nil {
+			return nil, fmt.Errorf("influx2 sink gets invalid timestamp %v: %v", v, err)
+		}
+		ts = milli * int64(time.Millisecond)
+	}
+	b.WriteByte(' ')
+	b.WriteString(strconv.FormatInt(ts/influxPrecisions[m.conf.Precision], 10))
+	return b.Bytes(), nil
+}
+
+// influxEscape escapes the special characters with backslash
+func influxEscape(s string, chars string) string {
+	if !strings.ContainsAny(s, chars) {
+		return s
+	}
+	var b strings.Builder
+	for _, c := range s {
+		if strings.ContainsRune(chars, c) {
+			b.WriteByte('\\')
+		}
+		b.WriteRune(c)
+	}
+	return b.String()
+}
+
+// influxFieldValue encodes the field value. Numbers are written as float as json does not distinguish integers.
+// The maps and arrays are encoded as json strings.
+func influxFieldValue(v interface{}) (string, error) {
+	switch vt := v.(type) {
+	case float64:
+		return strconv.FormatFloat(vt, 'f', -1, 64), nil
+	case bool:
+		return strconv.FormatBool(vt), nil
+	case string:
+		return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(vt) + `"`, nil
+	default:
+		s, err := json.Marshal(vt)
+		if err != nil {
+			return "", err
+		}
+		return influxFieldValue(string(s))
+	}
+}
+
+// flush writes the buffered lines in one request and resets the buffer if succeeded. If the lines are rejected by
+// a client error other than 429, they are dropped as writing them again will fail too. Must be called with the
+// lock held.
+func (m *Influx2Sink) flush() error {
+	if len(m.buffer) == 0 {
+		return nil
+	}
+	body := bytes.Join(m.buffer, []byte("\n"))
+	if m.conf.Gzip {
+		var b bytes.Buffer
+		w := gzip.NewWriter(&b)
+		if _, err := w.Write(body); err != nil {
+			return err
+		}
+		if err := w.Close(); err != nil {
+			return err
+		}
+		body = b.Bytes()
+	}
+	req, err := http.NewRequest(http.MethodPost, m.writeUrl, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "text/plain; charset=utf-8")
+	if m.conf.Gzip {
+		req.Header.Set("Content-Encoding", "gzip")
+	}
+	if m.conf.Token != "" {
+		req.Header.Set("Authorization", "Token "+m.conf.Token)
+	}
+	resp, err := m.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		buf, _ := ioutil.ReadAll(resp.Body)
+		if resp.StatusCode >= 400 && resp.StatusCode < 500 && resp.StatusCode != http.StatusTooManyRequests {
+			m.buffer = nil
+		}
+		return fmt.Errorf("http return code: %d and error message %s", resp.StatusCode, buf)
+	}
+	m.buffer = nil
+	return nil
+}
+
+func (m *Influx2Sink) Close(ctx api.StreamContext) error {
+	ctx.GetLogger().Infof("Closing influx2 sink")
+	if m.cancel != nil {
+		m.cancel()
+	}
+	m.Lock()
+	defer m.Unlock()
+	return m.flush()
+}
@@ -0,0 +1,222 @@
+// Copyright 2021 EMQ Technologies Co., Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sink
+
+import (
+	"compress/gzip"
+	"github.com/lf-edge/ekuiper/internal/conf"
+	"github.com/lf-edge/ekuiper/internal/topo/context"
+	"github.com/lf-edge/ekuiper/internal/topo/topotest/mockclock"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sync"
+	"testing"
+)
+
+type influxWrite struct {
+	query string
+	auth  string
+	body  string
+}
+
+func TestInflux2SinkConfigure(t *testing.T) {
+	var tests = []struct {
+		props map[string]interface{}
+		err   string
+	}{
+		{
+			props: map[string]interface{}{"bucket": "b", "measurement": "m"},
+			err:   "property org is required",
+		}, {
+			props: map[string]interface{}{"org": "o", "bucket": "b", "measurement": "m", "precision": "m"},
+			err:   "invalid property precision: m, must be ns, us, ms or s",
+		}, {
+			props: map[string]interface{}{"org": "o", "bucket": "b", "measurement": "m", "batchSize": 0},
+			err:   "invalid property batchSize: 0, must be a positive integer",
+		}, {
+			props: map[string]interface{}{"org": "o", "bucket": "b", "measurement": "m"},
+		},
+	}
+	for i, tt := range tests {
+		err := (&Influx2Sink{}).Configure(tt.props)
+		if tt.err == "" && err != nil || tt.err != "" && (err == nil || err.Error() != tt.err) {
+			t.Errorf("%d: expect error %s but got %v", i, tt.err, err)
+		}
+	}
+}
+
+func TestInflux2Sink(t *testing.T) {
+	mockclock.ResetClock(10000)
+	var (
+		mu     sync.Mutex
+		writes []influxWrite
+	)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/v2/write" {
+			http.NotFound(w, r)
+			return
+		}
+		var reader io.Reader = r.Body
+		if r.Header.Get("Content-Encoding") == "gzip" {
+			gr, err := gzip.NewReader(r.Body)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			reader = gr
+		}
+		body, _ := ioutil.ReadAll(reader)
+		mu.Lock()
+		writes = append(writes, influxWrite{query: r.URL.RawQuery, auth: r.Header.Get("Authorization"), body: string(body)})
+		mu.Unlock()
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer ts.Close()
+
+	var tests = []struct {
+		props  map[string]interface{}
+		data   [][]byte
+		writes []influxWrite
+	}{
+		{
+			props: map[string]interface{}{
+				"token":       "abc",
+				"tags":        []interface{}{"device", "site"},
+				"measurement": "room temp",
+			},
+			data: [][]byte{
+				[]byte(`{"device":"d 1","site":"a,b","temperature":25.5,"humidity":60,"status":"ok \"1\"","on":true}`),
+			},
+			writes: []influxWrite{{
+				query: "bucket=b1&org=o1&precision=ms",
+				auth:  "Token abc",
+				body:  `room\ temp,device=d\ 1,site=a\,b humidity=60,on=true,status="ok \"1\"",temperature=25.5 10000`,
+			}},
+		}, {
+			props: map[string]interface{}{
+				"tags":        []interface{}{"device"},
+				"fields":      []interface{}{"temperature"},
+				"tsFieldName": "ts",
+				"precision":   "s",
+				"measurement": "m",
+				"batchSize":   3,
+				"gzip":        true,
+			},
+			data: [][]byte{
+				[]byte(`[{"device":"d1","temperature":20,"humidity":60,"ts":1630000000000},{"device":"d2","humidity":60,"ts":1630000000000}]`),
+				[]byte(`[{"device":"d2","temperature":21.5,"ts":1630000001000},{"temperature":22,"ts":1630000002000}]`),
+				[]byte(`{"device":"d3","temperature":23,"ts":1630000003000}`),
+			},
+			writes: []influxWrite{{
+				query: "bucket=b1&org=o1&precision=s",
+				body:  "m,device=d1 temperature=20 1630000000\nm,device=d2 temperature=21.5 1630000001\nm temperature=22 1630000002",
+			}, {
+				query: "bucket=b1&org=o1&precision=s",
+				body:  "m,device=d3 temperature=23 1630000003",
+			}},
+		},
+	}
+	ctx := context.WithValue(context.Background(), context.LoggerKey, conf.Log)
+	for i, tt := range tests {
+		writes = nil
+		tt.props["addr"] = ts.URL
+		tt.props["org"] = "o1"
+		tt.props["bucket"] = "b1"
+		s := &Influx2Sink{}
+		if err := s.Configure(tt.props); err != nil {
+			t.Errorf("%d: configure error %v", i, err)
+			continue
+		}
+		if err := s.Open(ctx); err != nil {
+			t.Errorf("%d: open error %v", i, err)
+			continue
+		}
+		for _, d := range tt.data {
+			if err := s.Collect(ctx, d); err != nil {
+				t.Errorf("%d: collect error %v", i, err)
+			}
+		}
+		if err := s.Close(ctx); err != nil {
+			t.Errorf("%d: close error %v", i, err)
+		}
+		mu.Lock()
+		if !reflect.DeepEqual(tt.writes, writes) {
+			t.Errorf("%d: result mismatch:\n\nexp=%#v\n\ngot=%#v\n\n", i, tt.writes, writes)
+		}
+		mu.Unlock()
+	}
+
+	s := &Influx2Sink{}
+	_ = s.Configure(map[string]interface{}{"addr": ts.URL + "/wrong", "org": "o1", "bucket": "b1", "measurement": "m"})
+	_ = s.Open(ctx)
+	if err := s.Collect(ctx, []byte(`{"a":1}`)); err == nil {
+		t.Error("expect error for the wrong address")
+	}
+	_ = s.Close(ctx)
+}
+
+func TestInflux2SinkRetry(t *testing.T) {
+	mockclock.ResetClock(10000)
+	var (
+		mu     sync.Mutex
+		bodies []string
+		codes  = []int{http.StatusServiceUnavailable, http.StatusServiceUnavailable, http.StatusBadRequest}
+	)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		mu.Lock()
+		bodies = append(bodies, string(body))
+		code := http.StatusNoContent
+		if len(codes) > 0 {
+			code, codes = codes[0], codes[1:]
+		}
+		mu.Unlock()
+		w.WriteHeader(code)
+	}))
+	defer ts.Close()
+	ctx := context.WithValue(context.Background(), context.LoggerKey, conf.Log)
+	s := &Influx2Sink{}
+	if err := s.Configure(map[string]interface{}{"addr": ts.URL, "org": "o1", "bucket": "b1", "measurement": "m", "batchSize": 2, "lingerInterval": 0}); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Open(ctx); err != nil {
+		t.Fatal(err)
+	}
+	_ = s.Collect(ctx, []byte(`{"v":1}`))
+	// Only the lines of the current result are rejected when the batch fails
+	if err := s.Collect(ctx, []byte(`{"v":2}`)); err == nil {
+		t.Error("expect error when the batch fails")
+	}
+	if err := s.Flush(ctx); err == nil {
+		t.Error("expect error when the flush fails")
+	}
+	// The client error drops the lines
+	if err := s.Collect(ctx, []byte(`{"v":2}`)); err == nil {
+		t.Error("expect error when the batch is rejected")
+	}
+	if err := s.Collect(ctx, []byte(`[{"v":3},{"v":4}]`)); err != nil {
+		t.Errorf("expect no error but got %v", err)
+	}
+	_ = s.Close(ctx)
+	exp := []string{"m v=1 10000\nm v=2 10000", "m v=1 10000", "m v=1 10000\nm v=2 10000", "m v=3 10000\nm v=4 10000"}
+	mu.Lock()
+	defer mu.Unlock()
+	if !reflect.DeepEqual(exp, bodies) {
+		t.Errorf("result mismatch:\n  exp=%q\n  got=%q", exp, bodies)
+	}
+}
@@ -15,61 +15,57 @@
 package sink
 
 import (
-	"crypto/tls"
 	"fmt"
-	MQTT "github.com/eclipse/paho.mqtt.golang"
-	"github.com/google/uuid"
-	"github.com/lf-edge/ekuiper/internal/conf"
+	"github.com/lf-edge/ekuiper/internal/pkg/mqttx"
 	"github.com/lf-edge/ekuiper/pkg/api"
 	"github.com/lf-edge/ekuiper/pkg/cast"
 	"strings"
 )
 
 type MQTTSink struct {
-	srv      string
-	tpc      string
-	clientid string
-	pVersion uint
-	qos      byte
-	uName    string
-	password string
-	certPath string
-	pkeyPath string
-
-	insecureSkipVerify bool
-	retained           bool
-
-	conn MQTT.Client
+	tpc   string
+	cconf *mqttx.ClientConf
+	popts *mqttx.PublishOptions
+
+	conn *mqttx.Conn
 }
 
 func (ms *MQTTSink) Configure(ps map[string]interface{}) error {
-	srv, ok := ps["server"]
-	if !ok {
+	var servers []string
+	if srvs, ok := ps["servers"]; ok {
+		if vs, ok := srvs.([]interface{}); ok {
+			for _, v := range vs {
+				if s, ok := v.(string); ok && s != "" {
+					servers = append(servers, s)
+				}
+			}
+		}
+	}
+	if srv, ok := ps["server"]; ok {
+		if s, ok := srv.(string); ok && s != "" {
+			servers = append([]string{s}, servers...)
+		}
+	}
+	if len(servers) == 0 {
 		return fmt.Errorf("mqtt sink is missing property server")
 	}
 	tpc, ok := ps["topic"]
 	if !ok {
 		return fmt.Errorf("mqtt sink is missing property topic")
 	}
-	clientid, ok := ps["clientId"]
-	if !ok {
-		if uuid, err := uuid.NewUUID(); err != nil {
-			return fmt.Errorf("mqtt sink fails to get uuid, the error is %s", err)
-		} else {
-
-			clientid = uuid.String()
-		}
+	clientid := ""
+	if cid, ok := ps["clientId"]; ok {
+		clientid, _ = cid.(string)
 	}
-	var pVersion uint = 3
+	pVersion := ""
 	pVersionStr, ok := ps["protocolVersion"]
 	if ok {
 		v, _ := pVersionStr.(string)
-		if v == "3.1" {
-			pVersion = 3
-		} else if v == "3.1.1" {
-			pVersion = 4
-		} else {
-			return fmt.Errorf("unknown protocol version %s, the value could be only 3.1 or 3.1.1 (also refers to MQTT version 4)", pVersionStr)
+		switch v {
+		case mqttx.PROTOCOL_V31, mqttx.PROTOCOL_V311, mqttx.PROTOCOL_V5, "5.0":
+			pVersion = v
+		default:
+			return fmt.Errorf("unknown protocol version %s, the value could be only 3.1, 3.1.1 (also refers to MQTT version 4) or 5", pVersionStr)
 		}
 	}
 
@@ -129,91 +125,103 @@ func (ms *MQTTSink) Configure(ps map[string]interface{}) error {
 		}
 	}
 
-	ms.srv = srv.(string)
-	ms.tpc = tpc.(string)
-	ms.clientid = clientid.(string)
-	ms.pVersion = pVersion
-	ms.qos = qos
-	ms.uName = uName
-	ms.password = password
-	ms.certPath = certPath
-	ms.pkeyPath = pKeyPath
-	ms.insecureSkipVerify = insecureSkipVerify
-	ms.retained = retained
-
-	return nil
-}
+	maxReconnectInterval := 0
+	if mi, ok := ps["maxReconnectInterval"]; ok {
+		v, err := cast.ToInt(mi, cast.CONVERT_SAMEKIND)
+		if err != nil || v < 0 {
+			return fmt.Errorf("not valid maxReconnectInterval value %v, it must be a positive int", mi)
+		}
+		maxReconnectInterval = v
+	}
 
-func (ms *MQTTSink) Open(ctx api.StreamContext) error {
-	log := ctx.GetLogger()
-	log.Infof("Opening mqtt sink for rule %s.", ctx.GetRuleId())
-	opts := MQTT.NewClientOptions().AddBroker(ms.srv).SetClientID(ms.clientid)
-
-	if ms.certPath != "" || ms.pkeyPath != "" {
-		log.Infof("Connect MQTT broker with certification and keys.")
-		if cp, err := conf.ProcessPath(ms.certPath); err == nil {
-			if kp, err1 := conf.ProcessPath(ms.pkeyPath); err1 == nil {
-				if cer, err2 := tls.LoadX509KeyPair(cp, kp); err2 != nil {
-					return err2
-				} else {
-					opts.SetTLSConfig(&tls.Config{Certificates: []tls.Certificate{cer}, InsecureSkipVerify: ms.insecureSkipVerify})
-				}
-			} else {
-				return err1
-			}
-		} else {
-			return err
+	popts := &mqttx.PublishOptions{Qos: qos, Retained: retained}
+	// The properties of MQTT 5
+	if up, ok := ps["userProperties"]; ok {
+		m, ok := up.(map[string]interface{})
+		if !ok {
+			return fmt.Errorf("not valid userProperties value %v, it must be a map of string", up)
 		}
-	} else {
-		log.Infof("Connect MQTT broker with username and password.")
-		if ms.uName != "" {
-			opts = opts.SetUsername(ms.uName)
+		popts.UserProperties = make(map[string]string, len(m))
+		for k, v := range m {
+			popts.UserProperties[k] = fmt.Sprintf("%v", v)
 		}
-
-		if ms.password != "" {
-			opts = opts.SetPassword(ms.password)
+	}
+	if rt, ok := ps["responseTopic"]; ok {
+		popts.ResponseTopic, _ = rt.(string)
+	}
+	if ct, ok := ps["contentType"]; ok {
+		popts.ContentType, _ = ct.(string)
+	}
+	if cd, ok := ps["correlationData"]; ok {
+		if v, ok := cd.(string); ok {
+			popts.CorrelationData = []byte(v)
 		}
 	}
-
-	opts.SetAutoReconnect(true)
-	var reconn = false
-	opts.SetConnectionLostHandler(func(client MQTT.Client, e error) {
-		log.Errorf("The connection %s is disconnected due to error %s, will try to re-connect later.", ms.srv+": "+ms.clientid, e)
-		ms.conn = client
-		reconn = true
-	})
-
-	opts.SetOnConnectHandler(func(client MQTT.Client) {
-		if reconn {
-			log.Infof("The connection is %s re-established successfully.", ms.srv+": "+ms.clientid)
+	if me, ok := ps["messageExpiry"]; ok {
+		v, err := cast.ToInt(me, cast.CONVERT_SAMEKIND)
+		if err != nil || v < 0 {
+			return fmt.Errorf("not valid messageExpiry value %v, it must be a positive int", me)
 		}
-	})
+		popts.MessageExpiry = uint32(v)
+	}
 
-	c := MQTT.NewClient(opts)
-	if token := c.Connect(); token.Wait() && token.Error() != nil {
-		return fmt.Errorf("Found error: %s", token.Error())
+	ms.tpc = tpc.(string)
+	ms.cconf = &mqttx.ClientConf{
+		Servers:              servers,
+		ClientId:             clientid,
+		PVersion:             pVersion,
+		Username:             uName,
+		Password:             password,
+		Certification:        certPath,
+		PrivateKeyPath:       pKeyPath,
+		InsecureSkipVerify:   insecureSkipVerify,
+		MaxReconnectInterval: maxReconnectInterval,
 	}
+	ms.popts = popts
+	return nil
+}
 
-	log.Infof("The connection to server %s was established successfully", ms.srv)
+func (ms *MQTTSink) Open(ctx api.StreamContext) error {
+	log := ctx.GetLogger()
+	log.Infof("Opening mqtt sink for rule %s.", ctx.GetRuleId())
+	c, err := mqttx.GetConnection(ms.cconf)
+	if err != nil {
+		return err
+	}
 	ms.conn = c
 	return nil
 }
 
 func (ms *MQTTSink) Collect(ctx api.StreamContext, item interface{}) error {
 	logger := ctx.GetLogger()
-	c := ms.conn
 	logger.Debugf("%s publish %s", ctx.GetOpId(), item)
-	if token := c.Publish(ms.tpc, ms.qos, ms.retained, item); token.Wait() && token.Error() != nil {
-		return fmt.Errorf("publish error: %s", token.Error())
+	var payload []byte
+	switch v := item.(type) {
+	case []byte:
+		payload = v
+	case string:
+		payload = []byte(v)
+	default:
+		return fmt.Errorf("publish error: unknown payload type %T", item)
+	}
+	if err := ms.conn.Publish(ms.tpc, payload, ms.popts); err != nil {
+		return fmt.Errorf("publish error: %s", err)
 	}
 	return nil
 }
 
+func (ms *MQTTSink) ConnectionStatus() api.ConnectionStatus {
+	if ms.conn == nil {
+		return api.ConnectionStatus{Status: api.ConnectionDisconnected}
+	}
+	return ms.conn.Status()
+}
+
 func (ms *MQTTSink) Close(ctx api.StreamContext) error {
 	logger := ctx.GetLogger()
 	logger.Infof("Closing mqtt sink")
-	if ms.conn != nil && ms.conn.IsConnected() {
-		ms.conn.Disconnect(5000)
+	if ms.conn != nil {
+		ms.conn.Close()
 	}
 	return nil
 }
@@ -0,0 +1,168 @@
+// Copyright 2021 EMQ Technologies Co., Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sink
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/lf-edge/ekuiper/internal/pkg/natsx"
+	ct "github.com/lf-edge/ekuiper/internal/template"
+	"github.com/lf-edge/ekuiper/pkg/api"
+	"github.com/lf-edge/ekuiper/pkg/cast"
+	"github.com/nats-io/nats.go"
+	"strings"
+	"text/template"
+)
+
+type NATSSinkConfig struct {
+	natsx.ConnConf
+	Subject string            `json:"subject"`
+	Headers map[string]string `json:"headers"`
+	// Publish to JetStream and wait for the acknowledgement
+	JetStream bool `json:"jetStream"`
+}
+
+// NATSSink publishes the results to a NATS subject. The subject and the header values can be templates.
+type NATSSink struct {
+	c         *NATSSinkConfig
+	subjectTp *template.Template
+	headerTps map[string]*template.Template
+	conn      *natsx.Conn
+	js        nats.JetStreamContext
+}
+
+func (m *NATSSink) Configure(props map[string]interface{}) error {
+	c := &NATSSinkConfig{}
+	if err := cast.MapToStruct(props, c); err != nil {
+		return fmt.Errorf("read properties %v fail with error: %v", props, err)
+	}
+	if c.Subject == "" {
+		return errors.New("property subject is required")
+	}
+	if err := c.Validate(); err != nil {
+		return err
+	}
+	if strings.Contains(c.Subject, "{{") {
+		tp, err := template.New("subject").Funcs(ct.FuncMap).Parse(c.Subject)
+		if err != nil {
+			return fmt.Errorf("invalid property subject %s: %v", c.Subject, err)
+		}
+		m.subjectTp = tp
+	} else if strings.ContainsAny(c.Subject, "*>") {
+		return fmt.Errorf("invalid property subject %s, wildcards are not allowed", c.Subject)
+	}
+	m.headerTps = make(map[string]*template.Template, len(c.Headers))
+	for k, v := range c.Headers {
+		tp, err := template.New(k).Funcs(ct.FuncMap).Parse(v)
+		if err != nil {
+			return fmt.Errorf("invalid header %s template %s: %v", k, v, err)
+		}
+		m.headerTps[k] = tp
+	}
+	m.c = c
+	return nil
+}
+
+func (m *NATSSink) Open(ctx api.StreamContext) error {
+	logger := ctx.GetLogger()
+	logger.Infof("Opening nats sink for rule %s.", ctx.GetRuleId())
+	conn, err := natsx.Connect(&m.c.ConnConf, fmt.Sprintf("%s_%s_%d", ctx.GetRuleId(), ctx.GetOpId(), ctx.GetInstanceId()), logger)
+	if err != nil {
+		return err
+	}
+	if m.c.JetStream {
+		js, err := conn.JetStream()
+		if err != nil {
+			conn.Close()
+			return err
+		}
+		m.js = js
+	}
+	m.conn = conn
+	return nil
+}
+
+func (m *NATSSink) Collect(ctx api.StreamContext, item interface{}) error {
+	logger := ctx.GetLogger()
+	data, ok := item.([]byte)
+	if !ok {
+		return fmt.Errorf("nats sink receives non byte data %v", item)
+	}
+	logger.Debugf("nats sink receive %s", data)
+	msg, err := m.message(data)
+	if err != nil {
+		return err
+	}
+	if m.js != nil {
+		if _, err := m.js.PublishMsg(msg); err != nil {
+			return fmt.Errorf("nats sink fails to publish to jetstream subject %s: %v", msg.Subject, err)
+		}
+		return nil
+	}
+	if err := m.conn.PublishMsg(msg); err != nil {
+		return fmt.Errorf("nats sink fails to publish to subject %s: %v", msg.Subject, err)
+	}
+	return nil
+}
+
+// message renders the subject and headers with the decoded data
+func (m *NATSSink) message(data []byte) (*nats.Msg, error) {
+	msg := nats.NewMsg(m.c.Subject)
+	msg.Data = data
+	if m.subjectTp == nil && len(m.headerTps) == 0 {
+		return msg, nil
+	}
+	var v interface{}
+	if err := json.Unmarshal(data, &v); err != nil {
+		return nil, fmt.Errorf("nats sink fails to decode %s for the templates: %v", data, err)
+	}
+	if m.subjectTp != nil {
+		var b bytes.Buffer
+		if err := m.subjectTp.Execute(&b, v); err != nil {
+			return nil, fmt.Errorf("nats sink fails to execute the subject template: %v", err)
+		}
+		msg.Subject = b.String()
+		if msg.Subject == "" || strings.ContainsAny(msg.Subject, "*> ") {
+			return nil, fmt.Errorf("nats sink gets invalid subject %q from the template", msg.Subject)
+		}
+	}
+	for k, tp := range m.headerTps {
+		var b bytes.Buffer
+		if err := tp.Execute(&b, v); err != nil {
+			return nil, fmt.Errorf("nats sink fails to execute the template of header %s: %v", k, err)
+		}
+		msg.Header.Set(k, b.String())
+	}
+	return msg, nil
+}
+
+func (m *NATSSink) ConnectionStatus() api.ConnectionStatus {
+	if m.conn == nil {
+		return api.ConnectionStatus{Status: api.ConnectionDisconnected}
+	}
+	return m.conn.ConnectionStatus()
+}
+
+func (m *NATSSink) Close(ctx api.StreamContext) error {
+	ctx.GetLogger().Infof("Closing nats sink")
+	if m.conn != nil {
+		if err := m.conn.Drain(); err != nil {
+			m.conn.Close()
+		}
+	}
+	return nil
+}
@@ -0,0 +1,105 @@
+// Copyright 2021 EMQ Technologies Co., Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sink
+
+import (
+	"github.com/lf-edge/ekuiper/internal/conf"
+	"github.com/lf-edge/ekuiper/internal/topo/context"
+	"github.com/nats-io/nats-server/v2/server"
+	"github.com/nats-io/nats.go"
+	"testing"
+	"time"
+)
+
+func TestNATSSink(t *testing.T) {
+	srv, err := server.NewServer(&server.Options{Host: "127.0.0.1", Port: -1, JetStream: true, StoreDir: t.TempDir(), NoLog: true, NoSigs: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	go srv.Start()
+	defer srv.Shutdown()
+	if !srv.ReadyForConnections(5 * time.Second) {
+		t.Fatal("nats server is not ready")
+	}
+	nc, err := nats.Connect(srv.ClientURL())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer nc.Close()
+	ch := make(chan *nats.Msg, 10)
+	if _, err := nc.ChanSubscribe("devices.>", ch); err != nil {
+		t.Fatal(err)
+	}
+	if err := nc.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := context.WithValue(context.Background(), context.LoggerKey, conf.Log)
+	s := &NATSSink{}
+	err = s.Configure(map[string]interface{}{
+		"server":  srv.ClientURL(),
+		"subject": "devices.{{.id}}",
+		"headers": map[string]interface{}{"X-Source": "{{.source}}"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Open(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Collect(ctx, []byte(`{"id":"d1","source":"/dev/1","v":1}`)); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case msg := <-ch:
+		if msg.Subject != "devices.d1" || msg.Header.Get("X-Source") != "/dev/1" || string(msg.Data) != `{"id":"d1","source":"/dev/1","v":1}` {
+			t.Errorf("unexpected message %s %v %s", msg.Subject, msg.Header, msg.Data)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timeout to receive message")
+	}
+	if err := s.Collect(ctx, []byte(`{"id":"*","v":1}`)); err == nil || err.Error() != `nats sink gets invalid subject "devices.*" from the template` {
+		t.Errorf("unexpected error %v", err)
+	}
+	s.Close(ctx)
+
+	// Publish to JetStream
+	js, err := nc.JetStream()
+	if err != nil {
+		t.Fatal(err)
+	}
+	s = &NATSSink{}
+	_ = s.Configure(map[string]interface{}{"server": srv.ClientURL(), "subject": "metrics.d1", "jetStream": true})
+	if err := s.Open(ctx); err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close(ctx)
+	if err := s.Collect(ctx, []byte(`{"v":1}`)); err == nil {
+		t.Error("expect error for no stream")
+	}
+	if _, err := js.AddStream(&nats.StreamConfig{Name: "METRICS", Subjects: []string{"metrics.>"}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Collect(ctx, []byte(`{"v":1}`)); err != nil {
+		t.Fatal(err)
+	}
+	info, err := js.StreamInfo("METRICS")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.State.Msgs != 1 {
+		t.Errorf("expect 1 message in stream but got %d", info.State.Msgs)
+	}
+}
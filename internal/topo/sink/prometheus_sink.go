@@ -0,0 +1,318 @@
+// Copyright 2021 EMQ Technologies Co., Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sink
+
+import (
+	"bytes"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"github.com/golang/snappy"
+	"github.com/lf-edge/ekuiper/internal/conf"
+	"github.com/lf-edge/ekuiper/pkg/api"
+	"github.com/lf-edge/ekuiper/pkg/cast"
+	"google.golang.org/protobuf/encoding/protowire"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+)
+
+type PrometheusSinkConfig struct {
+	Url                string            `json:"url"`
+	MetricPrefix       string            `json:"metricPrefix"`
+	Fields             []string          `json:"fields"`
+	Labels             []string          `json:"labels"`
+	ConstLabels        map[string]string `json:"constLabels"`
+	TsFieldName        string            `json:"tsFieldName"`
+	Headers            map[string]string `json:"headers"`
+	BearerToken        string            `json:"bearerToken"`
+	Username           string            `json:"username"`
+	Password           string            `json:"password"`
+	Timeout            int               `json:"timeout"`
+	InsecureSkipVerify bool              `json:"insecureSkipVerify"`
+}
+
+type promLabel struct {
+	name  string
+	value string
+}
+
+type promSample struct {
+	value float64
+	ts    int64
+}
+
+type promSeries struct {
+	labels  []promLabel
+	samples []promSample
+}
+
+// PrometheusSink sends the results to a Prometheus remote write endpoint. Each selected field of a result row is
+// converted to a sample of the series named by the field and labeled by the label columns.
+type PrometheusSink struct {
+	conf   *PrometheusSinkConfig
+	labels map[string]bool
+	client *http.Client
+}
+
+func (m *PrometheusSink) Configure(props map[string]interface{}) error {
+	c := &PrometheusSinkConfig{Timeout: 5000}
+	if err := cast.MapToStruct(props, c); err != nil {
+		return fmt.Errorf("read properties %v fail with error: %v", props, err)
+	}
+	if c.Url == "" {
+		return errors.New("property url is required")
+	}
+	if _, err := url.Parse(c.Url); err != nil {
+		return fmt.Errorf("invalid property url %s: %v", c.Url, err)
+	}
+	if c.BearerToken != "" && c.Username != "" {
+		return errors.New("only one of bearerToken and username can be set")
+	}
+	if c.Timeout <= 0 {
+		return fmt.Errorf("invalid property timeout: %d, must be a positive integer", c.Timeout)
+	}
+	for k := range c.ConstLabels {
+		if !validPromName(k, false) {
+			return fmt.Errorf("invalid label name %s in constLabels", k)
+		}
+	}
+	m.labels = make(map[string]bool, len(c.Labels))
+	for _, l := range c.Labels {
+		m.labels[l] = true
+	}
+	m.conf = c
+	return nil
+}
+
+func (m *PrometheusSink) Open(ctx api.StreamContext) error {
+	ctx.GetLogger().Infof("Opening prometheus sink for rule %s.", ctx.GetRuleId())
+	m.client = &http.Client{
+		Transport: &http.Transport{TLSClientConfig: &tls.Config{InsecureSkipVerify: m.conf.InsecureSkipVerify}},
+		Timeout:   time.Duration(m.conf.Timeout) * time.Millisecond,
+	}
+	return nil
+}
+
+func (m *PrometheusSink) Collect(ctx api.StreamContext, item interface{}) error {
+	logger := ctx.GetLogger()
+	v, ok := item.([]byte)
+	if !ok {
+		logger.Warnf("prometheus sink receive non []byte data: %v", item)
+		return nil
+	}
+	logger.Debugf("prometheus sink receive %s", item)
+	records, err := decodeRecords(v)
+	if err != nil {
+		return fmt.Errorf("prometheus sink %v", err)
+	}
+	series, err := m.series(records, conf.GetNowInMilli())
+	if err != nil {
+		return err
+	}
+	if len(series) == 0 {
+		logger.Debugf("prometheus sink drops %s without any sample", v)
+		return nil
+	}
+	return m.send(encodeWriteRequest(series))
+}
+
+// series converts the records to the time series. The samples of the same labels are merged into one series.
+func (m *PrometheusSink) series(records []map[string]interface{}, now int64) ([]*promSeries, error) {
+	var (
+		result []*promSeries
+		index  = make(map[string]*promSeries)
+	)
+	for _, r := range records {
+		ts := now
+		if m.conf.TsFieldName != "" {
+			v, ok := r[m.conf.TsFieldName]
+			if !ok {
+				return nil, fmt.Errorf("prometheus sink cannot find timestamp field %s in %v", m.conf.TsFieldName, r)
+			}
+			t, err := cast.ToInt64(v, cast.CONVERT_SAMEKIND)
+			if err != nil {
+				return nil, fmt.Errorf("prometheus sink gets invalid timestamp %v: %v", v, err)
+			}
+			ts = t
+		}
+		labels := make([]promLabel, 0, len(m.conf.Labels)+len(m.conf.ConstLabels)+1)
+		for k, v := range m.conf.ConstLabels {
+			labels = append(labels, promLabel{name: k, value: v})
+		}
+		for _, k := range m.conf.Labels {
+			if v, ok := r[k]; ok && v != nil {
+				if s := cast.ToStringAlways(v); s != "" {
+					labels = append(labels, promLabel{name: promName(k, false), value: s})
+				}
+			}
+		}
+		fields := m.conf.Fields
+		if len(fields) == 0 {
+			fields = make([]string, 0, len(r))
+			for k := range r {
+				if !m.labels[k] && k != m.conf.TsFieldName {
+					fields = append(fields, k)
+				}
+			}
+			sort.Strings(fields)
+		}
+		for _, k := range fields {
+			v, ok := r[k]
+			if !ok || v == nil {
+				continue
+			}
+			var f float64
+			switch vt := v.(type) {
+			case float64:
+				f = vt
+			case bool:
+				if vt {
+					f = 1
+				}
+			default:
+				// Only report the error for the explicitly selected fields
+				if len(m.conf.Fields) > 0 {
+					return nil, fmt.Errorf("prometheus sink gets non numeric value %v of field %s", v, k)
+				}
+				continue
+			}
+			sl := make([]promLabel, len(labels), len(labels)+1)
+			copy(sl, labels)
+			sl = append(sl, promLabel{name: "__name__", value: promName(m.conf.MetricPrefix+k, true)})
+			sort.Slice(sl, func(i, j int) bool { return sl[i].name < sl[j].name })
+			key := seriesKey(sl)
+			s, ok := index[key]
+			if !ok {
+				s = &promSeries{labels: sl}
+				index[key] = s
+				result = append(result, s)
+			}
+			s.samples = append(s.samples, promSample{value: f, ts: ts})
+		}
+	}
+	return result, nil
+}
+
+func seriesKey(labels []promLabel) string {
+	var b strings.Builder
+	for _, l := range labels {
+		b.WriteString(l.name)
+		b.WriteByte(0)
+		b.WriteString(l.value)
+		b.WriteByte(0)
+	}
+	return b.String()
+}
+
+// promName replaces the invalid characters of the metric or label name with underscore
+func promName(s string, metric bool) string {
+	if validPromName(s, metric) {
+		return s
+	}
+	b := []byte(s)
+	for i, c := range b {
+		if !validPromChar(c, i, metric) {
+			b[i] = '_'
+		}
+	}
+	if len(b) == 0 {
+		return "_"
+	}
+	return string(b)
+}
+
+func validPromName(s string, metric bool) bool {
+	if s == "" {
+		return false
+	}
+	for i := 0; i < len(s); i++ {
+		if !validPromChar(s[i], i, metric) {
+			return false
+		}
+	}
+	return true
+}
+
+func validPromChar(c byte, i int, metric bool) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c == '_' || (metric && c == ':') || (i > 0 && c >= '0' && c <= '9')
+}
+
+// encodeWriteRequest encodes the series as the protobuf of prometheus.WriteRequest
+func encodeWriteRequest(series []*promSeries) []byte {
+	var buf []byte
+	for _, s := range series {
+		var ts []byte
+		for _, l := range s.labels {
+			var lb []byte
+			lb = protowire.AppendTag(lb, 1, protowire.BytesType)
+			lb = protowire.AppendString(lb, l.name)
+			lb = protowire.AppendTag(lb, 2, protowire.BytesType)
+			lb = protowire.AppendString(lb, l.value)
+			ts = protowire.AppendTag(ts, 1, protowire.BytesType)
+			ts = protowire.AppendBytes(ts, lb)
+		}
+		for _, sp := range s.samples {
+			var sb []byte
+			sb = protowire.AppendTag(sb, 1, protowire.Fixed64Type)
+			sb = protowire.AppendFixed64(sb, math.Float64bits(sp.value))
+			sb = protowire.AppendTag(sb, 2, protowire.VarintType)
+			sb = protowire.AppendVarint(sb, uint64(sp.ts))
+			ts = protowire.AppendTag(ts, 2, protowire.BytesType)
+			ts = protowire.AppendBytes(ts, sb)
+		}
+		buf = protowire.AppendTag(buf, 1, protowire.BytesType)
+		buf = protowire.AppendBytes(buf, ts)
+	}
+	return buf
+}
+
+func (m *PrometheusSink) send(data []byte) error {
+	req, err := http.NewRequest(http.MethodPost, m.conf.Url, bytes.NewReader(snappy.Encode(nil, data)))
+	if err != nil {
+		return err
+	}
+	for k, v := range m.conf.Headers {
+		req.Header.Set(k, v)
+	}
+	req.Header.Set("Content-Encoding", "snappy")
+	req.Header.Set("Content-Type", "application/x-protobuf")
+	req.Header.Set("X-Prometheus-Remote-Write-Version", "0.1.0")
+	switch {
+	case m.conf.BearerToken != "":
+		req.Header.Set("Authorization", "Bearer "+m.conf.BearerToken)
+	case m.conf.Username != "":
+		req.SetBasicAuth(m.conf.Username, m.conf.Password)
+	}
+	resp, err := m.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("prometheus sink fails to send out the data: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		buf, _ := ioutil.ReadAll(resp.Body)
+		return fmt.Errorf("prometheus sink fails with http return code: %d and error message %s", resp.StatusCode, buf)
+	}
+	return nil
+}
+
+func (m *PrometheusSink) Close(ctx api.StreamContext) error {
+	ctx.GetLogger().Infof("Closing prometheus sink")
+	return nil
+}
@@ -0,0 +1,215 @@
+// Copyright 2021 EMQ Technologies Co., Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sink
+
+import (
+	"fmt"
+	"github.com/golang/snappy"
+	"github.com/lf-edge/ekuiper/internal/conf"
+	"github.com/lf-edge/ekuiper/internal/topo/context"
+	"github.com/lf-edge/ekuiper/internal/topo/topotest/mockclock"
+	"google.golang.org/protobuf/encoding/protowire"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// decodeWriteRequest decodes the remote write request to the series strings like `name{k="v"} value@ts`
+func decodeWriteRequest(b []byte) ([]string, error) {
+	var result []string
+	err := decodeMessage(b, func(num protowire.Number, v []byte) error {
+		var (
+			labels  []string
+			samples []string
+		)
+		err := decodeMessage(v, func(num protowire.Number, v []byte) error {
+			switch num {
+			case 1:
+				var name, value string
+				err := decodeMessage(v, func(num protowire.Number, v []byte) error {
+					if num == 1 {
+						name = string(v)
+					} else {
+						value = string(v)
+					}
+					return nil
+				})
+				labels = append(labels, fmt.Sprintf("%s=%q", name, value))
+				return err
+			case 2:
+				var (
+					value float64
+					ts    int64
+				)
+				for len(v) > 0 {
+					num, typ, n := protowire.ConsumeTag(v)
+					if n < 0 {
+						return protowire.ParseError(n)
+					}
+					v = v[n:]
+					if num == 1 && typ == protowire.Fixed64Type {
+						f, n := protowire.ConsumeFixed64(v)
+						value = math.Float64frombits(f)
+						v = v[n:]
+					} else {
+						t, n := protowire.ConsumeVarint(v)
+						ts = int64(t)
+						v = v[n:]
+					}
+				}
+				samples = append(samples, fmt.Sprintf("%v@%d", value, ts))
+			}
+			return nil
+		})
+		result = append(result, fmt.Sprintf("{%s} %s", strings.Join(labels, ","), strings.Join(samples, ",")))
+		return err
+	})
+	return result, err
+}
+
+func decodeMessage(b []byte, f func(num protowire.Number, v []byte) error) error {
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 || typ != protowire.BytesType {
+			return fmt.Errorf("invalid tag")
+		}
+		b = b[n:]
+		v, n := protowire.ConsumeBytes(b)
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		b = b[n:]
+		if err := f(num, v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func TestPrometheusSink(t *testing.T) {
+	mockclock.ResetClock(10000)
+	var (
+		mu       sync.Mutex
+		requests [][]string
+		auth     string
+	)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Content-Encoding") != "snappy" || r.Header.Get("Content-Type") != "application/x-protobuf" {
+			http.Error(w, "invalid headers", http.StatusBadRequest)
+			return
+		}
+		body, _ := ioutil.ReadAll(r.Body)
+		data, err := snappy.Decode(nil, body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		series, err := decodeWriteRequest(data)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		sort.Strings(series)
+		mu.Lock()
+		requests = append(requests, series)
+		auth = r.Header.Get("Authorization")
+		mu.Unlock()
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer ts.Close()
+
+	var tests = []struct {
+		props    map[string]interface{}
+		data     [][]byte
+		requests [][]string
+		auth     string
+		err      string
+	}{
+		{
+			props: map[string]interface{}{
+				"labels":      []interface{}{"device", "room-id"},
+				"constLabels": map[string]interface{}{"job": "ekuiper"},
+				"bearerToken": "abc",
+			},
+			data: [][]byte{
+				[]byte(`[{"device":"d1","room-id":1,"temperature":25.5,"on":true,"status":"ok"},{"device":"d1","room-id":1,"temperature":26,"on":false}]`),
+			},
+			requests: [][]string{{
+				`{__name__="on",device="d1",job="ekuiper",room_id="1"} 1@10000,0@10000`,
+				`{__name__="temperature",device="d1",job="ekuiper",room_id="1"} 25.5@10000,26@10000`,
+			}},
+			auth: "Bearer abc",
+		}, {
+			props: map[string]interface{}{
+				"metricPrefix": "ekuiper_",
+				"fields":       []interface{}{"cpu.usage"},
+				"labels":       []interface{}{"host"},
+				"tsFieldName":  "ts",
+				"username":     "u",
+				"password":     "p",
+			},
+			data: [][]byte{
+				[]byte(`{"host":"h1","cpu.usage":0.5,"mem":100,"ts":1630000000000}`),
+				[]byte(`{"host":"h2","mem":100,"ts":1630000000000}`),
+				[]byte(`{"host":"h1","cpu.usage":"high","ts":1630000000000}`),
+			},
+			requests: [][]string{{
+				`{__name__="ekuiper_cpu_usage",host="h1"} 0.5@1630000000000`,
+			}},
+			auth: "Basic dTpw",
+			err:  "prometheus sink gets non numeric value high of field cpu.usage",
+		},
+	}
+	ctx := context.WithValue(context.Background(), context.LoggerKey, conf.Log)
+	for i, tt := range tests {
+		requests = nil
+		tt.props["url"] = ts.URL
+		s := &PrometheusSink{}
+		if err := s.Configure(tt.props); err != nil {
+			t.Errorf("%d: configure error %v", i, err)
+			continue
+		}
+		if err := s.Open(ctx); err != nil {
+			t.Errorf("%d: open error %v", i, err)
+			continue
+		}
+		var errs []string
+		for _, d := range tt.data {
+			if err := s.Collect(ctx, d); err != nil {
+				errs = append(errs, err.Error())
+			}
+		}
+		_ = s.Close(ctx)
+		if strings.Join(errs, ";") != tt.err {
+			t.Errorf("%d: expect error %s but got %v", i, tt.err, errs)
+		}
+		mu.Lock()
+		if !reflect.DeepEqual(tt.requests, requests) || auth != tt.auth {
+			t.Errorf("%d: result mismatch:\n\nexp=%v %s\n\ngot=%v %s\n\n", i, tt.requests, tt.auth, requests, auth)
+		}
+		mu.Unlock()
+	}
+
+	s := &PrometheusSink{}
+	if err := s.Configure(map[string]interface{}{"url": ts.URL, "constLabels": map[string]interface{}{"1a": "b"}}); err == nil || err.Error() != "invalid label name 1a in constLabels" {
+		t.Errorf("unexpected error %v", err)
+	}
+}
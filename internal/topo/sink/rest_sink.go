@@ -15,17 +15,60 @@
 package sink
 
 import (
+	"bytes"
 	"crypto/tls"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"github.com/lf-edge/ekuiper/internal/pkg/httpserver"
 	"github.com/lf-edge/ekuiper/internal/pkg/httpx"
+	ct "github.com/lf-edge/ekuiper/internal/template"
 	"github.com/lf-edge/ekuiper/pkg/api"
+	"github.com/lf-edge/ekuiper/pkg/cast"
 	"io/ioutil"
 	"net/http"
 	"net/url"
+	"sort"
+	"strconv"
 	"strings"
+	"sync"
+	"text/template"
 	"time"
 )
 
+// The status codes which are retried by default when maxRetries is set
+var defaultRetryStatusCodes = []int{http.StatusTooManyRequests, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout}
+
+// restConf is the configuration of the templating, batching, retrying, authentication and response capturing
+type restConf struct {
+	BatchSize        int              `json:"batchSize"`
+	LingerInterval   int              `json:"lingerInterval"`
+	MaxRetries       int              `json:"maxRetries"`
+	RetryStatusCodes []int            `json:"retryStatusCodes"`
+	RetryBackoff     int              `json:"retryBackoff"`
+	MaxRetryBackoff  int              `json:"maxRetryBackoff"`
+	BearerToken      string           `json:"bearerToken"`
+	OAuth            *httpx.OAuthConf `json:"oauth"`
+	ResponseEndpoint string           `json:"responseEndpoint"`
+	// The common sink property which limits the times a result of the failed batch is sent again
+	RetryCount int `json:"retryCount"`
+}
+
+// restBatch is the data collected for the same url and headers to be sent in one request
+type restBatch struct {
+	url     string
+	headers map[string]string
+	items   [][]byte
+	// The times each item has been put back after the batch fails
+	retries []int
+	timer   *time.Timer
+}
+
+// retriableError is the error of sending which may succeed if sent again later such as the network error
+type retriableError struct {
+	error
+}
+
 type RestSink struct {
 	method             string
 	url                string
@@ -35,8 +78,17 @@ type RestSink struct {
 	sendSingle         bool
 	debugResp          bool
 	insecureSkipVerify bool
+	conf               *restConf
+	urlTp              *template.Template
+	headerTps          map[string]*template.Template
+	retryCodes         map[int]bool
 
 	client *http.Client
+	tokens *httpx.TokenSource
+	ctx    api.StreamContext
+
+	mu      sync.Mutex
+	batches map[string]*restBatch
 }
 
 var methodsMap = map[string]bool{"GET": true, "HEAD": true, "POST": true, "PUT": true, "DELETE": true, "PATCH": true}
@@ -140,6 +192,77 @@ func (ms *RestSink) Configure(ps map[string]interface{}) error {
 			return fmt.Errorf("rest sink property insecureSkipVerify %v is not a bool", temp)
 		}
 	}
+	return ms.configureExt(ps)
+}
+
+// configureExt parses the properties of the templating, batching, retrying, authentication and response capturing
+func (ms *RestSink) configureExt(ps map[string]interface{}) error {
+	c := &restConf{LingerInterval: 1000, RetryBackoff: 1000, MaxRetryBackoff: 30000, RetryCount: 3}
+	if err := cast.MapToStruct(ps, c); err != nil {
+		return fmt.Errorf("read properties %v fail with error: %v", ps, err)
+	}
+	if strings.Contains(ms.url, "{{") {
+		tp, err := template.New("url").Funcs(ct.FuncMap).Parse(ms.url)
+		if err != nil {
+			return fmt.Errorf("invalid property url %s: %v", ms.url, err)
+		}
+		ms.urlTp = tp
+	}
+	ms.headerTps = nil
+	for k, v := range ms.headers {
+		if strings.Contains(v, "{{") {
+			tp, err := template.New(k).Funcs(ct.FuncMap).Parse(v)
+			if err != nil {
+				return fmt.Errorf("invalid header %s template %s: %v", k, v, err)
+			}
+			if ms.headerTps == nil {
+				ms.headerTps = make(map[string]*template.Template)
+			}
+			ms.headerTps[k] = tp
+		}
+	}
+	if c.BatchSize < 0 {
+		return fmt.Errorf("invalid property batchSize: %d, must not be negative", c.BatchSize)
+	}
+	if c.BatchSize > 1 {
+		switch ms.bodyType {
+		case "none", "form":
+			return fmt.Errorf("batchSize is not supported for bodyType %s", ms.bodyType)
+		}
+		if c.LingerInterval <= 0 {
+			return fmt.Errorf("invalid property lingerInterval: %d, must be a positive integer", c.LingerInterval)
+		}
+	}
+	if c.RetryCount < 0 {
+		return fmt.Errorf("invalid property retryCount: %d, must not be negative", c.RetryCount)
+	}
+	if c.MaxRetries < 0 {
+		return fmt.Errorf("invalid property maxRetries: %d, must not be negative", c.MaxRetries)
+	}
+	if c.RetryBackoff <= 0 {
+		return fmt.Errorf("invalid property retryBackoff: %d, must be a positive integer", c.RetryBackoff)
+	}
+	if c.MaxRetryBackoff < c.RetryBackoff {
+		c.MaxRetryBackoff = c.RetryBackoff
+	}
+	codes := c.RetryStatusCodes
+	if codes == nil {
+		codes = defaultRetryStatusCodes
+	}
+	ms.retryCodes = make(map[int]bool, len(codes))
+	for _, code := range codes {
+		if code < 100 || code > 599 {
+			return fmt.Errorf("invalid retry status code %d", code)
+		}
+		ms.retryCodes[code] = true
+	}
+	if c.OAuth != nil && c.BearerToken != "" {
+		return errors.New("only one of oauth and bearerToken can be set")
+	}
+	if c.ResponseEndpoint != "" && !strings.HasPrefix(c.ResponseEndpoint, "/") {
+		c.ResponseEndpoint = "/" + c.ResponseEndpoint
+	}
+	ms.conf = c
 	return nil
 }
 
@@ -153,9 +276,20 @@ func (ms *RestSink) Open(ctx api.StreamContext) error {
 		Timeout:   time.Duration(ms.timeout) * time.Millisecond}
 	logger.Infof("open rest sink with configuration: {method: %s, url: %s, bodyType: %s, timeout: %d,header: %v, sendSingle: %v, insecureSkipVerify: %v", ms.method, ms.url, ms.bodyType, ms.timeout, ms.headers, ms.sendSingle, ms.insecureSkipVerify)
 
-	if _, err := url.Parse(ms.url); err != nil {
-		return err
+	if ms.urlTp == nil {
+		if _, err := url.Parse(ms.url); err != nil {
+			return err
+		}
 	}
+	if ms.conf.OAuth != nil {
+		ts, err := httpx.NewTokenSource(ms.conf.OAuth, ms.client)
+		if err != nil {
+			return err
+		}
+		ms.tokens = ts
+	}
+	ms.ctx = ctx
+	ms.batches = make(map[string]*restBatch)
 	return nil
 }
 
@@ -181,31 +315,341 @@ func (ms *RestSink) Collect(ctx api.StreamContext, item interface{}) error {
 		logger.Warnf("rest sink receive non []byte data: %v", item)
 	}
 	logger.Debugf("rest sink receive %s", item)
-	resp, err := ms.Send(v, logger)
+	u, headers, err := ms.render(v)
 	if err != nil {
-		return fmt.Errorf("rest sink fails to send out the data: %s", err)
-	} else {
-		defer resp.Body.Close()
-		logger.Debugf("rest sink got response %v", resp)
-		if resp.StatusCode < 200 || resp.StatusCode > 299 {
-			if buf, bodyErr := ioutil.ReadAll(resp.Body); bodyErr != nil {
-				logger.Errorf("%s\n", bodyErr)
-				return fmt.Errorf("rest sink fails to err http return code: %d and error message %s.", resp.StatusCode, bodyErr)
-			} else {
-				logger.Errorf("%s\n", string(buf))
-				return fmt.Errorf("rest sink fails to err http return code: %d and error message %s.", resp.StatusCode, string(buf))
+		return err
+	}
+	if ms.conf.BatchSize > 1 {
+		return ms.batch(ctx, u, headers, v)
+	}
+	return ms.sendWithRetry(ctx, u, headers, v)
+}
+
+// render executes the url and header templates with the decoded data
+func (ms *RestSink) render(v []byte) (string, map[string]string, error) {
+	if ms.urlTp == nil && ms.headerTps == nil {
+		return ms.url, ms.headers, nil
+	}
+	var data interface{}
+	if err := json.Unmarshal(v, &data); err != nil {
+		return "", nil, fmt.Errorf("rest sink fails to decode %s for the templates: %v", v, err)
+	}
+	u := ms.url
+	if ms.urlTp != nil {
+		var b bytes.Buffer
+		if err := ms.urlTp.Execute(&b, data); err != nil {
+			return "", nil, fmt.Errorf("rest sink fails to execute the url template: %v", err)
+		}
+		u = b.String()
+	}
+	headers := ms.headers
+	if ms.headerTps != nil {
+		headers = make(map[string]string, len(ms.headers))
+		for k, h := range ms.headers {
+			if tp, ok := ms.headerTps[k]; ok {
+				var b bytes.Buffer
+				if err := tp.Execute(&b, data); err != nil {
+					return "", nil, fmt.Errorf("rest sink fails to execute the template of header %s: %v", k, err)
+				}
+				h = b.String()
 			}
+			headers[k] = h
+		}
+	}
+	return u, headers, nil
+}
+
+// batch adds the data to the batch of the url and headers. The batch is sent when it is full or lingers too long.
+// If the full batch fails to send, the data is returned as error to be retried by the caller and the others in
+// the batch are kept to send with the next batch.
+func (ms *RestSink) batch(ctx api.StreamContext, u string, headers map[string]string, v []byte) error {
+	if ms.bodyType == "json" && !json.Valid(v) {
+		return fmt.Errorf("rest sink fails to decode %s for batching", v)
+	}
+	key := batchKey(u, headers)
+	ms.mu.Lock()
+	b, ok := ms.batches[key]
+	if !ok {
+		b = ms.newBatch(key, u, headers)
+	}
+	b.items = append(b.items, v)
+	b.retries = append(b.retries, 0)
+	full := len(b.items) >= ms.conf.BatchSize
+	ms.mu.Unlock()
+	if full {
+		return ms.flushBatch(ctx, key, b, true)
+	}
+	return nil
+}
+
+// newBatch creates the batch of the key which is sent when lingers too long. Must be called with the lock held.
+func (ms *RestSink) newBatch(key string, u string, headers map[string]string) *restBatch {
+	b := &restBatch{url: u, headers: headers}
+	ms.batches[key] = b
+	b.timer = time.AfterFunc(time.Duration(ms.conf.LingerInterval)*time.Millisecond, func() {
+		if err := ms.flushBatch(ms.ctx, key, b, false); err != nil {
+			ms.ctx.GetLogger().Error(err)
+		}
+	})
+	return b
+}
+
+func batchKey(u string, headers map[string]string) string {
+	keys := make([]string, 0, len(headers))
+	for k := range headers {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var b strings.Builder
+	b.WriteString(u)
+	for _, k := range keys {
+		b.WriteString("\n")
+		b.WriteString(k)
+		b.WriteString(":")
+		b.WriteString(headers[k])
+	}
+	return b.String()
+}
+
+// flushBatch sends the batch if it is still pending. The batch may be already sent by the other trigger. If
+// failed to send and it is retriable, the items are put back except the last one if excludeLast is set, which
+// is left to the caller to retry.
+func (ms *RestSink) flushBatch(ctx api.StreamContext, key string, b *restBatch, excludeLast bool) error {
+	ms.mu.Lock()
+	if ms.batches[key] != b {
+		ms.mu.Unlock()
+		return nil
+	}
+	delete(ms.batches, key)
+	b.timer.Stop()
+	ms.mu.Unlock()
+	body, err := ms.mergeBatch(b.items)
+	if err != nil {
+		return err
+	}
+	err = ms.sendWithRetry(ctx, b.url, b.headers, body)
+	if _, ok := err.(retriableError); ok {
+		n := len(b.items)
+		if excludeLast {
+			n--
+		}
+		if dropped := ms.restore(key, b, n); dropped > 0 {
+			err = fmt.Errorf("%v, drop %d results which exceed the retry count or the batch size", err, dropped)
+		}
+	}
+	return err
+}
+
+// restore puts the first n items of the failed batch back before the items collected since then. The items which
+// have been put back for retryCount times are dropped. The batch keeps at most batchSize items so that it does not
+// grow without limit while the endpoint is down, the oldest items exceeding the size are dropped. It returns the
+// count of the dropped items.
+func (ms *RestSink) restore(key string, b *restBatch, n int) int {
+	var (
+		items   [][]byte
+		retries []int
+		dropped int
+	)
+	for i := 0; i < n; i++ {
+		if b.retries[i] >= ms.conf.RetryCount {
+			dropped++
+			continue
+		}
+		items = append(items, b.items[i])
+		retries = append(retries, b.retries[i]+1)
+	}
+	if len(items) == 0 {
+		return dropped
+	}
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	nb, ok := ms.batches[key]
+	if !ok {
+		nb = ms.newBatch(key, b.url, b.headers)
+	}
+	items = append(items, nb.items...)
+	retries = append(retries, nb.retries...)
+	if d := len(items) - ms.conf.BatchSize; d > 0 {
+		items, retries = items[d:], retries[d:]
+		dropped += d
+	}
+	nb.items, nb.retries = items, retries
+	return dropped
+}
+
+// mergeBatch combines the items into one body. The json items are merged into one array and the others are
+// joined by new lines.
+func (ms *RestSink) mergeBatch(items [][]byte) ([]byte, error) {
+	if ms.bodyType != "json" {
+		return bytes.Join(items, []byte("\n")), nil
+	}
+	var merged []interface{}
+	for _, item := range items {
+		var data interface{}
+		if err := json.Unmarshal(item, &data); err != nil {
+			return nil, fmt.Errorf("rest sink fails to decode %s for batching: %v", item, err)
+		}
+		if arr, ok := data.([]interface{}); ok {
+			merged = append(merged, arr...)
 		} else {
-			if ms.debugResp {
-				if buf, bodyErr := ioutil.ReadAll(resp.Body); bodyErr != nil {
+			merged = append(merged, data)
+		}
+	}
+	return json.Marshal(merged)
+}
+
+// Flush sends all the pending batches
+func (ms *RestSink) Flush(ctx api.StreamContext) error {
+	ms.mu.Lock()
+	batches := make(map[string]*restBatch, len(ms.batches))
+	for k, b := range ms.batches {
+		batches[k] = b
+	}
+	ms.mu.Unlock()
+	var errs MultiErrors
+	for k, b := range batches {
+		if err := ms.flushBatch(ctx, k, b, false); err != nil {
+			errs = errs.AddError(err)
+		}
+	}
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+// sendWithRetry sends the data and retries with exponential backoff for the network errors and the retriable
+// status codes. If the token is rejected, it is fetched again and the request is resent once. If the retries
+// are exhausted, a retriableError is returned.
+func (ms *RestSink) sendWithRetry(ctx api.StreamContext, u string, headers map[string]string, v []byte) error {
+	logger := ctx.GetLogger()
+	backoff := time.Duration(ms.conf.RetryBackoff) * time.Millisecond
+	maxBackoff := time.Duration(ms.conf.MaxRetryBackoff) * time.Millisecond
+	reauth := ms.tokens != nil
+	for attempt := 0; ; attempt++ {
+		h, err := ms.authHeaders(headers)
+		if err != nil {
+			return retriableError{fmt.Errorf("rest sink fails to get the token: %v", err)}
+		}
+		resp, err := httpx.Send(logger, ms.client, ms.bodyType, ms.method, u, h, ms.sendSingle, v)
+		var (
+			retry bool
+			wait  = backoff
+		)
+		if err != nil {
+			err = fmt.Errorf("rest sink fails to send out the data: %s", err)
+			retry = true
+		} else {
+			buf, bodyErr := ioutil.ReadAll(resp.Body)
+			resp.Body.Close()
+			logger.Debugf("rest sink got response %v", resp)
+			switch {
+			case resp.StatusCode >= 200 && resp.StatusCode <= 299:
+				if bodyErr != nil {
 					logger.Errorf("%s\n", bodyErr)
-				} else {
+					return nil
+				}
+				if ms.debugResp {
 					logger.Infof("Response content: %s\n", string(buf))
 				}
+				ms.capture(ctx, u, resp, buf)
+				return nil
+			case resp.StatusCode == http.StatusUnauthorized && reauth:
+				// The token may be revoked before expired, fetch a new one and resend immediately
+				reauth = false
+				ms.tokens.Invalidate()
+				attempt--
+				continue
+			}
+			if bodyErr != nil {
+				buf = []byte(bodyErr.Error())
 			}
+			logger.Errorf("%s\n", string(buf))
+			err = fmt.Errorf("rest sink fails to err http return code: %d and error message %s.", resp.StatusCode, string(buf))
+			retry = ms.retryCodes[resp.StatusCode]
+			if d, ok := retryAfter(resp); ok {
+				wait = d
+			}
+		}
+		if !retry {
+			return err
+		}
+		if attempt >= ms.conf.MaxRetries {
+			return retriableError{err}
+		}
+		if wait > maxBackoff {
+			wait = maxBackoff
+		}
+		logger.Warnf("%v, retry in %v", err, wait)
+		select {
+		case <-time.After(wait):
+		case <-ctx.Done():
+			return retriableError{err}
+		}
+		backoff *= 2
+		if backoff > maxBackoff {
+			backoff = maxBackoff
 		}
 	}
-	return nil
+}
+
+// retryAfter parses the Retry-After header in seconds or http date
+func retryAfter(resp *http.Response) (time.Duration, bool) {
+	h := resp.Header.Get("Retry-After")
+	if h == "" {
+		return 0, false
+	}
+	if s, err := strconv.Atoi(h); err == nil && s >= 0 {
+		return time.Duration(s) * time.Second, true
+	}
+	if t, err := http.ParseTime(h); err == nil {
+		d := t.Sub(time.Now())
+		if d < 0 {
+			d = 0
+		}
+		return d, true
+	}
+	return 0, false
+}
+
+// authHeaders returns the headers with the Authorization header of the configured token
+func (ms *RestSink) authHeaders(headers map[string]string) (map[string]string, error) {
+	var auth string
+	switch {
+	case ms.tokens != nil:
+		a, err := ms.tokens.Authorization()
+		if err != nil {
+			return nil, err
+		}
+		auth = a
+	case ms.conf.BearerToken != "":
+		auth = "Bearer " + ms.conf.BearerToken
+	default:
+		return headers, nil
+	}
+	h := make(map[string]string, len(headers)+1)
+	for k, v := range headers {
+		h[k] = v
+	}
+	h["Authorization"] = auth
+	return h, nil
+}
+
+// capture delivers the response body to the http push streams listening to the response endpoint
+func (ms *RestSink) capture(ctx api.StreamContext, u string, resp *http.Response, body []byte) {
+	if ms.conf.ResponseEndpoint == "" || len(bytes.TrimSpace(body)) == 0 {
+		return
+	}
+	meta := make(map[string]interface{}, len(resp.Header)+2)
+	for k, v := range resp.Header {
+		meta[k] = strings.Join(v, ",")
+	}
+	meta["url"] = u
+	meta["statusCode"] = resp.StatusCode
+	meta["method"] = ms.method
+	if err := httpserver.Deliver(ms.conf.ResponseEndpoint, body, meta); err != nil {
+		ctx.GetLogger().Warnf("rest sink fails to capture the response: %v", err)
+	}
 }
 
 func (ms *RestSink) Send(v interface{}, logger api.Logger) (*http.Response, error) {
@@ -215,5 +659,17 @@ func (ms *RestSink) Send(v interface{}, logger api.Logger) (*http.Response, erro
 func (ms *RestSink) Close(ctx api.StreamContext) error {
 	logger := ctx.GetLogger()
 	logger.Infof("Closing rest sink")
+	if ms.batches != nil {
+		if err := ms.Flush(ctx); err != nil {
+			logger.Errorf("rest sink fails to send the pending batches: %v", err)
+		}
+		// Drop the batches put back by the failure
+		ms.mu.Lock()
+		for _, b := range ms.batches {
+			b.timer.Stop()
+		}
+		ms.batches = make(map[string]*restBatch)
+		ms.mu.Unlock()
+	}
 	return nil
 }
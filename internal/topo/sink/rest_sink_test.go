@@ -18,12 +18,16 @@ import (
 	"encoding/json"
 	"fmt"
 	"github.com/lf-edge/ekuiper/internal/conf"
+	"github.com/lf-edge/ekuiper/internal/pkg/httpserver"
 	"github.com/lf-edge/ekuiper/internal/topo/context"
 	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
 	"reflect"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 )
 
 type request struct {
@@ -369,3 +373,357 @@ func TestRestSinkTemplate_Apply(t *testing.T) {
 		}
 	}
 }
+
+type restRequest struct {
+	Url  string
+	Body string
+	Auth string
+	Dev  string
+}
+
+type restRecorder struct {
+	sync.Mutex
+	requests []restRequest
+	// the status codes to return in order, 200 if exhausted
+	codes []int
+}
+
+func (r *restRecorder) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if req.URL.Path == "/token" {
+		fmt.Fprint(w, `{"access_token":"t1","expires_in":3600}`)
+		return
+	}
+	body, _ := ioutil.ReadAll(req.Body)
+	r.Lock()
+	r.requests = append(r.requests, restRequest{Url: req.URL.RequestURI(), Body: string(body), Auth: req.Header.Get("Authorization"), Dev: req.Header.Get("X-Device")})
+	code := http.StatusOK
+	if len(r.codes) > 0 {
+		code, r.codes = r.codes[0], r.codes[1:]
+	}
+	r.Unlock()
+	if code == http.StatusServiceUnavailable {
+		w.Header().Set("Retry-After", "0")
+	}
+	w.WriteHeader(code)
+	fmt.Fprintf(w, `{"received":%d}`, len(body))
+}
+
+func (r *restRecorder) result() []restRequest {
+	r.Lock()
+	defer r.Unlock()
+	return r.requests
+}
+
+func TestRestSinkExt(t *testing.T) {
+	var tests = []struct {
+		config map[string]interface{}
+		codes  []int
+		data   []string
+		result []restRequest
+		err    string
+	}{
+		{
+			config: map[string]interface{}{
+				"method":     "post",
+				"url":        "{{.base}}/devices/{{.id}}",
+				"headers":    map[string]interface{}{"X-Device": "dev-{{.id}}"},
+				"sendSingle": true,
+			},
+			data: []string{`{"id":1,"v":1}`, `{"id":2,"v":2}`},
+			result: []restRequest{
+				{Url: "/devices/1", Body: `{"id":1,"v":1}`, Dev: "dev-1"},
+				{Url: "/devices/2", Body: `{"id":2,"v":2}`, Dev: "dev-2"},
+			},
+		}, {
+			config: map[string]interface{}{
+				"method":     "post",
+				"url":        "{{.base}}/batch/{{.id}}",
+				"sendSingle": true,
+				"batchSize":  2,
+			},
+			data: []string{`{"id":1,"v":1}`, `{"id":2,"v":2}`, `{"id":1,"v":3}`, `{"id":2,"v":4}`, `{"id":1,"v":5}`},
+			result: []restRequest{
+				{Url: "/batch/1", Body: `[{"id":1,"v":1},{"id":1,"v":3}]`},
+				{Url: "/batch/2", Body: `[{"id":2,"v":2},{"id":2,"v":4}]`},
+				// flushed when closing
+				{Url: "/batch/1", Body: `[{"id":1,"v":5}]`},
+			},
+		}, {
+			config: map[string]interface{}{
+				"method":      "post",
+				"url":         "{{.base}}/retry",
+				"sendSingle":  true,
+				"maxRetries":  2,
+				"bearerToken": "abc",
+			},
+			codes: []int{http.StatusServiceUnavailable, http.StatusServiceUnavailable},
+			data:  []string{`{"v":1}`},
+			result: []restRequest{
+				{Url: "/retry", Body: `{"v":1}`, Auth: "Bearer abc"},
+				{Url: "/retry", Body: `{"v":1}`, Auth: "Bearer abc"},
+				{Url: "/retry", Body: `{"v":1}`, Auth: "Bearer abc"},
+			},
+		}, {
+			config: map[string]interface{}{
+				"method":     "post",
+				"url":        "{{.base}}/retry",
+				"sendSingle": true,
+				"maxRetries": 1,
+			},
+			codes: []int{http.StatusServiceUnavailable, http.StatusServiceUnavailable},
+			data:  []string{`{"v":1}`},
+			result: []restRequest{
+				{Url: "/retry", Body: `{"v":1}`},
+				{Url: "/retry", Body: `{"v":1}`},
+			},
+			err: `rest sink fails to err http return code: 503 and error message {"received":7}.`,
+		}, {
+			config: map[string]interface{}{
+				"method":     "post",
+				"url":        "{{.base}}/fail",
+				"sendSingle": true,
+				"maxRetries": 3,
+			},
+			codes: []int{http.StatusBadRequest},
+			data:  []string{`{"v":1}`},
+			result: []restRequest{
+				{Url: "/fail", Body: `{"v":1}`},
+			},
+			err: `rest sink fails to err http return code: 400 and error message {"received":7}.`,
+		}, {
+			config: map[string]interface{}{
+				"method":     "post",
+				"url":        "{{.base}}/oauth",
+				"sendSingle": true,
+				"oauth":      map[string]interface{}{"tokenUrl": "{{.base}}/token", "clientId": "c1"},
+			},
+			codes: []int{http.StatusUnauthorized},
+			data:  []string{`{"v":1}`},
+			result: []restRequest{
+				{Url: "/oauth", Body: `{"v":1}`, Auth: "Bearer t1"},
+				{Url: "/oauth", Body: `{"v":1}`, Auth: "Bearer t1"},
+			},
+		},
+	}
+	contextLogger := conf.Log.WithField("rule", "TestRestSinkExt")
+	ctx := context.WithValue(context.Background(), context.LoggerKey, contextLogger)
+	for i, tt := range tests {
+		rec := &restRecorder{codes: tt.codes}
+		ts := httptest.NewServer(rec)
+		s := &RestSink{}
+		tt.config["retryBackoff"] = 10
+		if u, ok := tt.config["url"].(string); ok {
+			tt.config["url"] = strings.ReplaceAll(u, "{{.base}}", ts.URL)
+		}
+		if o, ok := tt.config["oauth"].(map[string]interface{}); ok {
+			o["tokenUrl"] = strings.ReplaceAll(o["tokenUrl"].(string), "{{.base}}", ts.URL)
+		}
+		if err := s.Configure(tt.config); err != nil {
+			t.Errorf("%d: configure error %v", i, err)
+			ts.Close()
+			continue
+		}
+		if err := s.Open(ctx); err != nil {
+			t.Errorf("%d: open error %v", i, err)
+			ts.Close()
+			continue
+		}
+		var errs []string
+		for _, d := range tt.data {
+			if err := s.Collect(ctx, []byte(d)); err != nil {
+				errs = append(errs, err.Error())
+			}
+		}
+		s.Close(ctx)
+		ts.Close()
+		var expErrs []string
+		if tt.err != "" {
+			expErrs = []string{tt.err}
+		}
+		if !reflect.DeepEqual(expErrs, errs) {
+			t.Errorf("%d: error mismatch:\n  exp=%v\n  got=%v", i, expErrs, errs)
+		}
+		if !reflect.DeepEqual(tt.result, rec.result()) {
+			t.Errorf("%d: result mismatch:\n  exp=%v\n  got=%v", i, tt.result, rec.result())
+		}
+	}
+}
+
+func TestRestSinkLinger(t *testing.T) {
+	rec := &restRecorder{}
+	ts := httptest.NewServer(rec)
+	defer ts.Close()
+	ctx := context.WithValue(context.Background(), context.LoggerKey, conf.Log)
+	s := &RestSink{}
+	if err := s.Configure(map[string]interface{}{"method": "post", "url": ts.URL, "bodyType": "text", "batchSize": 10, "lingerInterval": 50}); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Open(ctx); err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close(ctx)
+	_ = s.Collect(ctx, []byte("line1"))
+	_ = s.Collect(ctx, []byte("line2"))
+	if r := rec.result(); len(r) != 0 {
+		t.Fatalf("expect no request before linger, but got %v", r)
+	}
+	time.Sleep(200 * time.Millisecond)
+	exp := []restRequest{{Url: "/", Body: "line1\nline2"}}
+	if !reflect.DeepEqual(exp, rec.result()) {
+		t.Errorf("result mismatch:\n  exp=%v\n  got=%v", exp, rec.result())
+	}
+}
+
+func TestRestSinkBatchRetry(t *testing.T) {
+	rec := &restRecorder{codes: []int{http.StatusServiceUnavailable, http.StatusServiceUnavailable}}
+	ts := httptest.NewServer(rec)
+	defer ts.Close()
+	ctx := context.WithValue(context.Background(), context.LoggerKey, conf.Log)
+	s := &RestSink{}
+	if err := s.Configure(map[string]interface{}{"method": "post", "url": ts.URL, "bodyType": "text", "batchSize": 2, "lingerInterval": 10000, "maxRetries": 0}); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Open(ctx); err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close(ctx)
+	_ = s.Collect(ctx, []byte("line1"))
+	// The full batch fails, only the current data is returned to retry
+	if err := s.Collect(ctx, []byte("line2")); err == nil {
+		t.Errorf("expect error when the batch fails")
+	}
+	// The kept data fails again and is still kept
+	if err := s.Flush(ctx); err == nil {
+		t.Errorf("expect error when the flush fails")
+	}
+	if err := s.Collect(ctx, []byte("line2")); err != nil {
+		t.Errorf("expect no error but got %v", err)
+	}
+	exp := []restRequest{{Url: "/", Body: "line1\nline2"}, {Url: "/", Body: "line1"}, {Url: "/", Body: "line1\nline2"}}
+	if !reflect.DeepEqual(exp, rec.result()) {
+		t.Errorf("result mismatch:\n  exp=%v\n  got=%v", exp, rec.result())
+	}
+}
+
+func TestRestSinkBatchDrop(t *testing.T) {
+	rec := &restRecorder{codes: []int{http.StatusServiceUnavailable, http.StatusServiceUnavailable, http.StatusServiceUnavailable}}
+	ts := httptest.NewServer(rec)
+	defer ts.Close()
+	ctx := context.WithValue(context.Background(), context.LoggerKey, conf.Log)
+	s := &RestSink{}
+	if err := s.Configure(map[string]interface{}{"method": "post", "url": ts.URL, "bodyType": "text", "batchSize": 2, "lingerInterval": 10000, "retryCount": 1}); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Open(ctx); err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close(ctx)
+	_ = s.Collect(ctx, []byte("line1"))
+	if err := s.Collect(ctx, []byte("line2")); err == nil {
+		t.Errorf("expect error when the batch fails")
+	}
+	// The kept data is dropped when it fails again after retryCount times
+	if err := s.Flush(ctx); err == nil || !strings.Contains(err.Error(), "drop 1 results which exceed the retry count or the batch size") {
+		t.Errorf("expect error to drop 1 result but got %v", err)
+	}
+	if err := s.Flush(ctx); err != nil {
+		t.Errorf("expect no error but got %v", err)
+	}
+	// The restored batch keeps at most batchSize items
+	key := batchKey(ts.URL, nil)
+	s.mu.Lock()
+	nb := s.newBatch(key, ts.URL, nil)
+	nb.items, nb.retries = [][]byte{[]byte("line3")}, []int{0}
+	s.mu.Unlock()
+	if dropped := s.restore(key, &restBatch{url: ts.URL, items: [][]byte{[]byte("line1"), []byte("line2")}, retries: []int{0, 0}}, 2); dropped != 1 {
+		t.Errorf("expect 1 dropped but got %d", dropped)
+	}
+	if exp := [][]byte{[]byte("line2"), []byte("line3")}; !reflect.DeepEqual(exp, nb.items) {
+		t.Errorf("batch mismatch:\n  exp=%q\n  got=%q", exp, nb.items)
+	}
+	expReq := []restRequest{{Url: "/", Body: "line1\nline2"}, {Url: "/", Body: "line1"}}
+	if !reflect.DeepEqual(expReq, rec.result()) {
+		t.Errorf("result mismatch:\n  exp=%v\n  got=%v", expReq, rec.result())
+	}
+}
+
+type captureReceiver struct {
+	ch chan map[string]interface{}
+}
+
+func (c *captureReceiver) ServeHTTP(w http.ResponseWriter, _ *http.Request) {
+	w.WriteHeader(http.StatusOK)
+}
+
+func (c *captureReceiver) Receive(body []byte, meta map[string]interface{}) error {
+	meta["body"] = string(body)
+	c.ch <- meta
+	return nil
+}
+
+func TestRestSinkCapture(t *testing.T) {
+	if conf.Config == nil {
+		conf.Config = &conf.KuiperConf{}
+	}
+	conf.Config.Source.HttpServerIp = "127.0.0.1"
+	conf.Config.Source.HttpServerPort = 10093
+	r := &captureReceiver{ch: make(chan map[string]interface{}, 1)}
+	if err := httpserver.RegisterEndpoint("/responses", r); err != nil {
+		t.Fatal(err)
+	}
+	defer httpserver.UnregisterEndpoint("/responses")
+	rec := &restRecorder{}
+	ts := httptest.NewServer(rec)
+	defer ts.Close()
+	ctx := context.WithValue(context.Background(), context.LoggerKey, conf.Log)
+	s := &RestSink{}
+	if err := s.Configure(map[string]interface{}{"method": "post", "url": ts.URL, "responseEndpoint": "responses"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Open(ctx); err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close(ctx)
+	if err := s.Collect(ctx, []byte(`{"a":1}`)); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case meta := <-r.ch:
+		if meta["body"] != `{"received":7}` || meta["statusCode"] != http.StatusOK || meta["url"] != ts.URL {
+			t.Errorf("unexpected captured response %v", meta)
+		}
+	case <-time.After(time.Second):
+		t.Error("response is not captured")
+	}
+}
+
+func TestRestSinkConfigure(t *testing.T) {
+	var tests = []struct {
+		config map[string]interface{}
+		err    string
+	}{
+		{
+			config: map[string]interface{}{"method": "get", "url": "http://localhost", "batchSize": 10},
+			err:    "batchSize is not supported for bodyType none",
+		}, {
+			config: map[string]interface{}{"method": "post", "url": "http://localhost/{{.a", "batchSize": 10},
+			err:    "invalid property url http://localhost/{{.a: template: url:1: unclosed action",
+		}, {
+			config: map[string]interface{}{"method": "post", "url": "http://localhost", "maxRetries": -1},
+			err:    "invalid property maxRetries: -1, must not be negative",
+		}, {
+			config: map[string]interface{}{"method": "post", "url": "http://localhost", "retryStatusCodes": []interface{}{503, 999}},
+			err:    "invalid retry status code 999",
+		}, {
+			config: map[string]interface{}{"method": "post", "url": "http://localhost", "bearerToken": "a", "oauth": map[string]interface{}{"tokenUrl": "http://localhost/token", "clientId": "c"}},
+			err:    "only one of oauth and bearerToken can be set",
+		},
+	}
+	for i, tt := range tests {
+		s := &RestSink{}
+		err := s.Configure(tt.config)
+		if err == nil || err.Error() != tt.err {
+			t.Errorf("%d: error mismatch:\n  exp=%s\n  got=%v", i, tt.err, err)
+		}
+	}
+}
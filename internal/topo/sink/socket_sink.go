@@ -0,0 +1,166 @@
+// Copyright 2021 EMQ Technologies Co., Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sink
+
+import (
+	"errors"
+	"fmt"
+	"github.com/lf-edge/ekuiper/internal/pkg/socketx"
+	"github.com/lf-edge/ekuiper/pkg/api"
+	"github.com/lf-edge/ekuiper/pkg/cast"
+	"net"
+	"sync"
+	"time"
+)
+
+type SocketSinkConfig struct {
+	socketx.FramingConf
+	Mode     string `json:"mode"`
+	Protocol string `json:"protocol"`
+	Addr     string `json:"addr"`
+	Timeout  int    `json:"timeout"`
+}
+
+// SocketSink sends each result as a frame over raw tcp or udp. In client mode, it connects to the device on the
+// first result and reconnects after a failure. In server mode, it listens to the address and sends to all the
+// connected clients, or the udp peers which have sent any datagram.
+type SocketSink struct {
+	conf   *SocketSinkConfig
+	framer *socketx.Framer
+
+	mu     sync.Mutex
+	conn   net.Conn
+	server *socketx.Server
+}
+
+func (ss *SocketSink) Configure(props map[string]interface{}) error {
+	c := &SocketSinkConfig{Timeout: socketx.DEFAULT_TIMEOUT}
+	err := cast.MapToStruct(props, c)
+	if err != nil {
+		return fmt.Errorf("read properties %v fail with error: %v", props, err)
+	}
+	c.Mode, err = socketx.GetMode(c.Mode, socketx.MODE_CLIENT)
+	if err != nil {
+		return err
+	}
+	c.Protocol, err = socketx.GetProtocol(c.Protocol)
+	if err != nil {
+		return err
+	}
+	if c.Addr == "" {
+		return errors.New("property addr is required")
+	}
+	if err := socketx.ValidateAddr(c.Addr, c.Mode); err != nil {
+		return err
+	}
+	if c.Timeout <= 0 {
+		return fmt.Errorf("invalid property timeout: %d, must be a positive integer", c.Timeout)
+	}
+	ss.framer, err = socketx.NewFramer(&c.FramingConf)
+	if err != nil {
+		return err
+	}
+	ss.conf = c
+	return nil
+}
+
+func (ss *SocketSink) Open(ctx api.StreamContext) error {
+	logger := ctx.GetLogger()
+	if ss.conf.Mode == socketx.MODE_SERVER {
+		s, err := socketx.AcquireServer(ss.conf.Protocol, ss.conf.Addr, &ss.conf.FramingConf)
+		if err != nil {
+			return err
+		}
+		ss.server = s
+		logger.Infof("Opening socket sink for rule %s listening to %s %s.", ctx.GetRuleId(), ss.conf.Protocol, s.Addr())
+		return nil
+	}
+	logger.Infof("Opening socket sink for rule %s to %s %s.", ctx.GetRuleId(), ss.conf.Protocol, ss.conf.Addr)
+	return nil
+}
+
+func (ss *SocketSink) Collect(ctx api.StreamContext, item interface{}) error {
+	logger := ctx.GetLogger()
+	v, ok := item.([]byte)
+	if !ok {
+		logger.Warnf("socket sink receive non []byte data: %v", item)
+		return nil
+	}
+	logger.Debugf("socket sink receive %s", item)
+	timeout := time.Duration(ss.conf.Timeout) * time.Millisecond
+	if ss.server != nil {
+		n, err := ss.server.Write(v, timeout)
+		if err != nil {
+			return fmt.Errorf("socket sink fails to send out the data: %v", err)
+		}
+		if n == 0 {
+			logger.Debugf("socket sink drops the data as no client is connected")
+		}
+		return nil
+	}
+	frame, err := ss.framer.Encode(v)
+	if err != nil {
+		return fmt.Errorf("socket sink fails to send out the data: %v", err)
+	}
+	c, err := ss.connect()
+	if err != nil {
+		return err
+	}
+	_ = c.SetWriteDeadline(time.Now().Add(timeout))
+	if _, err := c.Write(frame); err != nil {
+		ss.reset(c)
+		return fmt.Errorf("socket sink fails to send out the data: %v", err)
+	}
+	return nil
+}
+
+func (ss *SocketSink) connect() (net.Conn, error) {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+	if ss.conn != nil {
+		return ss.conn, nil
+	}
+	c, err := net.DialTimeout(ss.conf.Protocol, ss.conf.Addr, time.Duration(ss.conf.Timeout)*time.Millisecond)
+	if err != nil {
+		return nil, fmt.Errorf("socket sink fails to connect to %s: %v", ss.conf.Addr, err)
+	}
+	ss.conn = c
+	return c, nil
+}
+
+// reset closes the broken connection so that the next result reconnects
+func (ss *SocketSink) reset(c net.Conn) {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+	_ = c.Close()
+	if ss.conn == c {
+		ss.conn = nil
+	}
+}
+
+func (ss *SocketSink) Close(ctx api.StreamContext) error {
+	ctx.GetLogger().Infof("Closing socket sink")
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+	if ss.conn != nil {
+		_ = ss.conn.Close()
+		ss.conn = nil
+	}
+	if ss.server != nil {
+		socketx.ReleaseServer(ss.server)
+		ss.server = nil
+	}
+	return nil
+}
@@ -0,0 +1,137 @@
+// Copyright 2021 EMQ Technologies Co., Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sink
+
+import (
+	"bufio"
+	"github.com/lf-edge/ekuiper/internal/conf"
+	"github.com/lf-edge/ekuiper/internal/pkg/socketx"
+	"github.com/lf-edge/ekuiper/internal/testx"
+	"github.com/lf-edge/ekuiper/internal/topo/context"
+	"io"
+	"net"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestSocketSinkConfigure(t *testing.T) {
+	var tests = []struct {
+		props map[string]interface{}
+		conf  *SocketSinkConfig
+		err   string
+	}{
+		{
+			props: map[string]interface{}{"addr": "device:9000"},
+			conf:  &SocketSinkConfig{Mode: "client", Protocol: "tcp", Addr: "device:9000", Timeout: 10000},
+		}, {
+			props: map[string]interface{}{"addr": ":9000", "mode": "server", "protocol": "UDP", "delimiter": "0x0d0a"},
+			conf:  &SocketSinkConfig{FramingConf: socketx.FramingConf{Delimiter: "0x0d0a"}, Mode: "server", Protocol: "udp", Addr: ":9000", Timeout: 10000},
+		}, {
+			props: map[string]interface{}{},
+			err:   "property addr is required",
+		}, {
+			props: map[string]interface{}{"addr": "device"},
+			err:   "invalid address device: address device: missing port in address",
+		}, {
+			props: map[string]interface{}{"addr": "device:9000", "mode": "peer"},
+			err:   "invalid property mode: peer, must be client or server",
+		}, {
+			props: map[string]interface{}{"addr": "device:9000", "framing": "length", "lengthSize": 8},
+			err:   "invalid property lengthSize: 8, must be 1, 2 or 4",
+		},
+	}
+	for i, tt := range tests {
+		s := &SocketSink{}
+		err := s.Configure(tt.props)
+		if !reflect.DeepEqual(tt.err, testx.Errstring(err)) {
+			t.Errorf("%d: error mismatch:\n  exp=%s\n  got=%s", i, tt.err, err)
+		} else if err == nil && !reflect.DeepEqual(tt.conf, s.conf) {
+			t.Errorf("%d: conf mismatch:\n  exp=%v\n  got=%v", i, tt.conf, s.conf)
+		}
+	}
+}
+
+func TestSocketSinkClient(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	s := &SocketSink{}
+	if err := s.Configure(map[string]interface{}{"addr": ln.Addr().String(), "framing": "length", "lengthSize": 2}); err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.WithValue(context.Background(), context.LoggerKey, conf.Log)
+	if err := s.Open(ctx); err != nil {
+		t.Fatal(err)
+	}
+	// The connection is created on the first result and reused
+	for _, data := range []string{"a,1", "b,2"} {
+		if err := s.Collect(ctx, []byte(data)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	c, err := ln.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	_ = c.SetReadDeadline(time.Now().Add(2 * time.Second))
+	buf := make([]byte, 10)
+	if _, err := io.ReadFull(c, buf); err != nil || string(buf) != "\x00\x03a,1\x00\x03b,2" {
+		t.Errorf("frame mismatch, got %q %v", buf, err)
+	}
+	if err := s.Collect(ctx, make([]byte, 70000)); testx.Errstring(err) != "socket sink fails to send out the data: data length 70000 exceeds the max frame size" {
+		t.Errorf("error mismatch, got %v", err)
+	}
+	_ = s.Close(ctx)
+}
+
+func TestSocketSinkServer(t *testing.T) {
+	s := &SocketSink{}
+	if err := s.Configure(map[string]interface{}{"addr": "127.0.0.1:0", "mode": "server"}); err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.WithValue(context.Background(), context.LoggerKey, conf.Log)
+	if err := s.Open(ctx); err != nil {
+		t.Fatal(err)
+	}
+	// No client is connected
+	if err := s.Collect(ctx, []byte("a,1")); err != nil {
+		t.Error(err)
+	}
+	var readers []*bufio.Reader
+	for i := 0; i < 2; i++ {
+		c, err := net.Dial("tcp", s.server.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer c.Close()
+		_ = c.SetReadDeadline(time.Now().Add(2 * time.Second))
+		readers = append(readers, bufio.NewReader(c))
+	}
+	// Wait for the connections to be accepted
+	time.Sleep(100 * time.Millisecond)
+	if err := s.Collect(ctx, []byte("b,2")); err != nil {
+		t.Error(err)
+	}
+	for i, r := range readers {
+		if line, err := r.ReadString('\n'); err != nil || line != "b,2\n" {
+			t.Errorf("%d: line mismatch, got %q %v", i, line, err)
+		}
+	}
+	_ = s.Close(ctx)
+}
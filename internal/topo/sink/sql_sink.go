@@ -0,0 +1,375 @@
+// Copyright 2021 EMQ Technologies Co., Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sink
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"fmt"
+	sqlx "github.com/lf-edge/ekuiper/internal/pkg/db/sql"
+	"github.com/lf-edge/ekuiper/pkg/api"
+	"github.com/lf-edge/ekuiper/pkg/cast"
+	"github.com/mattn/go-sqlite3"
+	"io"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+type SQLSinkConfig struct {
+	Driver     string            `json:"driver"`
+	Dsn        string            `json:"dsn"`
+	Table      string            `json:"table"`
+	Fields     []string          `json:"fields"`
+	Columns    map[string]string `json:"columns"`
+	UpsertKeys []string          `json:"upsertKeys"`
+	BatchSize  int               `json:"batchSize"`
+	Linger     int               `json:"lingerInterval"`
+}
+
+// sqlRow is a buffered row with its columns and values
+type sqlRow struct {
+	data map[string]interface{}
+	cols []string
+	vals []interface{}
+}
+
+// sqlRejected is a buffered row rejected by the database
+type sqlRejected struct {
+	row *sqlRow
+	err error
+}
+
+// SQLSink writes the results as rows of a table. The results are buffered and written in one
+// transaction when the batch size is reached, the linger interval is passed or a checkpoint is taken.
+type SQLSink struct {
+	sync.Mutex
+	conf   *SQLSinkConfig
+	db     *sql.DB
+	buffer []*sqlRow
+	// The rows rejected by the linger or the previous results which are not reported yet
+	rejected []sqlRejected
+	cancel   func()
+}
+
+func (s *SQLSink) Configure(props map[string]interface{}) error {
+	cfg := &SQLSinkConfig{Driver: sqlx.DRIVER_SQLITE, BatchSize: 1}
+	err := cast.MapToStruct(props, cfg)
+	if err != nil {
+		return fmt.Errorf("read properties %v fail with error: %v", props, err)
+	}
+	if cfg.Dsn == "" {
+		return errors.New("property dsn is required")
+	}
+	if cfg.Table == "" {
+		return errors.New("property table is required")
+	}
+	if cfg.BatchSize <= 0 {
+		return fmt.Errorf("invalid property batchSize: %d, must be a positive integer", cfg.BatchSize)
+	}
+	if cfg.Linger < 0 {
+		return fmt.Errorf("invalid property lingerInterval: %d, must not be negative", cfg.Linger)
+	}
+	s.conf = cfg
+	return nil
+}
+
+func (s *SQLSink) Open(ctx api.StreamContext) error {
+	logger := ctx.GetLogger()
+	logger.Infof("Opening sql sink for rule %s.", ctx.GetRuleId())
+	db, err := sqlx.Open(s.conf.Driver, s.conf.Dsn)
+	if err != nil {
+		return err
+	}
+	s.db = db
+	if s.conf.BatchSize > 1 && s.conf.Linger > 0 {
+		done := make(chan struct{})
+		s.cancel = func() { close(done) }
+		go func() {
+			ticker := time.NewTicker(time.Duration(s.conf.Linger) * time.Millisecond)
+			defer ticker.Stop()
+			for {
+				select {
+				case <-ticker.C:
+					s.Lock()
+					rejected, err := s.flush()
+					if err != nil {
+						logger.Errorf("sql sink fails to write the batch of %d rows, retry later: %v", len(s.buffer), err)
+					}
+					if len(rejected) > 0 {
+						s.rejected = append(s.rejected, rejected...)
+						logger.Errorf("sql sink fails to write the batch: %v", rejectedError(rejected))
+					}
+					s.Unlock()
+				case <-done:
+					return
+				}
+			}
+		}()
+	}
+	return nil
+}
+
+func (s *SQLSink) Collect(ctx api.StreamContext, item interface{}) error {
+	logger := ctx.GetLogger()
+	v, ok := item.([]byte)
+	if !ok {
+		logger.Warnf("sql sink receive non []byte data: %v", item)
+		return nil
+	}
+	logger.Debugf("sql sink receive %s", item)
+	var rows []map[string]interface{}
+	trimmed := bytes.TrimSpace(v)
+	if len(trimmed) > 0 && trimmed[0] == '[' {
+		if err := json.Unmarshal(trimmed, &rows); err != nil {
+			return fmt.Errorf("sql sink fails to decode the data: %v", err)
+		}
+	} else {
+		row := make(map[string]interface{})
+		if err := json.Unmarshal(trimmed, &row); err != nil {
+			return fmt.Errorf("sql sink fails to decode the data: %v", err)
+		}
+		rows = append(rows, row)
+	}
+	// Reject the result which cannot be mapped to columns before buffering it
+	current := make([]*sqlRow, 0, len(rows))
+	for _, row := range rows {
+		cols, vals, err := s.columns(row)
+		if err != nil {
+			return fmt.Errorf("sql sink %v", err)
+		}
+		current = append(current, &sqlRow{data: row, cols: cols, vals: vals})
+	}
+	s.Lock()
+	defer s.Unlock()
+	s.buffer = append(s.buffer, current...)
+	if len(s.buffer) < s.conf.BatchSize {
+		return nil
+	}
+	rejected, err := s.flush()
+	// The rejected rows of this result are returned while the others are reported by the next flush
+	isCurrent := make(map[*sqlRow]bool, len(current))
+	for _, r := range current {
+		isCurrent[r] = true
+	}
+	var cur []sqlRejected
+	for _, r := range rejected {
+		if isCurrent[r.row] {
+			cur = append(cur, r)
+		} else {
+			s.rejected = append(s.rejected, r)
+		}
+	}
+	if err != nil {
+		// Only the rows of this result are removed so that the sink node can retry it. The rows collected before
+		// are kept to write by the next flush, thus the buffer never exceeds a batch and the current result.
+		kept := s.buffer[:0]
+		for _, r := range s.buffer {
+			if !isCurrent[r] {
+				kept = append(kept, r)
+			}
+		}
+		s.buffer = kept
+		return fmt.Errorf("sql sink fails to write the batch: %v", err)
+	}
+	if len(cur) > 0 {
+		return fmt.Errorf("sql sink fails to write the batch: %v", rejectedError(cur))
+	}
+	return nil
+}
+
+// Flush writes the buffered rows so that they are persisted before the checkpoint. It also returns the rejected
+// rows which are not reported yet.
+func (s *SQLSink) Flush(_ api.StreamContext) error {
+	s.Lock()
+	defer s.Unlock()
+	rejected, err := s.flush()
+	s.rejected = append(s.rejected, rejected...)
+	if err != nil {
+		return fmt.Errorf("sql sink fails to write the batch: %v", err)
+	}
+	if len(s.rejected) > 0 {
+		err = fmt.Errorf("sql sink fails to write the batch: %v", rejectedError(s.rejected))
+		s.rejected = nil
+		return err
+	}
+	return nil
+}
+
+// flush writes the buffered rows in a transaction and returns the rows rejected by the database. If a row fails for
+// its values such as violating a constraint, the transaction is rolled back and the rows are written again without
+// it, so that the row does not block the others forever. For the other errors such as the connection loss or the
+// missing table, the buffer is kept to write again by the next flush. Must be called with the lock held.
+func (s *SQLSink) flush() ([]sqlRejected, error) {
+	var rejected []sqlRejected
+	for len(s.buffer) > 0 {
+		i, err := s.write()
+		if err == nil {
+			s.buffer = nil
+			break
+		}
+		if i < 0 {
+			return rejected, err
+		}
+		rejected = append(rejected, sqlRejected{row: s.buffer[i], err: err})
+		s.buffer = append(s.buffer[:i:i], s.buffer[i+1:]...)
+	}
+	return rejected, nil
+}
+
+// write writes the buffered rows in a transaction. If a row is rejected for its values, its index is returned with
+// the error. Otherwise, the index is -1.
+func (s *SQLSink) write() (int, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return -1, err
+	}
+	stmts := make(map[string]*sql.Stmt)
+	for i, row := range s.buffer {
+		if len(row.cols) == 0 {
+			continue
+		}
+		key := strings.Join(row.cols, ",")
+		stmt, ok := stmts[key]
+		if !ok {
+			stmt, err = tx.Prepare(s.statement(row.cols))
+			if err != nil {
+				_ = tx.Rollback()
+				return -1, err
+			}
+			stmts[key] = stmt
+		}
+		if _, err := stmt.Exec(row.vals...); err != nil {
+			_ = tx.Rollback()
+			if isConnError(err) {
+				return -1, err
+			}
+			return i, err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return -1, err
+	}
+	return -1, nil
+}
+
+// isConnError checks if the error is caused by the connection instead of the row values, so that the row may be
+// written if retried later
+func isConnError(err error) bool {
+	if errors.Is(err, driver.ErrBadConn) || errors.Is(err, sql.ErrConnDone) || errors.Is(err, io.EOF) ||
+		errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, context.DeadlineExceeded) || errors.Is(err, context.Canceled) {
+		return true
+	}
+	var ne net.Error
+	if errors.As(err, &ne) {
+		return true
+	}
+	var se sqlite3.Error
+	return errors.As(err, &se) && (se.Code == sqlite3.ErrBusy || se.Code == sqlite3.ErrLocked || se.Code == sqlite3.ErrIoErr)
+}
+
+// rejectedError formats the rejected rows with their errors
+func rejectedError(rejected []sqlRejected) error {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%d rows are rejected:", len(rejected))
+	for _, r := range rejected {
+		fmt.Fprintf(&b, " [%v] %v;", r.err, r.row.data)
+	}
+	return errors.New(b.String())
+}
+
+// columns maps the fields of the row to the column names and values
+func (s *SQLSink) columns(row map[string]interface{}) ([]string, []interface{}, error) {
+	fields := s.conf.Fields
+	if len(fields) == 0 {
+		fields = make([]string, 0, len(row))
+		for k := range row {
+			fields = append(fields, k)
+		}
+		sort.Strings(fields)
+	}
+	cols := make([]string, 0, len(fields))
+	vals := make([]interface{}, 0, len(fields))
+	for _, f := range fields {
+		v, ok := row[f]
+		if !ok {
+			continue
+		}
+		switch v.(type) {
+		case map[string]interface{}, []interface{}:
+			b, err := json.Marshal(v)
+			if err != nil {
+				return nil, nil, fmt.Errorf("fail to encode field %s: %v", f, err)
+			}
+			v = string(b)
+		}
+		col := f
+		if c, ok := s.conf.Columns[f]; ok {
+			col = c
+		}
+		cols = append(cols, col)
+		vals = append(vals, v)
+	}
+	return cols, vals, nil
+}
+
+// statement creates the insert statement of the columns. If upsert keys are set, the row is updated on conflict.
+func (s *SQLSink) statement(cols []string) string {
+	placeholders := make([]string, len(cols))
+	for i := range cols {
+		placeholders[i] = "?"
+	}
+	stmt := fmt.Sprintf("INSERT INTO %s (%s) VALUES (%s)", s.conf.Table, strings.Join(cols, ","), strings.Join(placeholders, ","))
+	if len(s.conf.UpsertKeys) > 0 {
+		keys := make(map[string]bool, len(s.conf.UpsertKeys))
+		for _, k := range s.conf.UpsertKeys {
+			keys[k] = true
+		}
+		var sets []string
+		for _, c := range cols {
+			if !keys[c] {
+				sets = append(sets, fmt.Sprintf("%s=excluded.%s", c, c))
+			}
+		}
+		if len(sets) == 0 {
+			stmt += fmt.Sprintf(" ON CONFLICT(%s) DO NOTHING", strings.Join(s.conf.UpsertKeys, ","))
+		} else {
+			stmt += fmt.Sprintf(" ON CONFLICT(%s) DO UPDATE SET %s", strings.Join(s.conf.UpsertKeys, ","), strings.Join(sets, ","))
+		}
+	}
+	return stmt
+}
+
+func (s *SQLSink) Close(ctx api.StreamContext) error {
+	logger := ctx.GetLogger()
+	logger.Infof("Closing sql sink")
+	if s.cancel != nil {
+		s.cancel()
+	}
+	if s.db == nil {
+		return nil
+	}
+	err := s.Flush(ctx)
+	if err != nil {
+		logger.Errorf("sql sink fails to write the batch when closing: %v", err)
+	}
+	return s.db.Close()
+}
@@ -0,0 +1,177 @@
+// Copyright 2021 EMQ Technologies Co., Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sink
+
+import (
+	"database/sql"
+	"fmt"
+	"github.com/lf-edge/ekuiper/internal/conf"
+	"github.com/lf-edge/ekuiper/internal/topo/context"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestSQLSink(t *testing.T) {
+	dsn := filepath.Join(t.TempDir(), "test.db")
+	db, err := sql.Open("sqlite3", dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	if _, err := db.Exec("CREATE TABLE results (device TEXT PRIMARY KEY, temp REAL, tags TEXT)"); err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.WithValue(context.Background(), context.LoggerKey, conf.Log)
+	s := &SQLSink{}
+	err = s.Configure(map[string]interface{}{
+		"dsn":        dsn,
+		"table":      "results",
+		"fields":     []interface{}{"id", "temperature", "tags"},
+		"columns":    map[string]interface{}{"id": "device", "temperature": "temp"},
+		"upsertKeys": []interface{}{"device"},
+		"batchSize":  3,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Open(ctx); err != nil {
+		t.Fatal(err)
+	}
+	query := func() []string {
+		rows, err := db.Query("SELECT device, temp, tags FROM results ORDER BY device")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer rows.Close()
+		var result []string
+		for rows.Next() {
+			var device string
+			var temp float64
+			var tags sql.NullString
+			if err := rows.Scan(&device, &temp, &tags); err != nil {
+				t.Fatal(err)
+			}
+			result = append(result, fmt.Sprintf("%s:%v:%s", device, temp, tags.String))
+		}
+		return result
+	}
+
+	if err := s.Collect(ctx, []byte(`[{"id":"d1","temperature":20.5,"other":1},{"id":"d2","temperature":21}]`)); err != nil {
+		t.Fatal(err)
+	}
+	if r := query(); len(r) != 0 {
+		t.Errorf("expect no rows before the batch is full but got %v", r)
+	}
+	if err := s.Collect(ctx, []byte(`{"id":"d1","temperature":22,"tags":["a","b"]}`)); err != nil {
+		t.Fatal(err)
+	}
+	exp := []string{`d1:22:["a","b"]`, "d2:21:"}
+	if r := query(); !reflect.DeepEqual(exp, r) {
+		t.Errorf("result mismatch:\n  exp=%v\n  got=%v", exp, r)
+	}
+	// The remaining rows are written when closing
+	if err := s.Collect(ctx, []byte(`{"id":"d3","temperature":23}`)); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Close(ctx); err != nil {
+		t.Fatal(err)
+	}
+	exp = append(exp, "d3:23:")
+	if r := query(); !reflect.DeepEqual(exp, r) {
+		t.Errorf("result mismatch after close:\n  exp=%v\n  got=%v", exp, r)
+	}
+}
+
+func TestSQLSinkRetry(t *testing.T) {
+	dsn := filepath.Join(t.TempDir(), "test.db")
+	db, err := sql.Open("sqlite3", dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	ctx := context.WithValue(context.Background(), context.LoggerKey, conf.Log)
+	s := &SQLSink{}
+	if err := s.Configure(map[string]interface{}{"dsn": dsn, "table": "results", "batchSize": 2}); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Open(ctx); err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close(ctx)
+	if err := s.Collect(ctx, []byte(`{"id":"d1"}`)); err != nil {
+		t.Fatal(err)
+	}
+	// The table does not exist, only the current result is rejected
+	if err := s.Collect(ctx, []byte(`{"id":"d2"}`)); err == nil {
+		t.Fatal("expect error but got nil")
+	}
+	if err := s.Flush(ctx); err == nil {
+		t.Fatal("expect flush error but got nil")
+	}
+	if _, err := db.Exec("CREATE TABLE results (id TEXT)"); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Collect(ctx, []byte(`{"id":"d2"}`)); err != nil {
+		t.Fatal(err)
+	}
+	var count int
+	if err := db.QueryRow("SELECT count(*) FROM results").Scan(&count); err != nil || count != 2 {
+		t.Errorf("expect 2 rows but got %d: %v", count, err)
+	}
+}
+
+func TestSQLSinkReject(t *testing.T) {
+	dsn := filepath.Join(t.TempDir(), "test.db")
+	db, err := sql.Open("sqlite3", dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	if _, err := db.Exec("CREATE TABLE results (id TEXT PRIMARY KEY)"); err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.WithValue(context.Background(), context.LoggerKey, conf.Log)
+	s := &SQLSink{}
+	if err := s.Configure(map[string]interface{}{"dsn": dsn, "table": "results", "batchSize": 3}); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Open(ctx); err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close(ctx)
+	if err := s.Collect(ctx, []byte(`[{"id":"d1"},{"id":"d1"}]`)); err != nil {
+		t.Fatal(err)
+	}
+	// The duplicated row of the previous result is dropped and reported by the flush
+	if err := s.Collect(ctx, []byte(`{"id":"d2"}`)); err != nil {
+		t.Fatal(err)
+	}
+	exp := "sql sink fails to write the batch: 1 rows are rejected: [UNIQUE constraint failed: results.id] map[id:d1];"
+	if err := s.Flush(ctx); err == nil || err.Error() != exp {
+		t.Errorf("expect error %s but got %v", exp, err)
+	}
+	if err := s.Flush(ctx); err != nil {
+		t.Errorf("expect no error but got %v", err)
+	}
+	// The duplicated row of the current result is returned
+	if err := s.Collect(ctx, []byte(`[{"id":"d3"},{"id":"d2"},{"id":"d4"}]`)); err == nil {
+		t.Error("expect error but got nil")
+	}
+	var count int
+	if err := db.QueryRow("SELECT count(*) FROM results").Scan(&count); err != nil || count != 4 {
+		t.Errorf("expect 4 rows but got %d: %v", count, err)
+	}
+}
@@ -0,0 +1,75 @@
+// Copyright 2021 EMQ Technologies Co., Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sink
+
+import (
+	"fmt"
+	"github.com/lf-edge/ekuiper/internal/pkg/ssex"
+	"github.com/lf-edge/ekuiper/pkg/api"
+	"github.com/lf-edge/ekuiper/pkg/cast"
+)
+
+// SSESink publishes the results to the stream of the rule which is served as server sent events by the rest api
+// at /rules/{name}/stream
+type SSESink struct {
+	conf *ssex.HubConf
+	hub  *ssex.Hub
+}
+
+func (m *SSESink) Configure(props map[string]interface{}) error {
+	c := &ssex.HubConf{BufferLength: ssex.DEFAULT_BUFFER_LENGTH, ClientBuffer: ssex.DEFAULT_CLIENT_BUFFER, HeartbeatInterval: ssex.DEFAULT_HEARTBEAT_INTERVAL}
+	if err := cast.MapToStruct(props, c); err != nil {
+		return fmt.Errorf("read properties %v fail with error: %v", props, err)
+	}
+	if c.BufferLength <= 0 {
+		return fmt.Errorf("invalid property bufferLength: %d, must be a positive integer", c.BufferLength)
+	}
+	if c.ClientBuffer <= 0 {
+		return fmt.Errorf("invalid property clientBuffer: %d, must be a positive integer", c.ClientBuffer)
+	}
+	if c.HeartbeatInterval < 0 {
+		return fmt.Errorf("invalid property heartbeatInterval: %d, must not be negative", c.HeartbeatInterval)
+	}
+	m.conf = c
+	return nil
+}
+
+func (m *SSESink) Open(ctx api.StreamContext) error {
+	ctx.GetLogger().Infof("Opening sse sink for rule %s at /rules/%s/stream.", ctx.GetRuleId(), ctx.GetRuleId())
+	m.hub = ssex.AcquireHub(ctx.GetRuleId(), m.conf)
+	return nil
+}
+
+func (m *SSESink) Collect(ctx api.StreamContext, item interface{}) error {
+	logger := ctx.GetLogger()
+	v, ok := item.([]byte)
+	if !ok {
+		logger.Warnf("sse sink receive non []byte data: %v", item)
+		return nil
+	}
+	logger.Debugf("sse sink receive %s", item)
+	n := m.hub.Publish(v)
+	logger.Debugf("sse sink publish to %d clients", n)
+	return nil
+}
+
+func (m *SSESink) Close(ctx api.StreamContext) error {
+	ctx.GetLogger().Infof("Closing sse sink")
+	if m.hub != nil {
+		ssex.ReleaseHub(m.hub)
+		m.hub = nil
+	}
+	return nil
+}
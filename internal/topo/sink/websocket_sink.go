@@ -0,0 +1,113 @@
+// Copyright 2021 EMQ Technologies Co., Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sink
+
+import (
+	"errors"
+	"fmt"
+	"github.com/lf-edge/ekuiper/internal/pkg/wsx"
+	"github.com/lf-edge/ekuiper/pkg/api"
+	"github.com/lf-edge/ekuiper/pkg/cast"
+	"strings"
+)
+
+type WebsocketSinkConfig struct {
+	wsx.ClientConf
+	Mode string `json:"mode"`
+	Path string `json:"path"`
+}
+
+// WebsocketSink broadcasts the results to the clients connected to a path of the embedded http server
+// in server mode, or sends the results to a websocket server in client mode
+type WebsocketSink struct {
+	conf   *WebsocketSinkConfig
+	client *wsx.Client
+	ep     *wsx.Endpoint
+}
+
+func (ws *WebsocketSink) Configure(props map[string]interface{}) error {
+	cfg := &WebsocketSinkConfig{}
+	err := cast.MapToStruct(props, cfg)
+	if err != nil {
+		return fmt.Errorf("read properties %v fail with error: %v", props, err)
+	}
+	cfg.Mode, err = wsx.GetMode(cfg.Mode, cfg.Url)
+	if err != nil {
+		return err
+	}
+	switch cfg.Mode {
+	case wsx.MODE_CLIENT:
+		if !strings.HasPrefix(cfg.Url, "ws://") && !strings.HasPrefix(cfg.Url, "wss://") {
+			return fmt.Errorf("invalid property url: %s, must start with ws:// or wss://", cfg.Url)
+		}
+		ws.client = wsx.NewClient(&cfg.ClientConf)
+	case wsx.MODE_SERVER:
+		if cfg.Path == "" {
+			return errors.New("property path is required in server mode")
+		}
+		if !strings.HasPrefix(cfg.Path, "/") {
+			cfg.Path = "/" + cfg.Path
+		}
+	}
+	ws.conf = cfg
+	return nil
+}
+
+func (ws *WebsocketSink) Open(ctx api.StreamContext) error {
+	logger := ctx.GetLogger()
+	if ws.conf.Mode == wsx.MODE_SERVER {
+		ep, err := wsx.AcquireEndpoint(ws.conf.Path)
+		if err != nil {
+			return err
+		}
+		ws.ep = ep
+		logger.Infof("Opening websocket sink for rule %s at endpoint %s.", ctx.GetRuleId(), ws.conf.Path)
+		return nil
+	}
+	logger.Infof("Opening websocket sink for rule %s to %s.", ctx.GetRuleId(), ws.conf.Url)
+	go ws.client.Run(ctx, nil)
+	return nil
+}
+
+func (ws *WebsocketSink) Collect(ctx api.StreamContext, item interface{}) error {
+	logger := ctx.GetLogger()
+	v, ok := item.([]byte)
+	if !ok {
+		logger.Warnf("websocket sink receive non []byte data: %v", item)
+		return nil
+	}
+	logger.Debugf("websocket sink receive %s", item)
+	if ws.ep != nil {
+		n := ws.ep.Broadcast(v)
+		logger.Debugf("websocket sink broadcast to %d clients", n)
+		return nil
+	}
+	if err := ws.client.Send(v); err != nil {
+		return fmt.Errorf("websocket sink fails to send out the data: %v", err)
+	}
+	return nil
+}
+
+func (ws *WebsocketSink) Close(ctx api.StreamContext) error {
+	ctx.GetLogger().Infof("Closing websocket sink")
+	if ws.ep != nil {
+		wsx.ReleaseEndpoint(ws.ep)
+		ws.ep = nil
+	}
+	if ws.client != nil {
+		return ws.client.Close()
+	}
+	return nil
+}
@@ -0,0 +1,360 @@
+// Copyright 2021 EMQ Technologies Co., Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package source
+
+import (
+	"errors"
+	"fmt"
+	"github.com/lf-edge/ekuiper/internal/pkg/coapx"
+	"github.com/lf-edge/ekuiper/pkg/api"
+	"github.com/lf-edge/ekuiper/pkg/cast"
+	"github.com/lf-edge/ekuiper/pkg/message"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+// The extra time to wait for the notification after the max age before registering the observation again
+const coapObserveSlack = 5 * time.Second
+
+type CoapSourceConfig struct {
+	coapx.DTLSConf
+	Mode              string `json:"mode"`
+	ListenAddr        string `json:"listenAddr"`
+	Url               string `json:"url"`
+	Observe           bool   `json:"observe"`
+	Interval          int    `json:"interval"`
+	Confirmable       bool   `json:"confirmable"`
+	Timeout           int    `json:"timeout"`
+	ReconnectInterval int    `json:"reconnectInterval"`
+	Format            string `json:"format"`
+}
+
+// CoapSource receives the observations POSTed by the devices in server mode, or observes a resource of a device by
+// GET with the observe option in client mode. If the resource is not observable, it is polled by the interval.
+type CoapSource struct {
+	conf   *CoapSourceConfig
+	path   string
+	addr   string
+	query  []string
+	server *coapx.Server
+
+	mu     sync.RWMutex
+	status api.ConnectionStatus
+}
+
+// Configure the coap source. In server mode, the datasource is the path. In client mode, the datasource is appended
+// to the url.
+func (cs *CoapSource) Configure(datasource string, props map[string]interface{}) error {
+	c := &CoapSourceConfig{Observe: true, Interval: 10000, Confirmable: true, Timeout: coapx.DEFAULT_TIMEOUT, ReconnectInterval: 5000, Format: message.FormatJson}
+	err := cast.MapToStruct(props, c)
+	if err != nil {
+		return fmt.Errorf("read properties %v fail with error: %v", props, err)
+	}
+	c.Mode, err = coapx.GetMode(c.Mode, c.Url)
+	if err != nil {
+		return err
+	}
+	if err := c.DTLSConf.Validate(); err != nil {
+		return err
+	}
+	if c.Timeout <= 0 {
+		return fmt.Errorf("invalid property timeout: %d, must be a positive integer", c.Timeout)
+	}
+	switch c.Mode {
+	case coapx.MODE_SERVER:
+		if datasource == "" {
+			return errors.New("path must be specified as the datasource in server mode")
+		}
+		if !strings.HasPrefix(datasource, "/") {
+			datasource = "/" + datasource
+		}
+		cs.path = datasource
+		if c.ListenAddr == "" {
+			port := coapx.DEFAULT_PORT
+			if c.DTLSConf.Enabled() {
+				port = coapx.DTLS_PORT
+			}
+			c.ListenAddr = fmt.Sprintf(":%d", port)
+		}
+	case coapx.MODE_CLIENT:
+		addr, path, query, secure, err := coapx.ParseUrl(c.Url + datasource)
+		if err != nil {
+			return err
+		}
+		if secure != c.DTLSConf.Enabled() {
+			return errors.New("property psk is required for coaps url only")
+		}
+		if c.Interval <= 0 {
+			return fmt.Errorf("invalid property interval: %d, must be a positive integer", c.Interval)
+		}
+		if c.ReconnectInterval <= 0 {
+			return fmt.Errorf("invalid property reconnectInterval: %d, must be a positive integer", c.ReconnectInterval)
+		}
+		cs.addr, cs.path, cs.query = addr, path, query
+	}
+	cs.conf = c
+	return nil
+}
+
+func (cs *CoapSource) Open(ctx api.StreamContext, consumer chan<- api.SourceTuple, errCh chan<- error) {
+	if cs.conf.Mode == coapx.MODE_SERVER {
+		cs.serve(ctx, consumer, errCh)
+		return
+	}
+	cs.runClient(ctx, consumer)
+}
+
+func (cs *CoapSource) serve(ctx api.StreamContext, consumer chan<- api.SourceTuple, errCh chan<- error) {
+	logger := ctx.GetLogger()
+	s, err := coapx.AcquireServer(cs.conf.ListenAddr, &cs.conf.DTLSConf)
+	if err != nil {
+		errCh <- err
+		return
+	}
+	err = s.Handle(cs.path, func(req *coapx.Message, remote net.Addr) (coapx.Code, []byte) {
+		if req.Code != coapx.POST && req.Code != coapx.PUT {
+			return coapx.MethodNotAllowed, nil
+		}
+		results, err := decodeMessages(req.Payload, cs.conf.Format)
+		if err != nil {
+			logger.Warnf("coap source drops the invalid message from %s: %v", remote, err)
+			return coapx.BadRequest, []byte(err.Error())
+		}
+		meta := map[string]interface{}{"path": cs.path, "method": coapMethod(req.Code), "remoteAddr": remote.String()}
+		for _, result := range results {
+			select {
+			case consumer <- api.NewDefaultSourceTuple(result, meta):
+				logger.Debugf("send coap data to device node")
+			case <-ctx.Done():
+				return coapx.ServiceUnavailable, nil
+			}
+		}
+		return coapx.Changed, nil
+	})
+	if err != nil {
+		coapx.ReleaseServer(s)
+		errCh <- err
+		return
+	}
+	cs.server = s
+	logger.Infof("Listening to coap path %s at %s", cs.path, s.Addr())
+}
+
+func coapMethod(c coapx.Code) string {
+	if c == coapx.PUT {
+		return "PUT"
+	}
+	return "POST"
+}
+
+// runClient connects to the device and observes or polls the resource. It reconnects if the connection is broken.
+func (cs *CoapSource) runClient(ctx api.StreamContext, consumer chan<- api.SourceTuple) {
+	logger := ctx.GetLogger()
+	for {
+		cs.setStatus(api.ConnectionConnecting, nil)
+		cli, err := coapx.Dial(cs.addr, &cs.conf.DTLSConf, time.Duration(cs.conf.Timeout)*time.Millisecond)
+		if err == nil {
+			logger.Infof("coap source connected to %s", cs.addr)
+			if cs.conf.Observe {
+				err = cs.observe(ctx, cli, consumer)
+			} else {
+				err = cs.poll(ctx, cli, consumer)
+			}
+			_ = cli.Close()
+		}
+		select {
+		case <-ctx.Done():
+			return
+		default:
+		}
+		logger.Warnf("coap source fails to get %s%s: %v, retry after %d ms", cs.addr, cs.path, err, cs.conf.ReconnectInterval)
+		cs.setStatus(api.ConnectionDisconnected, err)
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(time.Duration(cs.conf.ReconnectInterval) * time.Millisecond):
+		}
+	}
+}
+
+func (cs *CoapSource) request() *coapx.Message {
+	req := &coapx.Message{Type: coapx.NonConfirmable, Code: coapx.GET}
+	if cs.conf.Confirmable {
+		req.Type = coapx.Confirmable
+	}
+	req.SetPath(cs.path)
+	for _, q := range cs.query {
+		req.AddQuery(q)
+	}
+	return req
+}
+
+// observe registers the observation and sends the notifications. The observation is registered again if no
+// notification is received within the max age. It returns when the connection is broken or the context is done.
+func (cs *CoapSource) observe(ctx api.StreamContext, cli *coapx.Client, consumer chan<- api.SourceTuple) error {
+	logger := ctx.GetLogger()
+	notes := make(chan *coapx.Message)
+	for {
+		obs, first, err := cli.Observe(cs.request(), func(m *coapx.Message) {
+			select {
+			case notes <- m:
+			case <-cli.Done():
+			case <-ctx.Done():
+			}
+		})
+		if err != nil {
+			return err
+		}
+		if !first.Code.IsSuccess() {
+			return fmt.Errorf("response code %s: %s", first.Code, first.Payload)
+		}
+		cs.setStatus(api.ConnectionConnected, nil)
+		if !cs.send(ctx, first, consumer) {
+			if obs != nil {
+				obs.Cancel()
+			}
+			return nil
+		}
+		if obs == nil {
+			logger.Infof("coap resource %s%s is not observable, poll it every %d ms", cs.addr, cs.path, cs.conf.Interval)
+			return cs.poll(ctx, cli, consumer)
+		}
+		timer := time.NewTimer(coapMaxAge(first))
+	loop:
+		for {
+			select {
+			case m := <-notes:
+				if !m.Code.IsSuccess() {
+					timer.Stop()
+					obs.Cancel()
+					return fmt.Errorf("observation is terminated with code %s: %s", m.Code, m.Payload)
+				}
+				if !cs.send(ctx, m, consumer) {
+					timer.Stop()
+					obs.Cancel()
+					return nil
+				}
+				timer.Reset(coapMaxAge(m))
+			case <-timer.C:
+				logger.Infof("coap source has no notification from %s%s in max age, observe again", cs.addr, cs.path)
+				obs.Cancel()
+				break loop
+			case <-cli.Done():
+				timer.Stop()
+				return cli.Err()
+			case <-ctx.Done():
+				timer.Stop()
+				obs.Cancel()
+				return nil
+			}
+		}
+	}
+}
+
+func coapMaxAge(m *coapx.Message) time.Duration {
+	age, ok := m.UintOption(coapx.MaxAge)
+	if !ok {
+		age = coapx.DefaultMaxAge
+	}
+	return time.Duration(age)*time.Second + coapObserveSlack
+}
+
+// poll gets the resource by the interval
+func (cs *CoapSource) poll(ctx api.StreamContext, cli *coapx.Client, consumer chan<- api.SourceTuple) error {
+	ticker := time.NewTicker(time.Duration(cs.conf.Interval) * time.Millisecond)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			resp, err := cli.Do(cs.request())
+			if err != nil {
+				return err
+			}
+			if !resp.Code.IsSuccess() {
+				ctx.GetLogger().Warnf("coap source gets response code %s from %s%s: %s", resp.Code, cs.addr, cs.path, resp.Payload)
+				continue
+			}
+			cs.setStatus(api.ConnectionConnected, nil)
+			if !cs.send(ctx, resp, consumer) {
+				return nil
+			}
+		case <-cli.Done():
+			return cli.Err()
+		case <-ctx.Done():
+			return nil
+		}
+	}
+}
+
+// send decodes the payload of the response and sends the tuples. It returns false if the context is done.
+func (cs *CoapSource) send(ctx api.StreamContext, m *coapx.Message, consumer chan<- api.SourceTuple) bool {
+	logger := ctx.GetLogger()
+	if len(m.Payload) == 0 {
+		return true
+	}
+	results, err := decodeMessages(m.Payload, cs.conf.Format)
+	if err != nil {
+		logger.Warnf("coap source drops the invalid message: %v", err)
+		return true
+	}
+	meta := map[string]interface{}{"url": cs.conf.Url, "path": cs.path, "code": m.Code.String()}
+	if seq, ok := m.UintOption(coapx.Observe); ok {
+		meta["observe"] = seq
+	}
+	for _, result := range results {
+		select {
+		case consumer <- api.NewDefaultSourceTuple(result, meta):
+			logger.Debugf("send coap data to device node")
+		case <-ctx.Done():
+			return false
+		}
+	}
+	return true
+}
+
+func (cs *CoapSource) setStatus(st string, err error) {
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+	cs.status.Status = st
+	cs.status.Server = cs.addr
+	if err != nil {
+		cs.status.LastError = err.Error()
+	}
+}
+
+// ConnectionStatus returns the connection status to the device in client mode
+func (cs *CoapSource) ConnectionStatus() api.ConnectionStatus {
+	cs.mu.RLock()
+	defer cs.mu.RUnlock()
+	if cs.conf.Mode == coapx.MODE_SERVER {
+		return api.ConnectionStatus{Status: api.ConnectionConnected, Server: cs.conf.ListenAddr}
+	}
+	if cs.status.Status == "" {
+		return api.ConnectionStatus{Status: api.ConnectionConnecting, Server: cs.addr}
+	}
+	return cs.status
+}
+
+func (cs *CoapSource) Close(ctx api.StreamContext) error {
+	ctx.GetLogger().Infof("Closing coap source")
+	if cs.server != nil {
+		cs.server.Remove(cs.path)
+		coapx.ReleaseServer(cs.server)
+		cs.server = nil
+	}
+	return nil
+}
@@ -0,0 +1,217 @@
+// Copyright 2021 EMQ Technologies Co., Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package source
+
+import (
+	"github.com/lf-edge/ekuiper/internal/conf"
+	"github.com/lf-edge/ekuiper/internal/pkg/coapx"
+	"github.com/lf-edge/ekuiper/internal/testx"
+	"github.com/lf-edge/ekuiper/internal/topo/context"
+	"github.com/lf-edge/ekuiper/pkg/api"
+	"net"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestCoapConfigure(t *testing.T) {
+	var tests = []struct {
+		datasource string
+		props      map[string]interface{}
+		conf       *CoapSourceConfig
+		addr       string
+		path       string
+		err        string
+	}{
+		{
+			datasource: "sensors",
+			props:      map[string]interface{}{},
+			conf:       &CoapSourceConfig{Mode: coapx.MODE_SERVER, ListenAddr: ":5683", Observe: true, Interval: 10000, Confirmable: true, Timeout: 10000, ReconnectInterval: 5000, Format: "json"},
+			path:       "/sensors",
+		}, {
+			datasource: "/sensors",
+			props:      map[string]interface{}{"psk": "secret"},
+			conf:       &CoapSourceConfig{DTLSConf: coapx.DTLSConf{Psk: "secret"}, Mode: coapx.MODE_SERVER, ListenAddr: ":5684", Observe: true, Interval: 10000, Confirmable: true, Timeout: 10000, ReconnectInterval: 5000, Format: "json"},
+			path:       "/sensors",
+		}, {
+			datasource: "/temp",
+			props:      map[string]interface{}{"url": "coap://device", "observe": false, "interval": 1000},
+			conf:       &CoapSourceConfig{Mode: coapx.MODE_CLIENT, Url: "coap://device", Observe: false, Interval: 1000, Confirmable: true, Timeout: 10000, ReconnectInterval: 5000, Format: "json"},
+			addr:       "device:5683",
+			path:       "/temp",
+		}, {
+			props: map[string]interface{}{"mode": "server"},
+			err:   "path must be specified as the datasource in server mode",
+		}, {
+			props: map[string]interface{}{"mode": "client"},
+			err:   "property url is required in client mode",
+		}, {
+			props: map[string]interface{}{"url": "coaps://device/temp"},
+			err:   "property psk is required for coaps url only",
+		}, {
+			props: map[string]interface{}{"url": "coap://device/temp", "interval": 0},
+			err:   "invalid property interval: 0, must be a positive integer",
+		}, {
+			datasource: "/temp",
+			props:      map[string]interface{}{"psk": "0xzz"},
+			err:        "invalid property psk: encoding/hex: invalid byte: U+007A 'z'",
+		},
+	}
+	for i, tt := range tests {
+		s := &CoapSource{}
+		err := s.Configure(tt.datasource, tt.props)
+		if !reflect.DeepEqual(tt.err, testx.Errstring(err)) {
+			t.Errorf("%d: error mismatch:\n  exp=%s\n  got=%s", i, tt.err, err)
+		} else if err == nil && (!reflect.DeepEqual(tt.conf, s.conf) || tt.path != s.path || tt.addr != s.addr) {
+			t.Errorf("%d: conf mismatch:\n  exp=%v %s %s\n  got=%v %s %s", i, tt.conf, tt.addr, tt.path, s.conf, s.addr, s.path)
+		}
+	}
+}
+
+func TestCoapServerMode(t *testing.T) {
+	s := &CoapSource{}
+	if err := s.Configure("/sensors", map[string]interface{}{"listenAddr": "127.0.0.1:0"}); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithValue(context.Background(), context.LoggerKey, conf.Log).WithCancel()
+	defer cancel()
+	consumer := make(chan api.SourceTuple, 10)
+	errCh := make(chan error, 1)
+	s.Open(ctx, consumer, errCh)
+	defer s.Close(ctx)
+	select {
+	case err := <-errCh:
+		t.Fatal(err)
+	default:
+	}
+	cli, err := coapx.Dial(s.server.Addr().String(), nil, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cli.Close()
+	var tests = []struct {
+		method  coapx.Code
+		payload string
+		code    coapx.Code
+		result  []map[string]interface{}
+	}{
+		{method: coapx.POST, payload: `{"temperature":20}`, code: coapx.Changed, result: []map[string]interface{}{{"temperature": float64(20)}}},
+		{method: coapx.PUT, payload: `[{"temperature":21},{"temperature":22}]`, code: coapx.Changed, result: []map[string]interface{}{{"temperature": float64(21)}, {"temperature": float64(22)}}},
+		{method: coapx.POST, payload: `{"temperature"`, code: coapx.BadRequest},
+		{method: coapx.GET, code: coapx.MethodNotAllowed},
+	}
+	for i, tt := range tests {
+		req := &coapx.Message{Type: coapx.Confirmable, Code: tt.method, Payload: []byte(tt.payload)}
+		req.SetPath("/sensors")
+		resp, err := cli.Do(req)
+		if err != nil {
+			t.Fatalf("%d: %v", i, err)
+		}
+		if resp.Code != tt.code {
+			t.Errorf("%d: code mismatch, exp %s, got %s", i, tt.code, resp.Code)
+		}
+		for _, exp := range tt.result {
+			tuple := <-consumer
+			if !reflect.DeepEqual(exp, tuple.Message()) {
+				t.Errorf("%d: result mismatch:\n  exp=%v\n  got=%v", i, exp, tuple.Message())
+			}
+			if tuple.Meta()["path"] != "/sensors" || tuple.Meta()["remoteAddr"] == "" {
+				t.Errorf("%d: meta mismatch, got %v", i, tuple.Meta())
+			}
+		}
+	}
+	if len(consumer) != 0 {
+		t.Errorf("unexpected tuples %d", len(consumer))
+	}
+}
+
+// mockCoapDevice serves the temperature resource. It is observable if observable is true, and the observers are
+// notified with the next temperature every 50 ms. Otherwise, each GET returns the next temperature.
+func mockCoapDevice(t *testing.T, observable bool) net.PacketConn {
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		buf := make([]byte, 1500)
+		temp := 20
+		for {
+			n, remote, err := pc.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			req, err := coapx.Unmarshal(buf[:n])
+			if err != nil || req.Code != coapx.GET || req.Path() != "/temp" {
+				continue
+			}
+			write := func(m *coapx.Message) {
+				b, _ := m.Marshal()
+				_, _ = pc.WriteTo(b, remote)
+			}
+			resp := &coapx.Message{Type: coapx.Acknowledgement, Code: coapx.Content, MessageID: req.MessageID, Token: req.Token, Payload: []byte(`{"temperature":` + string(rune('0'+temp/10)) + string(rune('0'+temp%10)) + `}`)}
+			temp++
+			if v, ok := req.UintOption(coapx.Observe); !observable || !ok || v != 0 {
+				write(resp)
+				continue
+			}
+			resp.SetUintOption(coapx.Observe, 1)
+			write(resp)
+			go func(token []byte, temp int) {
+				for seq := uint32(2); seq < 4; seq++ {
+					time.Sleep(50 * time.Millisecond)
+					m := &coapx.Message{Type: coapx.NonConfirmable, Code: coapx.Content, MessageID: uint16(seq), Token: token, Payload: []byte(`{"temperature":` + string(rune('0'+temp/10)) + string(rune('0'+temp%10)) + `}`)}
+					m.SetUintOption(coapx.Observe, seq)
+					write(m)
+					temp++
+				}
+			}(req.Token, temp)
+		}
+	}()
+	return pc
+}
+
+func TestCoapClientMode(t *testing.T) {
+	for _, observable := range []bool{true, false} {
+		pc := mockCoapDevice(t, observable)
+		s := &CoapSource{}
+		if err := s.Configure("/temp", map[string]interface{}{"url": "coap://" + pc.LocalAddr().String(), "interval": 50}); err != nil {
+			t.Fatal(err)
+		}
+		ctx, cancel := context.WithValue(context.Background(), context.LoggerKey, conf.Log).WithCancel()
+		consumer := make(chan api.SourceTuple, 10)
+		errCh := make(chan error, 1)
+		go s.Open(ctx, consumer, errCh)
+		for i := 20; i < 23; i++ {
+			select {
+			case tuple := <-consumer:
+				exp := map[string]interface{}{"temperature": float64(i)}
+				if !reflect.DeepEqual(exp, tuple.Message()) {
+					t.Errorf("observable %v: result mismatch:\n  exp=%v\n  got=%v", observable, exp, tuple.Message())
+				}
+				if _, ok := tuple.Meta()["observe"]; ok != observable {
+					t.Errorf("observable %v: meta mismatch, got %v", observable, tuple.Meta())
+				}
+			case <-time.After(2 * time.Second):
+				t.Fatalf("observable %v: timeout for the result %d", observable, i)
+			}
+		}
+		if st := s.ConnectionStatus(); st.Status != api.ConnectionConnected {
+			t.Errorf("observable %v: status mismatch, got %v", observable, st)
+		}
+		cancel()
+		_ = s.Close(ctx)
+		_ = pc.Close()
+	}
+}
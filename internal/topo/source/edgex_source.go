@@ -24,6 +24,7 @@ import (
 	"github.com/edgexfoundry/go-mod-core-contracts/v2/dtos/requests"
 	"github.com/edgexfoundry/go-mod-messaging/v2/messaging"
 	"github.com/edgexfoundry/go-mod-messaging/v2/pkg/types"
+	"github.com/fxamacker/cbor/v2"
 	"github.com/lf-edge/ekuiper/internal/conf"
 	"github.com/lf-edge/ekuiper/pkg/api"
 	"github.com/lf-edge/ekuiper/pkg/cast"
@@ -71,7 +72,7 @@ func (es *EdgexSource) Configure(_ string, props map[string]interface{}) error {
 		return fmt.Errorf("read properties %v fail with error: %v", props, err)
 	}
 	if c.Format != message.FormatJson {
-		return fmt.Errorf("edgex source only supports `json` format")
+		return fmt.Errorf("edgex source only supports `json` format, the events in json or cbor are decoded by the content type")
 	}
 
 	if c.MessageType != MessageTypeEvent && c.MessageType != MessageTypeRequest {
@@ -136,86 +137,96 @@ func (es *EdgexSource) Open(ctx api.StreamContext, consumer chan<- api.SourceTup
 				if !ok { // the source is closed
 					return
 				}
-				if strings.ToLower(env.ContentType) == "application/json" {
-					var r interface{}
-					switch es.messageType {
-					case MessageTypeEvent:
-						r = &dtos.Event{}
-					case MessageTypeRequest:
-						r = &requests.AddEventRequest{}
+				e, err := es.decodeEvent(env)
+				if err != nil {
+					l := len(env.Payload)
+					if l > 200 {
+						l = 200
 					}
-					if err := json.Unmarshal(env.Payload, r); err != nil {
-						l := len(env.Payload)
-						if l > 200 {
-							l = 200
-						}
-						log.Warnf("payload %s unmarshal fail: %v", env.Payload[0:(l-1)], err)
-					} else {
-						result := make(map[string]interface{})
-						meta := make(map[string]interface{})
-						var e *dtos.Event
-						switch t := r.(type) {
-						case *dtos.Event:
-							e = t
-						case *requests.AddEventRequest:
-							e = &t.Event
-						}
-
-						log.Debugf("receive message %s from device %s", env.Payload, e.DeviceName)
-						for _, r := range e.Readings {
-							if r.ResourceName != "" {
-								if v, err := es.getValue(r, log); err != nil {
-									log.Warnf("fail to get value for %s: %v", r.ResourceName, err)
-								} else {
-									result[r.ResourceName] = v
-								}
-								r_meta := map[string]interface{}{}
-								r_meta["id"] = r.Id
-								//r_meta["created"] = r.Created
-								//r_meta["modified"] = r.Modified
-								r_meta["origin"] = r.Origin
-								//r_meta["pushed"] = r.Pushed
-								r_meta["deviceName"] = r.DeviceName
-								r_meta["profileName"] = r.ProfileName
-								r_meta["valueType"] = r.ValueType
-								if r.MediaType != "" {
-									r_meta["mediaType"] = r.MediaType
-								}
-								meta[r.ResourceName] = r_meta
+					log.Warnf("payload %x decode fail: %v", env.Payload[0:l], err)
+				} else {
+					result := make(map[string]interface{})
+					meta := make(map[string]interface{})
+					log.Debugf("receive message %s from device %s", env.Payload, e.DeviceName)
+					for _, r := range e.Readings {
+						if r.ResourceName != "" {
+							if v, err := es.getValue(r, log); err != nil {
+								log.Warnf("fail to get value for %s: %v", r.ResourceName, err)
 							} else {
-								log.Warnf("The name of readings should not be empty!")
+								result[r.ResourceName] = v
 							}
-						}
-						if len(result) > 0 {
-							meta["id"] = e.Id
-							//meta["pushed"] = e.Pushed
-							meta["deviceName"] = e.DeviceName
-							meta["profileName"] = e.ProfileName
-							meta["sourceName"] = e.SourceName
-							//meta["created"] = e.Created
-							//meta["modified"] = e.Modified
-							meta["origin"] = e.Origin
-							meta["tags"] = e.Tags
-							meta["correlationid"] = env.CorrelationID
-
-							select {
-							case consumer <- api.NewDefaultSourceTuple(result, meta):
-								log.Debugf("send data to device node")
-							case <-ctx.Done():
-								return
+							r_meta := map[string]interface{}{}
+							r_meta["id"] = r.Id
+							//r_meta["created"] = r.Created
+							//r_meta["modified"] = r.Modified
+							r_meta["origin"] = r.Origin
+							//r_meta["pushed"] = r.Pushed
+							r_meta["deviceName"] = r.DeviceName
+							r_meta["profileName"] = r.ProfileName
+							r_meta["valueType"] = r.ValueType
+							if r.MediaType != "" {
+								r_meta["mediaType"] = r.MediaType
 							}
+							meta[r.ResourceName] = r_meta
 						} else {
-							log.Warnf("No readings are processed for the event, so ignore it.")
+							log.Warnf("The name of readings should not be empty!")
 						}
 					}
-				} else {
-					log.Errorf("Unsupported data type %s.", env.ContentType)
+					if len(result) > 0 {
+						meta["id"] = e.Id
+						//meta["pushed"] = e.Pushed
+						meta["deviceName"] = e.DeviceName
+						meta["profileName"] = e.ProfileName
+						meta["sourceName"] = e.SourceName
+						//meta["created"] = e.Created
+						//meta["modified"] = e.Modified
+						meta["origin"] = e.Origin
+						meta["tags"] = e.Tags
+						meta["correlationid"] = env.CorrelationID
+
+						select {
+						case consumer <- api.NewDefaultSourceTuple(result, meta):
+							log.Debugf("send data to device node")
+						case <-ctx.Done():
+							return
+						}
+					} else {
+						log.Warnf("No readings are processed for the event, so ignore it.")
+					}
 				}
 			}
 		}
 	}
 }
 
+// decodeEvent decodes the event or the add event request by the content type of the envelope. The events with
+// binary readings are encoded in CBOR by EdgeX.
+func (es *EdgexSource) decodeEvent(env types.MessageEnvelope) (*dtos.Event, error) {
+	var unmarshal func([]byte, interface{}) error
+	switch strings.ToLower(env.ContentType) {
+	case v2.ContentTypeJSON:
+		unmarshal = json.Unmarshal
+	case v2.ContentTypeCBOR:
+		unmarshal = cbor.Unmarshal
+	default:
+		return nil, fmt.Errorf("unsupported content type %s", env.ContentType)
+	}
+	switch es.messageType {
+	case MessageTypeRequest:
+		r := &requests.AddEventRequest{}
+		if err := r.Unmarshal(env.Payload, unmarshal); err != nil {
+			return nil, err
+		}
+		return &r.Event, nil
+	default:
+		e := &dtos.Event{}
+		if err := unmarshal(env.Payload, e); err != nil {
+			return nil, err
+		}
+		return e, nil
+	}
+}
+
 func (es *EdgexSource) getValue(r dtos.BaseReading, logger api.Logger) (interface{}, error) {
 	t := r.ValueType
 	logger.Debugf("name %s with type %s", r.ResourceName, r.ValueType)
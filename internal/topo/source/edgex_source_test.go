@@ -21,9 +21,12 @@ import (
 	"fmt"
 	v2 "github.com/edgexfoundry/go-mod-core-contracts/v2/common"
 	"github.com/edgexfoundry/go-mod-core-contracts/v2/dtos"
+	"github.com/edgexfoundry/go-mod-core-contracts/v2/dtos/requests"
 	"github.com/edgexfoundry/go-mod-core-contracts/v2/models"
 	"github.com/edgexfoundry/go-mod-messaging/v2/pkg/types"
+	"github.com/fxamacker/cbor/v2"
 	"github.com/lf-edge/ekuiper/internal/conf"
+	"github.com/lf-edge/ekuiper/internal/testx"
 	"math"
 	"reflect"
 	"testing"
@@ -338,3 +341,39 @@ func TestGetValue_Binary(t *testing.T) {
 		t.Errorf("result mismatch, expect %v, but got %v", ev, v)
 	}
 }
+
+func TestDecodeEvent(t *testing.T) {
+	e := dtos.NewEvent("profile1", "device1", "source1")
+	e.AddBinaryReading("bin", []byte("Hello World"), "image/png")
+	_ = e.AddSimpleReading("temperature", v2.ValueTypeFloat64, 21.5)
+	req := requests.NewAddEventRequest(e)
+	ej, _ := json.Marshal(e)
+	ec, _ := cbor.Marshal(e)
+	rj, _ := json.Marshal(req)
+	rc, _ := cbor.Marshal(req)
+	var tests = []struct {
+		messageType messageType
+		env         types.MessageEnvelope
+		err         string
+	}{
+		{messageType: MessageTypeEvent, env: types.MessageEnvelope{ContentType: v2.ContentTypeJSON, Payload: ej}},
+		{messageType: MessageTypeEvent, env: types.MessageEnvelope{ContentType: v2.ContentTypeCBOR, Payload: ec}},
+		{messageType: MessageTypeRequest, env: types.MessageEnvelope{ContentType: "Application/JSON", Payload: rj}},
+		{messageType: MessageTypeRequest, env: types.MessageEnvelope{ContentType: v2.ContentTypeCBOR, Payload: rc}},
+		{messageType: MessageTypeEvent, env: types.MessageEnvelope{ContentType: "text/plain", Payload: ej}, err: "unsupported content type text/plain"},
+	}
+	for i, tt := range tests {
+		s := &EdgexSource{messageType: tt.messageType}
+		r, err := s.decodeEvent(tt.env)
+		if tt.err != testx.Errstring(err) {
+			t.Errorf("%d: error mismatch:\n  exp=%s\n  got=%v", i, tt.err, err)
+		} else if err == nil && !reflect.DeepEqual(e, *r) {
+			t.Errorf("%d: result mismatch:\n  exp=%v\n  got=%v", i, e, *r)
+		}
+	}
+	// The binary reading is surfaced as bytea
+	r, _ := (&EdgexSource{messageType: MessageTypeEvent}).decodeEvent(tests[1].env)
+	if v, err := es.getValue(r.Readings[0], conf.Log); err != nil || !reflect.DeepEqual([]byte("Hello World"), v) {
+		t.Errorf("binary reading mismatch, got %v %v", v, err)
+	}
+}
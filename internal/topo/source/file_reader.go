@@ -0,0 +1,476 @@
+// Copyright 2021 EMQ Technologies Co., Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package source
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"github.com/lf-edge/ekuiper/internal/pkg/linex"
+	"github.com/lf-edge/ekuiper/pkg/api"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// lineDecoder decodes a line of the line based file types: jsonl, csv and lines
+type lineDecoder struct {
+	fileType  FileType
+	delimiter rune
+	hasHeader bool
+	columns   []string
+	header    []string
+	// The parser of the lines file type
+	parser *linex.Parser
+}
+
+func newLineDecoder(cfg *FileSourceConfig, parser *linex.Parser) *lineDecoder {
+	d := &lineDecoder{fileType: cfg.FileType, delimiter: ',', hasHeader: cfg.HasHeader, columns: cfg.Columns, parser: parser}
+	if cfg.Delimiter != "" {
+		d.delimiter = rune(cfg.Delimiter[0])
+	}
+	return d
+}
+
+// reset the decoder for a new file
+func (d *lineDecoder) reset() {
+	d.header = nil
+}
+
+// decode a line without the line break. Return nil if the line is empty, the csv header or not matched by the parser.
+func (d *lineDecoder) decode(line string) (map[string]interface{}, error) {
+	if strings.TrimSpace(line) == "" {
+		return nil, nil
+	}
+	switch d.fileType {
+	case JSONL_TYPE:
+		m := make(map[string]interface{})
+		if err := json.Unmarshal([]byte(line), &m); err != nil {
+			return nil, fmt.Errorf("invalid json line: %v", err)
+		}
+		return m, nil
+	case CSV_TYPE:
+		r := csv.NewReader(strings.NewReader(line))
+		r.Comma = d.delimiter
+		r.LazyQuotes = true
+		fields, err := r.Read()
+		if err != nil {
+			return nil, fmt.Errorf("invalid csv line: %v", err)
+		}
+		if d.hasHeader && d.header == nil {
+			d.header = fields
+			return nil, nil
+		}
+		names := d.columns
+		if d.header != nil {
+			names = d.header
+		}
+		m := make(map[string]interface{}, len(fields))
+		for i, f := range fields {
+			if i < len(names) {
+				m[names[i]] = f
+			} else {
+				m[fmt.Sprintf("col%d", i+1)] = f
+			}
+		}
+		return m, nil
+	default:
+		if d.parser != nil {
+			m, _, err := d.parser.Parse(line)
+			if err != nil {
+				return nil, fmt.Errorf("invalid line: %v", err)
+			}
+			return m, nil
+		}
+		return map[string]interface{}{"line": line}, nil
+	}
+}
+
+// readRecords decodes the records of the whole file and passes them to fn until fn returns false
+func (fs *FileSource) readRecords(file string, fn func(map[string]interface{}) bool) error {
+	f, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	var r io.Reader = f
+	if fs.config.Decompression == "gzip" || strings.HasSuffix(file, ".gz") {
+		gz, err := gzip.NewReader(f)
+		if err != nil {
+			return fmt.Errorf("fail to decompress: %v", err)
+		}
+		defer gz.Close()
+		r = gz
+	}
+	if fs.config.FileType == JSON_TYPE {
+		content, err := ioutil.ReadAll(r)
+		if err != nil {
+			return err
+		}
+		var records []map[string]interface{}
+		if trimmed := bytes.TrimSpace(content); len(trimmed) > 0 && trimmed[0] == '{' {
+			m := make(map[string]interface{})
+			err = json.Unmarshal(trimmed, &m)
+			records = append(records, m)
+		} else {
+			err = json.Unmarshal(content, &records)
+		}
+		if err != nil {
+			return err
+		}
+		for _, m := range records {
+			if !fn(m) {
+				return nil
+			}
+		}
+		return nil
+	}
+	fs.decoder.reset()
+	br := bufio.NewReader(r)
+	for lineNo := 1; ; lineNo++ {
+		line, err := br.ReadString('\n')
+		if len(line) > 0 {
+			m, derr := fs.decoder.decode(strings.TrimRight(line, "\r\n"))
+			if derr != nil {
+				return fmt.Errorf("line %d: %v", lineNo, derr)
+			}
+			if m != nil && !fn(m) {
+				return nil
+			}
+		}
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+	}
+}
+
+func (fs *FileSource) readFile(file string) ([]map[string]interface{}, error) {
+	var records []map[string]interface{}
+	err := fs.readRecords(file, func(m map[string]interface{}) bool {
+		records = append(records, m)
+		return true
+	})
+	return records, err
+}
+
+// follow tails the file and sends the appended lines. The incomplete last line is held until its line break
+// is written. If the file is rotated, the remaining lines of the old file are read before switching to the
+// new file. If the file is truncated, read from the beginning again.
+func (fs *FileSource) follow(ctx api.StreamContext, consumer chan<- api.SourceTuple) {
+	logger := ctx.GetLogger()
+	fs.Lock()
+	offset := fs.offset
+	if fs.curFile != fs.file {
+		offset = 0
+	}
+	fs.Unlock()
+	var (
+		f       *os.File
+		fi      os.FileInfo
+		reader  *bufio.Reader
+		partial string
+	)
+	defer func() {
+		if f != nil {
+			f.Close()
+		}
+	}()
+	meta := map[string]interface{}{"file": fs.file}
+	ticker := time.NewTicker(time.Duration(fs.config.Interval) * time.Millisecond)
+	defer ticker.Stop()
+	for {
+		if f == nil {
+			var err error
+			f, fi, err = fs.openFollow(offset)
+			if err != nil {
+				if !os.IsNotExist(err) {
+					logger.Warnf("file source fails to open %s: %v", fs.file, err)
+				}
+			} else {
+				if fi.Size() < offset {
+					offset = 0
+					_, _ = f.Seek(0, io.SeekStart)
+					fs.decoder.reset()
+				}
+				reader = bufio.NewReader(f)
+				partial = ""
+				logger.Infof("file source follows %s from offset %d", fs.file, offset)
+			}
+		}
+		if f != nil {
+			count := 0
+			for {
+				line, err := reader.ReadString('\n')
+				if err != nil {
+					partial += line
+					break
+				}
+				line = partial + line
+				partial = ""
+				offset += int64(len(line))
+				m, derr := fs.decoder.decode(strings.TrimRight(line, "\r\n"))
+				if derr != nil {
+					logger.Warnf("file source drops the invalid line of %s: %v", fs.file, derr)
+				} else if m != nil {
+					select {
+					case consumer <- api.NewDefaultSourceTuple(m, meta):
+						count++
+					case <-ctx.Done():
+						return
+					}
+				}
+				fs.setOffset(fs.file, offset)
+			}
+			if count > 0 && !fs.sendEOF(ctx, consumer) {
+				return
+			}
+			if nfi, err := os.Stat(fs.file); err == nil {
+				if !os.SameFile(fi, nfi) {
+					logger.Infof("file %s is rotated", fs.file)
+					f.Close()
+					f = nil
+					offset = 0
+					fs.decoder.reset()
+					fs.setOffset(fs.file, offset)
+					continue
+				} else if nfi.Size() < offset+int64(len(partial)) {
+					logger.Infof("file %s is truncated", fs.file)
+					_, _ = f.Seek(0, io.SeekStart)
+					reader.Reset(f)
+					offset = 0
+					partial = ""
+					fs.decoder.reset()
+					fs.setOffset(fs.file, offset)
+					continue
+				}
+			}
+		}
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// openFollow opens the followed file at the offset. The csv header is read first if resuming from the middle.
+func (fs *FileSource) openFollow(offset int64) (*os.File, os.FileInfo, error) {
+	f, err := os.Open(fs.file)
+	if err != nil {
+		return nil, nil, err
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, nil, err
+	}
+	fs.decoder.reset()
+	if offset > 0 && offset <= fi.Size() {
+		if fs.config.FileType == CSV_TYPE && fs.config.HasHeader {
+			line, _ := bufio.NewReader(f).ReadString('\n')
+			_, _ = fs.decoder.decode(strings.TrimRight(line, "\r\n"))
+		}
+		if _, err := f.Seek(offset, io.SeekStart); err != nil {
+			f.Close()
+			return nil, nil, err
+		}
+	}
+	return f, fi, nil
+}
+
+// watchDir processes the files in the directory by the order of modification time. After a file is read and
+// all its tuples are acknowledged, it is deleted, moved or kept according to actionAfterRead. The read files are
+// recorded to skip.
+func (fs *FileSource) watchDir(ctx api.StreamContext, consumer chan<- api.SourceTuple, errCh chan<- error) {
+	logger := ctx.GetLogger()
+	if fs.config.ActionAfterRead == ACTION_MOVE {
+		if err := os.MkdirAll(fs.config.MoveTo, os.ModePerm); err != nil {
+			errCh <- fmt.Errorf("fail to create moveTo directory %s: %v", fs.config.MoveTo, err)
+			return
+		}
+	}
+	// Apply the pending files restored from the checkpoint
+	fs.applyAction(ctx, 0)
+	ticker := time.NewTicker(time.Duration(fs.config.Interval) * time.Millisecond)
+	defer ticker.Stop()
+	for {
+		if err := fs.scanDir(ctx, consumer); err != nil {
+			logger.Warnf("file source fails to scan directory %s: %v", fs.file, err)
+		}
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func (fs *FileSource) scanDir(ctx api.StreamContext, consumer chan<- api.SourceTuple) error {
+	logger := ctx.GetLogger()
+	infos, err := ioutil.ReadDir(fs.file)
+	if err != nil {
+		return err
+	}
+	sort.SliceStable(infos, func(i, j int) bool {
+		return infos[i].ModTime().Before(infos[j].ModTime())
+	})
+	existed := make(map[string]bool, len(infos))
+	for _, info := range infos {
+		existed[info.Name()] = true
+	}
+	fs.Lock()
+	processed := make(map[string]bool, len(fs.processed))
+	var remained []string
+	for _, name := range fs.processed {
+		// Forget the removed files so that a new file of the same name could be processed
+		if existed[name] {
+			processed[name] = true
+			remained = append(remained, name)
+		}
+	}
+	fs.processed = remained
+	fs.Unlock()
+	for _, info := range infos {
+		name := info.Name()
+		if !info.Mode().IsRegular() || processed[name] {
+			continue
+		}
+		if fs.config.FilePattern != "" {
+			if ok, _ := filepath.Match(fs.config.FilePattern, name); !ok {
+				continue
+			}
+		}
+		fs.Lock()
+		var skip int64
+		if fs.curFile == name {
+			skip = fs.offset
+		}
+		fs.Unlock()
+		file := filepath.Join(fs.file, name)
+		logger.Infof("file source starts to read %s", file)
+		meta := map[string]interface{}{"file": file}
+		var count int64
+		done, sent := false, false
+		err := fs.readRecords(file, func(m map[string]interface{}) bool {
+			count++
+			if count <= skip {
+				return true
+			}
+			if !fs.sendDir(ctx, consumer, api.NewDefaultSourceTuple(m, meta), name, count) {
+				done = true
+				return false
+			}
+			sent = true
+			return true
+		})
+		if done {
+			return nil
+		}
+		fs.Lock()
+		fs.curFile = ""
+		fs.offset = 0
+		fs.processed = append(fs.processed, name)
+		fs.Unlock()
+		if err != nil {
+			logger.Errorf("file source fails to read %s: %v", file, err)
+			// Keep the invalid file for investigation
+			continue
+		}
+		if fs.config.IsTable && fs.config.RetainSize <= 0 {
+			if !fs.sendDir(ctx, consumer, api.NewDefaultSourceTuple(nil, nil), "", 0) {
+				return nil
+			}
+			sent = true
+		}
+		if fs.config.ActionAfterRead == ACTION_KEEP {
+			continue
+		}
+		fs.Lock()
+		fs.pending = append(fs.pending, pendingFile{name: name, seq: fs.seq})
+		fs.Unlock()
+		// Nothing to acknowledge, apply the action right away
+		if !sent {
+			fs.applyAction(ctx, fs.seq)
+		}
+	}
+	return nil
+}
+
+// sendDir sends the tuple with the next sequence and updates the offset if the file name is set. Returns false if
+// the source is done.
+func (fs *FileSource) sendDir(ctx api.StreamContext, consumer chan<- api.SourceTuple, t *api.DefaultSourceTuple, name string, offset int64) bool {
+	fs.Lock()
+	seq := fs.seq + 1
+	fs.Unlock()
+	select {
+	case consumer <- &dirTuple{DefaultSourceTuple: t, seq: seq}:
+		fs.Lock()
+		fs.seq = seq
+		if name != "" {
+			fs.curFile = name
+			fs.offset = offset
+		}
+		fs.Unlock()
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// applyAction deletes or moves the pending files whose tuples are acknowledged by the sequence. If failed,
+// the file is kept and skipped.
+func (fs *FileSource) applyAction(ctx api.StreamContext, seq int64) {
+	logger := ctx.GetLogger()
+	fs.Lock()
+	i := 0
+	for ; i < len(fs.pending) && fs.pending[i].seq <= seq; i++ {
+	}
+	files := fs.pending[:i]
+	fs.pending = fs.pending[i:]
+	fs.Unlock()
+	for _, f := range files {
+		file := filepath.Join(fs.file, f.name)
+		var err error
+		switch fs.config.ActionAfterRead {
+		case ACTION_DELETE:
+			err = os.Remove(file)
+		case ACTION_MOVE:
+			err = os.Rename(file, filepath.Join(fs.config.MoveTo, f.name))
+		default:
+			continue
+		}
+		if err != nil {
+			logger.Warnf("file source fails to %s %s: %v", fs.config.ActionAfterRead, file, err)
+			continue
+		}
+		fs.Lock()
+		for j, name := range fs.processed {
+			if name == f.name {
+				fs.processed = append(fs.processed[:j:j], fs.processed[j+1:]...)
+				break
+			}
+		}
+		fs.Unlock()
+	}
+}
@@ -18,41 +18,96 @@ import (
 	"errors"
 	"fmt"
 	"github.com/lf-edge/ekuiper/internal/conf"
-	"github.com/lf-edge/ekuiper/internal/pkg/filex"
+	"github.com/lf-edge/ekuiper/internal/pkg/linex"
 	"github.com/lf-edge/ekuiper/pkg/api"
 	"github.com/lf-edge/ekuiper/pkg/cast"
 	"os"
 	"path"
 	"path/filepath"
+	"strings"
+	"sync"
 	"time"
 )
 
 type FileType string
 
 const (
-	JSON_TYPE FileType = "json"
+	JSON_TYPE  FileType = "json"
+	JSONL_TYPE FileType = "jsonl"
+	CSV_TYPE   FileType = "csv"
+	LINES_TYPE FileType = "lines"
 )
 
 var fileTypes = map[FileType]bool{
-	JSON_TYPE: true,
+	JSON_TYPE:  true,
+	JSONL_TYPE: true,
+	CSV_TYPE:   true,
+	LINES_TYPE: true,
 }
 
+const (
+	ACTION_KEEP   = "keep"
+	ACTION_DELETE = "delete"
+	ACTION_MOVE   = "move"
+)
+
+const DEFAULT_WATCH_INTERVAL = 1000
+
 type FileSourceConfig struct {
 	FileType   FileType `json:"fileType"`
 	Path       string   `json:"path"`
 	Interval   int      `json:"interval"`
 	RetainSize int      `json:"$retainSize"`
+	IsTable    bool     `json:"$isTable"`
+	// Options of csv file
+	HasHeader bool     `json:"hasHeader"`
+	Columns   []string `json:"columns"`
+	Delimiter string   `json:"delimiter"`
+	// Options of lines file to parse each line into fields
+	linex.Conf
+	// gzip or empty. The file with .gz extension is always decompressed by gzip
+	Decompression string `json:"decompression"`
+	// Tail the file for the appended lines
+	Follow bool `json:"follow"`
+	// Options of directory mode
+	FilePattern     string `json:"filePattern"`
+	ActionAfterRead string `json:"actionAfterRead"`
+	MoveTo          string `json:"moveTo"`
 }
 
-// The BATCH to load data from file at once
+// FileSource reads the file content. By default, it loads the whole file at once and reloads by interval,
+// which is usually used by table. In follow mode, it tails the file for the appended lines like a log.
+// If the datasource is a directory, it processes the files in the directory one by one when they appear.
 type FileSource struct {
-	file   string
-	config *FileSourceConfig
+	sync.Mutex
+	file    string
+	isDir   bool
+	config  *FileSourceConfig
+	decoder *lineDecoder
+	// The offset of the reading file. It is the byte offset in follow mode, otherwise the record count
+	curFile   string
+	offset    int64
+	processed []string
+	// The sequence of the last tuple sent in directory mode and the read files waiting for the action after read
+	seq     int64
+	pending []pendingFile
+}
+
+// dirTuple is the tuple sent in directory mode. The sequence is used to find the files whose tuples are all
+// acknowledged.
+type dirTuple struct {
+	*api.DefaultSourceTuple
+	seq int64
+}
+
+// pendingFile is a read file whose action after read is applied once the tuple of the sequence is acknowledged
+type pendingFile struct {
+	name string
+	seq  int64
 }
 
 func (fs *FileSource) Close(ctx api.StreamContext) error {
 	ctx.GetLogger().Infof("Close file source")
-	// do nothing
 	return nil
 }
 
@@ -63,7 +118,7 @@ func (fs *FileSource) Configure(fileName string, props map[string]interface{}) e
 		return fmt.Errorf("read properties %v fail with error: %v", props, err)
 	}
 	if cfg.FileType == "" {
-		return errors.New("missing or invalid property fileType, must be 'json'")
+		return errors.New("missing or invalid property fileType, must be 'json', 'jsonl', 'csv' or 'lines'")
 	}
 	if _, ok := fileTypes[cfg.FileType]; !ok {
 		return fmt.Errorf("invalid property fileType: %s", cfg.FileType)
@@ -71,9 +126,6 @@ func (fs *FileSource) Configure(fileName string, props map[string]interface{}) e
 	if cfg.Path == "" {
 		return errors.New("missing property Path")
 	}
-	if fileName == "" {
-		return errors.New("file name must be specified")
-	}
 	if !filepath.IsAbs(cfg.Path) {
 		cfg.Path, err = conf.GetLoc(cfg.Path)
 		if err != nil {
@@ -81,19 +133,81 @@ func (fs *FileSource) Configure(fileName string, props map[string]interface{}) e
 		}
 	}
 	fs.file = path.Join(cfg.Path, fileName)
+	if cfg.Decompression != "" && cfg.Decompression != "gzip" {
+		return fmt.Errorf("invalid property decompression: %s, only gzip is supported", cfg.Decompression)
+	}
+	if len(cfg.Delimiter) > 1 {
+		return fmt.Errorf("invalid property delimiter: %s, must be a single character", cfg.Delimiter)
+	}
+	if cfg.Follow {
+		if cfg.FileType == JSON_TYPE {
+			return errors.New("follow mode does not support json file type, use jsonl instead")
+		}
+		if cfg.Decompression != "" || strings.HasSuffix(fs.file, ".gz") {
+			return errors.New("follow mode does not support compressed file")
+		}
+	}
+	switch cfg.ActionAfterRead {
+	case "":
+		cfg.ActionAfterRead = ACTION_KEEP
+	case ACTION_KEEP, ACTION_DELETE:
+	case ACTION_MOVE:
+		if cfg.MoveTo == "" {
+			return errors.New("property moveTo is required when actionAfterRead is move")
+		}
+		if !filepath.IsAbs(cfg.MoveTo) {
+			cfg.MoveTo, err = conf.GetLoc(cfg.MoveTo)
+			if err != nil {
+				return fmt.Errorf("invalid moveTo %s", cfg.MoveTo)
+			}
+		}
+	default:
+		return fmt.Errorf("invalid property actionAfterRead: %s, must be keep, delete or move", cfg.ActionAfterRead)
+	}
 
 	if fi, err := os.Stat(fs.file); err != nil {
-		if os.IsNotExist(err) {
-			return fmt.Errorf("file %s not exist", fs.file)
-		} else if !fi.Mode().IsRegular() {
-			return fmt.Errorf("file %s is not a regular file", fs.file)
+		// The followed file may be created later
+		if !cfg.Follow {
+			if os.IsNotExist(err) {
+				return fmt.Errorf("file %s not exist", fs.file)
+			}
+			return err
+		}
+	} else if fi.IsDir() {
+		if cfg.Follow {
+			return fmt.Errorf("follow mode requires a file but %s is a directory", fs.file)
+		}
+		fs.isDir = true
+	} else if !fi.Mode().IsRegular() {
+		return fmt.Errorf("file %s is not a regular file", fs.file)
+	}
+	if (cfg.Follow || fs.isDir) && cfg.Interval <= 0 {
+		cfg.Interval = DEFAULT_WATCH_INTERVAL
+	}
+	var parser *linex.Parser
+	if cfg.Conf.Enabled() {
+		if cfg.FileType != LINES_TYPE {
+			return errors.New("property pattern and grok are only supported by lines file type")
+		}
+		parser, err = linex.NewParser(&cfg.Conf)
+		if err != nil {
+			return err
 		}
 	}
 	fs.config = cfg
+	fs.decoder = newLineDecoder(cfg, parser)
 	return nil
 }
 
 func (fs *FileSource) Open(ctx api.StreamContext, consumer chan<- api.SourceTuple, errCh chan<- error) {
+	if fs.config.Follow {
+		fs.follow(ctx, consumer)
+		return
+	}
+	if fs.isDir {
+		fs.watchDir(ctx, consumer, errCh)
+		return
+	}
 	err := fs.Load(ctx, consumer)
 	if err != nil {
 		errCh <- err
@@ -119,39 +233,104 @@ func (fs *FileSource) Open(ctx api.StreamContext, consumer chan<- api.SourceTupl
 	}
 }
 
+// Load reads the whole file and sends the records. For table without retain size, an EOF is sent at last.
 func (fs *FileSource) Load(ctx api.StreamContext, consumer chan<- api.SourceTuple) error {
-	switch fs.config.FileType {
-	case JSON_TYPE:
-		ctx.GetLogger().Debugf("Start to load from file %s", fs.file)
-		resultMap := make([]map[string]interface{}, 0)
-		err := filex.ReadJsonUnmarshal(fs.file, &resultMap)
-		if err != nil {
-			return fmt.Errorf("loaded %s, check error %s", fs.file, err)
-		}
-		ctx.GetLogger().Debug("Sending tuples")
-		if fs.config.RetainSize > 0 && fs.config.RetainSize < len(resultMap) {
-			resultMap = resultMap[(len(resultMap) - fs.config.RetainSize):]
-			ctx.GetLogger().Debug("Sending tuples for retain size %d", fs.config.RetainSize)
-		}
-		for _, m := range resultMap {
-			select {
-			case consumer <- api.NewDefaultSourceTuple(m, nil):
-				// do nothing
-			case <-ctx.Done():
-				return nil
-			}
-		}
-		// Send EOF if retain size not set
-		if fs.config.RetainSize == 0 {
-			select {
-			case consumer <- api.NewDefaultSourceTuple(nil, nil):
-				// do nothing
-			case <-ctx.Done():
-				return nil
-			}
+	ctx.GetLogger().Debugf("Start to load from file %s", fs.file)
+	records, err := fs.readFile(fs.file)
+	if err != nil {
+		return fmt.Errorf("loaded %s, check error %s", fs.file, err)
+	}
+	ctx.GetLogger().Debug("Sending tuples")
+	if fs.config.RetainSize > 0 && fs.config.RetainSize < len(records) {
+		records = records[(len(records) - fs.config.RetainSize):]
+		ctx.GetLogger().Debug("Sending tuples for retain size %d", fs.config.RetainSize)
+	}
+	meta := map[string]interface{}{"file": fs.file}
+	for _, m := range records {
+		select {
+		case consumer <- api.NewDefaultSourceTuple(m, meta):
+			// do nothing
+		case <-ctx.Done():
+			return nil
 		}
-		ctx.GetLogger().Debug("All tuples sent")
+	}
+	if !fs.sendEOF(ctx, consumer) {
 		return nil
 	}
-	return fmt.Errorf("invalid file type %s", fs.config.FileType)
+	ctx.GetLogger().Debug("All tuples sent")
+	return nil
+}
+
+// sendEOF sends the EOF to notify the table that a batch is done. Returns false if the source is done.
+func (fs *FileSource) sendEOF(ctx api.StreamContext, consumer chan<- api.SourceTuple) bool {
+	if !fs.config.IsTable || fs.config.RetainSize > 0 {
+		return true
+	}
+	select {
+	case consumer <- api.NewDefaultSourceTuple(nil, nil):
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// GetOffset returns the reading position in follow and directory mode. The whole file is reloaded otherwise.
+// The processed files only include the kept files and the files waiting for the action after read. They are
+// removed once deleted or moved, and the files removed by others are trimmed in each scan.
+func (fs *FileSource) GetOffset() (interface{}, error) {
+	fs.Lock()
+	defer fs.Unlock()
+	if !fs.config.Follow && !fs.isDir {
+		return nil, nil
+	}
+	processed := make([]string, len(fs.processed))
+	copy(processed, fs.processed)
+	pending := make([]string, len(fs.pending))
+	for i, p := range fs.pending {
+		pending[i] = p.name
+	}
+	return map[string]interface{}{"file": fs.curFile, "offset": fs.offset, "processed": processed, "pending": pending}, nil
+}
+
+func (fs *FileSource) Rewind(offset interface{}) error {
+	m, ok := offset.(map[string]interface{})
+	if !ok {
+		return fmt.Errorf("file source fails to rewind: invalid offset %v", offset)
+	}
+	fs.Lock()
+	defer fs.Unlock()
+	if f, ok := m["file"].(string); ok {
+		fs.curFile = f
+	}
+	if o, err := cast.ToInt64(m["offset"], cast.CONVERT_SAMEKIND); err == nil {
+		fs.offset = o
+	}
+	if p, ok := m["processed"].([]string); ok {
+		fs.processed = p
+	}
+	// The pending files of the restored checkpoint are all consumed, so they are applied once the source opens
+	if p, ok := m["pending"].([]string); ok {
+		fs.pending = make([]pendingFile, len(p))
+		for i, name := range p {
+			fs.pending[i] = pendingFile{name: name}
+		}
+	}
+	return nil
+}
+
+// Ack applies the action after read to the files whose tuples are all acknowledged in directory mode. If the rule
+// qos is at least once, it is called once the checkpoint is completed so that the files are not deleted or
+// moved before the checkpoint covers them.
+func (fs *FileSource) Ack(ctx api.StreamContext, tuple api.SourceTuple) error {
+	if t, ok := tuple.(*dirTuple); ok {
+		fs.applyAction(ctx, t.seq)
+	}
+	return nil
+}
+
+func (fs *FileSource) setOffset(file string, offset int64) {
+	fs.Lock()
+	fs.curFile = file
+	fs.offset = offset
+	fs.Unlock()
 }
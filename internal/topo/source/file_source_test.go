@@ -0,0 +1,227 @@
+// Copyright 2021 EMQ Technologies Co., Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package source
+
+import (
+	"bytes"
+	"compress/gzip"
+	"github.com/lf-edge/ekuiper/internal/conf"
+	"github.com/lf-edge/ekuiper/internal/topo/context"
+	"github.com/lf-edge/ekuiper/pkg/api"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func receiveFile(t *testing.T, consumer chan api.SourceTuple, exp []map[string]interface{}) api.SourceTuple {
+	var last api.SourceTuple
+	for i, e := range exp {
+		select {
+		case r := <-consumer:
+			if !reflect.DeepEqual(e, r.Message()) {
+				t.Errorf("%d: message mismatch:\n  exp=%v\n  got=%v", i, e, r.Message())
+			}
+			last = r
+		case <-time.After(2 * time.Second):
+			t.Fatalf("%d: timeout to receive %v", i, e)
+		}
+	}
+	return last
+}
+
+func TestFileSourceLoad(t *testing.T) {
+	dir := t.TempDir()
+	var gz bytes.Buffer
+	w := gzip.NewWriter(&gz)
+	_, _ = w.Write([]byte("id;name\n1;\"a;b\"\n2;c\n"))
+	_ = w.Close()
+	files := map[string][]byte{
+		"data.csv.gz":  gz.Bytes(),
+		"data.jsonl":   []byte("{\"a\":1}\n\n{\"a\":2}"),
+		"data.log":     []byte("line1\r\nline2\n"),
+		"access.log":   []byte("10.0.0.1 GET /index.html 200 512\n-- restart --\n10.0.0.2 POST /api 500 0\n"),
+		"data.json":    []byte(`[{"a":1},{"a":2}]`),
+		"noheader.csv": []byte("1,2,3\n"),
+	}
+	for name, content := range files {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), content, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var tests = []struct {
+		file  string
+		props map[string]interface{}
+		exp   []map[string]interface{}
+	}{
+		{
+			file:  "data.csv.gz",
+			props: map[string]interface{}{"fileType": "csv", "hasHeader": true, "delimiter": ";"},
+			exp:   []map[string]interface{}{{"id": "1", "name": "a;b"}, {"id": "2", "name": "c"}},
+		}, {
+			file:  "noheader.csv",
+			props: map[string]interface{}{"fileType": "csv", "columns": []interface{}{"x", "y"}},
+			exp:   []map[string]interface{}{{"x": "1", "y": "2", "col3": "3"}},
+		}, {
+			file:  "data.jsonl",
+			props: map[string]interface{}{"fileType": "jsonl"},
+			exp:   []map[string]interface{}{{"a": float64(1)}, {"a": float64(2)}},
+		}, {
+			file:  "data.log",
+			props: map[string]interface{}{"fileType": "lines"},
+			exp:   []map[string]interface{}{{"line": "line1"}, {"line": "line2"}},
+		}, {
+			file:  "access.log",
+			props: map[string]interface{}{"fileType": "lines", "grok": "%{IP:client} %{WORD:method} %{URIPATH:path} %{INT:status:int} %{INT:bytes:int}"},
+			exp: []map[string]interface{}{
+				{"client": "10.0.0.1", "method": "GET", "path": "/index.html", "status": 200, "bytes": 512},
+				{"client": "10.0.0.2", "method": "POST", "path": "/api", "status": 500, "bytes": 0},
+			},
+		}, {
+			file:  "data.json",
+			props: map[string]interface{}{"fileType": "json", "$isTable": true},
+			exp:   []map[string]interface{}{{"a": float64(1)}, {"a": float64(2)}, nil},
+		},
+	}
+	ctx := context.WithValue(context.Background(), context.LoggerKey, conf.Log)
+	for i, tt := range tests {
+		tt.props["path"] = dir
+		s := &FileSource{}
+		if err := s.Configure(tt.file, tt.props); err != nil {
+			t.Errorf("%d: configure error %v", i, err)
+			continue
+		}
+		consumer := make(chan api.SourceTuple, 10)
+		if err := s.Load(ctx, consumer); err != nil {
+			t.Errorf("%d: load error %v", i, err)
+			continue
+		}
+		var result []map[string]interface{}
+		for len(consumer) > 0 {
+			result = append(result, (<-consumer).Message())
+		}
+		if !reflect.DeepEqual(tt.exp, result) {
+			t.Errorf("%d: result mismatch:\n  exp=%v\n  got=%v", i, tt.exp, result)
+		}
+	}
+}
+
+func TestFileSourceFollow(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "app.log")
+	if err := ioutil.WriteFile(file, []byte("{\"a\":1}\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	s := &FileSource{}
+	if err := s.Configure("app.log", map[string]interface{}{"path": dir, "fileType": "jsonl", "follow": true, "interval": 20}); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithValue(context.Background(), context.LoggerKey, conf.Log).WithCancel()
+	defer cancel()
+	consumer := make(chan api.SourceTuple, 10)
+	go s.Open(ctx, consumer, make(chan error, 1))
+	receiveFile(t, consumer, []map[string]interface{}{{"a": float64(1)}})
+
+	f, err := os.OpenFile(file, os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The incomplete line is held until the line break
+	_, _ = f.WriteString("{\"a\":2}\n{\"a\":")
+	receiveFile(t, consumer, []map[string]interface{}{{"a": float64(2)}})
+	_, _ = f.WriteString("3}\n")
+	_ = f.Close()
+	receiveFile(t, consumer, []map[string]interface{}{{"a": float64(3)}})
+	offset, _ := s.GetOffset()
+	exp := map[string]interface{}{"file": file, "offset": int64(24), "processed": []string{}, "pending": []string{}}
+	if !reflect.DeepEqual(exp, offset) {
+		t.Errorf("offset mismatch:\n  exp=%v\n  got=%v", exp, offset)
+	}
+
+	// Rotate
+	if err := os.Rename(file, file+".1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(file, []byte("{\"a\":4}\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	receiveFile(t, consumer, []map[string]interface{}{{"a": float64(4)}})
+	cancel()
+
+	// Resume from the offset
+	ctx, cancel = context.WithValue(context.Background(), context.LoggerKey, conf.Log).WithCancel()
+	defer cancel()
+	f, _ = os.OpenFile(file, os.O_APPEND|os.O_WRONLY, 0644)
+	_, _ = f.WriteString("{\"a\":5}\n")
+	_ = f.Close()
+	offset, _ = s.GetOffset()
+	s = &FileSource{}
+	_ = s.Configure("app.log", map[string]interface{}{"path": dir, "fileType": "jsonl", "follow": true, "interval": 20})
+	_ = s.Rewind(offset)
+	consumer = make(chan api.SourceTuple, 10)
+	go s.Open(ctx, consumer, make(chan error, 1))
+	receiveFile(t, consumer, []map[string]interface{}{{"a": float64(5)}})
+}
+
+func TestFileSourceDir(t *testing.T) {
+	dir := t.TempDir()
+	in := filepath.Join(dir, "in")
+	done := filepath.Join(dir, "done")
+	_ = os.Mkdir(in, 0755)
+	if err := ioutil.WriteFile(filepath.Join(in, "1.csv"), []byte("id\n1\n2\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	_ = ioutil.WriteFile(filepath.Join(in, "ignore.txt"), []byte("x\n"), 0644)
+	s := &FileSource{}
+	err := s.Configure("in", map[string]interface{}{"path": dir, "fileType": "csv", "hasHeader": true, "interval": 20, "filePattern": "*.csv", "actionAfterRead": "move", "moveTo": done})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithValue(context.Background(), context.LoggerKey, conf.Log).WithCancel()
+	defer cancel()
+	consumer := make(chan api.SourceTuple, 10)
+	go s.Open(ctx, consumer, make(chan error, 1))
+	receiveFile(t, consumer, []map[string]interface{}{{"id": "1"}, {"id": "2"}})
+	if err := ioutil.WriteFile(filepath.Join(in, "2.csv"), []byte("id\n3\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	last := receiveFile(t, consumer, []map[string]interface{}{{"id": "3"}})
+	time.Sleep(50 * time.Millisecond)
+	// The files are not moved until the tuples are acknowledged
+	if _, err := os.Stat(filepath.Join(in, "1.csv")); err != nil {
+		t.Errorf("expect 1.csv to be kept before ack: %v", err)
+	}
+	offset, _ := s.GetOffset()
+	exp := map[string]interface{}{"file": "", "offset": int64(0), "processed": []string{"1.csv", "2.csv"}, "pending": []string{"1.csv", "2.csv"}}
+	if !reflect.DeepEqual(exp, offset) {
+		t.Errorf("offset mismatch:\n  exp=%v\n  got=%v", exp, offset)
+	}
+	_ = s.Ack(ctx, last)
+	for _, name := range []string{"1.csv", "2.csv"} {
+		if _, err := os.Stat(filepath.Join(done, name)); err != nil {
+			t.Errorf("expect %s to be moved: %v", name, err)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(in, "ignore.txt")); err != nil {
+		t.Errorf("expect ignore.txt to be kept: %v", err)
+	}
+	offset, _ = s.GetOffset()
+	exp = map[string]interface{}{"file": "", "offset": int64(0), "processed": []string{}, "pending": []string{}}
+	if !reflect.DeepEqual(exp, offset) {
+		t.Errorf("offset mismatch:\n  exp=%v\n  got=%v", exp, offset)
+	}
+}
@@ -15,23 +15,53 @@
 package source
 
 import (
+	"bytes"
+	"context"
 	"crypto/md5"
 	"encoding/hex"
+	"encoding/json"
 	"fmt"
+	"github.com/PaesslerAG/gval"
+	"github.com/PaesslerAG/jsonpath"
 	"github.com/lf-edge/ekuiper/internal/conf"
 	"github.com/lf-edge/ekuiper/internal/pkg/httpx"
+	ct "github.com/lf-edge/ekuiper/internal/template"
 	"github.com/lf-edge/ekuiper/pkg/api"
 	"github.com/lf-edge/ekuiper/pkg/cast"
 	"github.com/lf-edge/ekuiper/pkg/message"
 	"io/ioutil"
 	"net/http"
 	"net/url"
+	"strconv"
 	"strings"
+	"text/template"
 	"time"
 )
 
 const DEFAULT_INTERVAL = 10000
 const DEFAULT_TIMEOUT = 5000
+const DEFAULT_MAX_PAGES = 100
+
+const (
+	PAGINATION_PAGE   = "page"
+	PAGINATION_CURSOR = "cursor"
+)
+
+type paginationConf struct {
+	// page or cursor
+	Type string `json:"type"`
+	// The query parameter of the page number and its start value
+	PageParam string `json:"pageParam"`
+	StartPage int    `json:"startPage"`
+	// The query parameter of the page size and its value. If set, the pagination stops when a page has less records.
+	SizeParam string `json:"sizeParam"`
+	PageSize  int    `json:"pageSize"`
+	// The query parameter of the cursor and the json path to get the next cursor from the response
+	CursorParam string `json:"cursorParam"`
+	CursorPath  string `json:"cursorPath"`
+	// The max pages to fetch in one pull
+	MaxPages int `json:"maxPages"`
+}
 
 type HTTPPullSource struct {
 	url           string
@@ -43,8 +73,18 @@ type HTTPPullSource struct {
 	bodyType      string
 	headers       map[string]string
 	messageFormat string
+	responsePath  gval.Evaluable
+	pagination    *paginationConf
+	oauth         *httpx.OAuthConf
+	// The templates of url and body if they have template actions
+	urlTp  *template.Template
+	bodyTp *template.Template
 
-	client *http.Client
+	client      *http.Client
+	tokenSource *httpx.TokenSource
+	// The time in milliseconds of the last successful pull
+	lastPull int64
+	omd5     string
 }
 
 var bodyTypeMap = map[string]string{"none": "", "text": "text/plain", "json": "application/json", "html": "text/html", "xml": "application/xml", "javascript": "application/javascript", "form": ""}
@@ -139,18 +179,112 @@ func (hps *HTTPPullSource) Configure(device string, props map[string]interface{}
 		}
 	}
 
+	if p, ok := props["responsePath"]; ok {
+		if p1, ok1 := p.(string); ok1 && p1 != "" {
+			e, err := gval.Full(jsonpath.PlaceholderExtension()).NewEvaluable(p1)
+			if err != nil {
+				return fmt.Errorf("Not valid responsePath value %s: %v.", p1, err)
+			}
+			hps.responsePath = e
+		}
+	}
+
+	if p, ok := props["pagination"]; ok {
+		pc := &paginationConf{}
+		if err := cast.MapToStruct(p, pc); err != nil {
+			return fmt.Errorf("Not valid pagination value %v: %v.", p, err)
+		}
+		if err := pc.validate(); err != nil {
+			return err
+		}
+		hps.pagination = pc
+	}
+
+	if (hps.responsePath != nil || hps.pagination != nil) && hps.messageFormat != message.FormatJson {
+		return fmt.Errorf("responsePath and pagination are only supported by json format.")
+	}
+
+	if o, ok := props["oauth"]; ok {
+		oc := &httpx.OAuthConf{}
+		if err := cast.MapToStruct(o, oc); err != nil {
+			return fmt.Errorf("Not valid oauth value %v: %v.", o, err)
+		}
+		hps.oauth = oc
+	}
+
+	var err error
+	if hps.urlTp, err = parseTemplate("url", hps.url); err != nil {
+		return err
+	}
+	if hps.bodyTp, err = parseTemplate("body", hps.body); err != nil {
+		return err
+	}
+
 	conf.Log.Debugf("Initialized with configurations %#v.", hps)
 	return nil
 }
 
+func (pc *paginationConf) validate() error {
+	switch pc.Type {
+	case PAGINATION_PAGE:
+		if pc.PageParam == "" {
+			pc.PageParam = "page"
+		}
+		if pc.StartPage == 0 {
+			pc.StartPage = 1
+		}
+		if pc.SizeParam != "" && pc.PageSize <= 0 {
+			return fmt.Errorf("pagination pageSize must be positive if sizeParam is set")
+		}
+	case PAGINATION_CURSOR:
+		if pc.CursorParam == "" {
+			pc.CursorParam = "cursor"
+		}
+		if pc.CursorPath == "" {
+			return fmt.Errorf("pagination cursorPath is required for cursor pagination")
+		}
+		if _, err := gval.Full(jsonpath.PlaceholderExtension()).NewEvaluable(pc.CursorPath); err != nil {
+			return fmt.Errorf("invalid pagination cursorPath %s: %v", pc.CursorPath, err)
+		}
+	default:
+		return fmt.Errorf("invalid pagination type %s, the value could be only page or cursor", pc.Type)
+	}
+	if pc.MaxPages <= 0 {
+		pc.MaxPages = DEFAULT_MAX_PAGES
+	}
+	return nil
+}
+
+// parseTemplate returns nil if the text has no template action
+func parseTemplate(name, text string) (*template.Template, error) {
+	if !strings.Contains(text, "{{") {
+		return nil, nil
+	}
+	tp, err := template.New(name).Funcs(ct.FuncMap).Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("Not valid %s template %s: %v.", name, text, err)
+	}
+	return tp, nil
+}
+
 func (hps *HTTPPullSource) Open(ctx api.StreamContext, consumer chan<- api.SourceTuple, errCh chan<- error) {
-	_, e := url.Parse(hps.url)
-	if e != nil {
-		errCh <- e
-		return
+	if hps.urlTp == nil {
+		_, e := url.Parse(hps.url)
+		if e != nil {
+			errCh <- e
+			return
+		}
 	}
 
 	hps.client = &http.Client{Timeout: time.Duration(hps.timeout) * time.Millisecond}
+	if hps.oauth != nil {
+		ts, err := httpx.NewTokenSource(hps.oauth, hps.client)
+		if err != nil {
+			errCh <- err
+			return
+		}
+		hps.tokenSource = ts
+	}
 	hps.initTimerPull(ctx, consumer, errCh)
 }
 
@@ -164,42 +298,17 @@ func (hps *HTTPPullSource) initTimerPull(ctx api.StreamContext, consumer chan<-
 	ticker := time.NewTicker(time.Millisecond * time.Duration(hps.interval))
 	logger := ctx.GetLogger()
 	defer ticker.Stop()
-	var omd5 = ""
 	for {
 		select {
 		case <-ticker.C:
-			if resp, e := httpx.Send(logger, hps.client, hps.bodyType, hps.method, hps.url, hps.headers, true, []byte(hps.body)); e != nil {
-				logger.Warnf("Found error %s when trying to reach %v ", e, hps)
-			} else {
-				logger.Debugf("rest sink got response %v", resp)
-				if resp.StatusCode < 200 || resp.StatusCode > 299 {
-					logger.Warnf("Found error http return code: %d when trying to reach %v ", resp.StatusCode, hps)
-					break
-				}
-				c, err := ioutil.ReadAll(resp.Body)
-				if err != nil {
-					logger.Warnf("Found error %s when trying to reach %v ", err, hps)
-				}
-				resp.Body.Close()
-				if hps.incremental {
-					nmd5 := getMD5Hash(c)
-					if omd5 == nmd5 {
-						logger.Debugf("Content has not changed since last fetch, so skip processing.")
-						continue
-					} else {
-						omd5 = nmd5
-					}
-				}
-
-				result, e := message.Decode(c, hps.messageFormat)
-				meta := make(map[string]interface{})
-				if e != nil {
-					logger.Errorf("Invalid data format, cannot decode %s to %s format with error %s", string(c), hps.messageFormat, e)
-					return
-				}
-
+			records, err := hps.pull(logger)
+			if err != nil {
+				logger.Warnf("Found error %s when trying to reach %v ", err, hps)
+				continue
+			}
+			for _, r := range records {
 				select {
-				case consumer <- api.NewDefaultSourceTuple(result, meta):
+				case consumer <- api.NewDefaultSourceTuple(r, make(map[string]interface{})):
 					logger.Debugf("send data to device node")
 				case <-ctx.Done():
 					return
@@ -211,7 +320,213 @@ func (hps *HTTPPullSource) initTimerPull(ctx api.StreamContext, consumer chan<-
 	}
 }
 
-func getMD5Hash(text []byte) string {
-	hash := md5.Sum(text)
-	return hex.EncodeToString(hash[:])
+// pull fetches all the pages and returns the records. The pull time is only updated if all pages are fetched
+// successfully so that the next pull covers the time range again.
+func (hps *HTTPPullSource) pull(logger api.Logger) ([]map[string]interface{}, error) {
+	now := conf.GetNowInMilli()
+	last := hps.lastPull
+	if last == 0 {
+		last = now - int64(hps.interval)
+	}
+	vars := map[string]interface{}{"LastPullTime": last, "PullTime": now}
+	u, err := execTemplate(hps.urlTp, hps.url, vars)
+	if err != nil {
+		return nil, err
+	}
+	body, err := execTemplate(hps.bodyTp, hps.body, vars)
+	if err != nil {
+		return nil, err
+	}
+
+	var (
+		result  []map[string]interface{}
+		hash    = md5.New()
+		page    int
+		cursor  string
+		fetched int
+	)
+	if hps.pagination != nil {
+		page = hps.pagination.StartPage
+	}
+	for {
+		pu, err := hps.pageUrl(u, page, cursor, fetched)
+		if err != nil {
+			return nil, err
+		}
+		c, err := hps.fetch(logger, pu, []byte(body))
+		if err != nil {
+			return nil, err
+		}
+		hash.Write(c)
+		records, data, err := hps.decode(c)
+		if err != nil {
+			return nil, fmt.Errorf("invalid data format, cannot decode %s to %s format with error %s", string(c), hps.messageFormat, err)
+		}
+		result = append(result, records...)
+		fetched++
+		pc := hps.pagination
+		if pc == nil || fetched >= pc.MaxPages {
+			break
+		}
+		if pc.Type == PAGINATION_PAGE {
+			if len(records) == 0 || (pc.SizeParam != "" && len(records) < pc.PageSize) {
+				break
+			}
+			page++
+		} else {
+			cursor, err = nextCursor(pc.CursorPath, data)
+			if err != nil {
+				return nil, err
+			}
+			if cursor == "" {
+				break
+			}
+		}
+	}
+	hps.lastPull = now
+	if hps.incremental {
+		nmd5 := hex.EncodeToString(hash.Sum(nil))
+		if hps.omd5 == nmd5 {
+			logger.Debugf("Content has not changed since last fetch, so skip processing.")
+			return nil, nil
+		}
+		hps.omd5 = nmd5
+	}
+	return result, nil
+}
+
+func execTemplate(tp *template.Template, text string, vars map[string]interface{}) (string, error) {
+	if tp == nil {
+		return text, nil
+	}
+	var b bytes.Buffer
+	if err := tp.Execute(&b, vars); err != nil {
+		return "", fmt.Errorf("fail to execute %s template: %v", tp.Name(), err)
+	}
+	return b.String(), nil
+}
+
+// pageUrl sets the pagination query parameters to the url
+func (hps *HTTPPullSource) pageUrl(u string, page int, cursor string, fetched int) (string, error) {
+	pc := hps.pagination
+	if pc == nil {
+		return u, nil
+	}
+	// The first page of the cursor pagination has no cursor
+	if pc.Type == PAGINATION_CURSOR && fetched == 0 {
+		return u, nil
+	}
+	pu, err := url.Parse(u)
+	if err != nil {
+		return "", err
+	}
+	q := pu.Query()
+	if pc.Type == PAGINATION_PAGE {
+		q.Set(pc.PageParam, strconv.Itoa(page))
+		if pc.SizeParam != "" {
+			q.Set(pc.SizeParam, strconv.Itoa(pc.PageSize))
+		}
+	} else {
+		q.Set(pc.CursorParam, cursor)
+	}
+	pu.RawQuery = q.Encode()
+	return pu.String(), nil
+}
+
+// fetch sends the request and returns the response body. If the OAuth token is rejected, it will be refreshed and
+// the request is retried once.
+func (hps *HTTPPullSource) fetch(logger api.Logger, u string, body []byte) ([]byte, error) {
+	for retried := false; ; retried = true {
+		headers := hps.headers
+		if hps.tokenSource != nil {
+			auth, err := hps.tokenSource.Authorization()
+			if err != nil {
+				return nil, err
+			}
+			headers = make(map[string]string, len(hps.headers)+1)
+			for k, v := range hps.headers {
+				headers[k] = v
+			}
+			headers["Authorization"] = auth
+		}
+		resp, err := httpx.Send(logger, hps.client, hps.bodyType, hps.method, u, headers, true, body)
+		if err != nil {
+			return nil, err
+		}
+		logger.Debugf("http pull source got response %v", resp)
+		c, err := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		if resp.StatusCode == http.StatusUnauthorized && hps.tokenSource != nil && !retried {
+			logger.Infof("The access token is rejected, refresh it and retry")
+			hps.tokenSource.Invalidate()
+			continue
+		}
+		if resp.StatusCode < 200 || resp.StatusCode > 299 {
+			return nil, fmt.Errorf("http return code: %d", resp.StatusCode)
+		}
+		if err != nil {
+			return nil, err
+		}
+		return c, nil
+	}
+}
+
+// decode returns the records and the decoded response. For json format, each element of the array selected by the
+// responsePath or the response itself becomes a record.
+func (hps *HTTPPullSource) decode(c []byte) ([]map[string]interface{}, interface{}, error) {
+	if hps.messageFormat != message.FormatJson {
+		r, err := message.Decode(c, hps.messageFormat)
+		if err != nil {
+			return nil, nil, err
+		}
+		return []map[string]interface{}{r}, nil, nil
+	}
+	var data interface{}
+	if err := json.Unmarshal(c, &data); err != nil {
+		return nil, nil, err
+	}
+	v := data
+	if hps.responsePath != nil {
+		var err error
+		v, err = hps.responsePath(context.Background(), data)
+		if err != nil {
+			return nil, nil, fmt.Errorf("fail to get responsePath: %v", err)
+		}
+	}
+	switch vt := v.(type) {
+	case map[string]interface{}:
+		return []map[string]interface{}{vt}, data, nil
+	case []interface{}:
+		result := make([]map[string]interface{}, 0, len(vt))
+		for _, e := range vt {
+			if m, ok := e.(map[string]interface{}); ok {
+				result = append(result, m)
+			} else {
+				return nil, nil, fmt.Errorf("the record %v is not an object", e)
+			}
+		}
+		return result, data, nil
+	case nil:
+		return nil, data, nil
+	default:
+		return nil, nil, fmt.Errorf("the response %v is not an object or array", v)
+	}
+}
+
+func nextCursor(path string, data interface{}) (string, error) {
+	v, err := jsonpath.Get(path, data)
+	if err != nil {
+		// The cursor does not exist in the last page
+		return "", nil
+	}
+	switch vt := v.(type) {
+	case nil:
+		return "", nil
+	case string:
+		return vt, nil
+	case float64:
+		return strconv.FormatFloat(vt, 'f', -1, 64), nil
+	default:
+		return "", fmt.Errorf("invalid cursor %v", v)
+	}
 }
@@ -0,0 +1,243 @@
+// Copyright 2021 EMQ Technologies Co., Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package source
+
+import (
+	"fmt"
+	"github.com/lf-edge/ekuiper/internal/conf"
+	"github.com/lf-edge/ekuiper/internal/pkg/httpx"
+	"github.com/lf-edge/ekuiper/internal/topo/topotest/mockclock"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+type mockHttpServer struct {
+	sync.Mutex
+	tokens  int
+	valid   string
+	queries []string
+}
+
+func (m *mockHttpServer) handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		if id, secret, ok := r.BasicAuth(); !ok || id != "cid" || secret != "secret" || r.FormValue("grant_type") != "client_credentials" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		m.Lock()
+		m.tokens++
+		m.valid = fmt.Sprintf("t%d", m.tokens)
+		token := m.valid
+		m.Unlock()
+		fmt.Fprintf(w, `{"access_token":"%s","token_type":"bearer","expires_in":3600}`, token)
+	})
+	mux.HandleFunc("/pages", func(w http.ResponseWriter, r *http.Request) {
+		m.Lock()
+		valid := m.valid
+		m.queries = append(m.queries, r.URL.RawQuery)
+		m.Unlock()
+		if r.Header.Get("Authorization") != "Bearer "+valid {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		switch r.URL.Query().Get("page") {
+		case "1":
+			fmt.Fprint(w, `{"data":{"items":[{"id":1},{"id":2}]}}`)
+		case "2":
+			fmt.Fprint(w, `{"data":{"items":[{"id":3}]}}`)
+		default:
+			fmt.Fprint(w, `{"data":{"items":[]}}`)
+		}
+	})
+	mux.HandleFunc("/cursor", func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Query().Get("cursor") {
+		case "":
+			fmt.Fprint(w, `{"items":[{"id":1}],"next":"c1"}`)
+		case "c1":
+			fmt.Fprint(w, `{"items":[{"id":2}],"next":null}`)
+		default:
+			w.WriteHeader(http.StatusBadRequest)
+		}
+	})
+	mux.HandleFunc("/range", func(w http.ResponseWriter, r *http.Request) {
+		m.Lock()
+		m.queries = append(m.queries, r.URL.RawQuery)
+		m.Unlock()
+		fmt.Fprint(w, `[{"id":1}]`)
+	})
+	return mux
+}
+
+func openPullSource(t *testing.T, props map[string]interface{}) *HTTPPullSource {
+	s := &HTTPPullSource{}
+	if err := s.Configure("", props); err != nil {
+		t.Fatal(err)
+	}
+	s.client = &http.Client{}
+	if s.oauth != nil {
+		ts, err := httpx.NewTokenSource(s.oauth, s.client)
+		if err != nil {
+			t.Fatal(err)
+		}
+		s.tokenSource = ts
+	}
+	return s
+}
+
+func TestHTTPPullPagination(t *testing.T) {
+	m := &mockHttpServer{}
+	server := httptest.NewServer(m.handler())
+	defer server.Close()
+	s := openPullSource(t, map[string]interface{}{
+		"url":          server.URL + "/pages",
+		"method":       "get",
+		"bodyType":     "none",
+		"responsePath": "$.data.items",
+		"pagination": map[string]interface{}{
+			"type":      "page",
+			"sizeParam": "size",
+			"pageSize":  2,
+		},
+		"oauth": map[string]interface{}{
+			"tokenUrl":     server.URL + "/token",
+			"clientId":     "cid",
+			"clientSecret": "secret",
+		},
+	})
+	exp := []map[string]interface{}{{"id": 1.0}, {"id": 2.0}, {"id": 3.0}}
+	r, err := s.pull(conf.Log)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(exp, r) {
+		t.Errorf("result mismatch:\n  exp=%v\n  got=%v", exp, r)
+	}
+	// The page 2 has less records than the page size so that page 3 is not fetched
+	if expQ := []string{"page=1&size=2", "page=2&size=2"}; !reflect.DeepEqual(expQ, m.queries) {
+		t.Errorf("queries mismatch:\n  exp=%v\n  got=%v", expQ, m.queries)
+	}
+
+	// The token is revoked by the server, it should be refreshed
+	m.Lock()
+	m.valid = "revoked"
+	m.Unlock()
+	r, err = s.pull(conf.Log)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(exp, r) {
+		t.Errorf("result mismatch after token refresh:\n  exp=%v\n  got=%v", exp, r)
+	}
+	if m.tokens != 2 {
+		t.Errorf("expect 2 tokens fetched but got %d", m.tokens)
+	}
+}
+
+func TestHTTPPullCursor(t *testing.T) {
+	m := &mockHttpServer{}
+	server := httptest.NewServer(m.handler())
+	defer server.Close()
+	s := openPullSource(t, map[string]interface{}{
+		"url":          server.URL + "/cursor",
+		"method":       "get",
+		"bodyType":     "none",
+		"responsePath": "$.items",
+		"incremental":  true,
+		"pagination": map[string]interface{}{
+			"type":       "cursor",
+			"cursorPath": "$.next",
+		},
+	})
+	exp := []map[string]interface{}{{"id": 1.0}, {"id": 2.0}}
+	r, err := s.pull(conf.Log)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(exp, r) {
+		t.Errorf("result mismatch:\n  exp=%v\n  got=%v", exp, r)
+	}
+	// Incremental, the content is not changed
+	r, err = s.pull(conf.Log)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(r) != 0 {
+		t.Errorf("expect no result for the unchanged content but got %v", r)
+	}
+}
+
+func TestHTTPPullTimeRange(t *testing.T) {
+	mockclock.ResetClock(10000)
+	m := &mockHttpServer{}
+	server := httptest.NewServer(m.handler())
+	defer server.Close()
+	s := openPullSource(t, map[string]interface{}{
+		"url":      server.URL + "/range?from={{.LastPullTime}}&to={{.PullTime}}",
+		"method":   "get",
+		"bodyType": "none",
+		"interval": 1000,
+	})
+	r, err := s.pull(conf.Log)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if exp := []map[string]interface{}{{"id": 1.0}}; !reflect.DeepEqual(exp, r) {
+		t.Errorf("result mismatch:\n  exp=%v\n  got=%v", exp, r)
+	}
+	mockclock.GetMockClock().Add(time.Second)
+	if _, err := s.pull(conf.Log); err != nil {
+		t.Fatal(err)
+	}
+	if expQ := []string{"from=9000&to=10000", "from=10000&to=11000"}; !reflect.DeepEqual(expQ, m.queries) {
+		t.Errorf("queries mismatch:\n  exp=%v\n  got=%v", expQ, m.queries)
+	}
+}
+
+func TestHTTPPullConfigure(t *testing.T) {
+	var tests = []struct {
+		props map[string]interface{}
+		err   string
+	}{
+		{
+			props: map[string]interface{}{"pagination": map[string]interface{}{"type": "offset"}},
+			err:   "invalid pagination type offset, the value could be only page or cursor",
+		}, {
+			props: map[string]interface{}{"pagination": map[string]interface{}{"type": "cursor"}},
+			err:   "pagination cursorPath is required for cursor pagination",
+		}, {
+			props: map[string]interface{}{"responsePath": "$.data", "format": "binary"},
+			err:   "responsePath and pagination are only supported by json format.",
+		}, {
+			props: map[string]interface{}{"url": "http://localhost/{{.LastPullTime"},
+			err:   "Not valid url template http://localhost/{{.LastPullTime: template: url:1: unclosed action.",
+		},
+	}
+	for i, tt := range tests {
+		s := &HTTPPullSource{}
+		err := s.Configure("", tt.props)
+		var errStr string
+		if err != nil {
+			errStr = err.Error()
+		}
+		if errStr != tt.err {
+			t.Errorf("%d: error mismatch:\n  exp=%s\n  got=%s", i, tt.err, errStr)
+		}
+	}
+}
@@ -0,0 +1,157 @@
+// Copyright 2021 EMQ Technologies Co., Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package source
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"github.com/lf-edge/ekuiper/internal/pkg/httpserver"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// pushServer manages the endpoints of the http push sources in the embedded http server
+type pushServer struct {
+	sync.RWMutex
+	endpoints map[string]*pushEndpoint
+}
+
+// pushEndpoint is a path of the embedded http server which may be subscribed by multiple source instances.
+// All the subscribers must share the same method and authentication.
+type pushEndpoint struct {
+	sync.RWMutex
+	path        string
+	conf        *HTTPPushConfig
+	subscribers []*HTTPPushSource
+}
+
+var pushSrv = &pushServer{endpoints: make(map[string]*pushEndpoint)}
+
+func (s *pushServer) register(endpoint string, source *HTTPPushSource) error {
+	s.Lock()
+	defer s.Unlock()
+	if ep, ok := s.endpoints[endpoint]; ok {
+		if !ep.conf.sameEndpoint(source.conf) {
+			return fmt.Errorf("endpoint %s is already registered with different method, authentication or max body size", endpoint)
+		}
+		ep.Lock()
+		ep.subscribers = append(ep.subscribers, source)
+		ep.Unlock()
+		return nil
+	}
+	ep := &pushEndpoint{path: endpoint, conf: source.conf, subscribers: []*HTTPPushSource{source}}
+	if err := httpserver.RegisterEndpoint(endpoint, ep); err != nil {
+		return err
+	}
+	s.endpoints[endpoint] = ep
+	return nil
+}
+
+func (s *pushServer) unregister(endpoint string, source *HTTPPushSource) {
+	s.Lock()
+	defer s.Unlock()
+	ep, ok := s.endpoints[endpoint]
+	if !ok {
+		return
+	}
+	ep.Lock()
+	for i, sub := range ep.subscribers {
+		if sub == source {
+			ep.subscribers = append(ep.subscribers[:i], ep.subscribers[i+1:]...)
+			break
+		}
+	}
+	empty := len(ep.subscribers) == 0
+	ep.Unlock()
+	if empty {
+		delete(s.endpoints, endpoint)
+		httpserver.UnregisterEndpoint(endpoint)
+	}
+}
+
+func (ep *pushEndpoint) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != ep.conf.Method {
+		http.Error(w, fmt.Sprintf("method %s is not allowed", r.Method), http.StatusMethodNotAllowed)
+		return
+	}
+	if !ep.conf.authorize(r) {
+		if ep.conf.AuthType == AUTH_BASIC {
+			w.Header().Set("WWW-Authenticate", `Basic realm="ekuiper"`)
+		}
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	body, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, ep.conf.MaxBodySize))
+	if err != nil {
+		// MaxBytesReader does not expose a typed error in the supported go versions
+		if strings.Contains(err.Error(), "request body too large") {
+			http.Error(w, fmt.Sprintf("body exceeds the max size %d bytes", ep.conf.MaxBodySize), http.StatusRequestEntityTooLarge)
+		} else {
+			http.Error(w, fmt.Sprintf("fail to read body: %v", err), http.StatusBadRequest)
+		}
+		return
+	}
+	meta := make(map[string]interface{}, len(r.Header)+2)
+	for k, v := range r.Header {
+		meta[k] = strings.Join(v, ",")
+	}
+	meta["method"] = r.Method
+	if err := ep.Receive(body, meta); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// Receive sends the body to all the subscribers. It is also called by the data delivered in process
+// such as the responses captured by the rest sink.
+func (ep *pushEndpoint) Receive(body []byte, meta map[string]interface{}) error {
+	meta["path"] = ep.path
+	ep.RLock()
+	subscribers := make([]*HTTPPushSource, len(ep.subscribers))
+	copy(subscribers, ep.subscribers)
+	ep.RUnlock()
+	for _, sub := range subscribers {
+		if err := sub.ingest(body, meta); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *HTTPPushConfig) sameEndpoint(o *HTTPPushConfig) bool {
+	return c.Method == o.Method && c.AuthType == o.AuthType && c.Token == o.Token && c.Username == o.Username && c.Password == o.Password && c.MaxBodySize == o.MaxBodySize
+}
+
+func (c *HTTPPushConfig) authorize(r *http.Request) bool {
+	switch c.AuthType {
+	case AUTH_BEARER:
+		h := r.Header.Get("Authorization")
+		if !strings.HasPrefix(h, "Bearer ") {
+			return false
+		}
+		return subtle.ConstantTimeCompare([]byte(strings.TrimPrefix(h, "Bearer ")), []byte(c.Token)) == 1
+	case AUTH_BASIC:
+		u, p, ok := r.BasicAuth()
+		if !ok {
+			return false
+		}
+		return subtle.ConstantTimeCompare([]byte(u), []byte(c.Username)) == 1 && subtle.ConstantTimeCompare([]byte(p), []byte(c.Password)) == 1
+	default:
+		return true
+	}
+}
@@ -0,0 +1,156 @@
+// Copyright 2021 EMQ Technologies Co., Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package source
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/lf-edge/ekuiper/pkg/api"
+	"github.com/lf-edge/ekuiper/pkg/cast"
+	"github.com/lf-edge/ekuiper/pkg/message"
+	"net/http"
+	"strings"
+)
+
+const (
+	AUTH_NONE   = "none"
+	AUTH_BEARER = "bearer"
+	AUTH_BASIC  = "basic"
+)
+
+// DEFAULT_MAX_BODY_SIZE is the default limit of the request body in bytes
+const DEFAULT_MAX_BODY_SIZE = 10 * 1024 * 1024
+
+type HTTPPushConfig struct {
+	Method   string `json:"method"`
+	AuthType string `json:"authType"`
+	Token    string `json:"token"`
+	Username string `json:"username"`
+	Password string `json:"password"`
+	Format   string `json:"format"`
+	// MaxBodySize is the limit of the request body in bytes
+	MaxBodySize int64 `json:"maxBodySize"`
+}
+
+// HTTPPushSource receives the data pushed to an endpoint of the shared http push server
+type HTTPPushSource struct {
+	endpoint string
+	conf     *HTTPPushConfig
+
+	ctx      api.StreamContext
+	consumer chan<- api.SourceTuple
+}
+
+func (hps *HTTPPushSource) Configure(endpoint string, props map[string]interface{}) error {
+	cfg := &HTTPPushConfig{}
+	err := cast.MapToStruct(props, cfg)
+	if err != nil {
+		return fmt.Errorf("read properties %v fail with error: %v", props, err)
+	}
+	if endpoint == "" {
+		return errors.New("endpoint must be specified as the datasource")
+	}
+	if !strings.HasPrefix(endpoint, "/") {
+		endpoint = "/" + endpoint
+	}
+	switch strings.ToUpper(cfg.Method) {
+	case "":
+		cfg.Method = http.MethodPost
+	case http.MethodPost, http.MethodPut:
+		cfg.Method = strings.ToUpper(cfg.Method)
+	default:
+		return fmt.Errorf("invalid property method: %s, must be post or put", cfg.Method)
+	}
+	cfg.AuthType = strings.ToLower(cfg.AuthType)
+	switch cfg.AuthType {
+	case "":
+		cfg.AuthType = AUTH_NONE
+	case AUTH_NONE:
+	case AUTH_BEARER:
+		if cfg.Token == "" {
+			return errors.New("property token is required for bearer authentication")
+		}
+	case AUTH_BASIC:
+		if cfg.Username == "" {
+			return errors.New("property username is required for basic authentication")
+		}
+	default:
+		return fmt.Errorf("invalid property authType: %s, must be none, bearer or basic", cfg.AuthType)
+	}
+	if cfg.MaxBodySize < 0 {
+		return fmt.Errorf("invalid property maxBodySize: %d, must not be negative", cfg.MaxBodySize)
+	}
+	if cfg.MaxBodySize == 0 {
+		cfg.MaxBodySize = DEFAULT_MAX_BODY_SIZE
+	}
+	if cfg.Format == "" {
+		cfg.Format = message.FormatJson
+	}
+	hps.endpoint = endpoint
+	hps.conf = cfg
+	return nil
+}
+
+func (hps *HTTPPushSource) Open(ctx api.StreamContext, consumer chan<- api.SourceTuple, errCh chan<- error) {
+	hps.ctx = ctx
+	hps.consumer = consumer
+	if err := pushSrv.register(hps.endpoint, hps); err != nil {
+		errCh <- err
+		return
+	}
+	ctx.GetLogger().Infof("Listening to http push endpoint %s", hps.endpoint)
+}
+
+// ingest decodes the pushed body and sends the messages to the consumer
+func (hps *HTTPPushSource) ingest(body []byte, meta map[string]interface{}) error {
+	results, err := decodeMessages(body, hps.conf.Format)
+	if err != nil {
+		return err
+	}
+	for _, result := range results {
+		select {
+		case hps.consumer <- api.NewDefaultSourceTuple(result, meta):
+			hps.ctx.GetLogger().Debugf("send pushed data to device node")
+		case <-hps.ctx.Done():
+			return errors.New("source is closed")
+		}
+	}
+	return nil
+}
+
+// decodeMessages decodes the payload by the format. The json payload could be an object or an array of objects.
+func decodeMessages(payload []byte, format string) ([]map[string]interface{}, error) {
+	var results []map[string]interface{}
+	trimmed := bytes.TrimSpace(payload)
+	if strings.ToLower(format) == message.FormatJson && len(trimmed) > 0 && trimmed[0] == '[' {
+		if err := json.Unmarshal(trimmed, &results); err != nil {
+			return nil, fmt.Errorf("cannot decode body to json array: %v", err)
+		}
+		return results, nil
+	}
+	result, err := message.Decode(payload, format)
+	if err != nil {
+		return nil, fmt.Errorf("cannot decode body to %s format: %v", format, err)
+	}
+	return append(results, result), nil
+}
+
+func (hps *HTTPPushSource) Close(ctx api.StreamContext) error {
+	ctx.GetLogger().Infof("Closing HTTP push source %s", hps.endpoint)
+	pushSrv.unregister(hps.endpoint, hps)
+	return nil
+}
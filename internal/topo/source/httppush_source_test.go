@@ -0,0 +1,150 @@
+// Copyright 2021 EMQ Technologies Co., Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package source
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/lf-edge/ekuiper/internal/conf"
+	"github.com/lf-edge/ekuiper/internal/testx"
+	"github.com/lf-edge/ekuiper/internal/topo/context"
+	"github.com/lf-edge/ekuiper/pkg/api"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestHTTPPushConfigure(t *testing.T) {
+	var tests = []struct {
+		props map[string]interface{}
+		conf  *HTTPPushConfig
+		err   string
+	}{
+		{
+			props: map[string]interface{}{},
+			conf:  &HTTPPushConfig{Method: http.MethodPost, AuthType: AUTH_NONE, Format: "json", MaxBodySize: DEFAULT_MAX_BODY_SIZE},
+		}, {
+			props: map[string]interface{}{"method": "put", "authType": "Bearer", "token": "abc", "format": "binary", "maxBodySize": 1024},
+			conf:  &HTTPPushConfig{Method: http.MethodPut, AuthType: AUTH_BEARER, Token: "abc", Format: "binary", MaxBodySize: 1024},
+		}, {
+			props: map[string]interface{}{"maxBodySize": -1},
+			err:   "invalid property maxBodySize: -1, must not be negative",
+		}, {
+			props: map[string]interface{}{"method": "get"},
+			err:   "invalid property method: get, must be post or put",
+		}, {
+			props: map[string]interface{}{"authType": "bearer"},
+			err:   "property token is required for bearer authentication",
+		}, {
+			props: map[string]interface{}{"authType": "basic"},
+			err:   "property username is required for basic authentication",
+		}, {
+			props: map[string]interface{}{"authType": "digest"},
+			err:   "invalid property authType: digest, must be none, bearer or basic",
+		},
+	}
+	for i, tt := range tests {
+		s := &HTTPPushSource{}
+		err := s.Configure("/test", tt.props)
+		if !reflect.DeepEqual(tt.err, testx.Errstring(err)) {
+			t.Errorf("%d: error mismatch:\n  exp=%s\n  got=%s", i, tt.err, err)
+		} else if err == nil && !reflect.DeepEqual(tt.conf, s.conf) {
+			t.Errorf("%d: conf mismatch:\n  exp=%v\n  got=%v", i, tt.conf, s.conf)
+		}
+	}
+}
+
+func TestHTTPPushSource(t *testing.T) {
+	if conf.Config == nil {
+		conf.Config = &conf.KuiperConf{}
+	}
+	conf.Config.Source.HttpServerIp = "127.0.0.1"
+	conf.Config.Source.HttpServerPort = 10091
+	ctx, cancel := context.WithValue(context.Background(), context.LoggerKey, conf.Log).WithCancel()
+	defer cancel()
+
+	s := &HTTPPushSource{}
+	if err := s.Configure("data", map[string]interface{}{"authType": "basic", "username": "user", "password": "pass", "maxBodySize": 32}); err != nil {
+		t.Fatal(err)
+	}
+	consumer := make(chan api.SourceTuple, 10)
+	errCh := make(chan error, 1)
+	s.Open(ctx, consumer, errCh)
+	defer s.Close(ctx)
+	other := &HTTPPushSource{}
+	_ = other.Configure("data", map[string]interface{}{})
+	other.Open(ctx, consumer, errCh)
+	select {
+	case err := <-errCh:
+		if err.Error() != "endpoint /data is already registered with different method, authentication or max body size" {
+			t.Errorf("unexpected error %v", err)
+		}
+	default:
+		t.Error("expect error for conflict endpoint")
+	}
+
+	url := fmt.Sprintf("http://127.0.0.1:%d/data", conf.Config.Source.HttpServerPort)
+	var tests = []struct {
+		method string
+		path   string
+		auth   bool
+		body   string
+		code   int
+		result []map[string]interface{}
+	}{
+		{method: http.MethodPost, body: `{"a":1}`, code: http.StatusUnauthorized},
+		{method: http.MethodGet, auth: true, code: http.StatusMethodNotAllowed},
+		{method: http.MethodPost, path: "/other", auth: true, body: `{"a":1}`, code: http.StatusNotFound},
+		{method: http.MethodPost, auth: true, body: `{"a":`, code: http.StatusBadRequest},
+		{method: http.MethodPost, auth: true, body: `{"a":"` + strings.Repeat("x", 32) + `"}`, code: http.StatusRequestEntityTooLarge},
+		{method: http.MethodPost, auth: true, body: `{"a":1}`, code: http.StatusOK, result: []map[string]interface{}{{"a": float64(1)}}},
+		{method: http.MethodPost, auth: true, body: `[{"a":1},{"a":2}]`, code: http.StatusOK, result: []map[string]interface{}{{"a": float64(1)}, {"a": float64(2)}}},
+	}
+	for i, tt := range tests {
+		u := url
+		if tt.path != "" {
+			u = fmt.Sprintf("http://127.0.0.1:%d%s", conf.Config.Source.HttpServerPort, tt.path)
+		}
+		req, _ := http.NewRequest(tt.method, u, bytes.NewBufferString(tt.body))
+		req.Header.Set("X-Device", "d1")
+		if tt.auth {
+			req.SetBasicAuth("user", "pass")
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Errorf("%d: request error %v", i, err)
+			continue
+		}
+		resp.Body.Close()
+		if resp.StatusCode != tt.code {
+			t.Errorf("%d: status code mismatch:\n  exp=%d\n  got=%d", i, tt.code, resp.StatusCode)
+		}
+		for _, exp := range tt.result {
+			select {
+			case r := <-consumer:
+				if !reflect.DeepEqual(exp, r.Message()) {
+					t.Errorf("%d: message mismatch:\n  exp=%v\n  got=%v", i, exp, r.Message())
+				}
+				if r.Meta()["X-Device"] != "d1" || r.Meta()["path"] != "/data" {
+					t.Errorf("%d: meta mismatch, got=%v", i, r.Meta())
+				}
+			case <-time.After(time.Second):
+				t.Errorf("%d: timeout to receive %v", i, exp)
+			}
+		}
+	}
+}
@@ -0,0 +1,421 @@
+// Copyright 2021 EMQ Technologies Co., Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package source
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"github.com/grid-x/modbus"
+	"github.com/lf-edge/ekuiper/internal/conf"
+	"github.com/lf-edge/ekuiper/pkg/api"
+	"github.com/lf-edge/ekuiper/pkg/cast"
+	"math"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	MODBUS_TCP = "tcp"
+	MODBUS_RTU = "rtu"
+)
+
+const (
+	REG_COIL     = "coil"
+	REG_DISCRETE = "discrete"
+	REG_HOLDING  = "holding"
+	REG_INPUT    = "input"
+)
+
+// The max quantity of a read request defined by the modbus specification
+const (
+	maxReadBits      = 2000
+	maxReadRegisters = 125
+)
+
+// The number of registers of the data types
+var modbusDataTypes = map[string]uint16{
+	"bool":    1,
+	"int16":   1,
+	"uint16":  1,
+	"int32":   2,
+	"uint32":  2,
+	"float32": 2,
+	"int64":   4,
+	"uint64":  4,
+	"float64": 4,
+}
+
+type ModbusConfig struct {
+	// tcp or rtu
+	Protocol string `json:"protocol"`
+	SlaveId  int    `json:"slaveId"`
+	Interval int    `json:"interval"`
+	Timeout  int    `json:"timeout"`
+	// The serial port settings of rtu
+	BaudRate  int               `json:"baudRate"`
+	DataBits  int               `json:"dataBits"`
+	StopBits  int               `json:"stopBits"`
+	Parity    string            `json:"parity"`
+	Registers []*ModbusRegister `json:"registers"`
+}
+
+// ModbusRegister is a value to read. It is a field of the tuple.
+type ModbusRegister struct {
+	Name string `json:"name"`
+	// coil, discrete, holding or input
+	Type    string `json:"type"`
+	Address uint16 `json:"address"`
+	// bool for coil and discrete; int16, uint16, int32, uint32, float32, int64, uint64 or float64 for registers
+	DataType string `json:"dataType"`
+	// The order of the bytes in a register and the order of the registers in a multi-register value
+	ByteOrder string `json:"byteOrder"`
+	WordOrder string `json:"wordOrder"`
+	// Multiply the value by the scale if set
+	Scale float64 `json:"scale"`
+	// Override the slave id of the source
+	SlaveId int `json:"slaveId"`
+}
+
+// modbusRequest reads the consecutive registers of the same type and slave in one request
+type modbusRequest struct {
+	slaveId   byte
+	regType   string
+	address   uint16
+	quantity  uint16
+	registers []*ModbusRegister
+}
+
+type modbusHandler interface {
+	modbus.ClientHandler
+	SetSlave(slaveID byte)
+	Connect() error
+	Close() error
+}
+
+// ModbusSource polls the registers of a modbus device by tcp or rtu at the interval. Each poll emits one tuple.
+type ModbusSource struct {
+	address  string
+	conf     *ModbusConfig
+	requests []*modbusRequest
+
+	handler modbusHandler
+	client  modbus.Client
+
+	mu     sync.RWMutex
+	status api.ConnectionStatus
+}
+
+func (ms *ModbusSource) Configure(address string, props map[string]interface{}) error {
+	cfg := &ModbusConfig{Protocol: MODBUS_TCP, SlaveId: 1, Interval: 1000, Timeout: 5000, BaudRate: 19200, DataBits: 8, StopBits: 1, Parity: "E"}
+	if err := cast.MapToStruct(props, cfg); err != nil {
+		return fmt.Errorf("read properties %v fail with error: %v", props, err)
+	}
+	if address == "" {
+		return errors.New("the device address must be specified as the datasource")
+	}
+	cfg.Protocol = strings.ToLower(cfg.Protocol)
+	switch cfg.Protocol {
+	case MODBUS_TCP:
+	case MODBUS_RTU:
+		cfg.Parity = strings.ToUpper(cfg.Parity)
+		switch cfg.Parity {
+		case "N", "E", "O":
+		default:
+			return fmt.Errorf("invalid property parity: %s, must be N, E or O", cfg.Parity)
+		}
+	default:
+		return fmt.Errorf("invalid property protocol: %s, must be tcp or rtu", cfg.Protocol)
+	}
+	if cfg.SlaveId < 0 || cfg.SlaveId > 255 {
+		return fmt.Errorf("invalid property slaveId: %d, must be between 0 and 255", cfg.SlaveId)
+	}
+	if cfg.Interval <= 0 {
+		return fmt.Errorf("invalid property interval: %d, must be a positive integer", cfg.Interval)
+	}
+	if cfg.Timeout <= 0 {
+		return fmt.Errorf("invalid property timeout: %d, must be a positive integer", cfg.Timeout)
+	}
+	if len(cfg.Registers) == 0 {
+		return errors.New("property registers is required")
+	}
+	names := make(map[string]bool, len(cfg.Registers))
+	for _, r := range cfg.Registers {
+		if err := r.validate(cfg.SlaveId); err != nil {
+			return err
+		}
+		if names[r.Name] {
+			return fmt.Errorf("duplicate register name %s", r.Name)
+		}
+		names[r.Name] = true
+	}
+	ms.address = address
+	ms.conf = cfg
+	ms.requests = buildModbusRequests(cfg.Registers)
+	return nil
+}
+
+func (r *ModbusRegister) validate(slaveId int) error {
+	if r.Name == "" {
+		return errors.New("register name is required")
+	}
+	r.Type = strings.ToLower(r.Type)
+	r.DataType = strings.ToLower(r.DataType)
+	switch r.Type {
+	case REG_COIL, REG_DISCRETE:
+		if r.DataType == "" {
+			r.DataType = "bool"
+		}
+		if r.DataType != "bool" {
+			return fmt.Errorf("invalid dataType %s of register %s, must be bool for %s", r.DataType, r.Name, r.Type)
+		}
+	case REG_HOLDING, REG_INPUT:
+		if r.DataType == "" {
+			r.DataType = "int16"
+		}
+		if _, ok := modbusDataTypes[r.DataType]; !ok || r.DataType == "bool" {
+			return fmt.Errorf("invalid dataType %s of register %s, must be int16, uint16, int32, uint32, float32, int64, uint64 or float64", r.DataType, r.Name)
+		}
+	default:
+		return fmt.Errorf("invalid type %s of register %s, must be coil, discrete, holding or input", r.Type, r.Name)
+	}
+	r.ByteOrder = strings.ToLower(r.ByteOrder)
+	r.WordOrder = strings.ToLower(r.WordOrder)
+	for _, o := range []string{r.ByteOrder, r.WordOrder} {
+		if o != "" && o != "big" && o != "little" {
+			return fmt.Errorf("invalid byte or word order %s of register %s, must be big or little", o, r.Name)
+		}
+	}
+	if r.SlaveId < 0 || r.SlaveId > 255 {
+		return fmt.Errorf("invalid slaveId %d of register %s, must be between 0 and 255", r.SlaveId, r.Name)
+	}
+	if r.SlaveId == 0 {
+		r.SlaveId = slaveId
+	}
+	if int(r.Address)+int(r.count()) > 65536 {
+		return fmt.Errorf("invalid address %d of register %s, out of range", r.Address, r.Name)
+	}
+	return nil
+}
+
+// count returns the number of the bits or registers of the value
+func (r *ModbusRegister) count() uint16 {
+	return modbusDataTypes[r.DataType]
+}
+
+// buildModbusRequests merges the registers of the same type and slave whose addresses are consecutive or
+// overlapped into one request
+func buildModbusRequests(registers []*ModbusRegister) []*modbusRequest {
+	sorted := make([]*ModbusRegister, len(registers))
+	copy(sorted, registers)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		a, b := sorted[i], sorted[j]
+		if a.SlaveId != b.SlaveId {
+			return a.SlaveId < b.SlaveId
+		}
+		if a.Type != b.Type {
+			return a.Type < b.Type
+		}
+		return a.Address < b.Address
+	})
+	var (
+		result []*modbusRequest
+		cur    *modbusRequest
+	)
+	for _, r := range sorted {
+		max := uint16(maxReadRegisters)
+		if r.Type == REG_COIL || r.Type == REG_DISCRETE {
+			max = maxReadBits
+		}
+		if cur != nil && cur.slaveId == byte(r.SlaveId) && cur.regType == r.Type && r.Address <= cur.address+cur.quantity {
+			end := r.Address + r.count()
+			if end < cur.address+cur.quantity {
+				end = cur.address + cur.quantity
+			}
+			if end-cur.address <= max {
+				cur.quantity = end - cur.address
+				cur.registers = append(cur.registers, r)
+				continue
+			}
+		}
+		cur = &modbusRequest{slaveId: byte(r.SlaveId), regType: r.Type, address: r.Address, quantity: r.count(), registers: []*ModbusRegister{r}}
+		result = append(result, cur)
+	}
+	return result
+}
+
+func (ms *ModbusSource) Open(ctx api.StreamContext, consumer chan<- api.SourceTuple, errCh chan<- error) {
+	logger := ctx.GetLogger()
+	timeout := time.Duration(ms.conf.Timeout) * time.Millisecond
+	switch ms.conf.Protocol {
+	case MODBUS_RTU:
+		h := modbus.NewRTUClientHandler(ms.address)
+		h.BaudRate = ms.conf.BaudRate
+		h.DataBits = ms.conf.DataBits
+		h.StopBits = ms.conf.StopBits
+		h.Parity = ms.conf.Parity
+		h.Timeout = timeout
+		ms.handler = h
+	default:
+		h := modbus.NewTCPClientHandler(ms.address)
+		h.Timeout = timeout
+		ms.handler = h
+	}
+	ms.client = modbus.NewClient(ms.handler)
+	logger.Infof("Start polling modbus device %s by %s every %d ms", ms.address, ms.conf.Protocol, ms.conf.Interval)
+	ticker := conf.GetTicker(ms.conf.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			result, err := ms.poll()
+			if err != nil {
+				logger.Warnf("Found error %s when polling modbus device %s", err, ms.address)
+				ms.setStatus(api.ConnectionDisconnected, err)
+				continue
+			}
+			ms.setStatus(api.ConnectionConnected, nil)
+			meta := map[string]interface{}{"address": ms.address, "timestamp": conf.GetNowInMilli()}
+			select {
+			case consumer <- api.NewDefaultSourceTuple(result, meta):
+				logger.Debugf("send modbus data to device node")
+			case <-ctx.Done():
+				return
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// poll reads all the registers. If any request fails, the whole poll fails so that the tuple is consistent.
+func (ms *ModbusSource) poll() (map[string]interface{}, error) {
+	result := make(map[string]interface{}, len(ms.conf.Registers))
+	for _, req := range ms.requests {
+		ms.handler.SetSlave(req.slaveId)
+		var (
+			data []byte
+			err  error
+		)
+		switch req.regType {
+		case REG_COIL:
+			data, err = ms.client.ReadCoils(req.address, req.quantity)
+		case REG_DISCRETE:
+			data, err = ms.client.ReadDiscreteInputs(req.address, req.quantity)
+		case REG_HOLDING:
+			data, err = ms.client.ReadHoldingRegisters(req.address, req.quantity)
+		case REG_INPUT:
+			data, err = ms.client.ReadInputRegisters(req.address, req.quantity)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("fail to read %d %s from address %d of slave %d: %v", req.quantity, req.regType, req.address, req.slaveId, err)
+		}
+		for _, r := range req.registers {
+			v, err := r.decode(data, r.Address-req.address)
+			if err != nil {
+				return nil, err
+			}
+			result[r.Name] = v
+		}
+	}
+	return result, nil
+}
+
+// decode the value of the register from the response data. The offset is the number of bits or registers
+// from the start of the request.
+func (r *ModbusRegister) decode(data []byte, offset uint16) (interface{}, error) {
+	if r.DataType == "bool" {
+		i := int(offset / 8)
+		if i >= len(data) {
+			return nil, fmt.Errorf("invalid response for register %s", r.Name)
+		}
+		return data[i]&(1<<(offset%8)) != 0, nil
+	}
+	start, n := int(offset)*2, int(r.count())*2
+	if start+n > len(data) {
+		return nil, fmt.Errorf("invalid response for register %s", r.Name)
+	}
+	b := make([]byte, n)
+	copy(b, data[start:start+n])
+	// Reorder to big endian bytes and words
+	if r.ByteOrder == "little" {
+		for i := 0; i < n; i += 2 {
+			b[i], b[i+1] = b[i+1], b[i]
+		}
+	}
+	if r.WordOrder == "little" {
+		for i, j := 0, n-2; i < j; i, j = i+2, j-2 {
+			b[i], b[i+1], b[j], b[j+1] = b[j], b[j+1], b[i], b[i+1]
+		}
+	}
+	var v interface{}
+	switch r.DataType {
+	case "int16":
+		v = int64(int16(binary.BigEndian.Uint16(b)))
+	case "uint16":
+		v = int64(binary.BigEndian.Uint16(b))
+	case "int32":
+		v = int64(int32(binary.BigEndian.Uint32(b)))
+	case "uint32":
+		v = int64(binary.BigEndian.Uint32(b))
+	case "float32":
+		v = float64(math.Float32frombits(binary.BigEndian.Uint32(b)))
+	case "int64":
+		v = int64(binary.BigEndian.Uint64(b))
+	case "uint64":
+		u := binary.BigEndian.Uint64(b)
+		if u > math.MaxInt64 {
+			v = float64(u)
+		} else {
+			v = int64(u)
+		}
+	case "float64":
+		v = math.Float64frombits(binary.BigEndian.Uint64(b))
+	}
+	if r.Scale != 0 && r.Scale != 1 {
+		f, _ := cast.ToFloat64(v, cast.CONVERT_SAMEKIND)
+		return f * r.Scale, nil
+	}
+	return v, nil
+}
+
+func (ms *ModbusSource) setStatus(st string, err error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	ms.status.Status = st
+	ms.status.Server = ms.address
+	if err != nil {
+		ms.status.LastError = err.Error()
+	}
+}
+
+func (ms *ModbusSource) ConnectionStatus() api.ConnectionStatus {
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
+	if ms.status.Status == "" {
+		return api.ConnectionStatus{Status: api.ConnectionConnecting, Server: ms.address}
+	}
+	return ms.status
+}
+
+func (ms *ModbusSource) Close(ctx api.StreamContext) error {
+	ctx.GetLogger().Infof("Closing modbus source %s", ms.address)
+	if ms.handler != nil {
+		return ms.handler.Close()
+	}
+	return nil
+}
@@ -0,0 +1,310 @@
+// Copyright 2021 EMQ Technologies Co., Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package source
+
+import (
+	"encoding/binary"
+	"github.com/lf-edge/ekuiper/internal/conf"
+	"github.com/lf-edge/ekuiper/internal/topo/context"
+	"github.com/lf-edge/ekuiper/internal/topo/topotest/mockclock"
+	"github.com/lf-edge/ekuiper/pkg/api"
+	"io"
+	"net"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// mockModbusServer is a minimal modbus tcp server which serves the read functions of one slave
+type mockModbusServer struct {
+	listener net.Listener
+	slaveId  byte
+	coils    []bool
+	discrete []bool
+	holding  []uint16
+	input    []uint16
+}
+
+func runModbusServer(t *testing.T, s *mockModbusServer) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.listener = l
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(c)
+		}
+	}()
+}
+
+func (s *mockModbusServer) serve(c net.Conn) {
+	defer c.Close()
+	header := make([]byte, 7)
+	for {
+		if _, err := io.ReadFull(c, header); err != nil {
+			return
+		}
+		pdu := make([]byte, binary.BigEndian.Uint16(header[4:])-1)
+		if _, err := io.ReadFull(c, pdu); err != nil {
+			return
+		}
+		resp := s.handle(header[6], pdu)
+		adu := make([]byte, 7+len(resp))
+		copy(adu, header[:4])
+		binary.BigEndian.PutUint16(adu[4:], uint16(len(resp)+1))
+		adu[6] = header[6]
+		copy(adu[7:], resp)
+		if _, err := c.Write(adu); err != nil {
+			return
+		}
+	}
+}
+
+func (s *mockModbusServer) handle(slaveId byte, pdu []byte) []byte {
+	fc := pdu[0]
+	address, quantity := int(binary.BigEndian.Uint16(pdu[1:])), int(binary.BigEndian.Uint16(pdu[3:]))
+	var (
+		bits []bool
+		regs []uint16
+	)
+	switch fc {
+	case 1:
+		bits = s.coils
+	case 2:
+		bits = s.discrete
+	case 3:
+		regs = s.holding
+	case 4:
+		regs = s.input
+	default:
+		// illegal function
+		return []byte{fc | 0x80, 1}
+	}
+	if slaveId != s.slaveId {
+		// gateway target device failed to respond
+		return []byte{fc | 0x80, 11}
+	}
+	if fc <= 2 {
+		if address+quantity > len(bits) {
+			return []byte{fc | 0x80, 2}
+		}
+		data := make([]byte, (quantity+7)/8)
+		for i := 0; i < quantity; i++ {
+			if bits[address+i] {
+				data[i/8] |= 1 << (i % 8)
+			}
+		}
+		return append([]byte{fc, byte(len(data))}, data...)
+	}
+	if address+quantity > len(regs) {
+		return []byte{fc | 0x80, 2}
+	}
+	data := make([]byte, quantity*2)
+	for i := 0; i < quantity; i++ {
+		binary.BigEndian.PutUint16(data[i*2:], regs[address+i])
+	}
+	return append([]byte{fc, byte(len(data))}, data...)
+}
+
+func TestModbusSourceConfigure(t *testing.T) {
+	var tests = []struct {
+		props map[string]interface{}
+		err   string
+	}{
+		{
+			props: map[string]interface{}{},
+			err:   "property registers is required",
+		}, {
+			props: map[string]interface{}{"protocol": "udp", "registers": []interface{}{map[string]interface{}{"name": "a", "type": "coil"}}},
+			err:   "invalid property protocol: udp, must be tcp or rtu",
+		}, {
+			props: map[string]interface{}{"protocol": "rtu", "parity": "X", "registers": []interface{}{map[string]interface{}{"name": "a", "type": "coil"}}},
+			err:   "invalid property parity: X, must be N, E or O",
+		}, {
+			props: map[string]interface{}{"registers": []interface{}{map[string]interface{}{"name": "a", "type": "coil", "dataType": "int16"}}},
+			err:   "invalid dataType int16 of register a, must be bool for coil",
+		}, {
+			props: map[string]interface{}{"registers": []interface{}{map[string]interface{}{"name": "a", "type": "holding", "dataType": "string"}}},
+			err:   "invalid dataType string of register a, must be int16, uint16, int32, uint32, float32, int64, uint64 or float64",
+		}, {
+			props: map[string]interface{}{"registers": []interface{}{map[string]interface{}{"name": "a", "type": "memory"}}},
+			err:   "invalid type memory of register a, must be coil, discrete, holding or input",
+		}, {
+			props: map[string]interface{}{"registers": []interface{}{map[string]interface{}{"name": "a", "type": "holding", "byteOrder": "middle"}}},
+			err:   "invalid byte or word order middle of register a, must be big or little",
+		}, {
+			props: map[string]interface{}{"registers": []interface{}{map[string]interface{}{"name": "a", "type": "holding"}, map[string]interface{}{"name": "a", "type": "input"}}},
+			err:   "duplicate register name a",
+		}, {
+			props: map[string]interface{}{"registers": []interface{}{map[string]interface{}{"name": "a", "type": "holding", "address": 65535, "dataType": "int32"}}},
+			err:   "invalid address 65535 of register a, out of range",
+		},
+	}
+	for i, tt := range tests {
+		s := &ModbusSource{}
+		err := s.Configure("127.0.0.1:502", tt.props)
+		if err == nil || err.Error() != tt.err {
+			t.Errorf("%d: error mismatch:\n  exp=%s\n  got=%v", i, tt.err, err)
+		}
+	}
+}
+
+func TestBuildModbusRequests(t *testing.T) {
+	registers := []*ModbusRegister{
+		{Name: "h3", Type: REG_HOLDING, Address: 3, DataType: "float32", SlaveId: 1},
+		{Name: "h0", Type: REG_HOLDING, Address: 0, DataType: "int32", SlaveId: 1},
+		{Name: "h2", Type: REG_HOLDING, Address: 2, DataType: "int16", SlaveId: 1},
+		{Name: "h10", Type: REG_HOLDING, Address: 10, DataType: "int16", SlaveId: 1},
+		{Name: "h200", Type: REG_HOLDING, Address: 120, DataType: "float64", SlaveId: 1},
+		{Name: "s2", Type: REG_HOLDING, Address: 0, DataType: "int16", SlaveId: 2},
+		{Name: "c0", Type: REG_COIL, Address: 0, DataType: "bool", SlaveId: 1},
+		{Name: "c1", Type: REG_COIL, Address: 1, DataType: "bool", SlaveId: 1},
+	}
+	requests := buildModbusRequests(registers)
+	var result [][]interface{}
+	for _, r := range requests {
+		var names []string
+		for _, reg := range r.registers {
+			names = append(names, reg.Name)
+		}
+		result = append(result, []interface{}{r.slaveId, r.regType, r.address, r.quantity, names})
+	}
+	exp := [][]interface{}{
+		{byte(1), REG_COIL, uint16(0), uint16(2), []string{"c0", "c1"}},
+		{byte(1), REG_HOLDING, uint16(0), uint16(5), []string{"h0", "h2", "h3"}},
+		{byte(1), REG_HOLDING, uint16(10), uint16(1), []string{"h10"}},
+		{byte(1), REG_HOLDING, uint16(120), uint16(4), []string{"h200"}},
+		{byte(2), REG_HOLDING, uint16(0), uint16(1), []string{"s2"}},
+	}
+	if !reflect.DeepEqual(exp, result) {
+		t.Errorf("requests mismatch:\n  exp=%v\n  got=%v", exp, result)
+	}
+}
+
+func TestModbusSource(t *testing.T) {
+	srv := &mockModbusServer{
+		slaveId:  1,
+		coils:    []bool{true, false, true},
+		discrete: []bool{false, true},
+		// 0: -2, 1-2: int32 -100000 big endian, 3-4: float32 1.5 little word order,
+		// 5: 0x3412 little byte order, 6: 250 scaled
+		holding: []uint16{0xfffe, 0xfffe, 0x7960, 0x0000, 0x3fc0, 0x1234, 250},
+		input:   []uint16{0x0000, 0x0000, 0x0001, 0x86a0},
+	}
+	runModbusServer(t, srv)
+	defer srv.listener.Close()
+	mockclock.ResetClock(10000)
+	ctx, cancel := context.WithValue(context.Background(), context.LoggerKey, conf.Log).WithCancel()
+	defer cancel()
+	s := &ModbusSource{}
+	err := s.Configure(srv.listener.Addr().String(), map[string]interface{}{
+		"interval": 1000,
+		"registers": []interface{}{
+			map[string]interface{}{"name": "c0", "type": "coil", "address": 0},
+			map[string]interface{}{"name": "c2", "type": "coil", "address": 2},
+			map[string]interface{}{"name": "d1", "type": "discrete", "address": 1},
+			map[string]interface{}{"name": "h0", "type": "holding", "address": 0},
+			map[string]interface{}{"name": "h1", "type": "holding", "address": 1, "dataType": "int32"},
+			map[string]interface{}{"name": "h3", "type": "holding", "address": 3, "dataType": "float32", "wordOrder": "little"},
+			map[string]interface{}{"name": "h5", "type": "holding", "address": 5, "dataType": "uint16", "byteOrder": "little"},
+			map[string]interface{}{"name": "h6", "type": "holding", "address": 6, "dataType": "uint16", "scale": 0.1},
+			map[string]interface{}{"name": "i0", "type": "input", "address": 0, "dataType": "uint64"},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if st := s.ConnectionStatus(); st.Status != api.ConnectionConnecting {
+		t.Errorf("unexpected connection status %v", st)
+	}
+	consumer := make(chan api.SourceTuple, 10)
+	errCh := make(chan error, 1)
+	go s.Open(ctx, consumer, errCh)
+	defer s.Close(ctx)
+
+	var tuple api.SourceTuple
+	for i := 0; tuple == nil && i < 50; i++ {
+		mockclock.GetMockClock().Add(time.Second)
+		select {
+		case tuple = <-consumer:
+		case <-time.After(100 * time.Millisecond):
+		}
+	}
+	if tuple == nil {
+		t.Fatalf("timeout to receive tuple, connection status %v", s.ConnectionStatus())
+	}
+	exp := map[string]interface{}{
+		"c0": true,
+		"c2": true,
+		"d1": true,
+		"h0": int64(-2),
+		"h1": int64(-100000),
+		"h3": 1.5,
+		"h5": int64(0x3412),
+		"h6": 25.0,
+		"i0": int64(100000),
+	}
+	if !reflect.DeepEqual(exp, tuple.Message()) {
+		t.Errorf("message mismatch:\n  exp=%v\n  got=%v", exp, tuple.Message())
+	}
+	if tuple.Meta()["address"] != srv.listener.Addr().String() {
+		t.Errorf("unexpected meta %v", tuple.Meta())
+	}
+	if st := s.ConnectionStatus(); st.Status != api.ConnectionConnected {
+		t.Errorf("unexpected connection status %v", st)
+	}
+}
+
+func TestModbusSourceError(t *testing.T) {
+	srv := &mockModbusServer{slaveId: 1, holding: []uint16{1}}
+	runModbusServer(t, srv)
+	defer srv.listener.Close()
+	mockclock.ResetClock(10000)
+	ctx, cancel := context.WithValue(context.Background(), context.LoggerKey, conf.Log).WithCancel()
+	defer cancel()
+	s := &ModbusSource{}
+	err := s.Configure(srv.listener.Addr().String(), map[string]interface{}{
+		"registers": []interface{}{
+			map[string]interface{}{"name": "h0", "type": "holding", "address": 0, "slaveId": 2},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	consumer := make(chan api.SourceTuple, 10)
+	errCh := make(chan error, 1)
+	go s.Open(ctx, consumer, errCh)
+	defer s.Close(ctx)
+	for i := 0; i < 50; i++ {
+		mockclock.GetMockClock().Add(time.Second)
+		time.Sleep(10 * time.Millisecond)
+		if s.ConnectionStatus().Status == api.ConnectionDisconnected {
+			break
+		}
+	}
+	if st := s.ConnectionStatus(); st.Status != api.ConnectionDisconnected || st.LastError == "" {
+		t.Errorf("unexpected connection status %v", st)
+	}
+	select {
+	case tuple := <-consumer:
+		t.Errorf("unexpected tuple %v", tuple.Message())
+	default:
+	}
+}
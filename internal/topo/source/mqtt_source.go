@@ -15,11 +15,9 @@
 package source
 
 import (
-	"crypto/tls"
 	"fmt"
-	MQTT "github.com/eclipse/paho.mqtt.golang"
-	"github.com/google/uuid"
 	"github.com/lf-edge/ekuiper/internal/conf"
+	"github.com/lf-edge/ekuiper/internal/pkg/mqttx"
 	"github.com/lf-edge/ekuiper/pkg/api"
 	"github.com/lf-edge/ekuiper/pkg/cast"
 	"github.com/lf-edge/ekuiper/pkg/message"
@@ -29,33 +27,30 @@ import (
 )
 
 type MQTTSource struct {
-	srv      string
-	format   string
-	tpc      string
-	clientid string
-	pVersion uint
-	uName    string
-	password string
-	certPath string
-	pkeyPath string
+	format string
+	tpc    string
+	qos    byte
+	cconf  *mqttx.ClientConf
 
 	model  modelVersion
 	schema map[string]interface{}
-	conn   MQTT.Client
+	conn   *mqttx.Conn
 }
 
 type MQTTConfig struct {
-	Format            string   `json:"format"`
-	Qos               int      `json:"qos"`
-	Servers           []string `json:"servers"`
-	Clientid          string   `json:"clientid"`
-	PVersion          string   `json:"protocolVersion"`
-	Uname             string   `json:"username"`
-	Password          string   `json:"password"`
-	Certification     string   `json:"certificationPath"`
-	PrivateKPath      string   `json:"privateKeyPath"`
-	KubeedgeModelFile string   `json:"kubeedgeModelFile"`
-	KubeedgeVersion   string   `json:"kubeedgeVersion"`
+	Format               string   `json:"format"`
+	Qos                  int      `json:"qos"`
+	Servers              []string `json:"servers"`
+	Clientid             string   `json:"clientid"`
+	PVersion             string   `json:"protocolVersion"`
+	Uname                string   `json:"username"`
+	Password             string   `json:"password"`
+	Certification        string   `json:"certificationPath"`
+	PrivateKPath         string   `json:"privateKeyPath"`
+	InsecureSkipVerify   bool     `json:"insecureSkipVerify"`
+	MaxReconnectInterval int      `json:"maxReconnectInterval"`
+	KubeedgeModelFile    string   `json:"kubeedgeModelFile"`
+	KubeedgeVersion      string   `json:"kubeedgeVersion"`
 }
 
 func (ms *MQTTSource) WithSchema(_ string) *MQTTSource {
@@ -69,25 +64,32 @@ func (ms *MQTTSource) Configure(topic string, props map[string]interface{}) erro
 		return fmt.Errorf("read properties %v fail with error: %v", props, err)
 	}
 	ms.tpc = topic
-	if srvs := cfg.Servers; srvs != nil && len(srvs) > 0 {
-		ms.srv = srvs[0]
-	} else {
+	if len(cfg.Servers) == 0 {
 		return fmt.Errorf("missing server property")
 	}
+	if cfg.Qos < 0 || cfg.Qos > 2 {
+		return fmt.Errorf("not valid qos value %v, the value could be only int 0 or 1 or 2", cfg.Qos)
+	}
+	switch cfg.PVersion {
+	case "", mqttx.PROTOCOL_V31, mqttx.PROTOCOL_V311, mqttx.PROTOCOL_V5, "5.0":
+	default:
+		return fmt.Errorf("unknown protocol version %s, the value could be only 3.1, 3.1.1 or 5", cfg.PVersion)
+	}
 
 	ms.format = cfg.Format
-	ms.clientid = cfg.Clientid
-
-	ms.pVersion = 3
-	if cfg.PVersion == "3.1.1" {
-		ms.pVersion = 4
+	ms.qos = byte(cfg.Qos)
+	ms.cconf = &mqttx.ClientConf{
+		Servers:              cfg.Servers,
+		ClientId:             cfg.Clientid,
+		PVersion:             cfg.PVersion,
+		Username:             cfg.Uname,
+		Password:             strings.Trim(cfg.Password, " "),
+		Certification:        cfg.Certification,
+		PrivateKeyPath:       cfg.PrivateKPath,
+		InsecureSkipVerify:   cfg.InsecureSkipVerify,
+		MaxReconnectInterval: cfg.MaxReconnectInterval,
 	}
 
-	ms.uName = cfg.Uname
-	ms.password = strings.Trim(cfg.Password, " ")
-	ms.certPath = cfg.Certification
-	ms.pkeyPath = cfg.PrivateKPath
-
 	if 0 != len(cfg.KubeedgeModelFile) {
 		p := path.Join("sources", cfg.KubeedgeModelFile)
 		ms.model = modelFactory(cfg.KubeedgeVersion)
@@ -102,120 +104,84 @@ func (ms *MQTTSource) Configure(topic string, props map[string]interface{}) erro
 func (ms *MQTTSource) Open(ctx api.StreamContext, consumer chan<- api.SourceTuple, errCh chan<- error) {
 	log := ctx.GetLogger()
 
-	opts := MQTT.NewClientOptions().AddBroker(ms.srv).SetProtocolVersion(ms.pVersion)
-	if ms.clientid == "" {
-		if newUUID, err := uuid.NewUUID(); err != nil {
-			errCh <- fmt.Errorf("failed to get uuid, the error is %s", err)
-			return
-		} else {
-			ms.clientid = newUUID.String()
-			opts.SetClientID(newUUID.String())
-		}
-	} else {
-		opts.SetClientID(ms.clientid)
-	}
-
-	if ms.certPath != "" || ms.pkeyPath != "" {
-		log.Infof("Connect MQTT broker with certification and keys.")
-		if cp, err := conf.ProcessPath(ms.certPath); err == nil {
-			log.Infof("The certification file is %s.", cp)
-			if kp, err1 := conf.ProcessPath(ms.pkeyPath); err1 == nil {
-				log.Infof("The private key file is %s.", kp)
-				if cer, err2 := tls.LoadX509KeyPair(cp, kp); err2 != nil {
-					errCh <- err2
-					return
-				} else {
-					opts.SetTLSConfig(&tls.Config{Certificates: []tls.Certificate{cer}})
-				}
-			} else {
-				errCh <- err1
-				return
-			}
-		} else {
-			errCh <- err
-			return
-		}
-	} else {
-		log.Infof("Connect MQTT broker with username and password.")
-		if ms.uName != "" {
-			opts = opts.SetUsername(ms.uName)
-		} else {
-			log.Infof("The username is empty.")
-		}
-
-		if ms.password != "" {
-			opts = opts.SetPassword(ms.password)
-		} else {
-			log.Infof("The password is empty.")
-		}
-	}
-	opts.SetAutoReconnect(true)
-	var reconn = false
-	opts.SetConnectionLostHandler(func(client MQTT.Client, e error) {
-		log.Errorf("The connection %s is disconnected due to error %s, will try to re-connect later.", ms.srv+": "+ms.clientid, e)
-		reconn = true
-		subscribe(ms.tpc, client, ctx, consumer, ms.model, ms.format)
-	})
-
-	opts.SetOnConnectHandler(func(client MQTT.Client) {
-		if reconn {
-			log.Infof("The connection is %s re-established successfully.", ms.srv+": "+ms.clientid)
-			subscribe(ms.tpc, client, ctx, consumer, ms.model, ms.format)
-		}
-	})
-
-	c := MQTT.NewClient(opts)
-	if token := c.Connect(); token.Wait() && token.Error() != nil {
-		errCh <- fmt.Errorf("found error when connecting to %s: %s", ms.srv, token.Error())
+	c, err := mqttx.GetConnection(ms.cconf)
+	if err != nil {
+		errCh <- err
 		return
 	}
-	log.Infof("The connection to server %s was established successfully", ms.srv)
 	ms.conn = c
-	subscribe(ms.tpc, c, ctx, consumer, ms.model, ms.format)
-	log.Infof("Successfully subscribe to topic %s", ms.srv+": "+ms.clientid)
+	if err := c.Subscribe(ms.tpc, ms.qos, ms.handler(ctx, consumer)); err != nil {
+		log.Errorf("Found error: %s", err)
+	} else {
+		log.Infof("Successfully subscribe to topic %s", ms.tpc)
+	}
 }
 
-func subscribe(topic string, client MQTT.Client, ctx api.StreamContext, consumer chan<- api.SourceTuple, model modelVersion, format string) {
+func (ms *MQTTSource) handler(ctx api.StreamContext, consumer chan<- api.SourceTuple) mqttx.MessageHandler {
 	log := ctx.GetLogger()
-	h := func(client MQTT.Client, msg MQTT.Message) {
-		log.Debugf("instance %d received %s", ctx.GetInstanceId(), msg.Payload())
-		result, e := message.Decode(msg.Payload(), format)
+	return func(msg *mqttx.Message) {
+		log.Debugf("instance %d received %s", ctx.GetInstanceId(), msg.Payload)
+		result, e := message.Decode(msg.Payload, ms.format)
 		//The unmarshal type can only be bool, float64, string, []interface{}, map[string]interface{}, nil
 		if e != nil {
-			log.Errorf("Invalid data format, cannot decode %s to %s format with error %s", string(msg.Payload()), format, e)
+			log.Errorf("Invalid data format, cannot decode %s to %s format with error %s", string(msg.Payload), ms.format, e)
 			return
 		}
 
-		meta := make(map[string]interface{})
-		meta["topic"] = msg.Topic()
-		meta["messageid"] = strconv.Itoa(int(msg.MessageID()))
-
-		if nil != model {
-			sliErr := model.checkType(result, msg.Topic())
+		if nil != ms.model {
+			sliErr := ms.model.checkType(result, msg.Topic)
 			for _, v := range sliErr {
 				log.Errorf(v)
 			}
 		}
 
 		select {
-		case consumer <- api.NewDefaultSourceTuple(result, meta):
+		case consumer <- api.NewDefaultSourceTuple(result, messageMeta(msg)):
 			log.Debugf("send data to source node")
 		case <-ctx.Done():
 			return
 		}
 	}
+}
 
-	if token := client.Subscribe(topic, 0, h); token.Wait() && token.Error() != nil {
-		log.Errorf("Found error: %s", token.Error())
-	} else {
-		log.Infof("Successfully subscribe to topic %s", topic)
+// messageMeta returns the metadata of the message. The MQTT 5 properties are only set if exist.
+func messageMeta(msg *mqttx.Message) map[string]interface{} {
+	meta := make(map[string]interface{})
+	meta["topic"] = msg.Topic
+	meta["messageid"] = strconv.Itoa(int(msg.MessageId))
+	if len(msg.UserProperties) > 0 {
+		props := make(map[string]interface{}, len(msg.UserProperties))
+		for k, v := range msg.UserProperties {
+			props[k] = v
+		}
+		meta["userProperties"] = props
+	}
+	if msg.ResponseTopic != "" {
+		meta["responseTopic"] = msg.ResponseTopic
+	}
+	if msg.ContentType != "" {
+		meta["contentType"] = msg.ContentType
+	}
+	if len(msg.CorrelationData) > 0 {
+		meta["correlationData"] = string(msg.CorrelationData)
+	}
+	if msg.MessageExpiry != nil {
+		meta["messageExpiry"] = int(*msg.MessageExpiry)
+	}
+	return meta
+}
+
+func (ms *MQTTSource) ConnectionStatus() api.ConnectionStatus {
+	if ms.conn == nil {
+		return api.ConnectionStatus{Status: api.ConnectionDisconnected}
 	}
+	return ms.conn.Status()
 }
 
 func (ms *MQTTSource) Close(ctx api.StreamContext) error {
 	ctx.GetLogger().Infof("Mqtt Source instance %d Done", ctx.GetInstanceId())
-	if ms.conn != nil && ms.conn.IsConnected() {
-		ms.conn.Disconnect(5000)
+	if ms.conn != nil {
+		ms.conn.Close()
 	}
 	return nil
 }
@@ -0,0 +1,226 @@
+// Copyright 2021 EMQ Technologies Co., Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package source
+
+import (
+	"errors"
+	"fmt"
+	"github.com/lf-edge/ekuiper/internal/pkg/natsx"
+	"github.com/lf-edge/ekuiper/pkg/api"
+	"github.com/lf-edge/ekuiper/pkg/cast"
+	"github.com/lf-edge/ekuiper/pkg/message"
+	"github.com/nats-io/nats.go"
+	"strings"
+	"time"
+)
+
+type NATSConfig struct {
+	natsx.ConnConf
+	Format string `json:"format"`
+	// The queue group to share the messages of the core subject among the subscribers
+	Queue string `json:"queue"`
+	// The JetStream consumer configurations
+	JetStream     bool   `json:"jetStream"`
+	Stream        string `json:"stream"`
+	Durable       string `json:"durable"`
+	DeliverPolicy string `json:"deliverPolicy"`
+	AckWait       int    `json:"ackWait"`
+	MaxAckPending int    `json:"maxAckPending"`
+}
+
+// natsTuple holds the message to acknowledge it after the tuple is processed
+type natsTuple struct {
+	*api.DefaultSourceTuple
+	msg *nats.Msg
+}
+
+// NATSSource subscribes a NATS subject or consumes a JetStream durable consumer. The JetStream messages are
+// acknowledged after processed, see api.Acknowledgeable.
+type NATSSource struct {
+	subject string
+	conf    *NATSConfig
+	conn    *natsx.Conn
+}
+
+func (ns *NATSSource) Configure(subject string, props map[string]interface{}) error {
+	cfg := &NATSConfig{}
+	if err := cast.MapToStruct(props, cfg); err != nil {
+		return fmt.Errorf("read properties %v fail with error: %v", props, err)
+	}
+	if subject == "" {
+		return errors.New("subject must be specified as the datasource")
+	}
+	if err := cfg.Validate(); err != nil {
+		return err
+	}
+	if cfg.Format == "" {
+		cfg.Format = message.FormatJson
+	}
+	if cfg.JetStream {
+		if cfg.Durable == "" {
+			return errors.New("property durable is required for jetStream")
+		}
+		if cfg.Queue != "" {
+			return errors.New("property queue is not supported for jetStream, share the durable consumer instead")
+		}
+		switch strings.ToLower(cfg.DeliverPolicy) {
+		case "":
+			cfg.DeliverPolicy = "all"
+		case "all", "new", "last":
+			cfg.DeliverPolicy = strings.ToLower(cfg.DeliverPolicy)
+		default:
+			return fmt.Errorf("invalid property deliverPolicy: %s, must be all, new or last", cfg.DeliverPolicy)
+		}
+		if cfg.AckWait < 0 {
+			return fmt.Errorf("invalid property ackWait: %d, must not be negative", cfg.AckWait)
+		}
+		if cfg.MaxAckPending < 0 {
+			return fmt.Errorf("invalid property maxAckPending: %d, must not be negative", cfg.MaxAckPending)
+		}
+	}
+	ns.subject = subject
+	ns.conf = cfg
+	return nil
+}
+
+func (ns *NATSSource) Open(ctx api.StreamContext, consumer chan<- api.SourceTuple, errCh chan<- error) {
+	logger := ctx.GetLogger()
+	conn, err := natsx.Connect(&ns.conf.ConnConf, fmt.Sprintf("%s_%s_%d", ctx.GetRuleId(), ctx.GetOpId(), ctx.GetInstanceId()), logger)
+	if err != nil {
+		errCh <- err
+		return
+	}
+	ns.conn = conn
+	handler := func(msg *nats.Msg) {
+		ns.handle(ctx, msg, consumer)
+	}
+	if ns.conf.JetStream {
+		err = ns.subscribeJetStream(handler)
+	} else if ns.conf.Queue != "" {
+		_, err = conn.QueueSubscribe(ns.subject, ns.conf.Queue, handler)
+	} else {
+		_, err = conn.Subscribe(ns.subject, handler)
+	}
+	if err != nil {
+		errCh <- fmt.Errorf("fail to subscribe nats subject %s: %v", ns.subject, err)
+		return
+	}
+	logger.Infof("Successfully subscribed to nats subject %s", ns.subject)
+}
+
+func (ns *NATSSource) subscribeJetStream(handler nats.MsgHandler) error {
+	js, err := ns.conn.JetStream()
+	if err != nil {
+		return err
+	}
+	// Acknowledging a message acknowledges all the messages before it, see Ack
+	opts := []nats.SubOpt{nats.Durable(ns.conf.Durable), nats.ManualAck(), nats.AckAll()}
+	if ns.conf.Stream != "" {
+		opts = append(opts, nats.BindStream(ns.conf.Stream))
+	}
+	switch ns.conf.DeliverPolicy {
+	case "new":
+		opts = append(opts, nats.DeliverNew())
+	case "last":
+		opts = append(opts, nats.DeliverLast())
+	default:
+		opts = append(opts, nats.DeliverAll())
+	}
+	if ns.conf.AckWait > 0 {
+		opts = append(opts, nats.AckWait(time.Duration(ns.conf.AckWait)*time.Millisecond))
+	}
+	if ns.conf.MaxAckPending > 0 {
+		opts = append(opts, nats.MaxAckPending(ns.conf.MaxAckPending))
+	}
+	_, err = js.Subscribe(ns.subject, handler, opts...)
+	return err
+}
+
+func (ns *NATSSource) handle(ctx api.StreamContext, msg *nats.Msg, consumer chan<- api.SourceTuple) {
+	logger := ctx.GetLogger()
+	results, err := decodeMessages(msg.Data, ns.conf.Format)
+	if err != nil {
+		logger.Errorf("Invalid data format from nats subject %s: %v", msg.Subject, err)
+		return
+	}
+	meta := ns.messageMeta(msg)
+	for i, result := range results {
+		t := &natsTuple{DefaultSourceTuple: api.NewDefaultSourceTuple(result, meta)}
+		// Only the last tuple of the message acknowledges it
+		if i == len(results)-1 {
+			t.msg = msg
+		}
+		select {
+		case consumer <- t:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func (ns *NATSSource) messageMeta(msg *nats.Msg) map[string]interface{} {
+	meta := map[string]interface{}{
+		"subject": msg.Subject,
+	}
+	if msg.Reply != "" && !ns.conf.JetStream {
+		meta["reply"] = msg.Reply
+	}
+	if len(msg.Header) > 0 {
+		headers := make(map[string]interface{}, len(msg.Header))
+		for k, v := range msg.Header {
+			headers[k] = strings.Join(v, ",")
+		}
+		meta["headers"] = headers
+	}
+	if ns.conf.JetStream {
+		if md, err := msg.Metadata(); err == nil {
+			meta["stream"] = md.Stream
+			meta["sequence"] = md.Sequence.Stream
+			meta["consumerSequence"] = md.Sequence.Consumer
+			meta["numDelivered"] = md.NumDelivered
+			meta["timestamp"] = md.Timestamp.UnixNano() / int64(time.Millisecond)
+		}
+	}
+	return meta
+}
+
+// Ack acknowledges the JetStream message of the tuple. As the consumer acks all, the messages before it are
+// acknowledged too.
+func (ns *NATSSource) Ack(_ api.StreamContext, tuple api.SourceTuple) error {
+	if !ns.conf.JetStream {
+		return nil
+	}
+	t, ok := tuple.(*natsTuple)
+	if !ok || t.msg == nil {
+		return nil
+	}
+	return t.msg.Ack()
+}
+
+func (ns *NATSSource) ConnectionStatus() api.ConnectionStatus {
+	if ns.conn == nil {
+		return api.ConnectionStatus{Status: api.ConnectionDisconnected}
+	}
+	return ns.conn.ConnectionStatus()
+}
+
+func (ns *NATSSource) Close(ctx api.StreamContext) error {
+	ctx.GetLogger().Infof("Closing nats source %s", ns.subject)
+	// Close the connection without unsubscribing so that the durable consumer is kept
+	if ns.conn != nil {
+		ns.conn.Close()
+	}
+	return nil
+}
@@ -0,0 +1,186 @@
+// Copyright 2021 EMQ Technologies Co., Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package source
+
+import (
+	"github.com/lf-edge/ekuiper/internal/conf"
+	"github.com/lf-edge/ekuiper/internal/topo/context"
+	"github.com/lf-edge/ekuiper/pkg/api"
+	"github.com/nats-io/nats-server/v2/server"
+	"github.com/nats-io/nats.go"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func runNATSServer(t *testing.T) *server.Server {
+	s, err := server.NewServer(&server.Options{Host: "127.0.0.1", Port: -1, JetStream: true, StoreDir: t.TempDir(), NoLog: true, NoSigs: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	go s.Start()
+	if !s.ReadyForConnections(5 * time.Second) {
+		t.Fatal("nats server is not ready")
+	}
+	return s
+}
+
+func receiveTuple(t *testing.T, consumer chan api.SourceTuple) api.SourceTuple {
+	select {
+	case tuple := <-consumer:
+		return tuple
+	case <-time.After(5 * time.Second):
+		t.Fatal("timeout to receive tuple")
+	}
+	return nil
+}
+
+func TestNATSSourceConfigure(t *testing.T) {
+	var tests = []struct {
+		props map[string]interface{}
+		err   string
+	}{
+		{
+			props: map[string]interface{}{"jetStream": true},
+			err:   "property durable is required for jetStream",
+		}, {
+			props: map[string]interface{}{"jetStream": true, "durable": "d1", "deliverPolicy": "first"},
+			err:   "invalid property deliverPolicy: first, must be all, new or last",
+		}, {
+			props: map[string]interface{}{"token": "a", "username": "b"},
+			err:   "only one of token and username can be set",
+		}, {
+			props: map[string]interface{}{"jetStream": true, "durable": "d1", "queue": "q"},
+			err:   "property queue is not supported for jetStream, share the durable consumer instead",
+		},
+	}
+	for i, tt := range tests {
+		s := &NATSSource{}
+		err := s.Configure("a.b", tt.props)
+		if err == nil || err.Error() != tt.err {
+			t.Errorf("%d: error mismatch:\n  exp=%s\n  got=%v", i, tt.err, err)
+		}
+	}
+}
+
+func TestNATSSource(t *testing.T) {
+	srv := runNATSServer(t)
+	defer srv.Shutdown()
+	ctx, cancel := context.WithValue(context.Background(), context.LoggerKey, conf.Log).WithCancel()
+	defer cancel()
+	s := &NATSSource{}
+	if err := s.Configure("devices.*", map[string]interface{}{"server": srv.ClientURL()}); err != nil {
+		t.Fatal(err)
+	}
+	consumer := make(chan api.SourceTuple, 10)
+	errCh := make(chan error, 1)
+	s.Open(ctx, consumer, errCh)
+	defer s.Close(ctx)
+	select {
+	case err := <-errCh:
+		t.Fatal(err)
+	default:
+	}
+	if st := s.ConnectionStatus(); st.Status != api.ConnectionConnected {
+		t.Errorf("unexpected connection status %v", st)
+	}
+
+	nc, err := nats.Connect(srv.ClientURL())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer nc.Close()
+	msg := nats.NewMsg("devices.d1")
+	msg.Header.Set("X-Type", "temp")
+	msg.Data = []byte(`[{"v":1},{"v":2}]`)
+	if err := nc.PublishMsg(msg); err != nil {
+		t.Fatal(err)
+	}
+	for _, exp := range []float64{1, 2} {
+		tuple := receiveTuple(t, consumer)
+		if !reflect.DeepEqual(map[string]interface{}{"v": exp}, tuple.Message()) {
+			t.Errorf("unexpected message %v", tuple.Message())
+		}
+		expMeta := map[string]interface{}{"subject": "devices.d1", "headers": map[string]interface{}{"X-Type": "temp"}}
+		if !reflect.DeepEqual(expMeta, tuple.Meta()) {
+			t.Errorf("unexpected meta %v", tuple.Meta())
+		}
+	}
+}
+
+func TestNATSSourceJetStream(t *testing.T) {
+	srv := runNATSServer(t)
+	defer srv.Shutdown()
+	nc, err := nats.Connect(srv.ClientURL())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer nc.Close()
+	js, err := nc.JetStream()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := js.AddStream(&nats.StreamConfig{Name: "DEVICES", Subjects: []string{"devices.>"}}); err != nil {
+		t.Fatal(err)
+	}
+	for _, d := range []string{`{"v":1}`, `{"v":2}`, `{"v":3}`} {
+		if _, err := js.Publish("devices.d1", []byte(d)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	ctx, cancel := context.WithValue(context.Background(), context.LoggerKey, conf.Log).WithCancel()
+	defer cancel()
+	props := map[string]interface{}{"server": srv.ClientURL(), "jetStream": true, "stream": "DEVICES", "durable": "rule1", "ackWait": 500}
+	s := &NATSSource{}
+	if err := s.Configure("devices.>", props); err != nil {
+		t.Fatal(err)
+	}
+	consumer := make(chan api.SourceTuple, 10)
+	errCh := make(chan error, 1)
+	s.Open(ctx, consumer, errCh)
+	var tuples []api.SourceTuple
+	for i := 0; i < 3; i++ {
+		tuples = append(tuples, receiveTuple(t, consumer))
+	}
+	if tuples[1].Meta()["sequence"] != uint64(2) || tuples[1].Meta()["stream"] != "DEVICES" {
+		t.Errorf("unexpected meta %v", tuples[1].Meta())
+	}
+	// Ack the second message which acks the first one too
+	if err := s.Ack(ctx, tuples[1]); err != nil {
+		t.Fatal(err)
+	}
+	// Wait for the ack processed before closing the connection
+	time.Sleep(100 * time.Millisecond)
+	s.Close(ctx)
+
+	// The durable consumer is kept and redelivers the message not acked
+	s = &NATSSource{}
+	if err := s.Configure("devices.>", props); err != nil {
+		t.Fatal(err)
+	}
+	consumer = make(chan api.SourceTuple, 10)
+	s.Open(ctx, consumer, errCh)
+	defer s.Close(ctx)
+	tuple := receiveTuple(t, consumer)
+	if !reflect.DeepEqual(map[string]interface{}{"v": float64(3)}, tuple.Message()) {
+		t.Errorf("unexpected redelivered message %v", tuple.Message())
+	}
+	select {
+	case err := <-errCh:
+		t.Fatal(err)
+	default:
+	}
+}
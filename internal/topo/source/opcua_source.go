@@ -0,0 +1,482 @@
+// Copyright 2021 EMQ Technologies Co., Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package source
+
+import (
+	"context"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"github.com/gopcua/opcua"
+	"github.com/gopcua/opcua/ua"
+	"github.com/lf-edge/ekuiper/internal/conf"
+	"github.com/lf-edge/ekuiper/pkg/api"
+	"github.com/lf-edge/ekuiper/pkg/cast"
+	"io/ioutil"
+	"math"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	OPCUA_SUBSCRIBE = "subscribe"
+	OPCUA_POLL      = "poll"
+)
+
+type OPCUAConfig struct {
+	// subscribe or poll
+	Mode string `json:"mode"`
+	// The publishing interval of the subscription or the interval to poll, time unit is ms
+	Interval int `json:"interval"`
+	// The sampling interval of the monitored items, time unit is ms
+	SamplingInterval int          `json:"samplingInterval"`
+	Timeout          int          `json:"timeout"`
+	SecurityPolicy   string       `json:"securityPolicy"`
+	SecurityMode     string       `json:"securityMode"`
+	Certification    string       `json:"certificationPath"`
+	PrivateKPath     string       `json:"privateKeyPath"`
+	Username         string       `json:"username"`
+	Password         string       `json:"password"`
+	Nodes            []*OPCUANode `json:"nodes"`
+}
+
+type OPCUANode struct {
+	NodeId string `json:"nodeId"`
+	// The name of the node in the tuple, default to the node id
+	Name string `json:"name"`
+	id   *ua.NodeID
+}
+
+// opcuaClient is the subset of the opcua client used by the source
+type opcuaClient interface {
+	Connect(ctx context.Context) error
+	Close() error
+	State() opcua.ConnState
+	Read(req *ua.ReadRequest) (*ua.ReadResponse, error)
+	Subscribe(params *opcua.SubscriptionParameters, notifyCh chan *opcua.PublishNotificationData) (opcuaSubscription, error)
+}
+
+type opcuaSubscription interface {
+	Monitor(ts ua.TimestampsToReturn, items ...*ua.MonitoredItemCreateRequest) (*ua.CreateMonitoredItemsResponse, error)
+	Cancel(ctx context.Context) error
+}
+
+type uaClient struct {
+	*opcua.Client
+}
+
+func (c *uaClient) Subscribe(params *opcua.SubscriptionParameters, notifyCh chan *opcua.PublishNotificationData) (opcuaSubscription, error) {
+	sub, err := c.Client.Subscribe(params, notifyCh)
+	if err != nil {
+		return nil, err
+	}
+	return sub, nil
+}
+
+// newOPCUAClient creates the client of the endpoint. Tests replace it with a stand-in of the server.
+var newOPCUAClient = func(ctx context.Context, o *OPCUASource) (opcuaClient, error) {
+	endpoints, err := opcua.GetEndpoints(ctx, o.endpoint)
+	if err != nil {
+		return nil, err
+	}
+	ep := opcua.SelectEndpoint(endpoints, o.conf.SecurityPolicy, ua.MessageSecurityModeFromString(o.conf.SecurityMode))
+	if ep == nil {
+		return nil, fmt.Errorf("no endpoint matches security policy %s and mode %s", o.conf.SecurityPolicy, o.conf.SecurityMode)
+	}
+	opts := append(o.opts[:len(o.opts):len(o.opts)], opcua.SecurityFromEndpoint(ep, o.authType))
+	return &uaClient{Client: opcua.NewClient(ep.EndpointURL, opts...)}, nil
+}
+
+// OPCUASource subscribes or polls the values of the nodes of an OPC UA server. Each value change of a node
+// is a tuple.
+type OPCUASource struct {
+	endpoint string
+	conf     *OPCUAConfig
+	opts     []opcua.Option
+	authType ua.UserTokenType
+
+	mu     sync.RWMutex
+	client opcuaClient
+	sub    opcuaSubscription
+	err    error
+}
+
+func (o *OPCUASource) Configure(endpoint string, props map[string]interface{}) error {
+	cfg := &OPCUAConfig{Mode: OPCUA_SUBSCRIBE, Interval: 1000, Timeout: 10000, SecurityPolicy: "None"}
+	if err := cast.MapToStruct(props, cfg); err != nil {
+		return fmt.Errorf("read properties %v fail with error: %v", props, err)
+	}
+	if endpoint == "" {
+		return errors.New("the server endpoint must be specified as the datasource")
+	}
+	cfg.Mode = strings.ToLower(cfg.Mode)
+	if cfg.Mode != OPCUA_SUBSCRIBE && cfg.Mode != OPCUA_POLL {
+		return fmt.Errorf("invalid property mode: %s, must be subscribe or poll", cfg.Mode)
+	}
+	if cfg.Interval <= 0 {
+		return fmt.Errorf("invalid property interval: %d, must be a positive integer", cfg.Interval)
+	}
+	if cfg.SamplingInterval < 0 {
+		return fmt.Errorf("invalid property samplingInterval: %d, must not be negative", cfg.SamplingInterval)
+	}
+	if cfg.Timeout <= 0 {
+		return fmt.Errorf("invalid property timeout: %d, must be a positive integer", cfg.Timeout)
+	}
+	if _, ok := ua.SecurityPolicyURIs[cfg.SecurityPolicy]; !ok {
+		return fmt.Errorf("invalid property securityPolicy: %s, must be None, Basic128Rsa15, Basic256, Basic256Sha256, Aes128Sha256RsaOaep or Aes256Sha256RsaPss", cfg.SecurityPolicy)
+	}
+	if cfg.SecurityMode == "" {
+		if cfg.SecurityPolicy == "None" {
+			cfg.SecurityMode = "None"
+		} else {
+			cfg.SecurityMode = "SignAndEncrypt"
+		}
+	}
+	switch cfg.SecurityMode {
+	case "None":
+		if cfg.SecurityPolicy != "None" {
+			return fmt.Errorf("securityMode None is not allowed for securityPolicy %s", cfg.SecurityPolicy)
+		}
+	case "Sign", "SignAndEncrypt":
+		if cfg.SecurityPolicy == "None" {
+			return fmt.Errorf("securityMode %s is not allowed for securityPolicy None", cfg.SecurityMode)
+		}
+	default:
+		return fmt.Errorf("invalid property securityMode: %s, must be None, Sign or SignAndEncrypt", cfg.SecurityMode)
+	}
+	if len(cfg.Nodes) == 0 {
+		return errors.New("property nodes is required")
+	}
+	for _, n := range cfg.Nodes {
+		if n.NodeId == "" {
+			return errors.New("nodeId of the node is required")
+		}
+		id, err := ua.ParseNodeID(n.NodeId)
+		if err != nil {
+			return fmt.Errorf("invalid nodeId: %v", err)
+		}
+		n.id = id
+		if n.Name == "" {
+			n.Name = n.NodeId
+		}
+	}
+
+	opts := []opcua.Option{
+		opcua.SecurityPolicy(cfg.SecurityPolicy),
+		opcua.SecurityModeString(cfg.SecurityMode),
+		opcua.RequestTimeout(time.Duration(cfg.Timeout) * time.Millisecond),
+		opcua.DialTimeout(time.Duration(cfg.Timeout) * time.Millisecond),
+	}
+	if cfg.SecurityPolicy != "None" {
+		if cfg.Certification == "" || cfg.PrivateKPath == "" {
+			return fmt.Errorf("certificationPath and privateKeyPath are required for securityPolicy %s", cfg.SecurityPolicy)
+		}
+		cert, key, err := loadOPCUACertificate(cfg.Certification, cfg.PrivateKPath)
+		if err != nil {
+			return err
+		}
+		opts = append(opts, opcua.Certificate(cert), opcua.PrivateKey(key))
+	}
+	if cfg.Username != "" {
+		o.authType = ua.UserTokenTypeUserName
+		opts = append(opts, opcua.AuthUsername(cfg.Username, cfg.Password))
+	} else {
+		o.authType = ua.UserTokenTypeAnonymous
+		opts = append(opts, opcua.AuthAnonymous())
+	}
+	o.endpoint = endpoint
+	o.conf = cfg
+	o.opts = opts
+	return nil
+}
+
+// loadOPCUACertificate loads the client certificate in DER or PEM and the RSA private key in PEM
+func loadOPCUACertificate(certPath, keyPath string) ([]byte, *rsa.PrivateKey, error) {
+	cp, err := conf.ProcessPath(certPath)
+	if err != nil {
+		return nil, nil, err
+	}
+	cert, err := ioutil.ReadFile(cp)
+	if err != nil {
+		return nil, nil, fmt.Errorf("fail to read certification %s: %v", certPath, err)
+	}
+	if block, _ := pem.Decode(cert); block != nil {
+		cert = block.Bytes
+	}
+	if _, err := x509.ParseCertificate(cert); err != nil {
+		return nil, nil, fmt.Errorf("invalid certification %s: %v", certPath, err)
+	}
+	kp, err := conf.ProcessPath(keyPath)
+	if err != nil {
+		return nil, nil, err
+	}
+	b, err := ioutil.ReadFile(kp)
+	if err != nil {
+		return nil, nil, fmt.Errorf("fail to read private key %s: %v", keyPath, err)
+	}
+	block, _ := pem.Decode(b)
+	if block == nil {
+		return nil, nil, fmt.Errorf("invalid private key %s: not in PEM format", keyPath)
+	}
+	if key, err := x509.ParsePKCS1PrivateKey(block.Bytes); err == nil {
+		return cert, key, nil
+	}
+	k, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid private key %s: %v", keyPath, err)
+	}
+	key, ok := k.(*rsa.PrivateKey)
+	if !ok {
+		return nil, nil, fmt.Errorf("invalid private key %s: must be a RSA key", keyPath)
+	}
+	return cert, key, nil
+}
+
+func (o *OPCUASource) Open(ctx api.StreamContext, consumer chan<- api.SourceTuple, errCh chan<- error) {
+	logger := ctx.GetLogger()
+	cli, err := newOPCUAClient(ctx, o)
+	if err == nil {
+		err = cli.Connect(ctx)
+	}
+	if err != nil {
+		o.setError(err)
+		errCh <- fmt.Errorf("fail to connect opcua server %s: %v", o.endpoint, err)
+		return
+	}
+	o.mu.Lock()
+	o.client = cli
+	o.mu.Unlock()
+	logger.Infof("Connected to opcua server %s, %s %d nodes every %d ms", o.endpoint, o.conf.Mode, len(o.conf.Nodes), o.conf.Interval)
+	if o.conf.Mode == OPCUA_POLL {
+		o.poll(ctx, cli, consumer)
+	} else {
+		o.subscribe(ctx, cli, consumer, errCh)
+	}
+}
+
+func (o *OPCUASource) subscribe(ctx api.StreamContext, cli opcuaClient, consumer chan<- api.SourceTuple, errCh chan<- error) {
+	logger := ctx.GetLogger()
+	notifyCh := make(chan *opcua.PublishNotificationData, 100)
+	sub, err := cli.Subscribe(&opcua.SubscriptionParameters{Interval: time.Duration(o.conf.Interval) * time.Millisecond}, notifyCh)
+	if err != nil {
+		errCh <- fmt.Errorf("fail to create opcua subscription: %v", err)
+		return
+	}
+	o.mu.Lock()
+	o.sub = sub
+	o.mu.Unlock()
+	items := make([]*ua.MonitoredItemCreateRequest, len(o.conf.Nodes))
+	for i, n := range o.conf.Nodes {
+		// The index of the node is the client handle to find the node of a notification
+		items[i] = opcua.NewMonitoredItemCreateRequestWithDefaults(n.id, ua.AttributeIDValue, uint32(i))
+		items[i].RequestedParameters.SamplingInterval = float64(o.conf.SamplingInterval)
+	}
+	res, err := sub.Monitor(ua.TimestampsToReturnBoth, items...)
+	if err == nil && res.ResponseHeader != nil && res.ResponseHeader.ServiceResult != ua.StatusOK {
+		err = res.ResponseHeader.ServiceResult
+	}
+	if err != nil {
+		errCh <- fmt.Errorf("fail to monitor opcua nodes: %v", err)
+		return
+	}
+	for i, r := range res.Results {
+		if r.StatusCode != ua.StatusOK && i < len(o.conf.Nodes) {
+			logger.Warnf("Fail to monitor opcua node %s: %v", o.conf.Nodes[i].NodeId, r.StatusCode)
+		}
+	}
+	for {
+		select {
+		case data := <-notifyCh:
+			if data.Error != nil {
+				logger.Warnf("Found error from opcua subscription: %v", data.Error)
+				o.setError(data.Error)
+				continue
+			}
+			if v, ok := data.Value.(*ua.DataChangeNotification); ok {
+				for _, item := range v.MonitoredItems {
+					if int(item.ClientHandle) >= len(o.conf.Nodes) {
+						continue
+					}
+					if !o.send(ctx, consumer, o.conf.Nodes[item.ClientHandle], item.Value) {
+						return
+					}
+				}
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func (o *OPCUASource) poll(ctx api.StreamContext, cli opcuaClient, consumer chan<- api.SourceTuple) {
+	logger := ctx.GetLogger()
+	req := &ua.ReadRequest{TimestampsToReturn: ua.TimestampsToReturnBoth, NodesToRead: make([]*ua.ReadValueID, len(o.conf.Nodes))}
+	for i, n := range o.conf.Nodes {
+		req.NodesToRead[i] = &ua.ReadValueID{NodeID: n.id, AttributeID: ua.AttributeIDValue}
+	}
+	ticker := conf.GetTicker(o.conf.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			res, err := cli.Read(req)
+			if err == nil && res.ResponseHeader != nil && res.ResponseHeader.ServiceResult != ua.StatusOK {
+				err = res.ResponseHeader.ServiceResult
+			}
+			if err != nil {
+				logger.Warnf("Found error %s when reading opcua nodes", err)
+				o.setError(err)
+				continue
+			}
+			for i, dv := range res.Results {
+				if i >= len(o.conf.Nodes) {
+					break
+				}
+				if !o.send(ctx, consumer, o.conf.Nodes[i], dv) {
+					return
+				}
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// send the value of the node as a tuple and returns false if the source is closed
+func (o *OPCUASource) send(ctx api.StreamContext, consumer chan<- api.SourceTuple, node *OPCUANode, dv *ua.DataValue) bool {
+	if dv == nil {
+		return true
+	}
+	message := map[string]interface{}{
+		"node":       node.Name,
+		"value":      nil,
+		"statusCode": int64(dv.Status),
+	}
+	if dv.Value != nil {
+		message["value"] = opcuaValue(dv.Value.Value())
+	}
+	if !dv.SourceTimestamp.IsZero() {
+		message["sourceTimestamp"] = cast.TimeToUnixMilli(dv.SourceTimestamp)
+	}
+	meta := map[string]interface{}{"nodeId": node.NodeId, "endpoint": o.endpoint}
+	if !dv.ServerTimestamp.IsZero() {
+		meta["serverTimestamp"] = cast.TimeToUnixMilli(dv.ServerTimestamp)
+	}
+	select {
+	case consumer <- api.NewDefaultSourceTuple(message, meta):
+		ctx.GetLogger().Debugf("send opcua data of node %s to device node", node.NodeId)
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// opcuaValue converts the variant value to the types of the rule engine
+func opcuaValue(v interface{}) interface{} {
+	switch t := v.(type) {
+	case nil, bool, string, int64, float64, time.Time, []byte:
+		return t
+	case int8:
+		return int64(t)
+	case int16:
+		return int64(t)
+	case int32:
+		return int64(t)
+	case uint8:
+		return int64(t)
+	case uint16:
+		return int64(t)
+	case uint32:
+		return int64(t)
+	case uint64:
+		if t > math.MaxInt64 {
+			return float64(t)
+		}
+		return int64(t)
+	case float32:
+		return float64(t)
+	case ua.StatusCode:
+		return int64(t)
+	case *ua.LocalizedText:
+		return t.Text
+	case *ua.QualifiedName:
+		return t.Name
+	case *ua.NodeID:
+		return t.String()
+	case *ua.ExpandedNodeID:
+		return t.String()
+	case *ua.GUID:
+		return t.String()
+	}
+	rv := reflect.ValueOf(v)
+	if rv.Kind() == reflect.Slice || rv.Kind() == reflect.Array {
+		result := make([]interface{}, rv.Len())
+		for i := range result {
+			result[i] = opcuaValue(rv.Index(i).Interface())
+		}
+		return result
+	}
+	return fmt.Sprintf("%v", v)
+}
+
+func (o *OPCUASource) setError(err error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.err = err
+}
+
+func (o *OPCUASource) ConnectionStatus() api.ConnectionStatus {
+	o.mu.RLock()
+	defer o.mu.RUnlock()
+	st := api.ConnectionStatus{Status: api.ConnectionConnecting, Server: o.endpoint}
+	if o.err != nil {
+		st.LastError = o.err.Error()
+	}
+	if o.client == nil {
+		if o.err != nil {
+			st.Status = api.ConnectionDisconnected
+		}
+		return st
+	}
+	switch o.client.State() {
+	case opcua.Connected:
+		st.Status = api.ConnectionConnected
+	case opcua.Disconnected, opcua.Closed:
+		st.Status = api.ConnectionDisconnected
+	}
+	return st
+}
+
+func (o *OPCUASource) Close(ctx api.StreamContext) error {
+	ctx.GetLogger().Infof("Closing opcua source %s", o.endpoint)
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if o.sub != nil {
+		if err := o.sub.Cancel(context.Background()); err != nil {
+			ctx.GetLogger().Warnf("Fail to cancel opcua subscription: %v", err)
+		}
+		o.sub = nil
+	}
+	if o.client != nil {
+		err := o.client.Close()
+		o.client = nil
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,370 @@
+// Copyright 2021 EMQ Technologies Co., Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package source
+
+import (
+	gocontext "context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"github.com/gopcua/opcua"
+	"github.com/gopcua/opcua/ua"
+	"github.com/lf-edge/ekuiper/internal/conf"
+	"github.com/lf-edge/ekuiper/internal/topo/context"
+	"github.com/lf-edge/ekuiper/internal/topo/topotest/mockclock"
+	"github.com/lf-edge/ekuiper/pkg/api"
+	"io/ioutil"
+	"math/big"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+// mockOPCUAServer stands in for an OPC UA server behind the client interface. It serves the values of the nodes
+// to read and publishes the data changes to the subscription.
+type mockOPCUAServer struct {
+	mu       sync.Mutex
+	values   map[string]*ua.DataValue
+	state    opcua.ConnState
+	notifyCh chan *opcua.PublishNotificationData
+	items    []*ua.MonitoredItemCreateRequest
+	canceled bool
+}
+
+func (m *mockOPCUAServer) Connect(_ gocontext.Context) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.state = opcua.Connected
+	return nil
+}
+
+func (m *mockOPCUAServer) Close() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.state = opcua.Closed
+	return nil
+}
+
+func (m *mockOPCUAServer) State() opcua.ConnState {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.state
+}
+
+func (m *mockOPCUAServer) Read(req *ua.ReadRequest) (*ua.ReadResponse, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	res := &ua.ReadResponse{ResponseHeader: &ua.ResponseHeader{ServiceResult: ua.StatusOK}}
+	for _, n := range req.NodesToRead {
+		dv, ok := m.values[n.NodeID.String()]
+		if !ok {
+			dv = &ua.DataValue{Status: ua.StatusBadNodeIDUnknown}
+		}
+		res.Results = append(res.Results, dv)
+	}
+	return res, nil
+}
+
+func (m *mockOPCUAServer) Subscribe(_ *opcua.SubscriptionParameters, notifyCh chan *opcua.PublishNotificationData) (opcuaSubscription, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.notifyCh = notifyCh
+	return m, nil
+}
+
+func (m *mockOPCUAServer) Monitor(_ ua.TimestampsToReturn, items ...*ua.MonitoredItemCreateRequest) (*ua.CreateMonitoredItemsResponse, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	res := &ua.CreateMonitoredItemsResponse{ResponseHeader: &ua.ResponseHeader{ServiceResult: ua.StatusOK}}
+	for _, item := range items {
+		status := ua.StatusOK
+		if _, ok := m.values[item.ItemToMonitor.NodeID.String()]; !ok {
+			status = ua.StatusBadNodeIDUnknown
+		}
+		res.Results = append(res.Results, &ua.MonitoredItemCreateResult{StatusCode: status})
+	}
+	m.items = items
+	return res, nil
+}
+
+func (m *mockOPCUAServer) Cancel(_ gocontext.Context) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.canceled = true
+	return nil
+}
+
+// publish the value change of the node to the subscription
+func (m *mockOPCUAServer) publish(t *testing.T, nodeId string, dv *ua.DataValue) {
+	var (
+		notifyCh chan *opcua.PublishNotificationData
+		item     *ua.MonitoredItemCreateRequest
+	)
+	for i := 0; i < 100; i++ {
+		m.mu.Lock()
+		for _, it := range m.items {
+			if it.ItemToMonitor.NodeID.String() == nodeId {
+				item = it
+			}
+		}
+		notifyCh = m.notifyCh
+		m.mu.Unlock()
+		if item != nil {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if item == nil {
+		t.Fatalf("node %s is not monitored", nodeId)
+	}
+	notifyCh <- &opcua.PublishNotificationData{Value: &ua.DataChangeNotification{
+		MonitoredItems: []*ua.MonitoredItemNotification{{ClientHandle: item.RequestedParameters.ClientHandle, Value: dv}},
+	}}
+}
+
+func mockOPCUAClient(t *testing.T, m *mockOPCUAServer) {
+	origin := newOPCUAClient
+	newOPCUAClient = func(_ gocontext.Context, _ *OPCUASource) (opcuaClient, error) {
+		return m, nil
+	}
+	t.Cleanup(func() {
+		newOPCUAClient = origin
+	})
+}
+
+func newDataValue(t *testing.T, v interface{}, status ua.StatusCode, ts time.Time) *ua.DataValue {
+	va, err := ua.NewVariant(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &ua.DataValue{Value: va, Status: status, SourceTimestamp: ts, ServerTimestamp: ts}
+}
+
+func TestOPCUASourceConfigure(t *testing.T) {
+	nodes := []interface{}{map[string]interface{}{"nodeId": "ns=2;s=Temperature"}}
+	var tests = []struct {
+		props map[string]interface{}
+		err   string
+	}{
+		{
+			props: map[string]interface{}{},
+			err:   "property nodes is required",
+		}, {
+			props: map[string]interface{}{"mode": "push", "nodes": nodes},
+			err:   "invalid property mode: push, must be subscribe or poll",
+		}, {
+			props: map[string]interface{}{"nodes": []interface{}{map[string]interface{}{"nodeId": "ns=a;i=1"}}},
+			err:   "invalid nodeId: opcua: invalid namespace id: ns=a;i=1",
+		}, {
+			props: map[string]interface{}{"securityPolicy": "Basic512", "nodes": nodes},
+			err:   "invalid property securityPolicy: Basic512, must be None, Basic128Rsa15, Basic256, Basic256Sha256, Aes128Sha256RsaOaep or Aes256Sha256RsaPss",
+		}, {
+			props: map[string]interface{}{"securityMode": "Sign", "nodes": nodes},
+			err:   "securityMode Sign is not allowed for securityPolicy None",
+		}, {
+			props: map[string]interface{}{"securityPolicy": "Basic256Sha256", "securityMode": "None", "nodes": nodes},
+			err:   "securityMode None is not allowed for securityPolicy Basic256Sha256",
+		}, {
+			props: map[string]interface{}{"securityPolicy": "Basic256Sha256", "nodes": nodes},
+			err:   "certificationPath and privateKeyPath are required for securityPolicy Basic256Sha256",
+		},
+	}
+	for i, tt := range tests {
+		s := &OPCUASource{}
+		err := s.Configure("opc.tcp://127.0.0.1:4840", tt.props)
+		if err == nil || err.Error() != tt.err {
+			t.Errorf("%d: error mismatch:\n  exp=%s\n  got=%v", i, tt.err, err)
+		}
+	}
+}
+
+func TestOPCUASourceCertificate(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{SerialNumber: big.NewInt(1), NotBefore: time.Now(), NotAfter: time.Now().Add(time.Hour)}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	certPath, keyPath := filepath.Join(dir, "cert.der"), filepath.Join(dir, "key.pem")
+	if err := ioutil.WriteFile(certPath, der, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(keyPath, pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	s := &OPCUASource{}
+	err = s.Configure("opc.tcp://127.0.0.1:4840", map[string]interface{}{
+		"securityPolicy":    "Basic256Sha256",
+		"certificationPath": certPath,
+		"privateKeyPath":    keyPath,
+		"username":          "user",
+		"password":          "pass",
+		"nodes":             []interface{}{map[string]interface{}{"nodeId": "ns=2;s=Temperature"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.conf.SecurityMode != "SignAndEncrypt" || s.authType != ua.UserTokenTypeUserName {
+		t.Errorf("unexpected security mode %s and auth type %v", s.conf.SecurityMode, s.authType)
+	}
+	// Swap the certificate and the key
+	err = s.Configure("opc.tcp://127.0.0.1:4840", map[string]interface{}{
+		"securityPolicy":    "Basic256Sha256",
+		"certificationPath": keyPath,
+		"privateKeyPath":    certPath,
+		"nodes":             []interface{}{map[string]interface{}{"nodeId": "ns=2;s=Temperature"}},
+	})
+	if err == nil {
+		t.Error("should fail to load the invalid certification")
+	}
+}
+
+func TestOPCUASourceSubscribe(t *testing.T) {
+	ts := time.Date(2021, 12, 1, 10, 0, 0, 0, time.UTC)
+	m := &mockOPCUAServer{values: map[string]*ua.DataValue{
+		"ns=2;s=Temperature": newDataValue(t, float32(20.5), ua.StatusOK, ts),
+		"i=2258":             newDataValue(t, ts, ua.StatusOK, ts),
+	}}
+	mockOPCUAClient(t, m)
+	ctx, cancel := context.WithValue(context.Background(), context.LoggerKey, conf.Log).WithCancel()
+	defer cancel()
+	s := &OPCUASource{}
+	err := s.Configure("opc.tcp://127.0.0.1:4840", map[string]interface{}{
+		"nodes": []interface{}{
+			map[string]interface{}{"nodeId": "ns=2;s=Temperature", "name": "temperature"},
+			map[string]interface{}{"nodeId": "i=2258"},
+			map[string]interface{}{"nodeId": "ns=2;s=Unknown"},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	consumer := make(chan api.SourceTuple, 10)
+	errCh := make(chan error, 1)
+	go s.Open(ctx, consumer, errCh)
+
+	m.publish(t, "ns=2;s=Temperature", m.values["ns=2;s=Temperature"])
+	m.publish(t, "i=2258", m.values["i=2258"])
+	m.publish(t, "ns=2;s=Temperature", newDataValue(t, float32(21), ua.StatusUncertain, time.Time{}))
+	exps := []struct {
+		message map[string]interface{}
+		meta    map[string]interface{}
+	}{
+		{
+			message: map[string]interface{}{"node": "temperature", "value": 20.5, "statusCode": int64(0), "sourceTimestamp": int64(1638352800000)},
+			meta:    map[string]interface{}{"nodeId": "ns=2;s=Temperature", "endpoint": "opc.tcp://127.0.0.1:4840", "serverTimestamp": int64(1638352800000)},
+		}, {
+			message: map[string]interface{}{"node": "i=2258", "value": ts, "statusCode": int64(0), "sourceTimestamp": int64(1638352800000)},
+			meta:    map[string]interface{}{"nodeId": "i=2258", "endpoint": "opc.tcp://127.0.0.1:4840", "serverTimestamp": int64(1638352800000)},
+		}, {
+			message: map[string]interface{}{"node": "temperature", "value": 21.0, "statusCode": int64(ua.StatusUncertain)},
+			meta:    map[string]interface{}{"nodeId": "ns=2;s=Temperature", "endpoint": "opc.tcp://127.0.0.1:4840"},
+		},
+	}
+	for i, exp := range exps {
+		tuple := receiveTuple(t, consumer)
+		if !reflect.DeepEqual(exp.message, tuple.Message()) {
+			t.Errorf("%d: message mismatch:\n  exp=%v\n  got=%v", i, exp.message, tuple.Message())
+		}
+		if !reflect.DeepEqual(exp.meta, tuple.Meta()) {
+			t.Errorf("%d: meta mismatch:\n  exp=%v\n  got=%v", i, exp.meta, tuple.Meta())
+		}
+	}
+	if st := s.ConnectionStatus(); st.Status != api.ConnectionConnected {
+		t.Errorf("unexpected connection status %v", st)
+	}
+	if err := s.Close(ctx); err != nil {
+		t.Error(err)
+	}
+	if !m.canceled || m.State() != opcua.Closed {
+		t.Error("the subscription and the client should be closed")
+	}
+	select {
+	case err := <-errCh:
+		t.Error(err)
+	default:
+	}
+}
+
+func TestOPCUASourcePoll(t *testing.T) {
+	m := &mockOPCUAServer{values: map[string]*ua.DataValue{
+		"ns=2;i=10": newDataValue(t, []int32{1, 2}, ua.StatusOK, time.Time{}),
+	}}
+	mockOPCUAClient(t, m)
+	mockclock.ResetClock(10000)
+	ctx, cancel := context.WithValue(context.Background(), context.LoggerKey, conf.Log).WithCancel()
+	defer cancel()
+	s := &OPCUASource{}
+	err := s.Configure("opc.tcp://127.0.0.1:4840", map[string]interface{}{
+		"mode":  "poll",
+		"nodes": []interface{}{map[string]interface{}{"nodeId": "ns=2;i=10", "name": "array"}, map[string]interface{}{"nodeId": "ns=2;i=11"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	consumer := make(chan api.SourceTuple, 10)
+	errCh := make(chan error, 1)
+	go s.Open(ctx, consumer, errCh)
+	defer s.Close(ctx)
+
+	var tuple api.SourceTuple
+	for i := 0; tuple == nil && i < 50; i++ {
+		mockclock.GetMockClock().Add(time.Second)
+		select {
+		case tuple = <-consumer:
+		case <-time.After(100 * time.Millisecond):
+		}
+	}
+	if tuple == nil {
+		t.Fatal("timeout to receive tuple")
+	}
+	exp := map[string]interface{}{"node": "array", "value": []interface{}{int64(1), int64(2)}, "statusCode": int64(0)}
+	if !reflect.DeepEqual(exp, tuple.Message()) {
+		t.Errorf("message mismatch:\n  exp=%v\n  got=%v", exp, tuple.Message())
+	}
+	tuple = receiveTuple(t, consumer)
+	exp = map[string]interface{}{"node": "ns=2;i=11", "value": nil, "statusCode": int64(ua.StatusBadNodeIDUnknown)}
+	if !reflect.DeepEqual(exp, tuple.Message()) {
+		t.Errorf("message mismatch:\n  exp=%v\n  got=%v", exp, tuple.Message())
+	}
+}
+
+func TestOPCUAValue(t *testing.T) {
+	var tests = []struct {
+		v   interface{}
+		exp interface{}
+	}{
+		{v: int8(-1), exp: int64(-1)},
+		{v: uint32(7), exp: int64(7)},
+		{v: uint64(1 << 63), exp: float64(1 << 63)},
+		{v: float32(0.5), exp: 0.5},
+		{v: "a", exp: "a"},
+		{v: ua.NewLocalizedText("hello"), exp: "hello"},
+		{v: ua.NewNumericNodeID(2, 10), exp: "ns=2;i=10"},
+		{v: []string{"a", "b"}, exp: []interface{}{"a", "b"}},
+	}
+	for i, tt := range tests {
+		if r := opcuaValue(tt.v); !reflect.DeepEqual(tt.exp, r) {
+			t.Errorf("%d: value mismatch:\n  exp=%v\n  got=%v", i, tt.exp, r)
+		}
+	}
+}
@@ -0,0 +1,392 @@
+// Copyright 2021 EMQ Technologies Co., Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package source
+
+import (
+	"errors"
+	"fmt"
+	"github.com/gomodule/redigo/redis"
+	"github.com/lf-edge/ekuiper/pkg/api"
+	"github.com/lf-edge/ekuiper/pkg/cast"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	REDIS_PUBSUB = "pubsub"
+	REDIS_STREAM = "stream"
+)
+
+type RedisSourceConfig struct {
+	Addr              string `json:"addr"`
+	Password          string `json:"password"`
+	Db                int    `json:"db"`
+	Timeout           int    `json:"timeout"`
+	ReconnectInterval int    `json:"reconnectInterval"`
+	Format            string `json:"format"`
+	// pubsub or stream
+	Type string `json:"type"`
+	// The consumer group configurations of stream
+	Group    string `json:"group"`
+	Consumer string `json:"consumer"`
+	StartId  string `json:"startId"`
+	Count    int    `json:"count"`
+	Block    int    `json:"block"`
+	// The field of the stream entry which is decoded by the format as the message
+	PayloadField string `json:"payloadField"`
+}
+
+// redisTuple holds the id of the stream entry to acknowledge it after the tuple is processed
+type redisTuple struct {
+	*api.DefaultSourceTuple
+	id string
+}
+
+// RedisSource subscribes the Pub/Sub channels or consumes a stream by a consumer group. The stream entries are
+// acknowledged after processed, see api.Acknowledgeable.
+type RedisSource struct {
+	key  string
+	conf *RedisSourceConfig
+	pool *redis.Pool
+
+	mu       sync.Mutex
+	consumer string
+	// The ids of the stream entries delivered but not acknowledged in order
+	pending []string
+	status  api.ConnectionStatus
+}
+
+func (rs *RedisSource) Configure(key string, props map[string]interface{}) error {
+	cfg := &RedisSourceConfig{Addr: "127.0.0.1:6379", Timeout: 5000, ReconnectInterval: 2000, Type: REDIS_PUBSUB, StartId: "$", Count: 10, Block: 1000}
+	if err := cast.MapToStruct(props, cfg); err != nil {
+		return fmt.Errorf("read properties %v fail with error: %v", props, err)
+	}
+	if key == "" {
+		return errors.New("the channel or stream key must be specified as the datasource")
+	}
+	switch cfg.Type {
+	case REDIS_PUBSUB:
+	case REDIS_STREAM:
+		if cfg.Group == "" {
+			return errors.New("property group is required for stream")
+		}
+		if cfg.Count <= 0 {
+			return fmt.Errorf("invalid property count: %d, must be a positive integer", cfg.Count)
+		}
+		if cfg.Block <= 0 {
+			return fmt.Errorf("invalid property block: %d, must be a positive integer", cfg.Block)
+		}
+	default:
+		return fmt.Errorf("invalid property type: %s, must be pubsub or stream", cfg.Type)
+	}
+	if cfg.ReconnectInterval <= 0 {
+		return fmt.Errorf("invalid property reconnectInterval: %d, must be a positive integer", cfg.ReconnectInterval)
+	}
+	rs.key = key
+	rs.conf = cfg
+	rs.pool = &redis.Pool{MaxIdle: 2, IdleTimeout: 5 * time.Minute, Dial: rs.dial}
+	return nil
+}
+
+func (rs *RedisSource) dial() (redis.Conn, error) {
+	opts := []redis.DialOption{
+		redis.DialConnectTimeout(time.Duration(rs.conf.Timeout) * time.Millisecond),
+		redis.DialDatabase(rs.conf.Db),
+	}
+	if rs.conf.Password != "" {
+		opts = append(opts, redis.DialPassword(rs.conf.Password))
+	}
+	return redis.Dial("tcp", rs.conf.Addr, opts...)
+}
+
+// isPattern returns whether the channel is a glob-style pattern to subscribe by PSUBSCRIBE
+func (rs *RedisSource) isPattern() bool {
+	return strings.ContainsAny(rs.key, "*?[")
+}
+
+func (rs *RedisSource) Open(ctx api.StreamContext, consumer chan<- api.SourceTuple, _ chan<- error) {
+	logger := ctx.GetLogger()
+	rs.mu.Lock()
+	rs.consumer = rs.conf.Consumer
+	if rs.consumer == "" {
+		rs.consumer = fmt.Sprintf("%s_%s_%d", ctx.GetRuleId(), ctx.GetOpId(), ctx.GetInstanceId())
+	}
+	rs.mu.Unlock()
+	for {
+		var err error
+		if rs.conf.Type == REDIS_STREAM {
+			err = rs.consumeStream(ctx, consumer)
+		} else {
+			err = rs.subscribe(ctx, consumer)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		default:
+		}
+		logger.Warnf("Redis source %s is disconnected: %v, reconnect in %d ms", rs.key, err, rs.conf.ReconnectInterval)
+		rs.setStatus(api.ConnectionDisconnected, err)
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(time.Duration(rs.conf.ReconnectInterval) * time.Millisecond):
+		}
+	}
+}
+
+// subscribe the channel until the connection is broken or the rule stops
+func (rs *RedisSource) subscribe(ctx api.StreamContext, consumer chan<- api.SourceTuple) error {
+	logger := ctx.GetLogger()
+	c, err := rs.dial()
+	if err != nil {
+		return err
+	}
+	psc := redis.PubSubConn{Conn: c}
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			// Unblock the receive
+			psc.Close()
+		case <-done:
+			psc.Close()
+		}
+	}()
+	if rs.isPattern() {
+		err = psc.PSubscribe(rs.key)
+	} else {
+		err = psc.Subscribe(rs.key)
+	}
+	if err != nil {
+		return err
+	}
+	for {
+		switch v := psc.Receive().(type) {
+		case redis.Message:
+			results, err := decodeMessages(v.Data, rs.conf.Format)
+			if err != nil {
+				logger.Errorf("Invalid data format from redis channel %s: %v", v.Channel, err)
+				continue
+			}
+			meta := map[string]interface{}{"channel": v.Channel}
+			if v.Pattern != "" {
+				meta["pattern"] = v.Pattern
+			}
+			for _, result := range results {
+				select {
+				case consumer <- api.NewDefaultSourceTuple(result, meta):
+				case <-ctx.Done():
+					return nil
+				}
+			}
+		case redis.Subscription:
+			logger.Infof("Successfully subscribed to redis channel %s", v.Channel)
+			rs.setStatus(api.ConnectionConnected, nil)
+		case error:
+			return v
+		}
+	}
+}
+
+// consumeStream reads the stream by the consumer group until the connection is broken or the rule stops. The
+// entries delivered to the consumer but not acknowledged before are read first.
+func (rs *RedisSource) consumeStream(ctx api.StreamContext, consumer chan<- api.SourceTuple) error {
+	c, err := rs.dial()
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+	if _, err := c.Do("XGROUP", "CREATE", rs.key, rs.conf.Group, rs.conf.StartId, "MKSTREAM"); err != nil && !strings.HasPrefix(err.Error(), "BUSYGROUP") {
+		return fmt.Errorf("fail to create consumer group %s: %v", rs.conf.Group, err)
+	}
+	rs.mu.Lock()
+	rs.pending = nil
+	consumerName := rs.consumer
+	rs.mu.Unlock()
+	rs.setStatus(api.ConnectionConnected, nil)
+	ctx.GetLogger().Infof("Successfully consume redis stream %s by group %s as consumer %s", rs.key, rs.conf.Group, consumerName)
+	lastId := "0"
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		default:
+		}
+		args := []interface{}{"GROUP", rs.conf.Group, consumerName, "COUNT", rs.conf.Count}
+		if lastId == ">" {
+			args = append(args, "BLOCK", rs.conf.Block)
+		}
+		args = append(args, "STREAMS", rs.key, lastId)
+		reply, err := redis.Values(c.Do("XREADGROUP", args...))
+		if err == redis.ErrNil {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		entries, err := parseStreamEntries(reply)
+		if err != nil {
+			return err
+		}
+		if lastId != ">" {
+			if len(entries) == 0 {
+				lastId = ">"
+				continue
+			}
+			lastId = entries[len(entries)-1].id
+		}
+		for _, e := range entries {
+			if !rs.handleEntry(ctx, e, consumer) {
+				return nil
+			}
+		}
+	}
+}
+
+type streamEntry struct {
+	id     string
+	fields map[string]string
+}
+
+// parseStreamEntries parses the reply of XREADGROUP of one stream
+func parseStreamEntries(reply []interface{}) ([]*streamEntry, error) {
+	if len(reply) == 0 {
+		return nil, nil
+	}
+	stream, err := redis.Values(reply[0], nil)
+	if err != nil || len(stream) != 2 {
+		return nil, fmt.Errorf("invalid stream reply %v", reply[0])
+	}
+	items, err := redis.Values(stream[1], nil)
+	if err != nil {
+		return nil, err
+	}
+	result := make([]*streamEntry, 0, len(items))
+	for _, item := range items {
+		pair, err := redis.Values(item, nil)
+		if err != nil || len(pair) != 2 {
+			return nil, fmt.Errorf("invalid stream entry %v", item)
+		}
+		id, err := redis.String(pair[0], nil)
+		if err != nil {
+			return nil, err
+		}
+		// The entry deleted after delivered has nil fields
+		fields, err := redis.StringMap(pair[1], nil)
+		if err != nil && err != redis.ErrNil {
+			return nil, err
+		}
+		result = append(result, &streamEntry{id: id, fields: fields})
+	}
+	return result, nil
+}
+
+// handleEntry sends the entry as tuples and returns false if the rule stops
+func (rs *RedisSource) handleEntry(ctx api.StreamContext, e *streamEntry, consumer chan<- api.SourceTuple) bool {
+	rs.mu.Lock()
+	rs.pending = append(rs.pending, e.id)
+	rs.mu.Unlock()
+	var results []map[string]interface{}
+	if rs.conf.PayloadField != "" {
+		payload, ok := e.fields[rs.conf.PayloadField]
+		if !ok {
+			ctx.GetLogger().Errorf("Field %s not found in redis stream entry %s", rs.conf.PayloadField, e.id)
+			return true
+		}
+		var err error
+		results, err = decodeMessages([]byte(payload), rs.conf.Format)
+		if err != nil {
+			ctx.GetLogger().Errorf("Invalid data format from redis stream entry %s: %v", e.id, err)
+			return true
+		}
+	} else {
+		result := make(map[string]interface{}, len(e.fields))
+		for k, v := range e.fields {
+			result[k] = v
+		}
+		results = append(results, result)
+	}
+	meta := map[string]interface{}{"stream": rs.key, "id": e.id}
+	for i, result := range results {
+		t := &redisTuple{DefaultSourceTuple: api.NewDefaultSourceTuple(result, meta)}
+		// Only the last tuple of the entry acknowledges it
+		if i == len(results)-1 {
+			t.id = e.id
+		}
+		select {
+		case consumer <- t:
+		case <-ctx.Done():
+			return false
+		}
+	}
+	return true
+}
+
+// Ack acknowledges the stream entry of the tuple and all the entries delivered before it, including the dropped
+// ones which cannot be decoded.
+func (rs *RedisSource) Ack(_ api.StreamContext, tuple api.SourceTuple) error {
+	t, ok := tuple.(*redisTuple)
+	if !ok || t.id == "" {
+		return nil
+	}
+	rs.mu.Lock()
+	var ids []interface{}
+	for i, id := range rs.pending {
+		if id == t.id {
+			for _, p := range rs.pending[:i+1] {
+				ids = append(ids, p)
+			}
+			rs.pending = rs.pending[i+1:]
+			break
+		}
+	}
+	rs.mu.Unlock()
+	if len(ids) == 0 {
+		return nil
+	}
+	c := rs.pool.Get()
+	defer c.Close()
+	_, err := c.Do("XACK", append([]interface{}{rs.key, rs.conf.Group}, ids...)...)
+	return err
+}
+
+func (rs *RedisSource) setStatus(st string, err error) {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	rs.status.Status = st
+	rs.status.Server = rs.conf.Addr
+	if err != nil {
+		rs.status.LastError = err.Error()
+	}
+}
+
+func (rs *RedisSource) ConnectionStatus() api.ConnectionStatus {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	if rs.status.Status == "" {
+		return api.ConnectionStatus{Status: api.ConnectionConnecting, Server: rs.conf.Addr}
+	}
+	return rs.status
+}
+
+func (rs *RedisSource) Close(ctx api.StreamContext) error {
+	ctx.GetLogger().Infof("Closing redis source %s", rs.key)
+	if rs.pool != nil {
+		return rs.pool.Close()
+	}
+	return nil
+}
@@ -0,0 +1,221 @@
+// Copyright 2021 EMQ Technologies Co., Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package source
+
+import (
+	"github.com/alicebob/miniredis/v2"
+	"github.com/gomodule/redigo/redis"
+	"github.com/lf-edge/ekuiper/internal/conf"
+	"github.com/lf-edge/ekuiper/internal/topo/context"
+	"github.com/lf-edge/ekuiper/pkg/api"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func runMiniredis(t *testing.T) *miniredis.Miniredis {
+	mr, err := miniredis.Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(mr.Close)
+	return mr
+}
+
+func TestRedisSourceConfigure(t *testing.T) {
+	var tests = []struct {
+		props map[string]interface{}
+		err   string
+	}{
+		{
+			props: map[string]interface{}{"type": "list"},
+			err:   "invalid property type: list, must be pubsub or stream",
+		}, {
+			props: map[string]interface{}{"type": "stream"},
+			err:   "property group is required for stream",
+		}, {
+			props: map[string]interface{}{"type": "stream", "group": "g1", "count": 0},
+			err:   "invalid property count: 0, must be a positive integer",
+		}, {
+			props: map[string]interface{}{"reconnectInterval": -1},
+			err:   "invalid property reconnectInterval: -1, must be a positive integer",
+		},
+	}
+	for i, tt := range tests {
+		s := &RedisSource{}
+		err := s.Configure("k1", tt.props)
+		if err == nil || err.Error() != tt.err {
+			t.Errorf("%d: error mismatch:\n  exp=%s\n  got=%v", i, tt.err, err)
+		}
+	}
+}
+
+func TestRedisSourcePubSub(t *testing.T) {
+	mr := runMiniredis(t)
+	ctx, cancel := context.WithValue(context.Background(), context.LoggerKey, conf.Log).WithCancel()
+	defer cancel()
+	s := &RedisSource{}
+	if err := s.Configure("devices.*", map[string]interface{}{"addr": mr.Addr(), "format": "json", "reconnectInterval": 50}); err != nil {
+		t.Fatal(err)
+	}
+	consumer := make(chan api.SourceTuple, 10)
+	go s.Open(ctx, consumer, make(chan error, 1))
+	defer s.Close(ctx)
+	// Publish until subscribed
+	for i := 0; i < 100 && mr.Publish("devices.d1", `[{"v":1},{"v":2}]`) == 0; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	for _, exp := range []float64{1, 2} {
+		tuple := receiveTuple(t, consumer)
+		if !reflect.DeepEqual(map[string]interface{}{"v": exp}, tuple.Message()) {
+			t.Errorf("unexpected message %v", tuple.Message())
+		}
+		expMeta := map[string]interface{}{"channel": "devices.d1", "pattern": "devices.*"}
+		if !reflect.DeepEqual(expMeta, tuple.Meta()) {
+			t.Errorf("unexpected meta %v", tuple.Meta())
+		}
+	}
+	if st := s.ConnectionStatus(); st.Status != api.ConnectionConnected {
+		t.Errorf("unexpected connection status %v", st)
+	}
+	// Resubscribe after the server restarts
+	mr.Close()
+	time.Sleep(50 * time.Millisecond)
+	if st := s.ConnectionStatus(); st.Status != api.ConnectionDisconnected {
+		t.Errorf("unexpected connection status %v", st)
+	}
+	if err := mr.Restart(); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 500 && mr.Publish("devices.d2", `{"v":3}`) == 0; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	tuple := receiveTuple(t, consumer)
+	if !reflect.DeepEqual(map[string]interface{}{"v": float64(3)}, tuple.Message()) {
+		t.Errorf("unexpected message %v", tuple.Message())
+	}
+}
+
+func xpending(t *testing.T, mr *miniredis.Miniredis, key, group string) int64 {
+	c, err := redis.Dial("tcp", mr.Addr())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	reply, err := redis.Values(c.Do("XPENDING", key, group))
+	if err != nil {
+		t.Fatal(err)
+	}
+	n, _ := redis.Int64(reply[0], nil)
+	return n
+}
+
+func TestRedisSourceStream(t *testing.T) {
+	mr := runMiniredis(t)
+	if _, err := mr.XAdd("events", "*", []string{"payload", `{"v":0}`}); err != nil {
+		t.Fatal(err)
+	}
+	props := map[string]interface{}{"addr": mr.Addr(), "format": "json", "type": "stream", "group": "g1", "startId": "0", "block": 100, "payloadField": "payload"}
+	ctx, cancel := context.WithValue(context.Background(), context.LoggerKey, conf.Log).WithCancel()
+	s := &RedisSource{}
+	if err := s.Configure("events", props); err != nil {
+		t.Fatal(err)
+	}
+	consumer := make(chan api.SourceTuple, 10)
+	go s.Open(ctx, consumer, make(chan error, 1))
+	ids := make([]string, 0, 3)
+	id, _ := mr.XAdd("events", "*", []string{"payload", `[{"v":1},{"v":2}]`})
+	ids = append(ids, id)
+	// The invalid entry is acknowledged with the next one
+	id, _ = mr.XAdd("events", "*", []string{"other", `{"v":3}`})
+	ids = append(ids, id)
+	id, _ = mr.XAdd("events", "*", []string{"payload", `{"v":4}`})
+	ids = append(ids, id)
+
+	var tuples []api.SourceTuple
+	for _, exp := range []float64{0, 1, 2, 4} {
+		tuple := receiveTuple(t, consumer)
+		if !reflect.DeepEqual(map[string]interface{}{"v": exp}, tuple.Message()) {
+			t.Errorf("unexpected message %v", tuple.Message())
+		}
+		tuples = append(tuples, tuple)
+	}
+	if tuples[1].Meta()["id"] != ids[0] || tuples[1].Meta()["stream"] != "events" {
+		t.Errorf("unexpected meta %v", tuples[1].Meta())
+	}
+	if n := xpending(t, mr, "events", "g1"); n != 4 {
+		t.Errorf("expect 4 pending entries but got %d", n)
+	}
+	// The first tuple of the entry does not ack it
+	if err := s.Ack(ctx, tuples[1]); err != nil {
+		t.Fatal(err)
+	}
+	if n := xpending(t, mr, "events", "g1"); n != 4 {
+		t.Errorf("expect 4 pending entries but got %d", n)
+	}
+	if err := s.Ack(ctx, tuples[2]); err != nil {
+		t.Fatal(err)
+	}
+	if n := xpending(t, mr, "events", "g1"); n != 2 {
+		t.Errorf("expect 2 pending entries but got %d", n)
+	}
+	cancel()
+	s.Close(ctx)
+
+	// The entries not acknowledged are redelivered to the same consumer after restarted
+	ctx, cancel = context.WithValue(context.Background(), context.LoggerKey, conf.Log).WithCancel()
+	defer cancel()
+	s = &RedisSource{}
+	if err := s.Configure("events", props); err != nil {
+		t.Fatal(err)
+	}
+	consumer = make(chan api.SourceTuple, 10)
+	go s.Open(ctx, consumer, make(chan error, 1))
+	defer s.Close(ctx)
+	tuple := receiveTuple(t, consumer)
+	if !reflect.DeepEqual(map[string]interface{}{"v": float64(4)}, tuple.Message()) {
+		t.Errorf("unexpected message %v", tuple.Message())
+	}
+	if err := s.Ack(ctx, tuple); err != nil {
+		t.Fatal(err)
+	}
+	if n := xpending(t, mr, "events", "g1"); n != 0 {
+		t.Errorf("expect no pending entries but got %d", n)
+	}
+}
+
+func TestRedisSourceStreamFields(t *testing.T) {
+	mr := runMiniredis(t)
+	ctx, cancel := context.WithValue(context.Background(), context.LoggerKey, conf.Log).WithCancel()
+	defer cancel()
+	s := &RedisSource{}
+	if err := s.Configure("events", map[string]interface{}{"addr": mr.Addr(), "type": "stream", "group": "g1", "consumer": "c1", "block": 100}); err != nil {
+		t.Fatal(err)
+	}
+	consumer := make(chan api.SourceTuple, 10)
+	go s.Open(ctx, consumer, make(chan error, 1))
+	defer s.Close(ctx)
+	// Wait until the group is created to read the new entries
+	for i := 0; i < 100 && s.ConnectionStatus().Status != api.ConnectionConnected; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if _, err := mr.XAdd("events", "*", []string{"name", "d1", "temperature", "20.5"}); err != nil {
+		t.Fatal(err)
+	}
+	tuple := receiveTuple(t, consumer)
+	if !reflect.DeepEqual(map[string]interface{}{"name": "d1", "temperature": "20.5"}, tuple.Message()) {
+		t.Errorf("unexpected message %v", tuple.Message())
+	}
+}
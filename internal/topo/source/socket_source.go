@@ -0,0 +1,200 @@
+// Copyright 2021 EMQ Technologies Co., Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package source
+
+import (
+	"errors"
+	"fmt"
+	"github.com/lf-edge/ekuiper/internal/pkg/socketx"
+	"github.com/lf-edge/ekuiper/pkg/api"
+	"github.com/lf-edge/ekuiper/pkg/cast"
+	"github.com/lf-edge/ekuiper/pkg/message"
+	"net"
+	"sync"
+	"time"
+)
+
+type SocketSourceConfig struct {
+	socketx.FramingConf
+	Mode              string `json:"mode"`
+	Protocol          string `json:"protocol"`
+	Timeout           int    `json:"timeout"`
+	ReconnectInterval int    `json:"reconnectInterval"`
+	Format            string `json:"format"`
+}
+
+// SocketSource reads the framed records over raw tcp or udp. In server mode, it listens to the address and receives
+// from all the connected clients. In client mode, it connects to the device by tcp and reconnects if the connection
+// is broken. The datasource is the address.
+type SocketSource struct {
+	conf   *SocketSourceConfig
+	addr   string
+	framer *socketx.Framer
+	server *socketx.Server
+
+	mu     sync.RWMutex
+	status api.ConnectionStatus
+}
+
+func (ss *SocketSource) Configure(datasource string, props map[string]interface{}) error {
+	c := &SocketSourceConfig{Timeout: socketx.DEFAULT_TIMEOUT, ReconnectInterval: 5000, Format: message.FormatJson}
+	err := cast.MapToStruct(props, c)
+	if err != nil {
+		return fmt.Errorf("read properties %v fail with error: %v", props, err)
+	}
+	c.Mode, err = socketx.GetMode(c.Mode, socketx.MODE_SERVER)
+	if err != nil {
+		return err
+	}
+	c.Protocol, err = socketx.GetProtocol(c.Protocol)
+	if err != nil {
+		return err
+	}
+	if datasource == "" {
+		return errors.New("address must be specified as the datasource")
+	}
+	if err := socketx.ValidateAddr(datasource, c.Mode); err != nil {
+		return err
+	}
+	if c.Mode == socketx.MODE_CLIENT {
+		if c.Protocol == socketx.PROTOCOL_UDP {
+			return errors.New("udp is supported in server mode only, the device must send to the listening address")
+		}
+		if c.Timeout <= 0 {
+			return fmt.Errorf("invalid property timeout: %d, must be a positive integer", c.Timeout)
+		}
+		if c.ReconnectInterval <= 0 {
+			return fmt.Errorf("invalid property reconnectInterval: %d, must be a positive integer", c.ReconnectInterval)
+		}
+	}
+	ss.framer, err = socketx.NewFramer(&c.FramingConf)
+	if err != nil {
+		return err
+	}
+	ss.addr = datasource
+	ss.conf = c
+	return nil
+}
+
+func (ss *SocketSource) Open(ctx api.StreamContext, consumer chan<- api.SourceTuple, errCh chan<- error) {
+	if ss.conf.Mode == socketx.MODE_SERVER {
+		s, err := socketx.AcquireServer(ss.conf.Protocol, ss.addr, &ss.conf.FramingConf)
+		if err != nil {
+			errCh <- err
+			return
+		}
+		s.Handle(ss, func(frame []byte, remote net.Addr) {
+			ss.send(ctx, frame, remote, consumer)
+		})
+		ss.server = s
+		ctx.GetLogger().Infof("Listening to socket at %s %s", ss.conf.Protocol, s.Addr())
+		return
+	}
+	ss.runClient(ctx, consumer)
+}
+
+// runClient connects to the device and reads the frames. It reconnects if the connection is broken.
+func (ss *SocketSource) runClient(ctx api.StreamContext, consumer chan<- api.SourceTuple) {
+	logger := ctx.GetLogger()
+	for {
+		ss.setStatus(api.ConnectionConnecting, nil)
+		c, err := net.DialTimeout(ss.conf.Protocol, ss.addr, time.Duration(ss.conf.Timeout)*time.Millisecond)
+		if err == nil {
+			logger.Infof("socket source connected to %s", ss.addr)
+			ss.setStatus(api.ConnectionConnected, nil)
+			done := make(chan struct{})
+			// Unblock the reading when the rule is stopped
+			go func() {
+				select {
+				case <-ctx.Done():
+					_ = c.Close()
+				case <-done:
+				}
+			}()
+			err = ss.framer.ReadFrames(c, func(frame []byte) {
+				ss.send(ctx, frame, c.RemoteAddr(), consumer)
+			})
+			close(done)
+			_ = c.Close()
+		}
+		select {
+		case <-ctx.Done():
+			return
+		default:
+		}
+		logger.Warnf("socket source fails to read from %s: %v, retry after %d ms", ss.addr, err, ss.conf.ReconnectInterval)
+		ss.setStatus(api.ConnectionDisconnected, err)
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(time.Duration(ss.conf.ReconnectInterval) * time.Millisecond):
+		}
+	}
+}
+
+// send decodes the frame by the format and sends the tuples. The empty frames are ignored.
+func (ss *SocketSource) send(ctx api.StreamContext, frame []byte, remote net.Addr, consumer chan<- api.SourceTuple) {
+	logger := ctx.GetLogger()
+	if len(frame) == 0 {
+		return
+	}
+	results, err := decodeMessages(frame, ss.conf.Format)
+	if err != nil {
+		logger.Warnf("socket source drops the invalid frame from %s: %v", remote, err)
+		return
+	}
+	meta := map[string]interface{}{"protocol": ss.conf.Protocol, "remoteAddr": remote.String()}
+	for _, result := range results {
+		select {
+		case consumer <- api.NewDefaultSourceTuple(result, meta):
+			logger.Debugf("send socket data to device node")
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func (ss *SocketSource) setStatus(st string, err error) {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+	ss.status.Status = st
+	ss.status.Server = ss.addr
+	if err != nil {
+		ss.status.LastError = err.Error()
+	}
+}
+
+// ConnectionStatus returns the connection status to the device in client mode
+func (ss *SocketSource) ConnectionStatus() api.ConnectionStatus {
+	ss.mu.RLock()
+	defer ss.mu.RUnlock()
+	if ss.conf.Mode == socketx.MODE_SERVER {
+		return api.ConnectionStatus{Status: api.ConnectionConnected, Server: ss.addr}
+	}
+	if ss.status.Status == "" {
+		return api.ConnectionStatus{Status: api.ConnectionConnecting, Server: ss.addr}
+	}
+	return ss.status
+}
+
+func (ss *SocketSource) Close(ctx api.StreamContext) error {
+	ctx.GetLogger().Infof("Closing socket source")
+	if ss.server != nil {
+		ss.server.Remove(ss)
+		socketx.ReleaseServer(ss.server)
+		ss.server = nil
+	}
+	return nil
+}
@@ -0,0 +1,145 @@
+// Copyright 2021 EMQ Technologies Co., Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package source
+
+import (
+	"github.com/lf-edge/ekuiper/internal/conf"
+	"github.com/lf-edge/ekuiper/internal/pkg/socketx"
+	"github.com/lf-edge/ekuiper/internal/testx"
+	"github.com/lf-edge/ekuiper/internal/topo/context"
+	"github.com/lf-edge/ekuiper/pkg/api"
+	"net"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestSocketConfigure(t *testing.T) {
+	var tests = []struct {
+		datasource string
+		props      map[string]interface{}
+		conf       *SocketSourceConfig
+		err        string
+	}{
+		{
+			datasource: ":9000",
+			props:      map[string]interface{}{},
+			conf:       &SocketSourceConfig{Mode: "server", Protocol: "tcp", Timeout: 10000, ReconnectInterval: 5000, Format: "json"},
+		}, {
+			datasource: "192.168.1.10:9000",
+			props:      map[string]interface{}{"mode": "Client", "framing": "length", "lengthSize": 2, "format": "binary"},
+			conf:       &SocketSourceConfig{FramingConf: socketx.FramingConf{Framing: "length", LengthSize: 2}, Mode: "client", Protocol: "tcp", Timeout: 10000, ReconnectInterval: 5000, Format: "binary"},
+		}, {
+			datasource: "",
+			props:      map[string]interface{}{},
+			err:        "address must be specified as the datasource",
+		}, {
+			datasource: ":9000",
+			props:      map[string]interface{}{"mode": "client"},
+			err:        "invalid address :9000: host is missing",
+		}, {
+			datasource: "device:9000",
+			props:      map[string]interface{}{"mode": "client", "protocol": "udp"},
+			err:        "udp is supported in server mode only, the device must send to the listening address",
+		}, {
+			datasource: ":9000",
+			props:      map[string]interface{}{"protocol": "sctp"},
+			err:        "invalid property protocol: sctp, must be tcp or udp",
+		}, {
+			datasource: ":9000",
+			props:      map[string]interface{}{"framing": "fixed"},
+			err:        "invalid property frameLength: 0, must be between 1 and maxFrameSize 65536",
+		},
+	}
+	for i, tt := range tests {
+		s := &SocketSource{}
+		err := s.Configure(tt.datasource, tt.props)
+		if !reflect.DeepEqual(tt.err, testx.Errstring(err)) {
+			t.Errorf("%d: error mismatch:\n  exp=%s\n  got=%s", i, tt.err, err)
+		} else if err == nil && !reflect.DeepEqual(tt.conf, s.conf) {
+			t.Errorf("%d: conf mismatch:\n  exp=%v\n  got=%v", i, tt.conf, s.conf)
+		}
+	}
+}
+
+func TestSocketSourceServer(t *testing.T) {
+	for _, protocol := range []string{"tcp", "udp"} {
+		ctx, cancel := context.WithValue(context.Background(), context.LoggerKey, conf.Log).WithCancel()
+		s := &SocketSource{}
+		if err := s.Configure("127.0.0.1:0", map[string]interface{}{"protocol": protocol}); err != nil {
+			t.Fatal(err)
+		}
+		errCh := make(chan error, 1)
+		consumer := make(chan api.SourceTuple, 10)
+		s.Open(ctx, consumer, errCh)
+		if len(errCh) > 0 {
+			t.Fatal(<-errCh)
+		}
+		c, err := net.Dial(protocol, s.server.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, _ = c.Write([]byte("{\"id\":1}\n[{\"id\":2},{\"id\":3}]\ninvalid\n"))
+		for i := 1; i <= 3; i++ {
+			select {
+			case tuple := <-consumer:
+				if tuple.Message()["id"] != float64(i) || tuple.Meta()["protocol"] != protocol || tuple.Meta()["remoteAddr"] != c.LocalAddr().String() {
+					t.Errorf("%s %d: tuple mismatch, got %v %v", protocol, i, tuple.Message(), tuple.Meta())
+				}
+			case <-time.After(2 * time.Second):
+				t.Fatalf("%s %d: timeout", protocol, i)
+			}
+		}
+		_ = c.Close()
+		cancel()
+		_ = s.Close(ctx)
+	}
+}
+
+func TestSocketSourceClient(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	ctx, cancel := context.WithValue(context.Background(), context.LoggerKey, conf.Log).WithCancel()
+	defer cancel()
+	s := &SocketSource{}
+	if err := s.Configure(ln.Addr().String(), map[string]interface{}{"mode": "client", "framing": "fixed", "frameLength": 3, "format": "binary", "reconnectInterval": 100}); err != nil {
+		t.Fatal(err)
+	}
+	consumer := make(chan api.SourceTuple, 10)
+	go s.Open(ctx, consumer, make(chan error, 1))
+	// The source reconnects after the device closes the connection
+	for _, data := range []string{"abc", "def"} {
+		c, err := ln.Accept()
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, _ = c.Write([]byte(data))
+		select {
+		case tuple := <-consumer:
+			if !reflect.DeepEqual(tuple.Message(), map[string]interface{}{"self": []byte(data)}) {
+				t.Errorf("tuple mismatch, got %v", tuple.Message())
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatalf("%s: timeout", data)
+		}
+		if st := s.ConnectionStatus(); st.Status != api.ConnectionConnected {
+			t.Errorf("status mismatch, got %v", st)
+		}
+		_ = c.Close()
+	}
+}
@@ -0,0 +1,222 @@
+// Copyright 2021 EMQ Technologies Co., Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package source
+
+import (
+	"database/sql"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	sqlx "github.com/lf-edge/ekuiper/internal/pkg/db/sql"
+	"github.com/lf-edge/ekuiper/pkg/api"
+	"github.com/lf-edge/ekuiper/pkg/cast"
+	"sync"
+	"time"
+)
+
+func init() {
+	// The tracking column value of timestamp type is saved in the state
+	gob.Register(time.Time{})
+}
+
+type SQLSourceConfig struct {
+	Driver         string      `json:"driver"`
+	Dsn            string      `json:"dsn"`
+	Query          string      `json:"query"`
+	Interval       int         `json:"interval"`
+	TrackingColumn string      `json:"trackingColumn"`
+	TrackingStart  interface{} `json:"trackingStart"`
+	Limit          int         `json:"limit"`
+}
+
+// SQLSource polls the query of a database by interval. If the tracking column is set, only the rows whose
+// tracking column is bigger than the last received row are queried. The last value of the tracking column
+// is the offset of the source which is saved in the state to resume after restart.
+type SQLSource struct {
+	sync.Mutex
+	conf     *SQLSourceConfig
+	query    string // The query to run if no offset
+	incQuery string // The query to run from the offset
+	last     interface{}
+	db       *sql.DB
+	closed   bool
+}
+
+func (s *SQLSource) Configure(table string, props map[string]interface{}) error {
+	cfg := &SQLSourceConfig{Driver: sqlx.DRIVER_SQLITE, Interval: DEFAULT_INTERVAL}
+	err := cast.MapToStruct(props, cfg)
+	if err != nil {
+		return fmt.Errorf("read properties %v fail with error: %v", props, err)
+	}
+	if cfg.Dsn == "" {
+		return errors.New("property dsn is required")
+	}
+	if cfg.Interval <= 0 {
+		return fmt.Errorf("invalid property interval: %d, must be a positive integer", cfg.Interval)
+	}
+	if cfg.Limit < 0 {
+		return fmt.Errorf("invalid property limit: %d, must not be negative", cfg.Limit)
+	}
+	query := cfg.Query
+	if query == "" {
+		if table == "" {
+			return errors.New("table name must be specified as the datasource if property query is not set")
+		}
+		query = fmt.Sprintf("SELECT * FROM %s", table)
+	}
+	var incQuery string
+	if cfg.TrackingColumn != "" {
+		incQuery = fmt.Sprintf("SELECT * FROM (%s) AS ekuiper_sql_source WHERE %s > ? ORDER BY %s ASC", query, cfg.TrackingColumn, cfg.TrackingColumn)
+		query = fmt.Sprintf("SELECT * FROM (%s) AS ekuiper_sql_source ORDER BY %s ASC", query, cfg.TrackingColumn)
+	}
+	if cfg.Limit > 0 {
+		query = fmt.Sprintf("%s LIMIT %d", query, cfg.Limit)
+		incQuery = fmt.Sprintf("%s LIMIT %d", incQuery, cfg.Limit)
+	}
+	s.conf = cfg
+	s.query = query
+	s.incQuery = incQuery
+	s.last = cfg.TrackingStart
+	return nil
+}
+
+func (s *SQLSource) Open(ctx api.StreamContext, consumer chan<- api.SourceTuple, errCh chan<- error) {
+	logger := ctx.GetLogger()
+	db, err := sqlx.Open(s.conf.Driver, s.conf.Dsn)
+	if err != nil {
+		errCh <- err
+		return
+	}
+	s.Lock()
+	if s.closed {
+		s.Unlock()
+		db.Close()
+		return
+	}
+	s.db = db
+	s.Unlock()
+	logger.Infof("Polling sql source with query %s", s.query)
+	ticker := time.NewTicker(time.Duration(s.conf.Interval) * time.Millisecond)
+	defer ticker.Stop()
+	for {
+		if err := s.poll(ctx, consumer); err != nil {
+			logger.Warnf("sql source poll error: %v", err)
+		}
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// poll queries the new rows and sends them out one by one. If there are more rows than the limit,
+// poll again immediately until all the new rows are read.
+func (s *SQLSource) poll(ctx api.StreamContext, consumer chan<- api.SourceTuple) error {
+	for {
+		var rows *sql.Rows
+		var err error
+		if offset := s.offset(); s.conf.TrackingColumn != "" && offset != nil {
+			rows, err = s.db.QueryContext(ctx, s.incQuery, offset)
+		} else {
+			rows, err = s.db.QueryContext(ctx, s.query)
+		}
+		if err != nil {
+			return err
+		}
+		count, err := s.consume(ctx, rows, consumer)
+		if err != nil {
+			return err
+		}
+		if s.conf.TrackingColumn == "" || s.conf.Limit == 0 || count < s.conf.Limit {
+			return nil
+		}
+	}
+}
+
+func (s *SQLSource) consume(ctx api.StreamContext, rows *sql.Rows, consumer chan<- api.SourceTuple) (int, error) {
+	defer rows.Close()
+	cols, err := rows.Columns()
+	if err != nil {
+		return 0, err
+	}
+	count := 0
+	for rows.Next() {
+		values := make([]interface{}, len(cols))
+		ptrs := make([]interface{}, len(cols))
+		for i := range values {
+			ptrs[i] = &values[i]
+		}
+		if err := rows.Scan(ptrs...); err != nil {
+			return count, err
+		}
+		data := make(map[string]interface{}, len(cols))
+		for i, col := range cols {
+			if b, ok := values[i].([]byte); ok {
+				data[col] = string(b)
+			} else {
+				data[col] = values[i]
+			}
+		}
+		var tracking interface{}
+		if s.conf.TrackingColumn != "" {
+			v, ok := data[s.conf.TrackingColumn]
+			if !ok {
+				return count, fmt.Errorf("tracking column %s is not found in the query result", s.conf.TrackingColumn)
+			}
+			tracking = v
+		}
+		select {
+		case consumer <- api.NewDefaultSourceTuple(data, nil):
+		case <-ctx.Done():
+			return count, nil
+		}
+		if tracking != nil {
+			s.Lock()
+			s.last = tracking
+			s.Unlock()
+		}
+		count++
+	}
+	return count, rows.Err()
+}
+
+func (s *SQLSource) offset() interface{} {
+	s.Lock()
+	defer s.Unlock()
+	return s.last
+}
+
+func (s *SQLSource) GetOffset() (interface{}, error) {
+	return s.offset(), nil
+}
+
+func (s *SQLSource) Rewind(offset interface{}) error {
+	s.Lock()
+	defer s.Unlock()
+	s.last = offset
+	return nil
+}
+
+func (s *SQLSource) Close(ctx api.StreamContext) error {
+	ctx.GetLogger().Infof("Closing sql source")
+	s.Lock()
+	defer s.Unlock()
+	s.closed = true
+	if s.db != nil {
+		return s.db.Close()
+	}
+	return nil
+}
@@ -0,0 +1,99 @@
+// Copyright 2021 EMQ Technologies Co., Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package source
+
+import (
+	"database/sql"
+	"github.com/lf-edge/ekuiper/internal/conf"
+	"github.com/lf-edge/ekuiper/internal/topo/context"
+	"github.com/lf-edge/ekuiper/pkg/api"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestSQLSource(t *testing.T) {
+	dsn := filepath.Join(t.TempDir(), "test.db")
+	db, err := sql.Open("sqlite3", dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	if _, err := db.Exec("CREATE TABLE readings (id INTEGER PRIMARY KEY, name TEXT, value REAL)"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec("INSERT INTO readings (id, name, value) VALUES (1, 'a', 1.5), (2, 'b', 2.5), (3, 'c', 3.5)"); err != nil {
+		t.Fatal(err)
+	}
+	props := map[string]interface{}{"dsn": dsn, "interval": 100, "trackingColumn": "id", "limit": 2}
+
+	receive := func(consumer chan api.SourceTuple, exp []map[string]interface{}) {
+		for _, e := range exp {
+			select {
+			case r := <-consumer:
+				if !reflect.DeepEqual(e, r.Message()) {
+					t.Errorf("message mismatch:\n  exp=%v\n  got=%v", e, r.Message())
+				}
+			case <-time.After(2 * time.Second):
+				t.Fatalf("timeout to receive %v", e)
+			}
+		}
+	}
+
+	ctx, cancel := context.WithValue(context.Background(), context.LoggerKey, conf.Log).WithCancel()
+	s := &SQLSource{}
+	if err := s.Configure("readings", props); err != nil {
+		t.Fatal(err)
+	}
+	consumer := make(chan api.SourceTuple, 10)
+	go s.Open(ctx, consumer, make(chan error, 1))
+	receive(consumer, []map[string]interface{}{
+		{"id": int64(1), "name": "a", "value": 1.5},
+		{"id": int64(2), "name": "b", "value": 2.5},
+		{"id": int64(3), "name": "c", "value": 3.5},
+	})
+	if _, err := db.Exec("INSERT INTO readings (id, name, value) VALUES (4, 'd', 4.5)"); err != nil {
+		t.Fatal(err)
+	}
+	receive(consumer, []map[string]interface{}{{"id": int64(4), "name": "d", "value": 4.5}})
+	offset, _ := s.GetOffset()
+	if offset != int64(4) {
+		t.Errorf("offset mismatch:\n  exp=4\n  got=%v", offset)
+	}
+	cancel()
+	_ = s.Close(ctx)
+
+	// Resume from the offset
+	ctx, cancel = context.WithValue(context.Background(), context.LoggerKey, conf.Log).WithCancel()
+	defer cancel()
+	s = &SQLSource{}
+	if err := s.Configure("readings", props); err != nil {
+		t.Fatal(err)
+	}
+	_ = s.Rewind(int64(2))
+	consumer = make(chan api.SourceTuple, 10)
+	go s.Open(ctx, consumer, make(chan error, 1))
+	defer s.Close(ctx)
+	receive(consumer, []map[string]interface{}{
+		{"id": int64(3), "name": "c", "value": 3.5},
+		{"id": int64(4), "name": "d", "value": 4.5},
+	})
+	select {
+	case r := <-consumer:
+		t.Errorf("unexpected message %v", r.Message())
+	case <-time.After(300 * time.Millisecond):
+	}
+}
@@ -0,0 +1,218 @@
+// Copyright 2021 EMQ Technologies Co., Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package source
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	SYSLOG_RFC_AUTO = "auto"
+	SYSLOG_RFC3164  = "3164"
+	SYSLOG_RFC5424  = "5424"
+)
+
+// The priority of the message without PRI part, which is user.notice by RFC3164
+const syslogDefaultPri = 13
+
+// parseSyslog parses a syslog message into the fields. The RFC is detected by the version after PRI in auto mode.
+// The RFC3164 message is parsed leniently: the missing parts are omitted and the remaining text is the msg.
+func parseSyslog(data string, rfc string, now time.Time) (map[string]interface{}, error) {
+	data = strings.TrimRight(data, "\r\n\x00")
+	pri, rest, ok := parsePri(data)
+	if !ok {
+		if rfc == SYSLOG_RFC5424 {
+			return nil, errors.New("invalid syslog message: missing PRI")
+		}
+		pri, rest = syslogDefaultPri, data
+	}
+	if rfc == SYSLOG_RFC5424 || rfc == SYSLOG_RFC_AUTO && strings.HasPrefix(rest, "1 ") {
+		return parse5424(pri, rest)
+	}
+	return parse3164(pri, rest, now), nil
+}
+
+// parsePri parses the <PRI> part
+func parsePri(data string) (int, string, bool) {
+	if len(data) < 3 || data[0] != '<' {
+		return 0, "", false
+	}
+	end := strings.IndexByte(data, '>')
+	if end < 2 || end > 4 {
+		return 0, "", false
+	}
+	pri, err := strconv.Atoi(data[1:end])
+	if err != nil || pri < 0 || pri > 191 {
+		return 0, "", false
+	}
+	return pri, data[end+1:], true
+}
+
+func newSyslogRecord(pri int) map[string]interface{} {
+	return map[string]interface{}{
+		"facility": pri / 8,
+		"severity": pri % 8,
+	}
+}
+
+// parse3164 parses the BSD syslog: TIMESTAMP HOSTNAME TAG[PID]: MSG. The timestamp could also be RFC3339 as sent
+// by rsyslog. The year of the RFC3164 timestamp is guessed by the current time.
+func parse3164(pri int, data string, now time.Time) map[string]interface{} {
+	result := newSyslogRecord(pri)
+	rest := data
+	if len(rest) >= 15 {
+		if t, err := time.ParseInLocation(time.Stamp, rest[:15], now.Location()); err == nil {
+			t = t.AddDate(now.Year(), 0, 0)
+			// It is a message of the last year if it is ahead more than the clock skew
+			if t.After(now.AddDate(0, 0, 1)) {
+				t = t.AddDate(-1, 0, 0)
+			}
+			result["timestamp"] = t.UnixNano() / int64(time.Millisecond)
+			rest = strings.TrimPrefix(rest[15:], " ")
+		}
+	}
+	if _, ok := result["timestamp"]; !ok {
+		if i := strings.IndexByte(rest, ' '); i > 0 {
+			if t, err := time.Parse(time.RFC3339Nano, rest[:i]); err == nil {
+				result["timestamp"] = t.UnixNano() / int64(time.Millisecond)
+				rest = rest[i+1:]
+			}
+		}
+	}
+	// The hostname is present only after the timestamp. It is followed by the tag which ends with a colon.
+	if _, ok := result["timestamp"]; ok {
+		if i := strings.IndexByte(rest, ' '); i > 0 && !strings.HasSuffix(rest[:i], ":") {
+			result["hostname"] = rest[:i]
+			rest = rest[i+1:]
+		}
+	}
+	if i := strings.Index(rest, ": "); i > 0 && !strings.ContainsAny(rest[:i], " ") {
+		tag := rest[:i]
+		if j := strings.IndexByte(tag, '['); j > 0 && strings.HasSuffix(tag, "]") {
+			result["procId"] = tag[j+1 : len(tag)-1]
+			tag = tag[:j]
+		}
+		result["app"] = tag
+		rest = rest[i+2:]
+	}
+	result["msg"] = rest
+	return result
+}
+
+// parse5424 parses VERSION SP TIMESTAMP SP HOSTNAME SP APP-NAME SP PROCID SP MSGID SP STRUCTURED-DATA [SP MSG].
+// The nil values "-" are omitted.
+func parse5424(pri int, data string) (map[string]interface{}, error) {
+	result := newSyslogRecord(pri)
+	rest := data
+	var headers [6]string
+	for i := range headers {
+		j := strings.IndexByte(rest, ' ')
+		if j < 0 {
+			return nil, errors.New("invalid rfc5424 syslog message: incomplete header")
+		}
+		headers[i], rest = rest[:j], rest[j+1:]
+	}
+	v, err := strconv.Atoi(headers[0])
+	if err != nil {
+		return nil, fmt.Errorf("invalid rfc5424 syslog version %s", headers[0])
+	}
+	result["version"] = v
+	if headers[1] != "-" {
+		t, err := time.Parse(time.RFC3339Nano, headers[1])
+		if err != nil {
+			return nil, fmt.Errorf("invalid rfc5424 syslog timestamp %s", headers[1])
+		}
+		result["timestamp"] = t.UnixNano() / int64(time.Millisecond)
+	}
+	for i, k := range []string{"hostname", "app", "procId", "msgId"} {
+		if headers[i+2] != "-" {
+			result[k] = headers[i+2]
+		}
+	}
+	sd, rest, err := parseStructuredData(rest)
+	if err != nil {
+		return nil, err
+	}
+	if sd != nil {
+		result["structuredData"] = sd
+	}
+	if strings.HasPrefix(rest, " ") {
+		rest = strings.TrimPrefix(rest[1:], "\ufeff")
+		result["msg"] = rest
+	} else if rest != "" {
+		return nil, errors.New("invalid rfc5424 syslog message: missing space before msg")
+	}
+	return result, nil
+}
+
+// parseStructuredData parses the elements like [id key="value" key2="value2"][id2] into a map of the element
+// id to the params
+func parseStructuredData(data string) (map[string]interface{}, string, error) {
+	if strings.HasPrefix(data, "-") {
+		return nil, data[1:], nil
+	}
+	errInvalid := errors.New("invalid rfc5424 syslog structured data")
+	result := make(map[string]interface{})
+	rest := data
+	for strings.HasPrefix(rest, "[") {
+		end := strings.IndexAny(rest, " ]")
+		if end < 0 {
+			return nil, "", errInvalid
+		}
+		id := rest[1:end]
+		params := make(map[string]interface{})
+		rest = rest[end:]
+		for strings.HasPrefix(rest, " ") {
+			eq := strings.Index(rest, `="`)
+			if eq < 0 {
+				return nil, "", errInvalid
+			}
+			name := rest[1:eq]
+			rest = rest[eq+2:]
+			var b strings.Builder
+			closed := false
+			for i := 0; i < len(rest); i++ {
+				c := rest[i]
+				if c == '\\' && i+1 < len(rest) && (rest[i+1] == '"' || rest[i+1] == '\\' || rest[i+1] == ']') {
+					b.WriteByte(rest[i+1])
+					i++
+				} else if c == '"' {
+					rest = rest[i+1:]
+					closed = true
+					break
+				} else {
+					b.WriteByte(c)
+				}
+			}
+			if !closed {
+				return nil, "", errInvalid
+			}
+			params[name] = b.String()
+		}
+		if !strings.HasPrefix(rest, "]") {
+			return nil, "", errInvalid
+		}
+		rest = rest[1:]
+		result[id] = params
+	}
+	if len(result) == 0 {
+		return nil, "", errInvalid
+	}
+	return result, rest, nil
+}
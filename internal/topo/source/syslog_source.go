@@ -0,0 +1,275 @@
+// Copyright 2021 EMQ Technologies Co., Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package source
+
+import (
+	"bufio"
+	"fmt"
+	"github.com/lf-edge/ekuiper/internal/conf"
+	"github.com/lf-edge/ekuiper/internal/pkg/linex"
+	"github.com/lf-edge/ekuiper/pkg/api"
+	"github.com/lf-edge/ekuiper/pkg/cast"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const syslogMaxMessageSize = 64 * 1024
+
+type SyslogSourceConfig struct {
+	linex.Conf
+	Protocol   string `json:"protocol"`
+	ListenAddr string `json:"listenAddr"`
+	Rfc        string `json:"rfc"`
+}
+
+// SyslogSource receives the syslog messages over UDP or TCP and parses them into the fields. The msg could be
+// parsed further by the line pattern. The datasource is the app name to receive, or * for all the apps.
+type SyslogSource struct {
+	conf   *SyslogSourceConfig
+	app    string
+	parser *linex.Parser
+	server *syslogServer
+	ctx    api.StreamContext
+	out    chan<- api.SourceTuple
+}
+
+func (s *SyslogSource) Configure(datasource string, props map[string]interface{}) error {
+	c := &SyslogSourceConfig{Protocol: "udp", ListenAddr: ":1514", Rfc: SYSLOG_RFC_AUTO}
+	err := cast.MapToStruct(props, c)
+	if err != nil {
+		return fmt.Errorf("read properties %v fail with error: %v", props, err)
+	}
+	c.Protocol = strings.ToLower(c.Protocol)
+	if c.Protocol != "udp" && c.Protocol != "tcp" {
+		return fmt.Errorf("invalid property protocol: %s, must be udp or tcp", c.Protocol)
+	}
+	switch c.Rfc {
+	case SYSLOG_RFC_AUTO, SYSLOG_RFC3164, SYSLOG_RFC5424:
+	default:
+		return fmt.Errorf("invalid property rfc: %s, must be auto, 3164 or 5424", c.Rfc)
+	}
+	if c.Conf.Enabled() {
+		s.parser, err = linex.NewParser(&c.Conf)
+		if err != nil {
+			return err
+		}
+	}
+	if datasource != "*" {
+		s.app = datasource
+	}
+	s.conf = c
+	return nil
+}
+
+func (s *SyslogSource) Open(ctx api.StreamContext, consumer chan<- api.SourceTuple, errCh chan<- error) {
+	s.ctx = ctx
+	s.out = consumer
+	srv, err := acquireSyslogServer(s.conf.Protocol, s.conf.ListenAddr, s)
+	if err != nil {
+		errCh <- err
+		return
+	}
+	s.server = srv
+	ctx.GetLogger().Infof("Listening to syslog at %s %s", s.conf.Protocol, srv.addr())
+}
+
+// ingest parses the message and sends it if the app matches
+func (s *SyslogSource) ingest(data string, remote net.Addr) {
+	logger := s.ctx.GetLogger()
+	m, err := parseSyslog(data, s.conf.Rfc, time.Now())
+	if err != nil {
+		logger.Warnf("syslog source drops the invalid message from %s: %v", remote, err)
+		return
+	}
+	if s.app != "" && m["app"] != s.app {
+		return
+	}
+	if s.parser != nil {
+		if msg, ok := m["msg"].(string); ok {
+			fields, _, err := s.parser.Parse(msg)
+			if err != nil {
+				logger.Warnf("syslog source fails to parse the msg from %s: %v", remote, err)
+			}
+			for k, v := range fields {
+				m[k] = v
+			}
+		}
+	}
+	meta := map[string]interface{}{"protocol": s.conf.Protocol, "remoteAddr": remote.String()}
+	select {
+	case s.out <- api.NewDefaultSourceTuple(m, meta):
+		logger.Debugf("send syslog data to device node")
+	case <-s.ctx.Done():
+	}
+}
+
+func (s *SyslogSource) Close(ctx api.StreamContext) error {
+	ctx.GetLogger().Infof("Closing syslog source")
+	if s.server != nil {
+		releaseSyslogServer(s.server, s)
+		s.server = nil
+	}
+	return nil
+}
+
+// The listeners are shared by the syslog sources of the same protocol and address. Each message is sent to all
+// the sources.
+var (
+	syslogServersMu sync.Mutex
+	syslogServers   = make(map[string]*syslogServer)
+)
+
+type syslogServer struct {
+	key string
+	pc  net.PacketConn
+	ln  net.Listener
+
+	mu   sync.RWMutex
+	subs map[*SyslogSource]struct{}
+}
+
+func acquireSyslogServer(protocol, addr string, s *SyslogSource) (*syslogServer, error) {
+	syslogServersMu.Lock()
+	defer syslogServersMu.Unlock()
+	key := protocol + "://" + addr
+	srv, ok := syslogServers[key]
+	if !ok {
+		srv = &syslogServer{key: key, subs: make(map[*SyslogSource]struct{})}
+		if protocol == "udp" {
+			pc, err := net.ListenPacket("udp", addr)
+			if err != nil {
+				return nil, fmt.Errorf("syslog source fails to listen to %s: %v", key, err)
+			}
+			srv.pc = pc
+			go srv.serveUDP()
+		} else {
+			ln, err := net.Listen("tcp", addr)
+			if err != nil {
+				return nil, fmt.Errorf("syslog source fails to listen to %s: %v", key, err)
+			}
+			srv.ln = ln
+			go srv.serveTCP()
+		}
+		syslogServers[key] = srv
+	}
+	srv.mu.Lock()
+	srv.subs[s] = struct{}{}
+	srv.mu.Unlock()
+	return srv, nil
+}
+
+// releaseSyslogServer removes the source. The listener is closed after the last source is removed.
+func releaseSyslogServer(srv *syslogServer, s *SyslogSource) {
+	syslogServersMu.Lock()
+	defer syslogServersMu.Unlock()
+	srv.mu.Lock()
+	delete(srv.subs, s)
+	n := len(srv.subs)
+	srv.mu.Unlock()
+	if n > 0 {
+		return
+	}
+	delete(syslogServers, srv.key)
+	if srv.pc != nil {
+		_ = srv.pc.Close()
+	}
+	if srv.ln != nil {
+		_ = srv.ln.Close()
+	}
+}
+
+// addr returns the listening address which has the actual port if listening to port 0
+func (srv *syslogServer) addr() net.Addr {
+	if srv.pc != nil {
+		return srv.pc.LocalAddr()
+	}
+	return srv.ln.Addr()
+}
+
+func (srv *syslogServer) dispatch(data string, remote net.Addr) {
+	srv.mu.RLock()
+	subs := make([]*SyslogSource, 0, len(srv.subs))
+	for s := range srv.subs {
+		subs = append(subs, s)
+	}
+	srv.mu.RUnlock()
+	for _, s := range subs {
+		s.ingest(data, remote)
+	}
+}
+
+// serveUDP receives a message in each datagram
+func (srv *syslogServer) serveUDP() {
+	buf := make([]byte, syslogMaxMessageSize)
+	for {
+		n, remote, err := srv.pc.ReadFrom(buf)
+		if err != nil {
+			return
+		}
+		srv.dispatch(string(buf[:n]), remote)
+	}
+}
+
+func (srv *syslogServer) serveTCP() {
+	for {
+		c, err := srv.ln.Accept()
+		if err != nil {
+			return
+		}
+		go srv.serveConn(c)
+	}
+}
+
+// serveConn reads the messages framed by octet counting or by the line break (RFC6587). The framing is detected for
+// each message by whether it starts with a digit.
+func (srv *syslogServer) serveConn(c net.Conn) {
+	defer c.Close()
+	r := bufio.NewReaderSize(c, syslogMaxMessageSize)
+	for {
+		b, err := r.Peek(1)
+		if err != nil {
+			return
+		}
+		var msg string
+		if b[0] >= '0' && b[0] <= '9' {
+			l, err := r.ReadString(' ')
+			if err != nil {
+				return
+			}
+			n, err := strconv.Atoi(strings.TrimSuffix(l, " "))
+			if err != nil || n <= 0 || n > syslogMaxMessageSize {
+				conf.Log.Warnf("syslog source gets invalid message length %q from %s, close the connection", l, c.RemoteAddr())
+				return
+			}
+			data := make([]byte, n)
+			if _, err := io.ReadFull(r, data); err != nil {
+				return
+			}
+			msg = string(data)
+		} else {
+			msg, err = r.ReadString('\n')
+			if err != nil && (err != io.EOF || msg == "") {
+				return
+			}
+		}
+		if strings.TrimSpace(msg) != "" {
+			srv.dispatch(msg, c.RemoteAddr())
+		}
+	}
+}
@@ -0,0 +1,207 @@
+// Copyright 2021 EMQ Technologies Co., Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package source
+
+import (
+	"fmt"
+	"github.com/lf-edge/ekuiper/internal/conf"
+	"github.com/lf-edge/ekuiper/internal/testx"
+	"github.com/lf-edge/ekuiper/internal/topo/context"
+	"github.com/lf-edge/ekuiper/pkg/api"
+	"net"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestParseSyslog(t *testing.T) {
+	now := time.Date(2021, 12, 1, 10, 0, 0, 0, time.UTC)
+	var tests = []struct {
+		data   string
+		rfc    string
+		result map[string]interface{}
+		err    string
+	}{
+		{
+			data: "<34>Oct 11 22:14:15 mymachine su[123]: 'su root' failed for lonvick on /dev/pts/8\n",
+			rfc:  SYSLOG_RFC_AUTO,
+			result: map[string]interface{}{
+				"facility": 4, "severity": 2, "timestamp": time.Date(2021, 10, 11, 22, 14, 15, 0, time.UTC).UnixNano() / 1e6,
+				"hostname": "mymachine", "app": "su", "procId": "123", "msg": "'su root' failed for lonvick on /dev/pts/8",
+			},
+		}, {
+			// The message sent in the last year
+			data: "<13>Dec 31 23:59:59 gw kernel: link down",
+			rfc:  SYSLOG_RFC3164,
+			result: map[string]interface{}{
+				"facility": 1, "severity": 5, "timestamp": time.Date(2020, 12, 31, 23, 59, 59, 0, time.UTC).UnixNano() / 1e6,
+				"hostname": "gw", "app": "kernel", "msg": "link down",
+			},
+		}, {
+			data: "<13>2021-11-30T08:00:00.5Z gw app: started",
+			rfc:  SYSLOG_RFC_AUTO,
+			result: map[string]interface{}{
+				"facility": 1, "severity": 5, "timestamp": time.Date(2021, 11, 30, 8, 0, 0, 5e8, time.UTC).UnixNano() / 1e6,
+				"hostname": "gw", "app": "app", "msg": "started",
+			},
+		}, {
+			data:   "just a message",
+			rfc:    SYSLOG_RFC_AUTO,
+			result: map[string]interface{}{"facility": 1, "severity": 5, "msg": "just a message"},
+		}, {
+			data: `<165>1 2003-10-11T22:14:15.003Z mymachine.example.com evntslog - ID47 [exampleSDID@32473 iut="3" eventSource="Application" eventID="1011"][examplePriority@32473 class="high\"x\]"] ` + "\ufeffAn application event log entry",
+			rfc:  SYSLOG_RFC_AUTO,
+			result: map[string]interface{}{
+				"facility": 20, "severity": 5, "version": 1, "timestamp": time.Date(2003, 10, 11, 22, 14, 15, 3e6, time.UTC).UnixNano() / 1e6,
+				"hostname": "mymachine.example.com", "app": "evntslog", "msgId": "ID47",
+				"structuredData": map[string]interface{}{
+					"exampleSDID@32473":     map[string]interface{}{"iut": "3", "eventSource": "Application", "eventID": "1011"},
+					"examplePriority@32473": map[string]interface{}{"class": `high"x]`},
+				},
+				"msg": "An application event log entry",
+			},
+		}, {
+			data:   "<14>1 - - - - - -",
+			rfc:    SYSLOG_RFC5424,
+			result: map[string]interface{}{"facility": 1, "severity": 6, "version": 1},
+		}, {
+			data: "<14>1 - - - - - [id a=\"1\"",
+			rfc:  SYSLOG_RFC5424,
+			err:  "invalid rfc5424 syslog structured data",
+		}, {
+			data: "<14>1 yesterday - - - - -",
+			rfc:  SYSLOG_RFC5424,
+			err:  "invalid rfc5424 syslog timestamp yesterday",
+		}, {
+			data: "no pri",
+			rfc:  SYSLOG_RFC5424,
+			err:  "invalid syslog message: missing PRI",
+		},
+	}
+	for i, tt := range tests {
+		result, err := parseSyslog(tt.data, tt.rfc, now)
+		if tt.err != testx.Errstring(err) || !reflect.DeepEqual(tt.result, result) {
+			t.Errorf("%d: result mismatch:\n  exp=%v %s\n  got=%v %v", i, tt.result, tt.err, result, err)
+		}
+	}
+}
+
+func TestSyslogConfigure(t *testing.T) {
+	var tests = []struct {
+		props map[string]interface{}
+		conf  *SyslogSourceConfig
+		err   string
+	}{
+		{
+			props: map[string]interface{}{},
+			conf:  &SyslogSourceConfig{Protocol: "udp", ListenAddr: ":1514", Rfc: "auto"},
+		}, {
+			props: map[string]interface{}{"protocol": "TCP", "listenAddr": ":514", "rfc": "5424"},
+			conf:  &SyslogSourceConfig{Protocol: "tcp", ListenAddr: ":514", Rfc: "5424"},
+		}, {
+			props: map[string]interface{}{"protocol": "tls"},
+			err:   "invalid property protocol: tls, must be udp or tcp",
+		}, {
+			props: map[string]interface{}{"rfc": "5425"},
+			err:   "invalid property rfc: 5425, must be auto, 3164 or 5424",
+		}, {
+			props: map[string]interface{}{"grok": "%{FOO:a}"},
+			err:   "invalid property grok: unknown pattern FOO",
+		},
+	}
+	for i, tt := range tests {
+		s := &SyslogSource{}
+		err := s.Configure("*", tt.props)
+		if !reflect.DeepEqual(tt.err, testx.Errstring(err)) {
+			t.Errorf("%d: error mismatch:\n  exp=%s\n  got=%s", i, tt.err, err)
+		} else if err == nil && !reflect.DeepEqual(tt.conf, s.conf) {
+			t.Errorf("%d: conf mismatch:\n  exp=%v\n  got=%v", i, tt.conf, s.conf)
+		}
+	}
+}
+
+func TestSyslogSource(t *testing.T) {
+	for _, protocol := range []string{"udp", "tcp"} {
+		ctx, cancel := context.WithValue(context.Background(), context.LoggerKey, conf.Log).WithCancel()
+		// Two sources share the listener, one for all the apps and one for the app sensor with the msg parsed
+		all := &SyslogSource{}
+		if err := all.Configure("*", map[string]interface{}{"protocol": protocol, "listenAddr": "127.0.0.1:0"}); err != nil {
+			t.Fatal(err)
+		}
+		errCh := make(chan error, 2)
+		allCh := make(chan api.SourceTuple, 10)
+		all.Open(ctx, allCh, errCh)
+		addr := all.server.addr().String()
+		sensor := &SyslogSource{}
+		if err := sensor.Configure("sensor", map[string]interface{}{"protocol": protocol, "listenAddr": "127.0.0.1:0", "grok": "t=%{NUMBER:temperature:float}"}); err != nil {
+			t.Fatal(err)
+		}
+		// Share the listener by the same key
+		sensor.conf.ListenAddr = all.conf.ListenAddr
+		sensorCh := make(chan api.SourceTuple, 10)
+		sensor.Open(ctx, sensorCh, errCh)
+		if len(errCh) > 0 {
+			t.Fatal(<-errCh)
+		}
+		if sensor.server != all.server {
+			t.Fatalf("%s: the listener is not shared", protocol)
+		}
+		c, err := net.Dial(protocol, addr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		msgs := []string{"<14>Dec  1 10:00:00 dev1 sensor: t=21.5", "<14>1 2021-12-01T10:00:01Z dev1 gateway - - - rebooted"}
+		if protocol == "udp" {
+			for _, m := range msgs {
+				_, _ = c.Write([]byte(m))
+			}
+		} else {
+			// Mix the octet counting and the non-transparent framing
+			_, _ = fmt.Fprintf(c, "%d %s%s\n", len(msgs[0]), msgs[0], msgs[1])
+		}
+		for i, exp := range []string{"sensor", "gateway"} {
+			select {
+			case tuple := <-allCh:
+				if tuple.Message()["app"] != exp || tuple.Meta()["protocol"] != protocol || tuple.Meta()["remoteAddr"] == "" {
+					t.Errorf("%s %d: message mismatch, got %v %v", protocol, i, tuple.Message(), tuple.Meta())
+				}
+			case <-time.After(2 * time.Second):
+				t.Fatalf("%s %d: timeout", protocol, i)
+			}
+		}
+		select {
+		case tuple := <-sensorCh:
+			if tuple.Message()["temperature"] != 21.5 || tuple.Message()["msg"] != "t=21.5" {
+				t.Errorf("%s: sensor message mismatch, got %v", protocol, tuple.Message())
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatalf("%s: timeout for the sensor message", protocol)
+		}
+		time.Sleep(50 * time.Millisecond)
+		if len(sensorCh) != 0 {
+			t.Errorf("%s: the messages of the other apps must be filtered", protocol)
+		}
+		_ = c.Close()
+		cancel()
+		_ = sensor.Close(ctx)
+		_ = all.Close(ctx)
+		syslogServersMu.Lock()
+		n := len(syslogServers)
+		syslogServersMu.Unlock()
+		if n != 0 {
+			t.Errorf("%s: the listener is not released", protocol)
+		}
+	}
+}
@@ -0,0 +1,120 @@
+// Copyright 2021 EMQ Technologies Co., Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package source
+
+import (
+	"errors"
+	"fmt"
+	"github.com/lf-edge/ekuiper/internal/pkg/wsx"
+	"github.com/lf-edge/ekuiper/pkg/api"
+	"github.com/lf-edge/ekuiper/pkg/cast"
+	"github.com/lf-edge/ekuiper/pkg/message"
+	"strings"
+)
+
+type WebsocketConfig struct {
+	wsx.ClientConf
+	Mode   string `json:"mode"`
+	Format string `json:"format"`
+}
+
+// WebsocketSource receives the messages from a websocket server in client mode,
+// or from the clients connected to a path of the embedded http server in server mode
+type WebsocketSource struct {
+	path   string
+	conf   *WebsocketConfig
+	client *wsx.Client
+	ep     *wsx.Endpoint
+	subId  int
+}
+
+// Configure the websocket source. The mode is client if the url is set, otherwise server.
+// In client mode, the datasource is appended to the url. In server mode, the datasource is the path.
+func (ws *WebsocketSource) Configure(datasource string, props map[string]interface{}) error {
+	cfg := &WebsocketConfig{}
+	err := cast.MapToStruct(props, cfg)
+	if err != nil {
+		return fmt.Errorf("read properties %v fail with error: %v", props, err)
+	}
+	cfg.Mode, err = wsx.GetMode(cfg.Mode, cfg.Url)
+	if err != nil {
+		return err
+	}
+	switch cfg.Mode {
+	case wsx.MODE_CLIENT:
+		cfg.Url = cfg.Url + datasource
+		if !strings.HasPrefix(cfg.Url, "ws://") && !strings.HasPrefix(cfg.Url, "wss://") {
+			return fmt.Errorf("invalid property url: %s, must start with ws:// or wss://", cfg.Url)
+		}
+		ws.client = wsx.NewClient(&cfg.ClientConf)
+	case wsx.MODE_SERVER:
+		if datasource == "" {
+			return errors.New("path must be specified as the datasource in server mode")
+		}
+		if !strings.HasPrefix(datasource, "/") {
+			datasource = "/" + datasource
+		}
+		ws.path = datasource
+	}
+	if cfg.Format == "" {
+		cfg.Format = message.FormatJson
+	}
+	ws.conf = cfg
+	return nil
+}
+
+func (ws *WebsocketSource) Open(ctx api.StreamContext, consumer chan<- api.SourceTuple, errCh chan<- error) {
+	logger := ctx.GetLogger()
+	handler := func(data []byte, meta map[string]interface{}) {
+		results, err := decodeMessages(data, ws.conf.Format)
+		if err != nil {
+			logger.Warnf("websocket source drops the invalid message: %v", err)
+			return
+		}
+		for _, result := range results {
+			select {
+			case consumer <- api.NewDefaultSourceTuple(result, meta):
+				logger.Debugf("send websocket data to device node")
+			case <-ctx.Done():
+				return
+			}
+		}
+	}
+	if ws.conf.Mode == wsx.MODE_SERVER {
+		ep, err := wsx.AcquireEndpoint(ws.path)
+		if err != nil {
+			errCh <- err
+			return
+		}
+		ws.ep = ep
+		ws.subId = ep.Subscribe(handler)
+		logger.Infof("Listening to websocket endpoint %s", ws.path)
+		return
+	}
+	ws.client.Run(ctx, handler)
+}
+
+func (ws *WebsocketSource) Close(ctx api.StreamContext) error {
+	ctx.GetLogger().Infof("Closing websocket source")
+	if ws.ep != nil {
+		ws.ep.Unsubscribe(ws.subId)
+		wsx.ReleaseEndpoint(ws.ep)
+		ws.ep = nil
+	}
+	if ws.client != nil {
+		return ws.client.Close()
+	}
+	return nil
+}
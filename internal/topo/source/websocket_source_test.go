@@ -0,0 +1,62 @@
+// Copyright 2021 EMQ Technologies Co., Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package source
+
+import (
+	"github.com/lf-edge/ekuiper/internal/pkg/wsx"
+	"github.com/lf-edge/ekuiper/internal/testx"
+	"reflect"
+	"testing"
+)
+
+func TestWebsocketConfigure(t *testing.T) {
+	var tests = []struct {
+		datasource string
+		props      map[string]interface{}
+		conf       *WebsocketConfig
+		path       string
+		err        string
+	}{
+		{
+			datasource: "hmi",
+			props:      map[string]interface{}{},
+			conf:       &WebsocketConfig{Mode: wsx.MODE_SERVER, Format: "json"},
+			path:       "/hmi",
+		}, {
+			datasource: "/data",
+			props:      map[string]interface{}{"url": "ws://localhost:8080", "reconnectInterval": 500, "format": "binary"},
+			conf: &WebsocketConfig{
+				ClientConf: wsx.ClientConf{Url: "ws://localhost:8080/data", ReconnectInterval: 500, MaxReconnectInterval: 30000},
+				Mode:       wsx.MODE_CLIENT,
+				Format:     "binary",
+			},
+		}, {
+			props: map[string]interface{}{"mode": "server"},
+			err:   "path must be specified as the datasource in server mode",
+		}, {
+			props: map[string]interface{}{"url": "http://localhost:8080"},
+			err:   "invalid property url: http://localhost:8080, must start with ws:// or wss://",
+		},
+	}
+	for i, tt := range tests {
+		s := &WebsocketSource{}
+		err := s.Configure(tt.datasource, tt.props)
+		if !reflect.DeepEqual(tt.err, testx.Errstring(err)) {
+			t.Errorf("%d: error mismatch:\n  exp=%s\n  got=%s", i, tt.err, err)
+		} else if err == nil && (!reflect.DeepEqual(tt.conf, s.conf) || tt.path != s.path) {
+			t.Errorf("%d: conf mismatch:\n  exp=%v %s\n  got=%v %s", i, tt.conf, tt.path, s.conf, s.path)
+		}
+	}
+}
@@ -23,6 +23,7 @@ import (
 	"github.com/lf-edge/ekuiper/internal/topo/node"
 	"github.com/lf-edge/ekuiper/internal/topo/state"
 	"github.com/lf-edge/ekuiper/pkg/api"
+	"sort"
 	"strconv"
 	"sync"
 )
@@ -215,6 +216,9 @@ func (s *Topo) GetMetrics() (keys []string, values []interface{}) {
 				values = append(values, v)
 			}
 		}
+		if cn, ok := sn.(node.ConnectionNode); ok {
+			keys, values = appendConnectionStatus(keys, values, "source_"+sn.GetName(), cn)
+		}
 	}
 	for _, so := range s.ops {
 		for ins, metrics := range so.GetMetrics() {
@@ -231,10 +235,31 @@ func (s *Topo) GetMetrics() (keys []string, values []interface{}) {
 				values = append(values, v)
 			}
 		}
+		keys, values = appendConnectionStatus(keys, values, "sink_"+sn.GetName(), sn)
 	}
 	return
 }
 
+func appendConnectionStatus(keys []string, values []interface{}, prefix string, cn node.ConnectionNode) ([]string, []interface{}) {
+	sts := cn.GetConnectionStatus()
+	ins := make([]int, 0, len(sts))
+	for i := range sts {
+		ins = append(ins, i)
+	}
+	sort.Ints(ins)
+	for _, i := range ins {
+		st := sts[i]
+		p := prefix + "_" + strconv.Itoa(i) + "_"
+		keys = append(keys, p+"connection_status", p+"connection_server")
+		values = append(values, st.Status, st.Server)
+		if st.LastError != "" {
+			keys = append(keys, p+"connection_last_error")
+			values = append(values, st.LastError)
+		}
+	}
+	return keys, values
+}
+
 func (s *Topo) GetTopo() *PrintableTopo {
 	return s.topo
 }
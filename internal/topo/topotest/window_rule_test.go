@@ -615,6 +615,181 @@ func TestWindow(t *testing.T) {
 				"op_3_window_0_records_in_total":   int64(3),
 				"op_3_window_0_records_out_total":  int64(4),
 			},
+		}, {
+			Name: `TestWindowRule12`,
+			Sql:  `SELECT count(*) as c, window_trigger() as t, window_start() as ws FROM demo GROUP BY TUMBLINGWINDOW(ss, 2) EMIT EVERY 2 EVENTS`,
+			R: [][]map[string]interface{}{
+				{{
+					"c":  float64(2),
+					"t":  "early",
+					"ws": float64(1541152486000),
+				}},
+				{{
+					"c":  float64(3),
+					"t":  "final",
+					"ws": float64(1541152486000),
+				}},
+				{{
+					"c":  float64(2),
+					"t":  "early",
+					"ws": float64(1541152488000),
+				}},
+				{{
+					"c":  float64(2),
+					"t":  "final",
+					"ws": float64(1541152488000),
+				}},
+			},
+			M: map[string]interface{}{
+				"op_1_preprocessor_demo_0_exceptions_total":   int64(0),
+				"op_1_preprocessor_demo_0_process_latency_us": int64(0),
+				"op_1_preprocessor_demo_0_records_in_total":   int64(5),
+				"op_1_preprocessor_demo_0_records_out_total":  int64(5),
+
+				"op_3_project_0_exceptions_total":   int64(0),
+				"op_3_project_0_process_latency_us": int64(0),
+				"op_3_project_0_records_in_total":   int64(4),
+				"op_3_project_0_records_out_total":  int64(4),
+
+				"sink_mockSink_0_exceptions_total":  int64(0),
+				"sink_mockSink_0_records_in_total":  int64(4),
+				"sink_mockSink_0_records_out_total": int64(4),
+
+				"source_demo_0_exceptions_total":  int64(0),
+				"source_demo_0_records_in_total":  int64(5),
+				"source_demo_0_records_out_total": int64(5),
+
+				"op_2_window_0_exceptions_total":   int64(0),
+				"op_2_window_0_process_latency_us": int64(0),
+				"op_2_window_0_records_in_total":   int64(5),
+				"op_2_window_0_records_out_total":  int64(4),
+			},
+		}, {
+			Name: `TestWindowRule13`,
+			Sql:  `SELECT count(*) as c, window_trigger() as t, window_start() as ws FROM demo GROUP BY TUMBLINGWINDOW(ss, 2) EMIT EVERY 2 EVENTS DISCARD`,
+			R: [][]map[string]interface{}{
+				{{
+					"c":  float64(2),
+					"t":  "early",
+					"ws": float64(1541152486000),
+				}},
+				{{
+					"c":  float64(1),
+					"t":  "final",
+					"ws": float64(1541152486000),
+				}},
+				{{
+					"c":  float64(2),
+					"t":  "early",
+					"ws": float64(1541152488000),
+				}},
+			},
+			M: map[string]interface{}{
+				"op_1_preprocessor_demo_0_exceptions_total":   int64(0),
+				"op_1_preprocessor_demo_0_process_latency_us": int64(0),
+				"op_1_preprocessor_demo_0_records_in_total":   int64(5),
+				"op_1_preprocessor_demo_0_records_out_total":  int64(5),
+
+				"op_3_project_0_exceptions_total":   int64(0),
+				"op_3_project_0_process_latency_us": int64(0),
+				"op_3_project_0_records_in_total":   int64(3),
+				"op_3_project_0_records_out_total":  int64(3),
+
+				"sink_mockSink_0_exceptions_total":  int64(0),
+				"sink_mockSink_0_records_in_total":  int64(3),
+				"sink_mockSink_0_records_out_total": int64(3),
+
+				"source_demo_0_exceptions_total":  int64(0),
+				"source_demo_0_records_in_total":  int64(5),
+				"source_demo_0_records_out_total": int64(5),
+
+				"op_2_window_0_exceptions_total":   int64(0),
+				"op_2_window_0_process_latency_us": int64(0),
+				"op_2_window_0_records_in_total":   int64(5),
+				"op_2_window_0_records_out_total":  int64(3),
+			},
+		}, {
+			Name: `TestWindowRule14`,
+			Sql:  `SELECT color, count(*) as c, sum(size) as s, avg(size), max(size) as mx, min(ts) FROM demo GROUP BY color, TUMBLINGWINDOW(ss, 2) ORDER BY color`,
+			R: [][]map[string]interface{}{
+				{{
+					"color": "blue",
+					"c":     float64(2),
+					"s":     float64(8),
+					"avg":   float64(4),
+					"mx":    float64(6),
+					"min":   float64(1541152486822),
+				}, {
+					"color": "red",
+					"c":     float64(1),
+					"s":     float64(3),
+					"avg":   float64(3),
+					"mx":    float64(3),
+					"min":   float64(1541152486013),
+				}},
+				{{
+					"color": "red",
+					"c":     float64(1),
+					"s":     float64(1),
+					"avg":   float64(1),
+					"mx":    float64(1),
+					"min":   float64(1541152489252),
+				}, {
+					"color": "yellow",
+					"c":     float64(1),
+					"s":     float64(4),
+					"avg":   float64(4),
+					"mx":    float64(4),
+					"min":   float64(1541152488442),
+				}},
+			},
+			M: map[string]interface{}{
+				"op_2_window_0_exceptions_total":  int64(0),
+				"op_2_window_0_records_in_total":  int64(5),
+				"op_2_window_0_records_out_total": int64(2),
+
+				"sink_mockSink_0_exceptions_total":  int64(0),
+				"sink_mockSink_0_records_in_total":  int64(2),
+				"sink_mockSink_0_records_out_total": int64(2),
+			},
+		}, {
+			Name: `TestWindowRule15`,
+			Sql:  `SELECT count(*) as c, avg(size) * 10 + 1 as a, window_start() as ws, window_end() as we FROM demo GROUP BY HOPPINGWINDOW(ss, 2, 1) HAVING c > 1 AND sum(size) < 12`,
+			R: [][]map[string]interface{}{
+				{{
+					"c":  float64(2),
+					"a":  float64(41),
+					"ws": float64(1541152485000),
+					"we": float64(1541152487000),
+				}},
+				{{
+					"c":  float64(3),
+					"a":  float64(31),
+					"ws": float64(1541152486000),
+					"we": float64(1541152488000),
+				}},
+				{{
+					"c":  float64(2),
+					"a":  float64(31),
+					"ws": float64(1541152487000),
+					"we": float64(1541152489000),
+				}},
+				{{
+					"c":  float64(2),
+					"a":  float64(21),
+					"ws": float64(1541152488000),
+					"we": float64(1541152490000),
+				}},
+			},
+			M: map[string]interface{}{
+				"op_2_window_0_exceptions_total":  int64(0),
+				"op_2_window_0_records_in_total":  int64(5),
+				"op_2_window_0_records_out_total": int64(4),
+
+				"sink_mockSink_0_exceptions_total":  int64(0),
+				"sink_mockSink_0_records_in_total":  int64(4),
+				"sink_mockSink_0_records_out_total": int64(4),
+			},
 		}, {
 			Name: `TestCountWindowRule1`,
 			Sql:  `SELECT collect(*)[0]->color as c FROM demo GROUP BY COUNTWINDOW(3)`,
@@ -647,6 +822,170 @@ func TestWindow(t *testing.T) {
 				"op_2_window_0_records_in_total":   int64(5),
 				"op_2_window_0_records_out_total":  int64(1),
 			},
+		}, {
+			Name: `TestCountWindowRule2`,
+			Sql:  `SELECT color, count(*) as c FROM demo GROUP BY color, COUNTWINDOW(2) PER KEY`,
+			R: [][]map[string]interface{}{
+				{{
+					"color": "blue",
+					"c":     float64(2),
+				}}, {{
+					"color": "red",
+					"c":     float64(2),
+				}},
+			},
+			M: map[string]interface{}{
+				"op_1_preprocessor_demo_0_exceptions_total":   int64(0),
+				"op_1_preprocessor_demo_0_process_latency_us": int64(0),
+				"op_1_preprocessor_demo_0_records_in_total":   int64(5),
+				"op_1_preprocessor_demo_0_records_out_total":  int64(5),
+
+				"op_4_project_0_exceptions_total":   int64(0),
+				"op_4_project_0_process_latency_us": int64(0),
+				"op_4_project_0_records_in_total":   int64(2),
+				"op_4_project_0_records_out_total":  int64(2),
+
+				"sink_mockSink_0_exceptions_total":  int64(0),
+				"sink_mockSink_0_records_in_total":  int64(2),
+				"sink_mockSink_0_records_out_total": int64(2),
+
+				"source_demo_0_exceptions_total":  int64(0),
+				"source_demo_0_records_in_total":  int64(5),
+				"source_demo_0_records_out_total": int64(5),
+
+				"op_2_window_0_exceptions_total":   int64(0),
+				"op_2_window_0_process_latency_us": int64(0),
+				"op_2_window_0_records_in_total":   int64(5),
+				"op_2_window_0_records_out_total":  int64(2),
+			},
+		}, {
+			Name: `TestSessionWindowRule1`,
+			Sql:  `SELECT color, count(*) as c, window_start() as ws, window_end() as we FROM demo GROUP BY color, SESSIONWINDOW(ss, 10, 1) PER KEY`,
+			R: [][]map[string]interface{}{
+				{{
+					"color": "red",
+					"c":     float64(1),
+					"ws":    float64(1541152486013),
+					"we":    float64(1541152487013),
+				}}, {{
+					"color": "blue",
+					"c":     float64(2),
+					"ws":    float64(1541152486822),
+					"we":    float64(1541152488632),
+				}}, {{
+					"color": "yellow",
+					"c":     float64(1),
+					"ws":    float64(1541152488442),
+					"we":    float64(1541152489442),
+				}}, {{
+					"color": "red",
+					"c":     float64(1),
+					"ws":    float64(1541152489252),
+					"we":    float64(1541152490000),
+				}},
+			},
+			M: map[string]interface{}{
+				"op_1_preprocessor_demo_0_exceptions_total":   int64(0),
+				"op_1_preprocessor_demo_0_process_latency_us": int64(0),
+				"op_1_preprocessor_demo_0_records_in_total":   int64(5),
+				"op_1_preprocessor_demo_0_records_out_total":  int64(5),
+
+				"op_4_project_0_exceptions_total":   int64(0),
+				"op_4_project_0_process_latency_us": int64(0),
+				"op_4_project_0_records_in_total":   int64(4),
+				"op_4_project_0_records_out_total":  int64(4),
+
+				"sink_mockSink_0_exceptions_total":  int64(0),
+				"sink_mockSink_0_records_in_total":  int64(4),
+				"sink_mockSink_0_records_out_total": int64(4),
+
+				"source_demo_0_exceptions_total":  int64(0),
+				"source_demo_0_records_in_total":  int64(5),
+				"source_demo_0_records_out_total": int64(5),
+
+				"op_2_window_0_exceptions_total":   int64(0),
+				"op_2_window_0_process_latency_us": int64(0),
+				"op_2_window_0_records_in_total":   int64(5),
+				"op_2_window_0_records_out_total":  int64(4),
+			},
+		}, {
+			Name: `TestStateWindowRule1`,
+			Sql:  `SELECT count(*) as c, window_start() as ws, window_end() as we FROM demo GROUP BY STATEWINDOW(size > 3, size < 3)`,
+			R: [][]map[string]interface{}{
+				{{
+					"c":  float64(2),
+					"ws": float64(1541152486822),
+					"we": float64(1541152487632),
+				}}, {{
+					"c":  float64(2),
+					"ws": float64(1541152488442),
+					"we": float64(1541152489252),
+				}},
+			},
+			M: map[string]interface{}{
+				"op_1_preprocessor_demo_0_exceptions_total":   int64(0),
+				"op_1_preprocessor_demo_0_process_latency_us": int64(0),
+				"op_1_preprocessor_demo_0_records_in_total":   int64(5),
+				"op_1_preprocessor_demo_0_records_out_total":  int64(5),
+
+				"op_3_project_0_exceptions_total":   int64(0),
+				"op_3_project_0_process_latency_us": int64(0),
+				"op_3_project_0_records_in_total":   int64(2),
+				"op_3_project_0_records_out_total":  int64(2),
+
+				"sink_mockSink_0_exceptions_total":  int64(0),
+				"sink_mockSink_0_records_in_total":  int64(2),
+				"sink_mockSink_0_records_out_total": int64(2),
+
+				"source_demo_0_exceptions_total":  int64(0),
+				"source_demo_0_records_in_total":  int64(5),
+				"source_demo_0_records_out_total": int64(5),
+
+				"op_2_window_0_exceptions_total":   int64(0),
+				"op_2_window_0_process_latency_us": int64(0),
+				"op_2_window_0_records_in_total":   int64(5),
+				"op_2_window_0_records_out_total":  int64(2),
+			},
+		}, {
+			Name: `TestStateWindowRule2`,
+			Sql:  `SELECT color, count(*) as c, window_start() as ws, window_end() as we FROM demo GROUP BY color, STATEWINDOW(size > 3, size < 3, ss, 1) PER KEY`,
+			R: [][]map[string]interface{}{
+				{{
+					"color": "blue",
+					"c":     float64(2),
+					"ws":    float64(1541152486822),
+					"we":    float64(1541152487632),
+				}}, {{
+					"color": "yellow",
+					"c":     float64(1),
+					"ws":    float64(1541152488442),
+					"we":    float64(1541152489442),
+				}},
+			},
+			M: map[string]interface{}{
+				"op_1_preprocessor_demo_0_exceptions_total":   int64(0),
+				"op_1_preprocessor_demo_0_process_latency_us": int64(0),
+				"op_1_preprocessor_demo_0_records_in_total":   int64(5),
+				"op_1_preprocessor_demo_0_records_out_total":  int64(5),
+
+				"op_4_project_0_exceptions_total":   int64(0),
+				"op_4_project_0_process_latency_us": int64(0),
+				"op_4_project_0_records_in_total":   int64(2),
+				"op_4_project_0_records_out_total":  int64(2),
+
+				"sink_mockSink_0_exceptions_total":  int64(0),
+				"sink_mockSink_0_records_in_total":  int64(2),
+				"sink_mockSink_0_records_out_total": int64(2),
+
+				"source_demo_0_exceptions_total":  int64(0),
+				"source_demo_0_records_in_total":  int64(5),
+				"source_demo_0_records_out_total": int64(5),
+
+				"op_2_window_0_exceptions_total":   int64(0),
+				"op_2_window_0_process_latency_us": int64(0),
+				"op_2_window_0_records_in_total":   int64(5),
+				"op_2_window_0_records_out_total":  int64(2),
+			},
 		}, {
 			Name: `TestWindowRule10`,
 			Sql:  `SELECT deduplicate(color, false)->color as c FROM demo GROUP BY SlidingWindow(hh, 1)`,
@@ -762,6 +1101,12 @@ func TestWindow(t *testing.T) {
 			SendError:          true,
 			Qos:                api.ExactlyOnce,
 			CheckpointInterval: 5000,
+		}, {
+			BufferLength:       100,
+			SendError:          true,
+			Qos:                api.AtLeastOnce,
+			CheckpointInterval: 5000,
+			WindowMemoryLimit:  300,
 		},
 	}
 	for j, opt := range options {
@@ -1329,6 +1674,92 @@ func TestEventWindow(t *testing.T) {
 				"op_2_window_0_records_in_total":   int64(6),
 				"op_2_window_0_records_out_total":  int64(5),
 			},
+		}, {
+			Name: `TestEventWindowRule10`,
+			Sql:  `SELECT color, window_trigger() as t FROM demoE GROUP BY TUMBLINGWINDOW(ss, 1) EMIT ALLOWED LATENESS 3s`,
+			R: [][]map[string]interface{}{
+				{{
+					"color": "red",
+					"t":     "final",
+				}},
+				{{
+					"color": "blue",
+					"t":     "final",
+				}},
+				{{
+					"color": "red",
+					"t":     "late",
+				}, {
+					"color": "blue",
+					"t":     "late",
+				}},
+				{{
+					"color": "yellow",
+					"t":     "final",
+				}},
+				{{
+					"color": "red",
+					"t":     "final",
+				}},
+			},
+			M: map[string]interface{}{
+				"op_1_preprocessor_demoE_0_exceptions_total":   int64(0),
+				"op_1_preprocessor_demoE_0_process_latency_us": int64(0),
+				"op_1_preprocessor_demoE_0_records_in_total":   int64(6),
+				"op_1_preprocessor_demoE_0_records_out_total":  int64(6),
+
+				"op_3_project_0_exceptions_total":   int64(0),
+				"op_3_project_0_process_latency_us": int64(0),
+				"op_3_project_0_records_in_total":   int64(5),
+				"op_3_project_0_records_out_total":  int64(5),
+
+				"sink_mockSink_0_exceptions_total":  int64(0),
+				"sink_mockSink_0_records_in_total":  int64(5),
+				"sink_mockSink_0_records_out_total": int64(5),
+
+				"source_demoE_0_exceptions_total":  int64(0),
+				"source_demoE_0_records_in_total":  int64(6),
+				"source_demoE_0_records_out_total": int64(6),
+
+				"op_2_window_0_exceptions_total":   int64(0),
+				"op_2_window_0_process_latency_us": int64(0),
+				"op_2_window_0_records_in_total":   int64(6),
+				"op_2_window_0_records_out_total":  int64(5),
+			},
+		}, {
+			Name: `TestEventWindowRule11`,
+			Sql:  `SELECT count(*) as c, window_start() as ws, window_end() as we FROM demoE GROUP BY STATEWINDOW(size < 3, size > 3)`,
+			R: [][]map[string]interface{}{
+				{{
+					"c":  float64(2),
+					"ws": float64(1541152487632),
+					"we": float64(1541152488442),
+				}},
+			},
+			M: map[string]interface{}{
+				"op_1_preprocessor_demoE_0_exceptions_total":   int64(0),
+				"op_1_preprocessor_demoE_0_process_latency_us": int64(0),
+				"op_1_preprocessor_demoE_0_records_in_total":   int64(6),
+				"op_1_preprocessor_demoE_0_records_out_total":  int64(6),
+
+				"op_3_project_0_exceptions_total":   int64(0),
+				"op_3_project_0_process_latency_us": int64(0),
+				"op_3_project_0_records_in_total":   int64(1),
+				"op_3_project_0_records_out_total":  int64(1),
+
+				"sink_mockSink_0_exceptions_total":  int64(0),
+				"sink_mockSink_0_records_in_total":  int64(1),
+				"sink_mockSink_0_records_out_total": int64(1),
+
+				"source_demoE_0_exceptions_total":  int64(0),
+				"source_demoE_0_records_in_total":  int64(6),
+				"source_demoE_0_records_out_total": int64(6),
+
+				"op_2_window_0_exceptions_total":   int64(0),
+				"op_2_window_0_process_latency_us": int64(0),
+				"op_2_window_0_records_in_total":   int64(6),
+				"op_2_window_0_records_out_total":  int64(1),
+			},
 		},
 	}
 	HandleStream(true, streamList, t)
@@ -1352,6 +1783,12 @@ func TestEventWindow(t *testing.T) {
 			CheckpointInterval: 5000,
 			IsEventTime:        true,
 			LateTol:            1000,
+		}, {
+			BufferLength:      100,
+			SendError:         true,
+			IsEventTime:       true,
+			LateTol:           1000,
+			WindowMemoryLimit: 300,
 		},
 	}
 	for j, opt := range options {
@@ -217,9 +217,29 @@ func (r *WindowRangeValuer) AppendAlias(_ string, _ interface{}) bool {
 	return false
 }
 
+type WindowTrigger int
+
+const (
+	FinalTrigger WindowTrigger = iota
+	EarlyTrigger
+	LateTrigger
+)
+
+var windowTriggers = []string{
+	FinalTrigger: "final",
+	EarlyTrigger: "early",
+	LateTrigger:  "late",
+}
+
+func (t WindowTrigger) String() string {
+	return windowTriggers[t]
+}
+
 type WindowRange struct {
 	WindowStart int64
 	WindowEnd   int64
+	// Trigger tells if the window result is fired at the window end, before it or by late events
+	Trigger WindowTrigger
 }
 
 func (r *WindowRange) FuncValue(key string) (interface{}, bool) {
@@ -228,6 +248,8 @@ func (r *WindowRange) FuncValue(key string) (interface{}, bool) {
 		return r.WindowStart, true
 	case "window_end":
 		return r.WindowEnd, true
+	case "window_trigger":
+		return r.Trigger.String(), true
 	default:
 		return nil, false
 	}
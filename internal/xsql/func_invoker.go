@@ -59,3 +59,43 @@ type MultiFunc interface {
 	ValidateWithName(args []ast.Expr, name string) error
 	ExecWithName(args []interface{}, ctx api.FunctionContext, name string) (interface{}, bool)
 }
+
+// MultiIncrementalFunc hack for builtin aggregate functions that can be calculated incrementally
+type MultiIncrementalFunc interface {
+	AccumulateWithName(acc interface{}, args []interface{}, ctx api.FunctionContext, name string) (interface{}, error)
+	MergeWithName(acc1 interface{}, acc2 interface{}, ctx api.FunctionContext, name string) (interface{}, error)
+	ResultWithName(acc interface{}, ctx api.FunctionContext, name string) (interface{}, bool)
+}
+
+func AccumulateFunc(funcName string, f api.Function, acc interface{}, args []interface{}, fctx api.FunctionContext) (interface{}, error) {
+	switch mf := f.(type) {
+	case MultiIncrementalFunc:
+		return mf.AccumulateWithName(acc, args, fctx, funcName)
+	case api.IncrementalFunction:
+		return mf.Accumulate(acc, args, fctx)
+	default:
+		return nil, fmt.Errorf("function %s cannot be calculated incrementally", funcName)
+	}
+}
+
+func MergeFunc(funcName string, f api.Function, acc1 interface{}, acc2 interface{}, fctx api.FunctionContext) (interface{}, error) {
+	switch mf := f.(type) {
+	case MultiIncrementalFunc:
+		return mf.MergeWithName(acc1, acc2, fctx, funcName)
+	case api.IncrementalFunction:
+		return mf.Merge(acc1, acc2, fctx)
+	default:
+		return nil, fmt.Errorf("function %s cannot be calculated incrementally", funcName)
+	}
+}
+
+func ResultFunc(funcName string, f api.Function, acc interface{}, fctx api.FunctionContext) (interface{}, bool) {
+	switch mf := f.(type) {
+	case MultiIncrementalFunc:
+		return mf.ResultWithName(acc, fctx, funcName)
+	case api.IncrementalFunction:
+		return mf.Result(acc, fctx)
+	default:
+		return fmt.Errorf("function %s cannot be calculated incrementally", funcName), false
+	}
+}
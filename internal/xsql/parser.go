@@ -711,6 +711,25 @@ func (p *Parser) parseCall(name string) (ast.Expr, error) {
 		} else if f != nil {
 			win.Filter = f
 		}
+		// parse per key clause
+		if perKey, err := p.parsePerKey(); err != nil {
+			return nil, err
+		} else if perKey {
+			if win.WindowType != ast.SESSION_WINDOW && win.WindowType != ast.COUNT_WINDOW && win.WindowType != ast.STATE_WINDOW {
+				return nil, fmt.Errorf("PER KEY clause is only supported by session, count and state window.")
+			}
+			win.PerKey = true
+		}
+		// parse emit clause
+		t, err := p.parseEmit()
+		if err != nil {
+			return nil, err
+		} else if t != nil {
+			if win.WindowType != ast.TUMBLING_WINDOW && win.WindowType != ast.HOPPING_WINDOW {
+				return nil, fmt.Errorf("EMIT clause is only supported by tumbling and hopping window.")
+			}
+			win.Trigger = t
+		}
 		return win, nil
 	}
 }
@@ -823,7 +842,19 @@ func validateWindows(name string, args []ast.Expr) (ast.WindowType, error) {
 		} else {
 			return ast.COUNT_WINDOW, fmt.Errorf("Invalid parameter count.")
 		}
-
+	case "statewindow":
+		if len(args) != 2 && len(args) != 4 {
+			return ast.STATE_WINDOW, fmt.Errorf("The arguments for %s should be 2 or 4.\n", fname)
+		}
+		if len(args) == 4 {
+			if _, ok := args[2].(*ast.TimeLiteral); !ok {
+				return ast.STATE_WINDOW, fmt.Errorf("The 3rd argument for %s is expecting timer literal expression. One value of [dd|hh|mi|ss|ms].\n", fname)
+			}
+			if _, ok := args[3].(*ast.IntegerLiteral); !ok {
+				return ast.STATE_WINDOW, fmt.Errorf("The 4th argument for %s is expecting interger literal expression. \n", fname)
+			}
+		}
+		return ast.STATE_WINDOW, nil
 	}
 	return ast.NOT_WINDOW, nil
 }
@@ -854,6 +885,17 @@ func (p *Parser) ConvertToWindows(wtype ast.WindowType, args []ast.Expr) (*ast.W
 		}
 		return win, nil
 	}
+	if wtype == ast.STATE_WINDOW {
+		win.BeginCondition = args[0]
+		win.EndCondition = args[1]
+		win.Length = &ast.IntegerLiteral{Val: 0}
+		win.Interval = &ast.IntegerLiteral{Val: 0}
+		if len(args) == 2 {
+			return win, nil
+		}
+		// The optional max duration
+		args = args[2:]
+	}
 	var unit = 1
 	v := args[0].(*ast.TimeLiteral).Val
 	switch v {
@@ -1308,3 +1350,113 @@ func (p *Parser) parseFilter() (ast.Expr, error) {
 	}
 	return expr, nil
 }
+
+// PER KEY partitions the window by the group by keys.
+func (p *Parser) parsePerKey() (bool, error) {
+	if tok, lit := p.scanIgnoreWhitespace(); !isIdentOf(tok, lit, "PER") {
+		p.unscan()
+		return false, nil
+	}
+	if tok, lit := p.scanIgnoreWhitespace(); tok != ast.KEY {
+		return false, fmt.Errorf("Found %q after PER, expect KEY.", lit)
+	}
+	return true, nil
+}
+
+// Only support emit on tumbling and hopping window. The keywords are not reserved so that they can still be used as field names.
+// EMIT [EVERY <duration> | EVERY <count> EVENTS] [ACCUMULATE | DISCARD] [ALLOWED LATENESS <duration>]
+func (p *Parser) parseEmit() (*ast.WindowTrigger, error) {
+	if tok, lit := p.scanIgnoreWhitespace(); !isIdentOf(tok, lit, "EMIT") {
+		p.unscan()
+		return nil, nil
+	}
+	t := &ast.WindowTrigger{}
+	tok, lit := p.scanIgnoreWhitespace()
+	if isIdentOf(tok, lit, "EVERY") {
+		n, err := p.parsePositiveInteger("EVERY")
+		if err != nil {
+			return nil, err
+		}
+		tok, lit = p.scanIgnoreWhitespace()
+		if isIdentOf(tok, lit, "EVENTS") {
+			t.Count = n
+		} else if unit, ok := durationUnit(tok, lit); ok {
+			t.Interval = n * unit
+		} else {
+			return nil, fmt.Errorf("Found %q after EVERY %d, expect time unit or EVENTS.", lit, n)
+		}
+		tok, lit = p.scanIgnoreWhitespace()
+	}
+	if isIdentOf(tok, lit, "ACCUMULATE") {
+		t.Mode = ast.EMIT_ACCUMULATE
+		tok, lit = p.scanIgnoreWhitespace()
+	} else if isIdentOf(tok, lit, "DISCARD") {
+		t.Mode = ast.EMIT_DISCARD
+		tok, lit = p.scanIgnoreWhitespace()
+	}
+	if isIdentOf(tok, lit, "ALLOWED") {
+		if tok, lit = p.scanIgnoreWhitespace(); !isIdentOf(tok, lit, "LATENESS") {
+			return nil, fmt.Errorf("Found %q after ALLOWED, expect LATENESS.", lit)
+		}
+		n, err := p.parsePositiveInteger("LATENESS")
+		if err != nil {
+			return nil, err
+		}
+		tok, lit = p.scanIgnoreWhitespace()
+		if unit, ok := durationUnit(tok, lit); ok {
+			t.AllowedLateness = n * unit
+		} else {
+			return nil, fmt.Errorf("Found %q after LATENESS %d, expect time unit.", lit, n)
+		}
+	} else {
+		p.unscan()
+	}
+	if t.Interval == 0 && t.Count == 0 && t.AllowedLateness == 0 {
+		return nil, fmt.Errorf("EMIT clause requires EVERY or ALLOWED LATENESS.")
+	}
+	return t, nil
+}
+
+func (p *Parser) parsePositiveInteger(after string) (int, error) {
+	tok, lit := p.scanIgnoreWhitespace()
+	if tok != ast.INTEGER {
+		return 0, fmt.Errorf("Found %q after %s, expect positive integer.", lit, after)
+	}
+	n, err := strconv.Atoi(lit)
+	if err != nil || n <= 0 {
+		return 0, fmt.Errorf("Found %q after %s, expect positive integer.", lit, after)
+	}
+	return n, nil
+}
+
+func isIdentOf(tok ast.Token, lit string, keyword string) bool {
+	return tok == ast.IDENT && strings.EqualFold(lit, keyword)
+}
+
+// durationUnit returns the milliseconds of the time unit. Both the time literal like ss and the short form like s are supported
+func durationUnit(tok ast.Token, lit string) (int, bool) {
+	switch tok {
+	case ast.DD:
+		return 24 * 3600 * 1000, true
+	case ast.HH:
+		return 3600 * 1000, true
+	case ast.MI:
+		return 60 * 1000, true
+	case ast.SS:
+		return 1000, true
+	case ast.MS:
+		return 1, true
+	case ast.IDENT:
+		switch strings.ToLower(lit) {
+		case "d":
+			return 24 * 3600 * 1000, true
+		case "h":
+			return 3600 * 1000, true
+		case "m":
+			return 60 * 1000, true
+		case "s":
+			return 1000, true
+		}
+	}
+	return 0, false
+}
@@ -1690,6 +1690,217 @@ func TestParser_ParseWindowsExpr(t *testing.T) {
 			stmt: nil,
 			err:  "found \"WHERE\", expected EOF.",
 		},
+		{
+			s: `SELECT count(*) FROM demo GROUP BY TUMBLINGWINDOW(ss, 60) EMIT EVERY 5s`,
+			stmt: &ast.SelectStatement{
+				Fields: []ast.Field{
+					{
+						Expr:  &ast.Call{Name: "count", Args: []ast.Expr{&ast.Wildcard{Token: ast.ASTERISK}}},
+						Name:  "count",
+						AName: ""},
+				},
+				Sources: []ast.Source{&ast.Table{Name: "demo"}},
+				Dimensions: ast.Dimensions{
+					ast.Dimension{
+						Expr: &ast.Window{
+							WindowType: ast.TUMBLING_WINDOW,
+							Length:     &ast.IntegerLiteral{Val: 60000},
+							Interval:   &ast.IntegerLiteral{Val: 0},
+							Trigger:    &ast.WindowTrigger{Interval: 5000},
+						},
+					},
+				},
+			},
+		},
+		{
+			s: `SELECT * FROM demo GROUP BY department, HOPPINGWINDOW(mi, 10, 5) FILTER( where revenue > 100 ) emit every 100 events discard allowed lateness 30 ss, year`,
+			stmt: &ast.SelectStatement{
+				Fields: []ast.Field{
+					{
+						Expr:  &ast.Wildcard{Token: ast.ASTERISK},
+						Name:  "",
+						AName: ""},
+				},
+				Sources: []ast.Source{&ast.Table{Name: "demo"}},
+				Dimensions: ast.Dimensions{
+					ast.Dimension{Expr: &ast.FieldRef{Name: "department", StreamName: ast.DefaultStream}},
+					ast.Dimension{
+						Expr: &ast.Window{
+							WindowType: ast.HOPPING_WINDOW,
+							Length:     &ast.IntegerLiteral{Val: 600000},
+							Interval:   &ast.IntegerLiteral{Val: 300000},
+							Filter: &ast.BinaryExpr{
+								LHS: &ast.FieldRef{Name: "revenue", StreamName: ast.DefaultStream},
+								OP:  ast.GT,
+								RHS: &ast.IntegerLiteral{Val: 100},
+							},
+							Trigger: &ast.WindowTrigger{Count: 100, Mode: ast.EMIT_DISCARD, AllowedLateness: 30000},
+						},
+					},
+					ast.Dimension{Expr: &ast.FieldRef{Name: "year", StreamName: ast.DefaultStream}},
+				},
+			},
+		},
+		{
+			s: `SELECT every FROM demo GROUP BY TUMBLINGWINDOW(ss, 10) EMIT ALLOWED LATENESS 1m`,
+			stmt: &ast.SelectStatement{
+				Fields: []ast.Field{
+					{
+						Expr:  &ast.FieldRef{Name: "every", StreamName: ast.DefaultStream},
+						Name:  "every",
+						AName: ""},
+				},
+				Sources: []ast.Source{&ast.Table{Name: "demo"}},
+				Dimensions: ast.Dimensions{
+					ast.Dimension{
+						Expr: &ast.Window{
+							WindowType: ast.TUMBLING_WINDOW,
+							Length:     &ast.IntegerLiteral{Val: 10000},
+							Interval:   &ast.IntegerLiteral{Val: 0},
+							Trigger:    &ast.WindowTrigger{AllowedLateness: 60000},
+						},
+					},
+				},
+			},
+		},
+		{
+			s:    `SELECT * FROM demo GROUP BY TUMBLINGWINDOW(ss, 10) EMIT DISCARD`,
+			stmt: nil,
+			err:  "EMIT clause requires EVERY or ALLOWED LATENESS.",
+		},
+		{
+			s:    `SELECT * FROM demo GROUP BY TUMBLINGWINDOW(ss, 10) EMIT EVERY 5 times`,
+			stmt: nil,
+			err:  "Found \"times\" after EVERY 5, expect time unit or EVENTS.",
+		},
+		{
+			s:    `SELECT * FROM demo GROUP BY TUMBLINGWINDOW(ss, 10) EMIT EVERY 0s`,
+			stmt: nil,
+			err:  "Found \"0\" after EVERY, expect positive integer.",
+		},
+		{
+			s:    `SELECT * FROM demo GROUP BY SESSIONWINDOW(ss, 10, 2) EMIT EVERY 1s`,
+			stmt: nil,
+			err:  "EMIT clause is only supported by tumbling and hopping window.",
+		},
+		{
+			s: `SELECT count(*) FROM demo GROUP BY deviceId, SESSIONWINDOW(ss, 10, 2) PER KEY`,
+			stmt: &ast.SelectStatement{
+				Fields: []ast.Field{
+					{
+						Expr:  &ast.Call{Name: "count", Args: []ast.Expr{&ast.Wildcard{Token: ast.ASTERISK}}},
+						Name:  "count",
+						AName: ""},
+				},
+				Sources: []ast.Source{&ast.Table{Name: "demo"}},
+				Dimensions: ast.Dimensions{
+					ast.Dimension{Expr: &ast.FieldRef{Name: "deviceId", StreamName: ast.DefaultStream}},
+					ast.Dimension{
+						Expr: &ast.Window{
+							WindowType: ast.SESSION_WINDOW,
+							Length:     &ast.IntegerLiteral{Val: 10000},
+							Interval:   &ast.IntegerLiteral{Val: 2000},
+							PerKey:     true,
+						},
+					},
+				},
+			},
+		},
+		{
+			s: `SELECT * FROM demo GROUP BY COUNTWINDOW(3) FILTER(where temperature > 20) PER KEY, deviceId`,
+			stmt: &ast.SelectStatement{
+				Fields: []ast.Field{
+					{
+						Expr:  &ast.Wildcard{Token: ast.ASTERISK},
+						Name:  "",
+						AName: ""},
+				},
+				Sources: []ast.Source{&ast.Table{Name: "demo"}},
+				Dimensions: ast.Dimensions{
+					ast.Dimension{
+						Expr: &ast.Window{
+							WindowType: ast.COUNT_WINDOW,
+							Length:     &ast.IntegerLiteral{Val: 3},
+							Filter: &ast.BinaryExpr{
+								OP:  ast.GT,
+								LHS: &ast.FieldRef{Name: "temperature", StreamName: ast.DefaultStream},
+								RHS: &ast.IntegerLiteral{Val: 20},
+							},
+							PerKey: true,
+						},
+					},
+					ast.Dimension{Expr: &ast.FieldRef{Name: "deviceId", StreamName: ast.DefaultStream}},
+				},
+			},
+		},
+		{
+			s: `SELECT count(*) FROM demo GROUP BY STATEWINDOW(state = "start", state = "stop")`,
+			stmt: &ast.SelectStatement{
+				Fields: []ast.Field{
+					{
+						Expr:  &ast.Call{Name: "count", Args: []ast.Expr{&ast.Wildcard{Token: ast.ASTERISK}}},
+						Name:  "count",
+						AName: ""},
+				},
+				Sources: []ast.Source{&ast.Table{Name: "demo"}},
+				Dimensions: ast.Dimensions{
+					ast.Dimension{
+						Expr: &ast.Window{
+							WindowType:     ast.STATE_WINDOW,
+							Length:         &ast.IntegerLiteral{Val: 0},
+							Interval:       &ast.IntegerLiteral{Val: 0},
+							BeginCondition: &ast.BinaryExpr{OP: ast.EQ, LHS: &ast.FieldRef{Name: "state", StreamName: ast.DefaultStream}, RHS: &ast.StringLiteral{Val: "start"}},
+							EndCondition:   &ast.BinaryExpr{OP: ast.EQ, LHS: &ast.FieldRef{Name: "state", StreamName: ast.DefaultStream}, RHS: &ast.StringLiteral{Val: "stop"}},
+						},
+					},
+				},
+			},
+		},
+		{
+			s: `SELECT count(*) FROM demo GROUP BY deviceId, STATEWINDOW(temperature > 30, temperature < 20, mi, 10) PER KEY`,
+			stmt: &ast.SelectStatement{
+				Fields: []ast.Field{
+					{
+						Expr:  &ast.Call{Name: "count", Args: []ast.Expr{&ast.Wildcard{Token: ast.ASTERISK}}},
+						Name:  "count",
+						AName: ""},
+				},
+				Sources: []ast.Source{&ast.Table{Name: "demo"}},
+				Dimensions: ast.Dimensions{
+					ast.Dimension{Expr: &ast.FieldRef{Name: "deviceId", StreamName: ast.DefaultStream}},
+					ast.Dimension{
+						Expr: &ast.Window{
+							WindowType:     ast.STATE_WINDOW,
+							Length:         &ast.IntegerLiteral{Val: 600000},
+							Interval:       &ast.IntegerLiteral{Val: 0},
+							BeginCondition: &ast.BinaryExpr{OP: ast.GT, LHS: &ast.FieldRef{Name: "temperature", StreamName: ast.DefaultStream}, RHS: &ast.IntegerLiteral{Val: 30}},
+							EndCondition:   &ast.BinaryExpr{OP: ast.LT, LHS: &ast.FieldRef{Name: "temperature", StreamName: ast.DefaultStream}, RHS: &ast.IntegerLiteral{Val: 20}},
+							PerKey:         true,
+						},
+					},
+				},
+			},
+		},
+		{
+			s:    `SELECT count(*) FROM demo GROUP BY STATEWINDOW(temperature > 30)`,
+			stmt: nil,
+			err:  "The arguments for statewindow should be 2 or 4.\n",
+		},
+		{
+			s:    `SELECT count(*) FROM demo GROUP BY STATEWINDOW(temperature > 30, temperature < 20, 10, 10)`,
+			stmt: nil,
+			err:  "The 3rd argument for statewindow is expecting timer literal expression. One value of [dd|hh|mi|ss|ms].\n",
+		},
+		{
+			s:    `SELECT * FROM demo GROUP BY deviceId, TUMBLINGWINDOW(ss, 10) PER KEY`,
+			stmt: nil,
+			err:  "PER KEY clause is only supported by session, count and state window.",
+		},
+		{
+			s:    `SELECT * FROM demo GROUP BY deviceId, COUNTWINDOW(3) PER device`,
+			stmt: nil,
+			err:  "Found \"device\" after PER, expect KEY.",
+		},
 	}
 
 	fmt.Printf("The test bucket size is %d.\n\n", len(tests))
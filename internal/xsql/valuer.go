@@ -27,8 +27,9 @@ import (
 )
 
 var implicitValueFuncs = map[string]bool{
-	"window_start": true,
-	"window_end":   true,
+	"window_start":   true,
+	"window_end":     true,
+	"window_trigger": true,
 }
 
 // Valuer is the interface that wraps the Value() method.
@@ -113,6 +113,41 @@ type Rewindable interface {
 	Rewind(offset interface{}) error
 }
 
+// Flushable is implemented by the sinks which buffer the collected data. When checkpoint is enabled,
+// Flush is called before the checkpoint barrier is acknowledged so that the data collected before
+// the checkpoint are persisted. If Flush returns an error, the checkpoint is declined.
+type Flushable interface {
+	Flush(ctx StreamContext) error
+}
+
+// Acknowledgeable is implemented by the sources which acknowledge the consumed messages to the external
+// system. If the rule qos is at least once or exactly once, Ack is called with the last tuple sent out
+// before a checkpoint once the checkpoint is completed. Otherwise, it is called once the tuple is sent out.
+// The source must acknowledge all the messages received before the tuple and the tuple itself.
+type Acknowledgeable interface {
+	Ack(ctx StreamContext, tuple SourceTuple) error
+}
+
+const (
+	ConnectionConnected    = "connected"
+	ConnectionConnecting   = "connecting"
+	ConnectionDisconnected = "disconnected"
+)
+
+type ConnectionStatus struct {
+	// connected, connecting or disconnected
+	Status string
+	// The server connecting or connected to
+	Server    string
+	LastError string
+}
+
+// Connectable is implemented by the sources and sinks which connect to external servers. The connection
+// status is shown in the rule status.
+type Connectable interface {
+	ConnectionStatus() ConnectionStatus
+}
+
 type RuleOption struct {
 	IsEventTime        bool  `json:"isEventTime" yaml:"isEventTime"`
 	LateTol            int64 `json:"lateTolerance" yaml:"lateTolerance"`
@@ -122,6 +157,8 @@ type RuleOption struct {
 	SendError          bool  `json:"sendError" yaml:"sendError"`
 	Qos                Qos   `json:"qos" yaml:"qos"`
 	CheckpointInterval int   `json:"checkpointInterval" yaml:"checkpointInterval"`
+	MaxWindowKeys      int   `json:"maxWindowKeys" yaml:"maxWindowKeys"`
+	WindowMemoryLimit  int   `json:"windowMemoryLimit" yaml:"windowMemoryLimit"`
 }
 
 type Rule struct {
@@ -174,6 +211,22 @@ type Function interface {
 	IsAggregate() bool
 }
 
+// IncrementalFunction is an aggregate function which can be calculated incrementally in a window.
+// The window keeps an accumulator for each group and updates it on each event instead of keeping all the events.
+// The accumulator will be saved in the checkpoint, so it must be registered by gob if it is a custom type.
+// The checkpoint encodes the accumulators asynchronously, so Accumulate and Merge must return a new accumulator
+// instead of updating the given ones in place.
+type IncrementalFunction interface {
+	Function
+	//Accumulate the arguments of an event into the accumulator and return the updated accumulator.
+	//The accumulator is nil for the first event. Each argument is the value of a single event instead of a slice.
+	Accumulate(acc interface{}, args []interface{}, ctx FunctionContext) (interface{}, error)
+	//Merge two accumulators of the same group. Either of them could be nil
+	Merge(acc1 interface{}, acc2 interface{}, ctx FunctionContext) (interface{}, error)
+	//Result returns the aggregate result of the accumulator and if it is successful.
+	Result(acc interface{}, ctx FunctionContext) (interface{}, bool)
+}
+
 const (
 	AtMostOnce Qos = iota
 	AtLeastOnce
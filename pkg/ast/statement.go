@@ -166,6 +166,7 @@ const (
 	SLIDING_WINDOW
 	SESSION_WINDOW
 	COUNT_WINDOW
+	STATE_WINDOW
 )
 
 type Window struct {
@@ -173,9 +174,31 @@ type Window struct {
 	Length     *IntegerLiteral
 	Interval   *IntegerLiteral
 	Filter     Expr
+	Trigger    *WindowTrigger
+	PerKey     bool // Partition the window by the group by keys
+	// The begin and end condition of the state window
+	BeginCondition Expr
+	EndCondition   Expr
 	Expr
 }
 
+type EmitMode int
+
+const (
+	// EMIT_ACCUMULATE fires all the events of the window so far for each firing
+	EMIT_ACCUMULATE EmitMode = iota
+	// EMIT_DISCARD fires only the events arrived since the previous firing of the window
+	EMIT_DISCARD
+)
+
+// WindowTrigger defines the early and late firings of a time window besides the firing at the window end
+type WindowTrigger struct {
+	Interval        int // Early firing period in milliseconds
+	Count           int // Early firing for every count of events
+	Mode            EmitMode
+	AllowedLateness int // Keep the window to fire late events in milliseconds after the window end. Event time only
+}
+
 type SortField struct {
 	Name      string
 	Ascending bool
@@ -77,6 +77,8 @@ func Walk(v Visitor, node Node) {
 		Walk(v, n.Length)
 		Walk(v, n.Interval)
 		Walk(v, n.Filter)
+		Walk(v, n.BeginCondition)
+		Walk(v, n.EndCondition)
 
 	case SortFields:
 		for _, sf := range n {